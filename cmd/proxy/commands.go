@@ -0,0 +1,374 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"proxy-server/internal/proxy"
+	"proxy-server/internal/server"
+	"runtime"
+	"runtime/debug"
+	"syscall"
+	"text/tabwriter"
+	"time"
+)
+
+const defaultConfigPath = "cmd/proxy/config.json"
+
+// newFlagSet creates the flags of a command, all of which accept a -config flag.
+func newFlagSet(name string, stderr io.Writer) (*flag.FlagSet, *string) {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	configPath := flags.String("config", envOrDefault("PROXY_CONFIG", defaultConfigPath), "path to the config file, .json, .yaml or .yml (env PROXY_CONFIG)")
+	return flags, configPath
+}
+
+// newServeFlagSet creates the flags of the serve command, which override
+// the server settings, see applyServerOverrides.
+func newServeFlagSet(stderr io.Writer) (*flag.FlagSet, *string) {
+	flags, configPath := newFlagSet("serve", stderr)
+	flags.String("listen", "", "address to listen on, replaces the configured listeners (env PROXY_LISTEN)")
+	flags.Duration("read-timeout", 0, "maximum duration for reading a request (env PROXY_READ_TIMEOUT)")
+	flags.Duration("write-timeout", 0, "maximum duration for writing a response (env PROXY_WRITE_TIMEOUT)")
+	flags.Duration("idle-timeout", 0, "maximum duration to keep idle connections open (env PROXY_IDLE_TIMEOUT)")
+	flags.Duration("shutdown-timeout", 0, "maximum duration to wait for connections to drain on shutdown (env PROXY_SHUTDOWN_TIMEOUT)")
+	return flags, configPath
+}
+
+func serveCommand(args []string, stderr io.Writer) int {
+	flags, configPath := newServeFlagSet(stderr)
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	overrides := func(serverConfig *server.Config) error {
+		return applyServerOverrides(serverConfig, flags)
+	}
+	if err := serve(*configPath, overrides); err != nil {
+		slog.Error("Server failed", "error", err)
+		return 1
+	}
+	return 0
+}
+
+// durationSetting is a server setting which can be overridden by an
+// environment variable and a flag.
+type durationSetting struct {
+	env      string
+	duration *time.Duration
+}
+
+// durationSettings maps the serve flags to the settings they override.
+func durationSettings(serverConfig *server.Config) map[string]durationSetting {
+	return map[string]durationSetting{
+		"read-timeout":     {"PROXY_READ_TIMEOUT", &serverConfig.ReadTimeout},
+		"write-timeout":    {"PROXY_WRITE_TIMEOUT", &serverConfig.WriteTimeout},
+		"idle-timeout":     {"PROXY_IDLE_TIMEOUT", &serverConfig.IdleTimeout},
+		"shutdown-timeout": {"PROXY_SHUTDOWN_TIMEOUT", &serverConfig.ShutdownTimeout},
+	}
+}
+
+// applyServerOverrides applies environment variables and then explicitly set
+// flags on top of the server settings from the config file.
+func applyServerOverrides(serverConfig *server.Config, flags *flag.FlagSet) error {
+	settings := durationSettings(serverConfig)
+
+	if listen, ok := os.LookupEnv("PROXY_LISTEN"); ok {
+		serverConfig.Port = listen
+		serverConfig.Listeners = nil
+	}
+	for _, setting := range settings {
+		duration, err := durationFromEnv(setting.env, *setting.duration)
+		if err != nil {
+			return err
+		}
+		*setting.duration = duration
+	}
+
+	flags.Visit(func(f *flag.Flag) {
+		if f.Name == "listen" {
+			serverConfig.Port = f.Value.String()
+			serverConfig.Listeners = nil
+			return
+		}
+		if setting, ok := settings[f.Name]; ok {
+			*setting.duration = f.Value.(flag.Getter).Get().(time.Duration)
+		}
+	})
+	return nil
+}
+
+func serve(configPath string, overrides func(*server.Config) error) error {
+	config, err := proxy.ReadConfigFile(configPath)
+	if err != nil {
+		return err
+	}
+	// errors may quote values of the config, which must not leak secrets
+	return config.RedactError(serveConfig(configPath, config, overrides))
+}
+
+func serveConfig(configPath string, config *proxy.Config, overrides func(*server.Config) error) (err error) {
+	loggers, err := config.CreateLoggers(os.Stderr)
+	if err != nil {
+		return err
+	}
+	config.SetLoggers(loggers)
+	slog.SetDefault(loggers.Logger(""))
+	// metrics are served by the admin API
+	proxy.DefaultMetrics.Logger = loggers.Logger(proxy.LogAdmin)
+
+	serverConfig, err := config.CreateServerConfig()
+	if err != nil {
+		return err
+	}
+	if err := overrides(serverConfig); err != nil {
+		return err
+	}
+
+	tracer, err := config.CreateTracer()
+	if err != nil {
+		return fmt.Errorf("failed to create tracer: %w", err)
+	}
+	if tracer != nil {
+		proxy.SetTracer(tracer)
+		defer shutdownTracer(tracer, loggers.Logger(proxy.LogTracing))
+	}
+
+	router, err := config.CreateRouter()
+	if err != nil {
+		return fmt.Errorf("failed to create router: %w", err)
+	}
+	reloadableRouter := proxy.NewReloadableRouter(router)
+	reloadableRouter.Logger = loggers.Logger(proxy.LogReload)
+	// drains the requests in flight and closes the handlers of the current
+	// routes, whether the server ran or failed to start
+	defer func() {
+		err = errors.Join(err, reloadableRouter.Close())
+	}()
+
+	tcpProxies, err := config.CreateTCPProxies()
+	if err != nil {
+		return fmt.Errorf("failed to create tcp proxies: %w", err)
+	}
+	// health checkers are stopped on shutdown, but only once they're served
+	defer func() {
+		for _, tcpProxy := range tcpProxies {
+			if tcpProxy.HealthChecker != nil {
+				_ = tcpProxy.HealthChecker.Close()
+			}
+		}
+	}()
+
+	udpProxies, err := config.CreateUDPProxies()
+	if err != nil {
+		return fmt.Errorf("failed to create udp proxies: %w", err)
+	}
+
+	socksProxy, err := config.CreateSOCKS5Proxy()
+	if err != nil {
+		return fmt.Errorf("failed to create socks5 proxy: %w", err)
+	}
+
+	accessLog, err := config.CreateAccessLog()
+	if err != nil {
+		return fmt.Errorf("failed to create access log: %w", err)
+	}
+	if accessLog != nil {
+		defer accessLog.Close()
+	}
+
+	requestIDs, err := config.CreateRequestIDs()
+	if err != nil {
+		return fmt.Errorf("failed to create request ids: %w", err)
+	}
+
+	reloader := proxy.NewConfigReloader(configPath, config, reloadableRouter)
+	admin, err := config.CreateAdmin(reloadableRouter, reloader, buildInfo())
+	if err != nil {
+		return fmt.Errorf("failed to create admin api: %w", err)
+	}
+
+	stopWatching := make(chan struct{})
+	defer close(stopWatching)
+	go reloader.Watch(2*time.Second, stopWatching)
+	go reloadOnSignal(reloader)
+
+	var handler http.Handler = reloadableRouter
+	if accessLog != nil {
+		handler = accessLog.Handler(handler)
+	}
+	// outermost, so the access log has the request ID
+	if requestIDs != nil {
+		handler = requestIDs.Handler(handler)
+	}
+
+	srv := server.New(handler, serverConfig)
+	srv.Logger = loggers.Logger(proxy.LogServer)
+	for _, tcpProxy := range tcpProxies {
+		srv.AddService(tcpProxy)
+	}
+	for _, udpProxy := range udpProxies {
+		srv.AddService(udpProxy)
+	}
+	if socksProxy != nil {
+		srv.AddService(socksProxy)
+	}
+	if admin != nil {
+		for _, tcpProxy := range tcpProxies {
+			admin.AddPool("tcp", tcpProxy.Address, tcpProxy.Pool)
+		}
+		for _, udpProxy := range udpProxies {
+			admin.AddPool("udp", udpProxy.Address, udpProxy.Pool)
+		}
+		adminService := server.NewHTTPService(config.Admin.Listen, admin)
+		adminService.Logger = admin.Logger
+		srv.AddService(adminService)
+	}
+	return srv.Start()
+}
+
+// shutdownTracer exports the spans which are still queued.
+func shutdownTracer(tracer *proxy.Tracer, logger *slog.Logger) {
+	proxy.SetTracer(nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := tracer.Shutdown(ctx); err != nil {
+		logger.Error("Error shutting down tracer", "error", err)
+	}
+}
+
+// reloadOnSignal reloads the config whenever the process receives SIGHUP.
+func reloadOnSignal(reloader *proxy.ConfigReloader) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	for range hangup {
+		if err := reloader.Reload(); err != nil {
+			reloader.Logger.Error("Config reload failed, keeping current routes", "error", err)
+		}
+	}
+}
+
+func validateCommand(args []string, stdout, stderr io.Writer) int {
+	flags, configPath := newFlagSet("validate", stderr)
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	config, err := proxy.ReadConfigFile(*configPath)
+	if err == nil {
+		err = config.Validate()
+	}
+	if err != nil {
+		_, _ = fmt.Fprintf(stderr, "%s is invalid:\n", *configPath)
+		for _, e := range unwrapJoined(err) {
+			if config != nil {
+				e = config.RedactError(e)
+			}
+			_, _ = fmt.Fprintf(stderr, "  - %v\n", e)
+		}
+		return 1
+	}
+	_, _ = fmt.Fprintf(stdout, "%s is valid\n", *configPath)
+	return 0
+}
+
+func routesCommand(args []string, stdout, stderr io.Writer) int {
+	flags, configPath := newFlagSet("routes", stderr)
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	config, err := proxy.ReadConfigFile(*configPath)
+	if err != nil {
+		_, _ = fmt.Fprintln(stderr, err)
+		return 1
+	}
+	config = config.Redacted()
+
+	w := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "LISTENER\tMATCH\tHANDLER")
+	for _, route := range config.Routes {
+		_, _ = fmt.Fprintf(w, "http\t%s\t%s\n", route.Matcher.Pattern(), route.Handler.Describe())
+	}
+	for _, tcpConfig := range config.TCP {
+		_, _ = fmt.Fprintf(w, "tcp\t%s\t%v\n", tcpConfig.Listen, tcpConfig.Upstreams)
+	}
+	for _, udpConfig := range config.UDP {
+		_, _ = fmt.Fprintf(w, "udp\t%s\t%v\n", udpConfig.Listen, udpConfig.Upstreams)
+	}
+	if config.SOCKS5 != nil {
+		_, _ = fmt.Fprintf(w, "socks5\t%s\tconnect\n", config.SOCKS5.Listen)
+	}
+	if err := w.Flush(); err != nil {
+		_, _ = fmt.Fprintln(stderr, err)
+		return 1
+	}
+	return 0
+}
+
+func schemaCommand(stdout, stderr io.Writer) int {
+	encoder := json.NewEncoder(stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(proxy.ConfigSchema()); err != nil {
+		_, _ = fmt.Fprintln(stderr, err)
+		return 1
+	}
+	return 0
+}
+
+func versionCommand(stdout io.Writer) int {
+	info := buildInfo()
+	_, _ = fmt.Fprintf(stdout, "proxy %s (revision %s, %s)\n", info.Version, info.Revision, info.GoVersion)
+	return 0
+}
+
+func buildInfo() proxy.BuildInfo {
+	revision := "unknown"
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range info.Settings {
+			if setting.Key == "vcs.revision" {
+				revision = setting.Value
+			}
+		}
+	}
+	return proxy.BuildInfo{
+		Version:   version,
+		Revision:  revision,
+		GoVersion: runtime.Version(),
+	}
+}
+
+// unwrapJoined splits an error created by errors.Join into its errors.
+func unwrapJoined(err error) []error {
+	var joined interface{ Unwrap() []error }
+	if errors.As(err, &joined) {
+		return joined.Unwrap()
+	}
+	return []error{err}
+}
+
+func envOrDefault(name, defaultValue string) string {
+	if value, ok := os.LookupEnv(name); ok {
+		return value
+	}
+	return defaultValue
+}
+
+func durationFromEnv(name string, defaultValue time.Duration) (time.Duration, error) {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return defaultValue, nil
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", name, err)
+	}
+	return duration, nil
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"proxy-server/internal/server"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestApplyServerOverrides(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		args []string
+		want func(config *server.Config)
+	}{
+		{
+			name: "configFile",
+			want: func(config *server.Config) {},
+		},
+		{
+			name: "envOverridesConfigFile",
+			env:  map[string]string{"PROXY_LISTEN": ":9000", "PROXY_READ_TIMEOUT": "3s"},
+			want: func(config *server.Config) {
+				config.Port = ":9000"
+				config.Listeners = nil
+				config.ReadTimeout = 3 * time.Second
+			},
+		},
+		{
+			name: "flagsOverrideEnv",
+			env:  map[string]string{"PROXY_LISTEN": ":9000", "PROXY_READ_TIMEOUT": "3s", "PROXY_IDLE_TIMEOUT": "1m"},
+			args: []string{"-listen", ":9100", "-read-timeout", "4s"},
+			want: func(config *server.Config) {
+				config.Port = ":9100"
+				config.Listeners = nil
+				config.ReadTimeout = 4 * time.Second
+				config.IdleTimeout = time.Minute
+			},
+		},
+		{
+			name: "unsetFlagsKeepConfigFile",
+			args: []string{"-shutdown-timeout", "5s"},
+			want: func(config *server.Config) {
+				config.ShutdownTimeout = 5 * time.Second
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+			flags, _ := newServeFlagSet(io.Discard)
+			require.NoError(t, flags.Parse(tt.args))
+			config := &server.Config{Port: ":8080", Listeners: []string{":8081"}, ReadTimeout: time.Second, ShutdownTimeout: time.Minute}
+			want := *config
+			tt.want(&want)
+
+			require.NoError(t, applyServerOverrides(config, flags))
+
+			assert.Equal(t, &want, config)
+		})
+	}
+
+	t.Run("invalid env duration", func(t *testing.T) {
+		t.Setenv("PROXY_WRITE_TIMEOUT", "soon")
+		flags, _ := newServeFlagSet(io.Discard)
+
+		err := applyServerOverrides(&server.Config{}, flags)
+
+		assert.ErrorContains(t, err, "invalid PROXY_WRITE_TIMEOUT")
+	})
+}
+
+func TestRun(t *testing.T) {
+	// language=JSON
+	validConfig := `{
+		"routes": [{"matcher": {"path": "/hello"}, "handler": {"static": {"message": "Hello"}}}],
+		"tcp": [{"listen": ":9000", "upstreams": ["localhost:9001"]}]
+	}`
+	// language=JSON
+	invalidConfig := `{"routes": [{"matcher": {"path": "/a"}, "handler": {}}, {"matcher": {"path": "/b"}, "handler": {"retrier": {"retries": -1, "handler": {"echo": {}}}}}]}`
+
+	tests := []struct {
+		name       string
+		config     string
+		args       []string
+		env        map[string]string
+		wantCode   int
+		wantStdout string
+		wantStderr string
+	}{
+		{
+			name:       "validateValid",
+			config:     validConfig,
+			args:       []string{"validate", "-config", "{config}"},
+			wantStdout: "{config} is valid\n",
+		},
+		{
+			name:       "validateInvalid",
+			config:     invalidConfig,
+			args:       []string{"validate", "-config", "{config}"},
+			wantCode:   1,
+			wantStderr: "{config} is invalid:\n  - routes[0].handler: no handler set\n  - routes[1].handler.retrier: retries must not be negative, got -1\n",
+		},
+		{
+			name:       "validateConfigFromEnv",
+			config:     validConfig,
+			args:       []string{"validate"},
+			env:        map[string]string{"PROXY_CONFIG": "{config}"},
+			wantStdout: "{config} is valid\n",
+		},
+		{
+			name:   "routes",
+			config: validConfig,
+			args:   []string{"routes", "-config", "{config}"},
+			wantStdout: "LISTENER  MATCH   HANDLER\n" +
+				"http      /hello  static\n" +
+				"tcp       :9000   [localhost:9001]\n",
+		},
+		{
+			name:       "version",
+			args:       []string{"version"},
+			wantStdout: "proxy dev (revision {revision}, {go})\n",
+		},
+		{
+			name:       "unknownCommand",
+			args:       []string{"unknown"},
+			wantCode:   2,
+			wantStderr: "unknown command \"unknown\"\n\n" + usage,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.json")
+			require.NoError(t, os.WriteFile(path, []byte(tt.config), 0o644))
+			info := buildInfo()
+			placeholders := strings.NewReplacer("{config}", path, "{revision}", info.Revision, "{go}", info.GoVersion)
+			for name, value := range tt.env {
+				t.Setenv(name, placeholders.Replace(value))
+			}
+			args := make([]string, len(tt.args))
+			for i, arg := range tt.args {
+				args[i] = placeholders.Replace(arg)
+			}
+			var stdout, stderr bytes.Buffer
+
+			code := run(args, &stdout, &stderr)
+
+			assert.Equal(t, tt.wantCode, code)
+			assert.Equal(t, placeholders.Replace(tt.wantStdout), stdout.String())
+			assert.Equal(t, placeholders.Replace(tt.wantStderr), stderr.String())
+		})
+	}
+}
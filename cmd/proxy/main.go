@@ -1,30 +1,54 @@
 package main
 
 import (
-	"log"
+	"fmt"
+	"io"
 	"os"
-	"proxy-server/internal/proxy"
-	"proxy-server/internal/server"
+	"strings"
 )
 
-func main() {
-	file, err := os.ReadFile("cmd/proxy/config.json")
-	if err != nil {
-		log.Fatalf("Failed to read config file: %v", err)
-	}
+// version is set at build time with -ldflags "-X main.version=..."
+var version = "dev"
 
-	config, err := proxy.ReadConfigFromBytes(file)
-	if err != nil {
-		log.Fatalf("Failed to parse config file: %v", err)
-	}
+const usage = `Usage: proxy [command] [flags]
+
+Commands:
+  serve     start the proxy server (default)
+  validate  parse the config and build all routes, reporting every error
+  routes    print the resolved route table
+  schema    print the JSON Schema of config files
+  version   print version information
+
+Run "proxy <command> -h" for the flags of a command.
+`
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
 
-	router, err := config.CreateRouter()
-	if err != nil {
-		log.Fatalf("Failed to create router: %v", err)
+// run executes the command given by args and returns the process exit code.
+func run(args []string, stdout, stderr io.Writer) int {
+	command := "serve"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
 	}
 
-	srv := server.New(router, server.DefaultConfig())
-	if err := srv.Start(); err != nil {
-		log.Fatalf("Server failed: %v", err)
+	switch command {
+	case "serve":
+		return serveCommand(args, stderr)
+	case "validate":
+		return validateCommand(args, stdout, stderr)
+	case "routes":
+		return routesCommand(args, stdout, stderr)
+	case "schema":
+		return schemaCommand(stdout, stderr)
+	case "version":
+		return versionCommand(stdout)
+	case "help":
+		_, _ = fmt.Fprint(stdout, usage)
+		return 0
+	default:
+		_, _ = fmt.Fprintf(stderr, "unknown command %q\n\n%s", command, usage)
+		return 2
 	}
 }
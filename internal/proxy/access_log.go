@@ -0,0 +1,310 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// AccessLogEntry describes a served request. Custom access log templates
+// are executed with it.
+type AccessLogEntry struct {
+	Time       time.Time
+	RemoteAddr string
+	User       string
+	Method     string
+	Host       string
+	URI        string
+	Proto      string
+	Referer    string
+	UserAgent  string
+	Status     int
+	BytesIn    int64
+	BytesOut   int64
+	Duration   time.Duration
+	// RequestID is the ID assigned to the request, empty without RequestIDs.
+	RequestID string
+	// Route is the pattern of the route which served the request, empty if none matched.
+	Route string
+	// Upstreams are the targets the request was forwarded to.
+	Upstreams []string
+	// Attempts is the number of times a retrier tried the request, 1 without retrier.
+	Attempts         int
+	UpstreamDuration time.Duration
+}
+
+// DurationMs returns the duration in milliseconds, for templates.
+func (e *AccessLogEntry) DurationMs() float64 {
+	return float64(e.Duration.Microseconds()) / 1000
+}
+
+// UpstreamDurationMs returns the upstream duration in milliseconds, for templates.
+func (e *AccessLogEntry) UpstreamDurationMs() float64 {
+	return float64(e.UpstreamDuration.Microseconds()) / 1000
+}
+
+// Upstream returns the upstreams separated by commas, for templates.
+func (e *AccessLogEntry) Upstream() string {
+	return strings.Join(e.Upstreams, ",")
+}
+
+// AccessLogFormat writes an entry as a single line to buf.
+type AccessLogFormat func(buf *bytes.Buffer, entry *AccessLogEntry) error
+
+const clfTimeLayout = "02/Jan/2006:15:04:05 -0700"
+
+// CommonLogFormat writes entries in the NCSA Common Log Format.
+func CommonLogFormat(buf *bytes.Buffer, e *AccessLogEntry) error {
+	bytesOut := "-"
+	if e.BytesOut > 0 {
+		bytesOut = strconv.FormatInt(e.BytesOut, 10)
+	}
+	_, err := fmt.Fprintf(buf, "%s - %s [%s] \"%s %s %s\" %d %s",
+		orDash(e.RemoteAddr), orDash(e.User), e.Time.Format(clfTimeLayout),
+		e.Method, e.URI, e.Proto, e.Status, bytesOut)
+	return err
+}
+
+// CombinedLogFormat writes entries in the Combined Log Format, which adds
+// referer and user agent to the Common Log Format.
+func CombinedLogFormat(buf *bytes.Buffer, e *AccessLogEntry) error {
+	if err := CommonLogFormat(buf, e); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(buf, " %q %q", orDash(e.Referer), orDash(e.UserAgent))
+	return err
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+type jsonAccessLogEntry struct {
+	Time               time.Time `json:"time"`
+	RemoteAddr         string    `json:"remote_addr"`
+	User               string    `json:"user,omitempty"`
+	Method             string    `json:"method"`
+	Host               string    `json:"host"`
+	URI                string    `json:"uri"`
+	Proto              string    `json:"proto"`
+	Referer            string    `json:"referer,omitempty"`
+	UserAgent          string    `json:"user_agent,omitempty"`
+	Status             int       `json:"status"`
+	BytesIn            int64     `json:"bytes_in"`
+	BytesOut           int64     `json:"bytes_out"`
+	DurationMs         float64   `json:"duration_ms"`
+	RequestID          string    `json:"request_id,omitempty"`
+	Route              string    `json:"route,omitempty"`
+	Upstreams          []string  `json:"upstreams,omitempty"`
+	Attempts           int       `json:"attempts"`
+	UpstreamDurationMs float64   `json:"upstream_duration_ms,omitempty"`
+}
+
+// JSONLogFormat writes entries as JSON objects.
+func JSONLogFormat(buf *bytes.Buffer, e *AccessLogEntry) error {
+	content, err := json.Marshal(jsonAccessLogEntry{
+		Time:               e.Time,
+		RemoteAddr:         e.RemoteAddr,
+		User:               e.User,
+		Method:             e.Method,
+		Host:               e.Host,
+		URI:                e.URI,
+		Proto:              e.Proto,
+		Referer:            e.Referer,
+		UserAgent:          e.UserAgent,
+		Status:             e.Status,
+		BytesIn:            e.BytesIn,
+		BytesOut:           e.BytesOut,
+		DurationMs:         e.DurationMs(),
+		RequestID:          e.RequestID,
+		Route:              e.Route,
+		Upstreams:          e.Upstreams,
+		Attempts:           e.Attempts,
+		UpstreamDurationMs: e.UpstreamDurationMs(),
+	})
+	if err != nil {
+		return err
+	}
+	buf.Write(content)
+	return nil
+}
+
+// TemplateLogFormat writes entries by executing a text/template with the
+// AccessLogEntry, e.g. "{{.Method}} {{.URI}} {{.Status}} {{.DurationMs}}ms".
+func TemplateLogFormat(text string) (AccessLogFormat, error) {
+	tmpl, err := template.New("access_log").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	return func(buf *bytes.Buffer, e *AccessLogEntry) error {
+		return tmpl.Execute(buf, e)
+	}, nil
+}
+
+// AccessLog writes an entry for every request served by its handlers.
+type AccessLog struct {
+	Format AccessLogFormat
+	Output io.Writer
+	// Logger logs entries which couldn't be written.
+	Logger *slog.Logger
+
+	mu sync.Mutex
+}
+
+func NewAccessLog(format AccessLogFormat, output io.Writer) *AccessLog {
+	return &AccessLog{Format: format, Output: output}
+}
+
+// Handler logs the requests served by next.
+func (l *AccessLog) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		details := &requestDetails{}
+		body := &countingReader{ReadCloser: r.Body}
+		if r.Body != nil {
+			r.Body = body
+		}
+		sw := &statusWriter{ResponseWriter: w}
+
+		next.ServeHTTP(sw, r.WithContext(context.WithValue(r.Context(), requestDetailsKey{}, details)))
+
+		entry := &AccessLogEntry{
+			Time:       start,
+			RemoteAddr: remoteHost(r.RemoteAddr),
+			Method:     r.Method,
+			Host:       r.Host,
+			URI:        r.RequestURI,
+			Proto:      r.Proto,
+			Referer:    r.Referer(),
+			UserAgent:  r.UserAgent(),
+			Status:     sw.status(),
+			BytesIn:    body.n,
+			BytesOut:   sw.written,
+			Duration:   time.Since(start),
+			RequestID:  requestIDFromContext(r.Context()),
+		}
+		if user, _, ok := r.BasicAuth(); ok {
+			entry.User = user
+		}
+		details.fill(entry)
+		l.write(r.Context(), entry)
+	})
+}
+
+func (l *AccessLog) write(ctx context.Context, entry *AccessLogEntry) {
+	var buf bytes.Buffer
+	if err := l.Format(&buf, entry); err != nil {
+		loggerOrDefault(l.Logger).ErrorContext(ctx, "Error formatting access log entry", "error", err)
+		return
+	}
+	if buf.Len() == 0 || buf.Bytes()[buf.Len()-1] != '\n' {
+		buf.WriteByte('\n')
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := l.Output.Write(buf.Bytes()); err != nil {
+		loggerOrDefault(l.Logger).ErrorContext(ctx, "Error writing access log", "error", err)
+	}
+}
+
+// Close closes the output, if it's closable and not stdout or stderr.
+func (l *AccessLog) Close() error {
+	if l.Output == os.Stdout || l.Output == os.Stderr {
+		return nil
+	}
+	if closer, ok := l.Output.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+func remoteHost(remoteAddr string) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return remoteAddr
+	}
+	return host
+}
+
+type countingReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+type requestDetailsKey struct{}
+
+// requestDetails collects what handlers did to serve a request, for the
+// access log. Handlers may run concurrently, e.g. fanout branches.
+type requestDetails struct {
+	mu               sync.Mutex
+	route            string
+	upstreams        []string
+	attempts         int
+	upstreamDuration time.Duration
+}
+
+func detailsFromContext(ctx context.Context) *requestDetails {
+	details, _ := ctx.Value(requestDetailsKey{}).(*requestDetails)
+	return details
+}
+
+func (d *requestDetails) setRoute(pattern string) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.route = pattern
+}
+
+func (d *requestDetails) addAttempt() {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.attempts++
+}
+
+func (d *requestDetails) addUpstream(upstream string, duration time.Duration) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !slices.Contains(d.upstreams, upstream) {
+		d.upstreams = append(d.upstreams, upstream)
+	}
+	d.upstreamDuration += duration
+}
+
+func (d *requestDetails) fill(entry *AccessLogEntry) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	entry.Route = d.route
+	entry.Upstreams = d.upstreams
+	entry.Attempts = max(d.attempts, 1)
+	entry.UpstreamDuration = d.upstreamDuration
+}
@@ -0,0 +1,226 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAccessLogFormats(t *testing.T) {
+	entry := &AccessLogEntry{
+		Time:             time.Date(2000, 10, 10, 13, 55, 36, 0, time.FixedZone("", -7*60*60)),
+		RemoteAddr:       "127.0.0.1",
+		User:             "frank",
+		Method:           "GET",
+		Host:             "example.com",
+		URI:              "/apache_pb.gif",
+		Proto:            "HTTP/1.0",
+		Referer:          "http://www.example.com/start.html",
+		UserAgent:        "Mozilla/4.08",
+		Status:           200,
+		BytesIn:          10,
+		BytesOut:         2326,
+		Duration:         1500 * time.Microsecond,
+		Route:            "/",
+		Upstreams:        []string{"a:80", "b:80"},
+		Attempts:         2,
+		UpstreamDuration: time.Millisecond,
+	}
+	format := func(format AccessLogFormat) string {
+		var buf bytes.Buffer
+		require.NoError(t, format(&buf, entry))
+		return buf.String()
+	}
+
+	t.Run("common", func(t *testing.T) {
+		assert.Equal(t, `127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /apache_pb.gif HTTP/1.0" 200 2326`, format(CommonLogFormat))
+	})
+
+	t.Run("combined", func(t *testing.T) {
+		assert.Equal(t, `127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /apache_pb.gif HTTP/1.0" 200 2326 "http://www.example.com/start.html" "Mozilla/4.08"`, format(CombinedLogFormat))
+	})
+
+	t.Run("json", func(t *testing.T) {
+		// language=JSON
+		expected := `{
+			"time": "2000-10-10T13:55:36-07:00",
+			"remote_addr": "127.0.0.1",
+			"user": "frank",
+			"method": "GET",
+			"host": "example.com",
+			"uri": "/apache_pb.gif",
+			"proto": "HTTP/1.0",
+			"referer": "http://www.example.com/start.html",
+			"user_agent": "Mozilla/4.08",
+			"status": 200,
+			"bytes_in": 10,
+			"bytes_out": 2326,
+			"duration_ms": 1.5,
+			"route": "/",
+			"upstreams": ["a:80", "b:80"],
+			"attempts": 2,
+			"upstream_duration_ms": 1
+		}`
+		assert.JSONEq(t, expected, format(JSONLogFormat))
+	})
+
+	t.Run("template", func(t *testing.T) {
+		tmpl, err := TemplateLogFormat("{{.Method}} {{.URI}} {{.Status}} {{.Route}} {{.Upstream}} {{.Attempts}} {{.DurationMs}}ms")
+		require.NoError(t, err)
+
+		assert.Equal(t, "GET /apache_pb.gif 200 / a:80,b:80 2 1.5ms", format(tmpl))
+	})
+
+	t.Run("invalid template", func(t *testing.T) {
+		_, err := TemplateLogFormat("{{.Method")
+
+		assert.Error(t, err)
+	})
+}
+
+func TestAccessLog_Handler(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+		_, _ = w.Write([]byte("upstream failed"))
+	}))
+	defer upstream.Close()
+	forward, err := NewForwardHandler(upstream.URL)
+	require.NoError(t, err)
+
+	router := NewPathRouter()
+	router.AddRoute("/retry", &RetrierHandler{Handler: forward, RetryPolicy: &RetryOnNon2xxRetryPolicy{}, Retries: 2})
+	var buf bytes.Buffer
+	handler := NewAccessLog(JSONLogFormat, &buf).Handler(router)
+
+	t.Run("logs route, upstreams, attempts and sizes", func(t *testing.T) {
+		buf.Reset()
+		r := httptest.NewRequest("POST", "/retry?q=1", strings.NewReader("hello"))
+		r.SetBasicAuth("user", "password")
+
+		handler.ServeHTTP(httptest.NewRecorder(), r)
+
+		var entry map[string]any
+		require.NoError(t, json.Unmarshal(buf.Bytes(), &entry))
+		assert.Equal(t, "POST", entry["method"])
+		assert.Equal(t, "/retry?q=1", entry["uri"])
+		assert.Equal(t, "user", entry["user"])
+		assert.Equal(t, "192.0.2.1", entry["remote_addr"])
+		assert.Equal(t, 502.0, entry["status"])
+		assert.Equal(t, "/retry", entry["route"])
+		assert.Equal(t, []any{forward.URL.Host}, entry["upstreams"])
+		assert.Equal(t, 3.0, entry["attempts"])
+		assert.Equal(t, 5.0, entry["bytes_in"])
+		assert.Equal(t, float64(len("upstream failed")), entry["bytes_out"])
+		assert.Greater(t, entry["upstream_duration_ms"], 0.0)
+	})
+
+	t.Run("logs requests without route", func(t *testing.T) {
+		buf.Reset()
+
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/unknown", nil))
+
+		var entry map[string]any
+		require.NoError(t, json.Unmarshal(buf.Bytes(), &entry))
+		assert.Equal(t, 404.0, entry["status"])
+		assert.Nil(t, entry["route"])
+		assert.Equal(t, 1.0, entry["attempts"])
+		assert.True(t, strings.HasSuffix(buf.String(), "}\n"))
+	})
+}
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	file, err := OpenRotatingFile(path, 10, 2)
+	require.NoError(t, err)
+
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		_, err := file.Write([]byte(line))
+		require.NoError(t, err)
+	}
+	require.NoError(t, file.Close())
+
+	assertFile := func(path, content string) {
+		t.Helper()
+		actual, err := os.ReadFile(path)
+		require.NoError(t, err)
+		assert.Equal(t, content, string(actual))
+	}
+	assertFile(path, "fourth\n")
+	assertFile(path+".1", "third\n")
+	assertFile(path+".2", "second\n")
+	assert.NoFileExists(t, path+".3")
+
+	t.Run("appends to an existing file", func(t *testing.T) {
+		file, err := OpenRotatingFile(path, 100, 2)
+		require.NoError(t, err)
+		_, err = file.Write([]byte("fifth\n"))
+		require.NoError(t, err)
+		require.NoError(t, file.Close())
+
+		assertFile(path, "fourth\nfifth\n")
+	})
+
+	t.Run("fails after close", func(t *testing.T) {
+		_, err := file.Write([]byte("closed\n"))
+
+		assert.ErrorIs(t, err, os.ErrClosed)
+	})
+}
+
+func TestConfig_CreateAccessLog(t *testing.T) {
+	tests := []struct {
+		name      string
+		accessLog string
+		err       string
+	}{
+		{"defaults", `{}`, ""},
+		{"combined", `{"format": "combined", "output": "stdout"}`, ""},
+		{"template", `{"format": "template", "template": "{{.Method}} {{.URI}}"}`, ""},
+		{"unknown format", `{"format": "xml"}`, `access_log: unknown format "xml", expected common, combined, json or template`},
+		{"template without template", `{"format": "template"}`, "access_log: no template set"},
+		{"template of other format", `{"format": "json", "template": "{{.URI}}"}`, "access_log: template is only used by the template format"},
+		{"invalid template", `{"format": "template", "template": "{{.URI"}`, "access_log: invalid template: template: access_log:1: unclosed action"},
+		{"negative size", `{"max_size_mb": -1}`, "access_log: max_size_mb and max_backups must not be negative"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := ReadConfigFromString(`{"routes": [], "access_log": ` + tt.accessLog + `}`)
+			require.NoError(t, err)
+
+			accessLog, err := config.CreateAccessLog()
+
+			if tt.err != "" {
+				assert.EqualError(t, err, tt.err)
+				assert.EqualError(t, config.Validate(), tt.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Same(t, os.Stdout, accessLog.Output)
+			assert.NoError(t, accessLog.Close())
+		})
+	}
+
+	t.Run("file output", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "access.log")
+		config, err := ReadConfigFromString(`{"routes": [], "access_log": {"output": "` + path + `", "max_size_mb": 1}}`)
+		require.NoError(t, err)
+
+		accessLog, err := config.CreateAccessLog()
+
+		require.NoError(t, err)
+		file := accessLog.Output.(*RotatingFile)
+		assert.Equal(t, int64(1<<20), file.MaxSize)
+		assert.Equal(t, 5, file.MaxBackups)
+		assert.NoError(t, accessLog.Close())
+		assert.FileExists(t, path)
+	})
+}
@@ -0,0 +1,321 @@
+package proxy
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// BuildInfo identifies the running build.
+type BuildInfo struct {
+	Version   string `json:"version"`
+	Revision  string `json:"revision"`
+	GoVersion string `json:"go_version"`
+}
+
+// Admin serves the admin API, which exposes the state of the proxy and
+// changes it at runtime. It's meant to be served on a separate, private
+// listener:
+//
+//	GET  /build               build info and uptime
+//	GET  /config              the running config, with secrets redacted
+//	POST /reload              reload the config file
+//	GET  /routes              the route table
+//	POST /routes/disable      disable the route given by ?path=
+//	POST /routes/enable       enable the route given by ?path=
+//	GET  /upstreams           upstream pools of the TCP and UDP proxies, and the
+//	                          upstreams of the forward handlers of the current routes
+//	POST /upstreams/drain     drain the upstream given by ?listen= and ?address=
+//	POST /upstreams/undrain   undo draining an upstream
+//	GET  /metrics             metrics in the Prometheus text format
+//	GET  /captures            requests recorded by capture handlers, newest first,
+//	                          optionally of the route given by ?route= and at most ?limit=
+//	GET  /captures/stream     requests as they are captured, as server-sent events
+type Admin struct {
+	Router    *ReloadableRouter
+	Reloader  *ConfigReloader
+	Captures  *Captures
+	BuildInfo BuildInfo
+	// Token, if set, must be sent by clients as "Authorization: Bearer <token>".
+	Token  string
+	Logger *slog.Logger
+
+	pools   []adminPool
+	started time.Time
+	mux     *http.ServeMux
+}
+
+type adminPool struct {
+	Protocol string `json:"protocol"`
+	Listen   string `json:"listen,omitempty"`
+	pool     *UpstreamPool
+}
+
+func NewAdmin(router *ReloadableRouter, reloader *ConfigReloader, buildInfo BuildInfo) *Admin {
+	a := &Admin{
+		Router:    router,
+		Reloader:  reloader,
+		Captures:  DefaultCaptures,
+		BuildInfo: buildInfo,
+		started:   time.Now(),
+		mux:       http.NewServeMux(),
+	}
+	a.mux.HandleFunc("GET /build", a.build)
+	a.mux.HandleFunc("GET /config", a.config)
+	a.mux.HandleFunc("POST /reload", a.reload)
+	a.mux.HandleFunc("GET /routes", a.routes)
+	a.mux.HandleFunc("POST /routes/disable", a.setRouteDisabled(true))
+	a.mux.HandleFunc("POST /routes/enable", a.setRouteDisabled(false))
+	a.mux.HandleFunc("GET /upstreams", a.upstreams)
+	a.mux.HandleFunc("POST /upstreams/drain", a.setUpstreamDraining(true))
+	a.mux.HandleFunc("POST /upstreams/undrain", a.setUpstreamDraining(false))
+	a.mux.Handle("GET /metrics", DefaultMetrics)
+	a.mux.HandleFunc("GET /captures", a.captures)
+	a.mux.HandleFunc("GET /captures/stream", a.streamCaptures)
+	return a
+}
+
+// AddPool exposes the upstream pool of a proxy listening on listen.
+func (a *Admin) AddPool(protocol, listen string, pool *UpstreamPool) {
+	a.pools = append(a.pools, adminPool{Protocol: protocol, Listen: listen, pool: pool})
+}
+
+// Handle adds a handler to the admin API, e.g. for profiling.
+func (a *Admin) Handle(pattern string, handler http.Handler) {
+	a.mux.Handle(pattern, handler)
+}
+
+func (a *Admin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !a.authorized(r) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	a.mux.ServeHTTP(w, r)
+}
+
+func (a *Admin) authorized(r *http.Request) bool {
+	if a.Token == "" {
+		return true
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(token), []byte(a.Token)) == 1
+}
+
+type adminBuildResponse struct {
+	BuildInfo
+	StartedAt     time.Time `json:"started_at"`
+	UptimeSeconds int64     `json:"uptime_seconds"`
+}
+
+func (a *Admin) build(w http.ResponseWriter, r *http.Request) {
+	a.writeJSON(w, r, adminBuildResponse{
+		BuildInfo:     a.BuildInfo,
+		StartedAt:     a.started,
+		UptimeSeconds: int64(time.Since(a.started).Seconds()),
+	})
+}
+
+func (a *Admin) config(w http.ResponseWriter, r *http.Request) {
+	a.writeJSON(w, r, a.Reloader.Running().Redacted())
+}
+
+func (a *Admin) reload(w http.ResponseWriter, r *http.Request) {
+	if err := a.Reloader.Reload(); err != nil {
+		a.logger().ErrorContext(r.Context(), "Config reload failed, keeping current routes", "error", err)
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	a.writeJSON(w, r, map[string]string{"status": "reloaded"})
+}
+
+type adminRoute struct {
+	Path     string `json:"path"`
+	Handler  string `json:"handler"`
+	Disabled bool   `json:"disabled"`
+}
+
+func (a *Admin) routes(w http.ResponseWriter, r *http.Request) {
+	config := a.Reloader.Config().Redacted()
+	routes := make([]adminRoute, len(config.Routes))
+	for i, route := range config.Routes {
+		routes[i] = adminRoute{
+			Path:     route.Matcher.Pattern(),
+			Handler:  route.Handler.Describe(),
+			Disabled: a.Router.RouteDisabled(route.Matcher.Pattern()),
+		}
+	}
+	a.writeJSON(w, r, routes)
+}
+
+func (a *Admin) setRouteDisabled(disabled bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		path := r.URL.Query().Get("path")
+		if err := a.Router.SetRouteDisabled(path, disabled); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		a.logger().InfoContext(r.Context(), "Route disabled", "path", path, "disabled", disabled)
+		a.writeJSON(w, r, adminRoute{Path: path, Disabled: disabled})
+	}
+}
+
+type adminPoolResponse struct {
+	adminPool
+	// Route is the pattern of the route of an HTTP forward handler.
+	Route     string          `json:"route,omitempty"`
+	Upstreams []UpstreamStats `json:"upstreams"`
+}
+
+func (a *Admin) upstreams(w http.ResponseWriter, r *http.Request) {
+	pools := make([]adminPoolResponse, len(a.pools))
+	for i, pool := range a.pools {
+		pools[i] = adminPoolResponse{adminPool: pool, Upstreams: pool.pool.Stats()}
+	}
+	a.writeJSON(w, r, append(pools, a.httpUpstreams()...))
+}
+
+// httpUpstreams returns the upstreams of the forward handlers of the current
+// routes. They aren't health checked, so they count as healthy, and their
+// active connections are the requests in flight.
+func (a *Admin) httpUpstreams() []adminPoolResponse {
+	router := a.Router.Router()
+	var pools []adminPoolResponse
+	for _, pattern := range slices.Sorted(maps.Keys(router.Routes)) {
+		for _, forward := range forwardHandlers(router.Routes[pattern]) {
+			pools = append(pools, adminPoolResponse{
+				adminPool: adminPool{Protocol: "http"},
+				Route:     pattern,
+				Upstreams: []UpstreamStats{{
+					Address:           forward.URL.Host,
+					Healthy:           true,
+					ActiveConnections: int64(upstreamInFlight.family.value(forward.URL.Host)),
+				}},
+			})
+		}
+	}
+	return pools
+}
+
+// forwardHandlers returns the forward handlers in the tree of h, following the
+// exported Handler and []Handler fields of the handlers wrapping others.
+func forwardHandlers(h Handler) []*ForwardHandler {
+	if forward, ok := h.(*ForwardHandler); ok {
+		return []*ForwardHandler{forward}
+	}
+	v := reflect.ValueOf(h)
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return nil
+	}
+	handlerType := reflect.TypeFor[Handler]()
+	var forwards []*ForwardHandler
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		switch field.Type {
+		case handlerType:
+			if child, ok := v.Field(i).Interface().(Handler); ok {
+				forwards = append(forwards, forwardHandlers(child)...)
+			}
+		case reflect.SliceOf(handlerType):
+			for _, child := range v.Field(i).Interface().([]Handler) {
+				forwards = append(forwards, forwardHandlers(child)...)
+			}
+		}
+	}
+	return forwards
+}
+
+func (a *Admin) setUpstreamDraining(draining bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		listen, address := r.URL.Query().Get("listen"), r.URL.Query().Get("address")
+		for _, pool := range a.pools {
+			if pool.Listen != listen {
+				continue
+			}
+			if upstream, ok := pool.pool.Upstream(address); ok {
+				upstream.SetDraining(draining)
+				a.logger().InfoContext(r.Context(), "Upstream draining", "listen", listen, "address", address, "draining", draining)
+				a.writeJSON(w, r, upstream.Stats())
+				return
+			}
+		}
+		http.Error(w, "Unknown upstream", http.StatusNotFound)
+	}
+}
+
+func (a *Admin) captures(w http.ResponseWriter, r *http.Request) {
+	var limit int
+	if value := r.URL.Query().Get("limit"); value != "" {
+		var err error
+		if limit, err = strconv.Atoi(value); err != nil || limit < 0 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+	entries := a.Captures.Entries(r.URL.Query().Get("route"), limit)
+	if entries == nil {
+		entries = []CaptureEntry{}
+	}
+	a.writeJSON(w, r, entries)
+}
+
+// streamCaptures sends entries as server-sent events until the client disconnects.
+func (a *Admin) streamCaptures(w http.ResponseWriter, r *http.Request) {
+	entries, cancel := a.Captures.Subscribe(r.URL.Query().Get("route"))
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	controller := http.NewResponseController(w)
+	if err := controller.Flush(); err != nil {
+		a.logger().ErrorContext(r.Context(), "Error streaming captures", "error", err)
+		return
+	}
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case entry := <-entries:
+			data, err := json.Marshal(entry)
+			if err != nil {
+				a.logger().ErrorContext(r.Context(), "Error encoding capture", "error", err)
+				return
+			}
+			if _, err := fmt.Fprintf(w, "id: %d\ndata: %s\n\n", entry.ID, data); err != nil {
+				return
+			}
+			if err := controller.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (a *Admin) writeJSON(w http.ResponseWriter, r *http.Request, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		a.logger().ErrorContext(r.Context(), "Error encoding response", "error", err)
+	}
+}
+
+func (a *Admin) logger() *slog.Logger {
+	return loggerOrDefault(a.Logger)
+}
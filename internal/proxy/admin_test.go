@@ -0,0 +1,266 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdmin(t *testing.T) {
+	// language=JSON
+	configJson := `{
+		"routes": [
+			{"matcher": {"path": "/hello"}, "handler": {"static": {"message": "Hello"}}},
+			{"matcher": {"path": "/"}, "handler": {"forward_proxy": {"auth": {"username": "user", "password": "secret"}}}}
+		]
+	}`
+
+	newAdmin := func(t *testing.T) (*Admin, *ReloadableRouter, string) {
+		path := writeConfigFile(t, configJson)
+		config := mustReadConfigFile(t, path)
+		router, err := config.CreateRouter()
+		require.NoError(t, err)
+		rr := NewReloadableRouter(router)
+		reloader := NewConfigReloader(path, config, rr)
+		admin := NewAdmin(rr, reloader, BuildInfo{Version: "1.2.3", Revision: "abc", GoVersion: "go1"})
+		return admin, rr, path
+	}
+
+	t.Run("route table", func(t *testing.T) {
+		admin, _, _ := newAdmin(t)
+
+		w := serveAdmin(admin, "GET", "/routes")
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		var routes []adminRoute
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &routes))
+		assert.Equal(t, []adminRoute{
+			{Path: "/hello", Handler: "static"},
+			{Path: "/", Handler: "forward_proxy"},
+		}, routes)
+	})
+
+	t.Run("effective config is redacted", func(t *testing.T) {
+		admin, _, _ := newAdmin(t)
+
+		w := serveAdmin(admin, "GET", "/config")
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), `"password":"[REDACTED]"`)
+		assert.NotContains(t, w.Body.String(), "secret")
+	})
+
+	t.Run("disable and enable a route", func(t *testing.T) {
+		admin, rr, _ := newAdmin(t)
+
+		w := serveAdmin(admin, "POST", "/routes/disable?path=/hello")
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		w = httptest.NewRecorder()
+		rr.ServeHTTP(w, newGetRequest("/hello"))
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+		assert.Contains(t, serveAdmin(admin, "GET", "/routes").Body.String(), `"disabled":true`)
+
+		w = serveAdmin(admin, "POST", "/routes/enable?path=/hello")
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		w = httptest.NewRecorder()
+		rr.ServeHTTP(w, newGetRequest("/hello"))
+		assert.Equal(t, "Hello", w.Body.String())
+	})
+
+	t.Run("disabling an unknown route fails", func(t *testing.T) {
+		admin, _, _ := newAdmin(t)
+
+		w := serveAdmin(admin, "POST", "/routes/disable?path=/unknown")
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("reload", func(t *testing.T) {
+		admin, rr, path := newAdmin(t)
+		// language=JSON
+		newConfig := `{"routes": [{"matcher": {"path": "/hello"}, "handler": {"static": {"message": "Reloaded"}}}]}`
+		require.NoError(t, os.WriteFile(path, []byte(newConfig), 0o644))
+
+		w := serveAdmin(admin, "POST", "/reload")
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		w = httptest.NewRecorder()
+		rr.ServeHTTP(w, newGetRequest("/hello"))
+		assert.Equal(t, "Reloaded", w.Body.String())
+
+		require.NoError(t, os.WriteFile(path, []byte(`{"routes": [{}]}`), 0o644))
+		w = serveAdmin(admin, "POST", "/reload")
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+		assert.Contains(t, w.Body.String(), "no handler set")
+	})
+
+	t.Run("reload errors are redacted", func(t *testing.T) {
+		t.Setenv("ADMIN_TEST_SECRET", "s3cr3t-value")
+		// language=JSON
+		path := writeConfigFile(t, `{"routes": [{"matcher": {"path": "/"}, "handler": {"static": {"message": "${secret:ADMIN_TEST_SECRET}"}}}]}`)
+		config := mustReadConfigFile(t, path)
+		router, err := config.CreateRouter()
+		require.NoError(t, err)
+		rr := NewReloadableRouter(router)
+		admin := NewAdmin(rr, NewConfigReloader(path, config, rr), BuildInfo{})
+		require.NoError(t, os.WriteFile(path, []byte(`{"s3cr3t-value": {}}`), 0o644))
+
+		w := serveAdmin(admin, "POST", "/reload")
+
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+		assert.Contains(t, w.Body.String(), `unknown field "[REDACTED]"`)
+		assert.NotContains(t, w.Body.String(), "s3cr3t-value")
+	})
+
+	t.Run("config shows sections requiring a restart as on start", func(t *testing.T) {
+		admin, _, path := newAdmin(t)
+		// language=JSON
+		newConfig := `{
+			"routes": [{"matcher": {"path": "/hello"}, "handler": {"static": {"message": "Reloaded"}}}],
+			"tcp": [{"listen": ":9000", "upstreams": ["localhost:9001"]}]
+		}`
+		require.NoError(t, os.WriteFile(path, []byte(newConfig), 0o644))
+		require.Equal(t, http.StatusOK, serveAdmin(admin, "POST", "/reload").Code)
+
+		w := serveAdmin(admin, "GET", "/config")
+
+		var config Config
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &config))
+		assert.Len(t, config.Routes, 1, "routes are reloaded")
+		assert.Empty(t, config.TCP, "tcp proxies aren't reloaded")
+	})
+
+	t.Run("upstreams and draining", func(t *testing.T) {
+		admin, _, _ := newAdmin(t)
+		pool := mustUpstreamPool(t, []string{"a:1", "b:1"})
+		admin.AddPool("tcp", ":9000", pool)
+
+		w := serveAdmin(admin, "POST", "/upstreams/drain?listen=:9000&address=b:1")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.True(t, pool.Upstreams[1].Draining())
+
+		w = serveAdmin(admin, "GET", "/upstreams")
+		var pools []struct {
+			Protocol  string          `json:"protocol"`
+			Listen    string          `json:"listen"`
+			Upstreams []UpstreamStats `json:"upstreams"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &pools))
+		require.Len(t, pools, 1)
+		assert.Equal(t, "tcp", pools[0].Protocol)
+		assert.Equal(t, ":9000", pools[0].Listen)
+		assert.False(t, pools[0].Upstreams[0].Draining)
+		assert.True(t, pools[0].Upstreams[1].Draining)
+
+		w = serveAdmin(admin, "POST", "/upstreams/undrain?listen=:9000&address=b:1")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.False(t, pool.Upstreams[1].Draining())
+
+		w = serveAdmin(admin, "POST", "/upstreams/drain?listen=:9000&address=c:1")
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("upstreams of forward handlers", func(t *testing.T) {
+		forward, err := NewForwardHandler("http://backend:8080")
+		require.NoError(t, err)
+		router := NewPathRouter()
+		router.AddRoute("/api/", &RetrierHandler{Handler: forward, RetryPolicy: &RetryOnNon2xxRetryPolicy{}})
+		router.AddRoute("/hello", &StaticHandler{message: "Hello"})
+		admin := NewAdmin(NewReloadableRouter(router), nil, BuildInfo{})
+
+		w := serveAdmin(admin, "GET", "/upstreams")
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		// language=JSON
+		assert.JSONEq(t, `[{
+			"protocol": "http",
+			"route": "/api/",
+			"upstreams": [{"address": "backend:8080", "healthy": true, "draining": false, "active_connections": 0, "bytes_sent": 0, "bytes_received": 0}]
+		}]`, w.Body.String())
+	})
+
+	t.Run("build info", func(t *testing.T) {
+		admin, _, _ := newAdmin(t)
+
+		w := serveAdmin(admin, "GET", "/build")
+
+		var build adminBuildResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &build))
+		assert.Equal(t, BuildInfo{Version: "1.2.3", Revision: "abc", GoVersion: "go1"}, build.BuildInfo)
+	})
+
+	t.Run("token is required if set", func(t *testing.T) {
+		admin, _, _ := newAdmin(t)
+		admin.Token = "t0ken"
+
+		w := serveAdmin(admin, "GET", "/routes")
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+		r := newGetRequest("/routes")
+		r.Header.Set("Authorization", "Bearer t0ken")
+		w = httptest.NewRecorder()
+		admin.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+}
+
+func TestConfig_CreateAdmin(t *testing.T) {
+	tests := []struct {
+		name    string
+		listen  string
+		token   string
+		wantErr string
+	}{
+		{name: "loopbackIPv4", listen: "127.0.0.1:9901"},
+		{name: "loopbackIPv6", listen: "[::1]:9901"},
+		{name: "localhost", listen: "localhost:9901"},
+		{name: "publicWithToken", listen: ":9901", token: "t0ken"},
+		{name: "allInterfaces", listen: ":9901", wantErr: "admin: a token is required to listen on :9901, which isn't a loopback address"},
+		{name: "publicIP", listen: "10.0.0.1:9901", wantErr: "admin: a token is required to listen on 10.0.0.1:9901, which isn't a loopback address"},
+		{name: "noListen", wantErr: "admin: no listen address set"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &Config{Admin: &AdminConfig{Listen: tt.listen, Token: tt.token}}
+
+			admin, err := config.CreateAdmin(nil, nil, BuildInfo{})
+
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				assert.EqualError(t, config.Validate(), tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.token, admin.Token)
+		})
+	}
+
+	t.Run("no admin section", func(t *testing.T) {
+		admin, err := (&Config{}).CreateAdmin(nil, nil, BuildInfo{})
+		assert.NoError(t, err)
+		assert.Nil(t, admin)
+	})
+}
+
+func TestReloadableRouter_DisabledRoutesOutliveReloads(t *testing.T) {
+	rr := NewReloadableRouter(newStaticRouter("/hello", "old"))
+	require.NoError(t, rr.SetRouteDisabled("/hello", true))
+
+	rr.Swap(newStaticRouter("/hello", "new"))
+
+	w := httptest.NewRecorder()
+	rr.ServeHTTP(w, newGetRequest("/hello"))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+}
+
+func serveAdmin(admin *Admin, method, target string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	admin.ServeHTTP(w, httptest.NewRequest(method, target, nil))
+	return w
+}
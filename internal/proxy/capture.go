@@ -0,0 +1,279 @@
+package proxy
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultRedactedHeaders are redacted from captures and recordings unless
+// configured otherwise.
+var DefaultRedactedHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"}
+
+// CaptureEntry is a captured request along with its response.
+type CaptureEntry struct {
+	ID        uint64    `json:"id"`
+	Time      time.Time `json:"time"`
+	Route     string    `json:"route"`
+	RequestID string    `json:"request_id,omitempty"`
+	// Handler describes the handlers which served the request, e.g. "retrier(retries=1) > forward(url=...)".
+	Handler string `json:"handler,omitempty"`
+
+	Method               string      `json:"method"`
+	URL                  string      `json:"url"`
+	Proto                string      `json:"proto"`
+	RemoteAddr           string      `json:"remote_addr"`
+	RequestHeader        http.Header `json:"request_header"`
+	RequestBody          string      `json:"request_body,omitempty"`
+	RequestBodyTruncated bool        `json:"request_body_truncated,omitempty"`
+
+	Status                int         `json:"status"`
+	ResponseHeader        http.Header `json:"response_header"`
+	ResponseBody          string      `json:"response_body,omitempty"`
+	ResponseBodyTruncated bool        `json:"response_body_truncated,omitempty"`
+
+	DurationMs float64  `json:"duration_ms"`
+	Upstreams  []string `json:"upstreams,omitempty"`
+	Attempts   int      `json:"attempts"`
+}
+
+// DefaultCaptures stores the requests captured by capture handlers.
+var DefaultCaptures = NewCaptures()
+
+// Captures keeps the most recent captured requests of every route in a
+// ring buffer, and passes new ones to subscribers.
+type Captures struct {
+	mu          sync.Mutex
+	lastID      uint64
+	rings       map[string]*captureRing
+	subscribers map[chan CaptureEntry]string
+}
+
+func NewCaptures() *Captures {
+	return &Captures{
+		rings:       make(map[string]*captureRing),
+		subscribers: make(map[chan CaptureEntry]string),
+	}
+}
+
+// add stores an entry of a route, keeping at most size entries of it.
+func (c *Captures) add(size int, entry CaptureEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lastID++
+	entry.ID = c.lastID
+	ring, ok := c.rings[entry.Route]
+	if !ok {
+		ring = &captureRing{}
+		c.rings[entry.Route] = ring
+	}
+	ring.add(size, entry)
+
+	for ch, route := range c.subscribers {
+		if route != "" && route != entry.Route {
+			continue
+		}
+		select {
+		case ch <- entry:
+		default:
+			// slow subscribers miss entries rather than slowing down requests
+		}
+	}
+}
+
+// Entries returns the captured entries of a route, or of all routes if it's
+// empty, newest first. A positive limit returns at most that many.
+func (c *Captures) Entries(route string, limit int) []CaptureEntry {
+	c.mu.Lock()
+	var entries []CaptureEntry
+	for ringRoute, ring := range c.rings {
+		if route == "" || route == ringRoute {
+			entries = append(entries, ring.entries()...)
+		}
+	}
+	c.mu.Unlock()
+
+	slices.SortFunc(entries, func(a, b CaptureEntry) int { return cmp.Compare(b.ID, a.ID) })
+	if limit > 0 && len(entries) > limit {
+		entries = entries[:limit]
+	}
+	return entries
+}
+
+// dropRoutes drops the entries of routes, like those removed by a reload.
+func (c *Captures) dropRoutes(routes []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, route := range routes {
+		delete(c.rings, route)
+	}
+}
+
+// Subscribe returns a channel receiving entries of a route, or of all routes
+// if it's empty, as they are captured. cancel must be called to stop receiving.
+func (c *Captures) Subscribe(route string) (entries <-chan CaptureEntry, cancel func()) {
+	ch := make(chan CaptureEntry, 64)
+	c.mu.Lock()
+	c.subscribers[ch] = route
+	c.mu.Unlock()
+	return ch, func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		delete(c.subscribers, ch)
+	}
+}
+
+// captureRing holds the most recent entries of a route in a fixed number of
+// slots, overwriting the oldest entry once all are used.
+type captureRing struct {
+	slots []CaptureEntry
+	// next is the slot of the next entry, count the number of slots used
+	next  int
+	count int
+}
+
+func (r *captureRing) add(size int, entry CaptureEntry) {
+	if size <= 0 {
+		*r = captureRing{}
+		return
+	}
+	if size != len(r.slots) {
+		r.resize(size)
+	}
+	r.slots[r.next] = entry
+	r.next = (r.next + 1) % size
+	r.count = min(r.count+1, size)
+}
+
+// entries returns the entries, oldest first.
+func (r *captureRing) entries() []CaptureEntry {
+	entries := make([]CaptureEntry, 0, r.count)
+	for i := r.next - r.count; i < r.next; i++ {
+		entries = append(entries, r.slots[(i+len(r.slots))%len(r.slots)])
+	}
+	return entries
+}
+
+// resize changes the number of slots, e.g. after a reload changed the size
+// of the route, keeping the most recent entries.
+func (r *captureRing) resize(size int) {
+	entries := r.entries()
+	entries = entries[max(0, len(entries)-size):]
+	r.slots = make([]CaptureEntry, size)
+	r.count = copy(r.slots, entries)
+	r.next = r.count % size
+}
+
+// CaptureHandler records the requests served by its handler, with bodies
+// truncated to MaxBodySize and the values of Redact headers replaced.
+type CaptureHandler struct {
+	Handler  Handler
+	Captures *Captures
+	// Size is the number of entries kept for the route.
+	Size        int
+	MaxBodySize int
+	Redact      []string
+	// Description describes Handler in entries.
+	Description string
+}
+
+func (h *CaptureHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	ctx := r.Context()
+	// upstreams and attempts are collected like for the access log
+	details := detailsFromContext(ctx)
+	if details == nil {
+		details = &requestDetails{}
+		r = requestWithContext(r, context.WithValue(ctx, requestDetailsKey{}, details))
+	}
+	entry := CaptureEntry{
+		Time:          start,
+		Route:         routeFromContext(ctx),
+		RequestID:     requestIDFromContext(ctx),
+		Handler:       h.Description,
+		Method:        r.Method,
+		URL:           r.URL.String(),
+		Proto:         r.Proto,
+		RemoteAddr:    r.RemoteAddr,
+		RequestHeader: redactHeader(r.Header, h.Redact),
+	}
+	body := &captureReader{ReadCloser: r.Body, captureBuffer: captureBuffer{limit: h.MaxBodySize}}
+	if r.Body != nil && r.Body != http.NoBody {
+		r.Body = body
+	}
+	cw := &captureWriter{statusWriter: statusWriter{ResponseWriter: w}, captureBuffer: captureBuffer{limit: h.MaxBodySize}}
+
+	h.Handler.ServeHTTP(cw, r)
+
+	entry.RequestBody, entry.RequestBodyTruncated = body.buf.String(), body.truncated
+	entry.Status = cw.status()
+	entry.ResponseHeader = redactHeader(w.Header(), h.Redact)
+	entry.ResponseBody, entry.ResponseBodyTruncated = cw.buf.String(), cw.truncated
+	entry.DurationMs = float64(time.Since(start).Microseconds()) / 1000
+	var accessLogEntry AccessLogEntry
+	details.fill(&accessLogEntry)
+	entry.Upstreams = slices.Clone(accessLogEntry.Upstreams)
+	entry.Attempts = accessLogEntry.Attempts
+	h.Captures.add(h.Size, entry)
+}
+
+func (h *CaptureHandler) Close() error {
+	return closeHandler(h.Handler)
+}
+
+// redactHeader copies header, replacing the values of the headers named by redact.
+func redactHeader(header http.Header, redact []string) http.Header {
+	copied := header.Clone()
+	if copied == nil {
+		copied = http.Header{}
+	}
+	for name := range copied {
+		if slices.ContainsFunc(redact, func(redact string) bool { return strings.EqualFold(redact, name) }) {
+			copied[name] = []string{redacted}
+		}
+	}
+	return copied
+}
+
+// captureBuffer keeps the first limit bytes written to it.
+type captureBuffer struct {
+	buf       bytes.Buffer
+	limit     int
+	truncated bool
+}
+
+func (b *captureBuffer) capture(p []byte) {
+	if remaining := b.limit - b.buf.Len(); remaining < len(p) {
+		p = p[:max(remaining, 0)]
+		b.truncated = true
+	}
+	b.buf.Write(p)
+}
+
+type captureReader struct {
+	io.ReadCloser
+	captureBuffer
+}
+
+func (r *captureReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.capture(p[:n])
+	return n, err
+}
+
+type captureWriter struct {
+	statusWriter
+	captureBuffer
+}
+
+func (w *captureWriter) Write(p []byte) (int, error) {
+	n, err := w.statusWriter.Write(p)
+	w.capture(p[:n])
+	return n, err
+}
@@ -0,0 +1,221 @@
+package proxy
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCaptureHandler(t *testing.T) {
+	captures := NewCaptures()
+	router := NewPathRouter()
+	router.AddRoute("/echo", &CaptureHandler{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			w.Header().Set("Set-Cookie", "session=secret")
+			w.WriteHeader(http.StatusCreated)
+			_, _ = w.Write(body)
+		}),
+		Captures:    captures,
+		Size:        2,
+		MaxBodySize: 5,
+		Redact:      DefaultRedactedHeaders,
+		Description: "echo",
+	})
+
+	t.Run("captures request and response", func(t *testing.T) {
+		r := httptest.NewRequest("POST", "/echo?q=1", strings.NewReader("hello world"))
+		r.Header.Set("Authorization", "Bearer secret")
+		r.Header.Set("Accept", "text/plain")
+
+		router.ServeHTTP(httptest.NewRecorder(), r)
+
+		entries := captures.Entries("/echo", 0)
+		require.Len(t, entries, 1)
+		entry := entries[0]
+		assert.Equal(t, "/echo", entry.Route)
+		assert.Equal(t, "echo", entry.Handler)
+		assert.Equal(t, "POST", entry.Method)
+		assert.Equal(t, "/echo?q=1", entry.URL)
+		assert.Equal(t, []string{"[REDACTED]"}, entry.RequestHeader["Authorization"])
+		assert.Equal(t, []string{"text/plain"}, entry.RequestHeader["Accept"])
+		assert.Equal(t, "hello", entry.RequestBody)
+		assert.True(t, entry.RequestBodyTruncated)
+		assert.Equal(t, http.StatusCreated, entry.Status)
+		assert.Equal(t, []string{"[REDACTED]"}, entry.ResponseHeader["Set-Cookie"])
+		assert.Equal(t, "hello", entry.ResponseBody)
+		assert.True(t, entry.ResponseBodyTruncated)
+		assert.Equal(t, 1, entry.Attempts)
+	})
+
+	t.Run("keeps the most recent entries", func(t *testing.T) {
+		for _, path := range []string{"/echo?n=2", "/echo?n=3", "/echo?n=4"} {
+			router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+		}
+
+		entries := captures.Entries("", 0)
+
+		require.Len(t, entries, 2)
+		assert.Equal(t, "/echo?n=4", entries[0].URL)
+		assert.Equal(t, "/echo?n=3", entries[1].URL)
+		assert.Len(t, captures.Entries("/echo", 1), 1)
+		assert.Empty(t, captures.Entries("/other", 0))
+	})
+}
+
+func TestCaptureRing(t *testing.T) {
+	urls := func(r *captureRing) []string {
+		var urls []string
+		for _, entry := range r.entries() {
+			urls = append(urls, entry.URL)
+		}
+		return urls
+	}
+	ring := &captureRing{}
+	for _, url := range []string{"1", "2", "3", "4"} {
+		ring.add(3, CaptureEntry{URL: url})
+	}
+	assert.Equal(t, []string{"2", "3", "4"}, urls(ring))
+
+	ring.add(2, CaptureEntry{URL: "5"})
+	assert.Equal(t, []string{"4", "5"}, urls(ring), "Expected the most recent entries to be kept when shrinking")
+
+	ring.add(4, CaptureEntry{URL: "6"})
+	ring.add(4, CaptureEntry{URL: "7"})
+	assert.Equal(t, []string{"4", "5", "6", "7"}, urls(ring))
+
+	ring.add(0, CaptureEntry{URL: "8"})
+	assert.Empty(t, urls(ring))
+}
+
+func TestCaptures_dropRoutes(t *testing.T) {
+	t.Run("drops entries of routes removed by a reload", func(t *testing.T) {
+		// language=JSON
+		path := writeConfigFile(t, `{"routes": [
+			{"matcher": {"path": "/kept-by-reload"}, "handler": {"static": {}}},
+			{"matcher": {"path": "/removed-by-reload"}, "handler": {"static": {}}}
+		]}`)
+		config := mustReadConfigFile(t, path)
+		router, err := config.CreateRouter()
+		require.NoError(t, err)
+		reloader := NewConfigReloader(path, config, NewReloadableRouter(router))
+		t.Cleanup(func() {
+			DefaultCaptures.dropRoutes([]string{"/kept-by-reload", "/removed-by-reload"})
+		})
+		DefaultCaptures.add(10, CaptureEntry{Route: "/kept-by-reload"})
+		DefaultCaptures.add(10, CaptureEntry{Route: "/removed-by-reload"})
+
+		// language=JSON
+		newConfig := `{"routes": [{"matcher": {"path": "/kept-by-reload"}, "handler": {"static": {}}}]}`
+		require.NoError(t, os.WriteFile(path, []byte(newConfig), 0o644))
+		require.NoError(t, reloader.Reload())
+
+		assert.Eventually(t, func() bool {
+			return len(DefaultCaptures.Entries("/removed-by-reload", 0)) == 0
+		}, time.Second, time.Millisecond)
+		assert.Len(t, DefaultCaptures.Entries("/kept-by-reload", 0), 1)
+	})
+}
+
+func TestConfig_capture(t *testing.T) {
+	t.Run("records upstreams and attempts", func(t *testing.T) {
+		upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+		defer upstream.Close()
+		// language=JSON
+		config, err := ReadConfigFromString(`{"routes": [{"matcher": {"path": "/captured-retry"}, "handler": {"capture": {"handler":
+			{"retrier": {"retries": 1, "handler": {"forward": {"url": "` + upstream.URL + `"}}}}
+		}}}]}`)
+		require.NoError(t, err)
+		router, err := config.CreateRouter()
+		require.NoError(t, err)
+		defer router.Close()
+
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/captured-retry", nil))
+
+		entries := DefaultCaptures.Entries("/captured-retry", 1)
+		require.Len(t, entries, 1)
+		assert.Equal(t, http.StatusServiceUnavailable, entries[0].Status)
+		assert.Equal(t, 2, entries[0].Attempts)
+		assert.Equal(t, []string{strings.TrimPrefix(upstream.URL, "http://")}, entries[0].Upstreams)
+		assert.Equal(t, "retrier(retries=1) > forward(url="+upstream.URL+")", entries[0].Handler)
+	})
+
+	t.Run("rejects negative sizes", func(t *testing.T) {
+		config, err := ReadConfigFromString(`{"routes": [{"matcher": {"path": "/"}, "handler": {"capture": {"size": -1, "handler": {"debug": {}}}}}]}`)
+		require.NoError(t, err)
+
+		assert.EqualError(t, config.Validate(), "routes[0].handler.capture: size and max_body_bytes must not be negative")
+	})
+}
+
+func TestAdmin_captures(t *testing.T) {
+	captures := NewCaptures()
+	admin := NewAdmin(nil, nil, BuildInfo{})
+	admin.Captures = captures
+	captures.add(10, CaptureEntry{Route: "/a", URL: "/a?n=1"})
+	captures.add(10, CaptureEntry{Route: "/b", URL: "/b?n=1"})
+	captures.add(10, CaptureEntry{Route: "/a", URL: "/a?n=2"})
+
+	t.Run("lists entries of a route", func(t *testing.T) {
+		w := serveAdmin(admin, "GET", "/captures?route=/a&limit=1")
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		var entries []CaptureEntry
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &entries))
+		require.Len(t, entries, 1)
+		assert.Equal(t, "/a?n=2", entries[0].URL)
+	})
+
+	t.Run("lists no entries", func(t *testing.T) {
+		w := serveAdmin(admin, "GET", "/captures?route=/none")
+
+		assert.Equal(t, "[]\n", w.Body.String())
+	})
+
+	t.Run("rejects an invalid limit", func(t *testing.T) {
+		w := serveAdmin(admin, "GET", "/captures?limit=x")
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("streams new entries", func(t *testing.T) {
+		server := httptest.NewServer(admin)
+		defer server.Close()
+		resp, err := http.Get(server.URL + "/captures/stream?route=/b")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+		captures.add(10, CaptureEntry{Route: "/a", URL: "/a?n=3"})
+		captures.add(10, CaptureEntry{Route: "/b", URL: "/b?n=2"})
+
+		events := make(chan string)
+		go func() {
+			scanner := bufio.NewScanner(resp.Body)
+			for scanner.Scan() {
+				if data, ok := strings.CutPrefix(scanner.Text(), "data: "); ok {
+					events <- data
+				}
+			}
+		}()
+		select {
+		case data := <-events:
+			var entry CaptureEntry
+			require.NoError(t, json.Unmarshal([]byte(data), &entry))
+			assert.Equal(t, "/b?n=2", entry.URL)
+		case <-time.After(5 * time.Second):
+			t.Fatal("no entry streamed")
+		}
+	})
+}
@@ -2,12 +2,51 @@ package proxy
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net"
+	"net/url"
+	"os"
+	"proxy-server/internal/server"
 	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"time"
 )
 
 type Config struct {
-	Routes []RouteConfig `json:"routes"`
+	// Schema is the URL of a JSON Schema of the file for editors, it's ignored.
+	Schema string `json:"$schema,omitempty"`
+	// Include lists config files merged into this one, see ReadConfigFile.
+	Include []string      `json:"include,omitempty"` // e.g., ["routes/*.yaml"]
+	Server  *ServerConfig `json:"server,omitempty"`
+	// Handlers are named handler definitions, which routes can reference.
+	Handlers map[string]HandlerConfig `json:"handlers,omitempty"`
+	Routes   []RouteConfig            `json:"routes"`
+	TCP      []TCPProxyConfig         `json:"tcp,omitempty"`
+	UDP      []UDPProxyConfig         `json:"udp,omitempty"`
+	SOCKS5   *SOCKS5Config            `json:"socks5,omitempty"`
+	Admin    *AdminConfig             `json:"admin,omitempty"`
+	Tracing  *TracingConfig           `json:"tracing,omitempty"`
+	// AccessLog enables logging every request, see AccessLogConfig.
+	AccessLog *AccessLogConfig `json:"access_log,omitempty"`
+	// Log configures the process log, see LogConfig.
+	Log *LogConfig `json:"log,omitempty"`
+	// RequestID assigns requests IDs to correlate logs, see RequestIDConfig.
+	RequestID *RequestIDConfig `json:"request_id,omitempty"`
+
+	// loggers are used by everything created from the config, see SetLoggers
+	loggers *Loggers
+	// secrets are values read from secret files or ${secret:NAME}, see interpolate
+	secrets []string
+	// files holds the content of the files the config was read from by path
+	files map[string][]byte
+	// globs are the absolute include patterns, which may match more files later
+	globs []string
 }
 
 func ReadConfigFromString(jsonString string) (*Config, error) {
@@ -15,111 +54,428 @@ func ReadConfigFromString(jsonString string) (*Config, error) {
 }
 
 func ReadConfigFromBytes(jsonBytes []byte) (*Config, error) {
-	config := Config{}
-	err := json.Unmarshal(jsonBytes, &config)
+	return decodeConfig(jsonBytes, func(err error) error {
+		return locateJSONError(jsonBytes, err)
+	})
+}
+
+// decodeConfig decodes a JSON config, rejecting unknown fields, and resolves
+// references in its values. locate attaches the position in the source to
+// JSON decoding errors.
+func decodeConfig(jsonBytes []byte, locate func(error) error) (*Config, error) {
+	i := newInterpolator()
+	jsonBytes, locate = i.interpolateDocument(jsonBytes, locate)
+	config, err := unmarshalConfig(jsonBytes, locate)
 	if err != nil {
+		return nil, (&Config{secrets: i.secrets}).RedactError(err)
+	}
+	if err := config.interpolate(i); err != nil {
+		return nil, config.RedactError(err)
+	}
+	return config, nil
+}
+
+func unmarshalConfig(jsonBytes []byte, locate func(error) error) (*Config, error) {
+	config := Config{}
+	if err := json.Unmarshal(jsonBytes, &config); err != nil {
+		return nil, locate(err)
+	}
+	if err := decodeFields(jsonBytes, reflect.ValueOf(&config), locate); err != nil {
 		return nil, err
 	}
 	return &config, nil
 }
 
+// ServerConfig configures the HTTP server, unset fields keep their defaults.
+type ServerConfig struct {
+	Listen            []string `json:"listen,omitempty"`              // e.g., [":8080", ":8081"]
+	ReadTimeout       string   `json:"read_timeout,omitempty"`        // e.g., "15s"
+	ReadHeaderTimeout string   `json:"read_header_timeout,omitempty"` // e.g., "5s"
+	WriteTimeout      string   `json:"write_timeout,omitempty"`       // e.g., "15s"
+	IdleTimeout       string   `json:"idle_timeout,omitempty"`        // e.g., "60s"
+	MaxHeaderBytes    int      `json:"max_header_bytes,omitempty"`
+	ShutdownTimeout   string   `json:"shutdown_timeout,omitempty"` // e.g., "30s"
+	KeepAlive         *bool    `json:"keep_alive,omitempty"`
+}
+
+// ApplyTo overrides the settings of serverConfig which are set in the configuration.
+func (c *ServerConfig) ApplyTo(serverConfig *server.Config) error {
+	if len(c.Listen) > 0 {
+		serverConfig.Port = c.Listen[0]
+		serverConfig.Listeners = c.Listen[1:]
+	}
+
+	durations := []struct {
+		name   string
+		value  string
+		target *time.Duration
+	}{
+		{"read timeout", c.ReadTimeout, &serverConfig.ReadTimeout},
+		{"read header timeout", c.ReadHeaderTimeout, &serverConfig.ReadHeaderTimeout},
+		{"write timeout", c.WriteTimeout, &serverConfig.WriteTimeout},
+		{"idle timeout", c.IdleTimeout, &serverConfig.IdleTimeout},
+		{"shutdown timeout", c.ShutdownTimeout, &serverConfig.ShutdownTimeout},
+	}
+	for _, d := range durations {
+		duration, err := parseDuration(d.value, *d.target)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %w", d.name, err)
+		}
+		if duration < 0 {
+			return fmt.Errorf("%s must not be negative", d.name)
+		}
+		*d.target = duration
+	}
+
+	if c.MaxHeaderBytes < 0 {
+		return fmt.Errorf("max header bytes must not be negative")
+	}
+	if c.MaxHeaderBytes > 0 {
+		serverConfig.MaxHeaderBytes = c.MaxHeaderBytes
+	}
+	if c.KeepAlive != nil {
+		serverConfig.DisableKeepAlives = !*c.KeepAlive
+	}
+	return nil
+}
+
+// CreateServerConfig creates the HTTP server settings from the configuration
+func (c *Config) CreateServerConfig() (*server.Config, error) {
+	serverConfig := server.DefaultConfig()
+	if c.Server == nil {
+		return serverConfig, nil
+	}
+	if err := c.Server.ApplyTo(serverConfig); err != nil {
+		return nil, fmt.Errorf("invalid server config: %w", err)
+	}
+	return serverConfig, nil
+}
+
 type RouteConfig struct {
-	Matcher MatcherConfig `json:"matcher"`
-	Handler HandlerConfig `json:"handler"`
+	Matcher MatcherConfig `json:"matcher" schema:"required"`
+	Handler HandlerConfig `json:"handler" schema:"required"`
 }
 
+// MatcherConfig selects the requests of a route, by Path or by GRPC.
 type MatcherConfig struct {
-	Path string `json:"path"`
+	Path string             `json:"path" schema:"one_of"`
+	GRPC *GRPCMatcherConfig `json:"grpc" schema:"one_of"`
+}
+
+// GRPCMatcherConfig matches gRPC calls of a service, or only those of one
+// of its methods if Method is set.
+type GRPCMatcherConfig struct {
+	Service string `json:"service" schema:"required"`
+	Method  string `json:"method"`
+}
+
+// Pattern returns the pattern the route is registered with. gRPC calls are
+// routed by their /package.Service/Method path.
+func (c *MatcherConfig) Pattern() string {
+	if c.GRPC != nil {
+		return "/" + c.GRPC.Service + "/" + c.GRPC.Method
+	}
+	return c.Path
+}
+
+// createHandler restricts handler to the requests matched beyond the
+// pattern, like the content type of gRPC calls.
+func (c *MatcherConfig) createHandler(handler Handler) (Handler, error) {
+	if c.GRPC == nil {
+		return handler, nil
+	}
+	if c.Path != "" {
+		return nil, fmt.Errorf("path and grpc can't both be set")
+	}
+	predicate, err := NewGRPCPredicate(c.GRPC.Service, c.GRPC.Method)
+	if err != nil {
+		return nil, err
+	}
+	return &predicateHandler{Predicate: predicate, Handler: handler}, nil
 }
 
+// HandlerConfig configures a handler, exactly one handler type must be set.
+// Handler types are registered with RegisterHandlerType, like the built-in
+// ones, and are selected by their name, e.g. {"static": {"message": "Hi"}}.
 type HandlerConfig struct {
-	Static   *StaticHandlerConfig   `json:"static"`
-	Forward  *ForwardHandlerConfig  `json:"forward"`
-	Debug    *DebugHandlerConfig    `json:"debug"`
-	Echo     *EchoHandlerConfig     `json:"echo"`
-	NotFound *NotFoundHandlerConfig `json:"not_found"`
-	Chaos    *ChaosHandlerConfig    `json:"chaos"`
-	Fanout   *FanOutHandlerConfig   `json:"fanout"`
-	Retrier  *RetrierHandlerConfig  `json:"retrier"`
+	// Configs holds the decoded configs of the handler types by name.
+	Configs map[string]any `json:"-" config:"inline"`
 }
 
-func (h *HandlerConfig) createHandler() (Handler, error) {
-	val := reflect.ValueOf(*h)
+// handlerConfig is implemented by the config of every built-in handler type
+type handlerConfig interface {
+	createHandler(b *HandlerBuilder) (Handler, error)
+	describe() string
+}
 
-	typeToConfig := make(map[string]any, val.NumField())
-	for i := 0; i < val.NumField(); i++ {
-		field := val.Field(i)
-		if !field.IsNil() {
-			typeToConfig[val.Type().Field(i).Name] = field.Interface()
-		}
+// Describe returns a short description of the configured handler tree,
+// e.g. "retrier(retries=1) > chaos(failure_chance=0.3) > static".
+func (h *HandlerConfig) Describe() string {
+	handlerType, config, err := h.selected()
+	if err != nil {
+		return fmt.Sprintf("invalid(%v)", err)
 	}
+	return handlerType.describe(config)
+}
 
-	if len(typeToConfig) == 0 {
-		return nil, fmt.Errorf("no handler set")
+// MarshalJSON encodes the configs of the handler types which are set.
+func (h HandlerConfig) MarshalJSON() ([]byte, error) {
+	if h.Configs == nil {
+		return []byte("{}"), nil
 	}
-	if len(typeToConfig) > 1 {
-		names := make([]string, len(typeToConfig))
-		for name := range typeToConfig {
-			names = append(names, name)
-		}
-		return nil, fmt.Errorf("exactly one handler must be set, got %v", names)
+	return json.Marshal(h.Configs)
+}
+
+// selected returns the single handler type which is set, along with its config
+func (h *HandlerConfig) selected() (*HandlerType, any, error) {
+	names := slices.Sorted(maps.Keys(h.Configs))
+	if len(names) == 0 {
+		return nil, nil, fmt.Errorf("no handler set")
+	}
+	if len(names) > 1 {
+		return nil, nil, fmt.Errorf("exactly one handler must be set, got %v", names)
+	}
+	handlerType, ok := lookupHandlerType(names[0])
+	if !ok {
+		return nil, nil, fmt.Errorf("unknown handler type %q", names[0])
+	}
+	return handlerType, h.Configs[names[0]], nil
+}
+
+// HandlerRef is the name of a handler defined in the top-level handlers. All
+// references to a name share a single handler instance.
+type HandlerRef string
+
+func (r *HandlerRef) createHandler(b *HandlerBuilder) (Handler, error) {
+	return b.named(string(*r))
+}
+
+func (r *HandlerRef) describe() string {
+	return fmt.Sprintf("ref(%s)", string(*r))
+}
+
+// HandlerBuilder creates the handlers of a single router, and is passed to
+// handler types to create the handlers they wrap. Named handlers are created
+// once on their first reference and shared by all later ones.
+type HandlerBuilder struct {
+	logger      *slog.Logger
+	definitions map[string]HandlerConfig
+	instances   map[string]Handler
+	// failed holds the named handlers which failed to be created, so their
+	// errors are reported once instead of at every reference
+	failed map[string]bool
+	// resolving holds the names currently being created, to detect cycles
+	resolving []string
+	// path is the JSON path of the handler currently being created
+	path []string
+	// route is the pattern of the route whose handler is being created
+	route string
+}
+
+func newHandlerBuilder(definitions map[string]HandlerConfig, logger *slog.Logger) *HandlerBuilder {
+	return &HandlerBuilder{
+		logger:      logger,
+		definitions: definitions,
+		instances:   make(map[string]Handler),
+		failed:      make(map[string]bool),
+	}
+}
+
+// Logger returns the logger handlers should log with.
+func (b *HandlerBuilder) Logger() *slog.Logger {
+	return loggerOrDefault(b.logger)
+}
+
+// Create creates the handler configured by config, which is found at the
+// JSON field name of the handler config currently being created, e.g.
+// "handler". Errors are reported at the path of the handler they occurred in.
+func (b *HandlerBuilder) Create(name string, config *HandlerConfig) (Handler, error) {
+	b.path = append(b.path, name)
+	defer func() { b.path = b.path[:len(b.path)-1] }()
+
+	handlerType, selected, err := config.selected()
+	if err != nil {
+		return nil, atConfigPath(strings.Join(b.path, "."), err)
+	}
+
+	b.path = append(b.path, handlerType.Name)
+	defer func() { b.path = b.path[:len(b.path)-1] }()
+
+	handler, err := handlerType.Create(selected, b)
+	if err != nil {
+		return nil, atConfigPath(strings.Join(b.path, "."), err)
+	}
+	return handler, nil
+}
+
+// createRoute creates the handler of the route at index i.
+func (b *HandlerBuilder) createRoute(i int, route *RouteConfig) (Handler, error) {
+	b.path = []string{fmt.Sprintf("routes[%d]", i)}
+	b.route = route.Matcher.Pattern()
+	return b.Create("handler", &route.Handler)
+}
+
+// location identifies the handler being created by where it's configured,
+// e.g. "/api" for the handler of route /api or "handlers.limited" for a
+// named one. Unlike its JSON path, it's kept when routes are reordered.
+func (b *HandlerBuilder) location() string {
+	// the handler type is left out, it's part of the config being created
+	path := slices.Clone(b.path[:max(len(b.path)-1, 0)])
+	if len(path) > 0 && strings.HasPrefix(path[0], "routes[") {
+		path[0] = b.route
+	}
+	if len(path) > 1 && path[len(path)-1] == "handler" {
+		path = path[:len(path)-1]
 	}
+	return strings.Join(path, ".")
+}
 
-	for _, config := range typeToConfig {
-		return config.(interface{ createHandler() (Handler, error) }).createHandler()
+func (b *HandlerBuilder) named(name string) (Handler, error) {
+	if handler, ok := b.instances[name]; ok {
+		return handler, nil
+	}
+	definition, ok := b.definitions[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown handler %q", name)
+	}
+	if b.failed[name] {
+		return nil, fmt.Errorf("handler %q is invalid", name)
+	}
+	if slices.Contains(b.resolving, name) {
+		return nil, fmt.Errorf("handler reference cycle: %s > %s", strings.Join(b.resolving, " > "), name)
 	}
-	return nil, fmt.Errorf("unreachable state error")
+
+	path := b.path
+	b.path = []string{"handlers"}
+	b.resolving = append(b.resolving, name)
+	handler, err := b.Create(name, &definition)
+	b.resolving = b.resolving[:len(b.resolving)-1]
+	b.path = path
+	if err != nil {
+		b.failed[name] = true
+		return nil, err
+	}
+
+	shared := &sharedHandler{Handler: handler}
+	b.instances[name] = shared
+	return shared, nil
+}
+
+// sharedHandler is a named handler, which may be part of several routes but
+// must be closed only once.
+type sharedHandler struct {
+	Handler
+	closeOnce sync.Once
+	closeErr  error
+}
+
+func (h *sharedHandler) Close() error {
+	h.closeOnce.Do(func() {
+		h.closeErr = closeHandler(h.Handler)
+	})
+	return h.closeErr
 }
 
 type StaticHandlerConfig struct {
 	Message string `json:"message"`
 }
 
-func (c *StaticHandlerConfig) createHandler() (Handler, error) {
-	return &StaticHandler{message: c.Message}, nil
+func (c *StaticHandlerConfig) createHandler(b *HandlerBuilder) (Handler, error) {
+	return &StaticHandler{message: c.Message, Logger: b.logger}, nil
+}
+
+func (c *StaticHandlerConfig) describe() string {
+	return "static"
 }
 
 type ForwardHandlerConfig struct {
-	URL     string `json:"url"`
-	Timeout string `json:"timeout,omitempty"` // e.g., "30s"
+	URL           string `json:"url" schema:"required"`
+	Timeout       string `json:"timeout,omitempty"`        // e.g., "30s", streams are only bounded until their header arrives
+	FlushInterval string `json:"flush_interval,omitempty"` // e.g., "100ms", negative flushes after every write
+	HTTP2         bool   `json:"http2,omitempty"`          // forward over HTTP/2 only, required for gRPC upstreams
 }
 
-func (c *ForwardHandlerConfig) createHandler() (Handler, error) {
-	return NewForwardHandler(c.URL)
+func (c *ForwardHandlerConfig) createHandler(b *HandlerBuilder) (Handler, error) {
+	handler, err := NewForwardHandler(c.URL)
+	if err != nil {
+		return nil, err
+	}
+	handler.Timeout, err = parseDuration(c.Timeout, handler.Timeout)
+	if err != nil {
+		return nil, fmt.Errorf("invalid timeout for forward: %w", err)
+	}
+	handler.FlushInterval, err = parseDuration(c.FlushInterval, 0)
+	if err != nil {
+		return nil, fmt.Errorf("invalid flush interval for forward: %w", err)
+	}
+	if c.HTTP2 {
+		handler.Client.Transport = NewHTTP2Transport()
+	}
+	handler.Logger = b.logger
+	return handler, nil
+}
+
+func (c *ForwardHandlerConfig) describe() string {
+	return fmt.Sprintf("forward(url=%s)", c.URL)
 }
 
 type DebugHandlerConfig struct {
 }
 
-func (c *DebugHandlerConfig) createHandler() (Handler, error) {
-	return &DebugHandler{}, nil
+func (c *DebugHandlerConfig) createHandler(b *HandlerBuilder) (Handler, error) {
+	return &DebugHandler{Logger: b.logger}, nil
+}
+
+func (c *DebugHandlerConfig) describe() string {
+	return "debug"
 }
 
 type EchoHandlerConfig struct {
 }
 
-func (c *EchoHandlerConfig) createHandler() (Handler, error) {
-	return &EchoHandler{}, nil
+func (c *EchoHandlerConfig) createHandler(b *HandlerBuilder) (Handler, error) {
+	return &EchoHandler{Logger: b.logger}, nil
+}
+
+func (c *EchoHandlerConfig) describe() string {
+	return "echo"
 }
 
 type NotFoundHandlerConfig struct {
 }
 
-func (c *NotFoundHandlerConfig) createHandler() (Handler, error) {
-	return &NotFoundHandler{}, nil
+func (c *NotFoundHandlerConfig) createHandler(b *HandlerBuilder) (Handler, error) {
+	return &NotFoundHandler{Logger: b.logger}, nil
+}
+
+func (c *NotFoundHandlerConfig) describe() string {
+	return "not_found"
 }
 
 type ChaosHandlerConfig struct {
 	Handler       HandlerConfig `json:"handler"`
-	FailureChance float64       `json:"failure_chance"`
+	FailureChance float64       `json:"failure_chance" schema:"minimum=0,maximum=1"`
 }
 
-func (c *ChaosHandlerConfig) createHandler() (Handler, error) {
-	wrappedHandler, err := c.Handler.createHandler()
+func (c *ChaosHandlerConfig) createHandler(b *HandlerBuilder) (Handler, error) {
+	var errs []error
+	if err := checkChance("failure_chance", c.FailureChance); err != nil {
+		errs = append(errs, err)
+	}
+	wrappedHandler, err := b.Create("handler", &c.Handler)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create wrapped handler for chaos: %w", err)
+		errs = append(errs, err)
 	}
-	return NewChaosHandler(wrappedHandler, c.FailureChance), nil
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	handler := NewChaosHandler(wrappedHandler, c.FailureChance)
+	handler.Logger = b.logger
+	return handler, nil
+}
+
+func (c *ChaosHandlerConfig) describe() string {
+	return fmt.Sprintf("chaos(failure_chance=%v) > %s", c.FailureChance, c.Handler.Describe())
 }
 
 // FanOut handler config
@@ -128,12 +484,16 @@ type FanOutHandlerConfig struct {
 	ResponseStrategy string          `json:"response_strategy"` // e.g., "first_successful"
 }
 
-func (c *FanOutHandlerConfig) createHandler() (Handler, error) {
+func (c *FanOutHandlerConfig) createHandler(b *HandlerBuilder) (Handler, error) {
+	var errs []error
+	if len(c.Handlers) == 0 {
+		errs = append(errs, fmt.Errorf("at least one handler must be set"))
+	}
 	handlers := make([]Handler, len(c.Handlers))
-	for i, handlerConfig := range c.Handlers {
-		handler, err := handlerConfig.createHandler()
+	for i := range c.Handlers {
+		handler, err := b.Create(fmt.Sprintf("handlers[%d]", i), &c.Handlers[i])
 		if err != nil {
-			return nil, fmt.Errorf("failed to create handler %d for fanout: %w", i, err)
+			errs = append(errs, err)
 		}
 		handlers[i] = handler
 	}
@@ -143,22 +503,35 @@ func (c *FanOutHandlerConfig) createHandler() (Handler, error) {
 	case "first_successful":
 		strategy = &FirstSuccessfulResponseStrategy{}
 	default:
-		return nil, fmt.Errorf("unknown response strategy: %s", c.ResponseStrategy)
+		errs = append(errs, fmt.Errorf("unknown response strategy: %s", c.ResponseStrategy))
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
 	}
 
 	return &FanOutHandler{
 		Handlers:         handlers,
 		ResponseStrategy: strategy,
+		Logger:           b.logger,
 	}, nil
 }
 
+func (c *FanOutHandlerConfig) describe() string {
+	descriptions := make([]string, len(c.Handlers))
+	for i, handlerConfig := range c.Handlers {
+		descriptions[i] = handlerConfig.Describe()
+	}
+	return fmt.Sprintf("fanout(response_strategy=%s)[%s]", c.ResponseStrategy, strings.Join(descriptions, ", "))
+}
+
 type RetrierHandlerConfig struct {
 	Handler     HandlerConfig `json:"handler"`
 	RetryPolicy string        `json:"retry_policy"`
-	Retries     int           `json:"retries"`
+	Retries     int           `json:"retries" schema:"minimum=0"`
 }
 
-func (c *RetrierHandlerConfig) createHandler() (Handler, error) {
+func (c *RetrierHandlerConfig) createHandler(b *HandlerBuilder) (Handler, error) {
+	var errs []error
 	var retryPolicy RetryPolicy
 
 	policy := c.RetryPolicy
@@ -167,11 +540,17 @@ func (c *RetrierHandlerConfig) createHandler() (Handler, error) {
 	case "", "non_2xx_retry":
 		retryPolicy = &RetryOnNon2xxRetryPolicy{}
 	default:
-		return nil, fmt.Errorf("unknown retry policy: %s", policy)
+		errs = append(errs, fmt.Errorf("unknown retry policy: %s", policy))
+	}
+	if c.Retries < 0 {
+		errs = append(errs, fmt.Errorf("retries must not be negative, got %d", c.Retries))
 	}
-	handler, err := c.Handler.createHandler()
+	handler, err := b.Create("handler", &c.Handler)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create handler for retrier: %w", err)
+		errs = append(errs, err)
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
 	}
 	return &RetrierHandler{
 		Handler:     handler,
@@ -180,17 +559,826 @@ func (c *RetrierHandlerConfig) createHandler() (Handler, error) {
 	}, nil
 }
 
-// CreateRouter creates a PathRouter from the configuration
+func (c *RetrierHandlerConfig) describe() string {
+	return fmt.Sprintf("retrier(retries=%d) > %s", c.Retries, c.Handler.Describe())
+}
+
+// CaptureHandlerConfig configures recording recent requests of a route,
+// which are served by the admin API.
+type CaptureHandlerConfig struct {
+	Handler       HandlerConfig `json:"handler"`
+	Size          int           `json:"size,omitempty"`           // entries kept for the route, defaults to 100
+	MaxBodyBytes  int           `json:"max_body_bytes,omitempty"` // bodies are truncated to it, defaults to 4096
+	RedactHeaders []string      `json:"redact_headers,omitempty"` // defaults to Authorization, Proxy-Authorization, Cookie and Set-Cookie
+}
+
+func (c *CaptureHandlerConfig) createHandler(b *HandlerBuilder) (Handler, error) {
+	var errs []error
+	if c.Size < 0 || c.MaxBodyBytes < 0 {
+		errs = append(errs, fmt.Errorf("size and max_body_bytes must not be negative"))
+	}
+	handler, err := b.Create("handler", &c.Handler)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	captureHandler := &CaptureHandler{
+		Handler:     handler,
+		Captures:    DefaultCaptures,
+		Size:        c.size(),
+		MaxBodySize: c.MaxBodyBytes,
+		Redact:      c.RedactHeaders,
+		Description: c.Handler.Describe(),
+	}
+	if captureHandler.MaxBodySize == 0 {
+		captureHandler.MaxBodySize = 4096
+	}
+	if captureHandler.Redact == nil {
+		captureHandler.Redact = DefaultRedactedHeaders
+	}
+	return captureHandler, nil
+}
+
+func (c *CaptureHandlerConfig) size() int {
+	if c.Size == 0 {
+		return 100
+	}
+	return c.Size
+}
+
+func (c *CaptureHandlerConfig) describe() string {
+	return fmt.Sprintf("capture(size=%d) > %s", c.size(), c.Handler.Describe())
+}
+
+// RecordHandlerConfig configures recording the traffic of a handler to a
+// HAR file, which can be served by a replay handler.
+type RecordHandlerConfig struct {
+	Handler HandlerConfig `json:"handler"`
+	Path    string        `json:"path" schema:"required"` // e.g., "recordings/api.har"
+	// FlushInterval is how often recorded requests are written, defaults to 1s.
+	FlushInterval string `json:"flush_interval,omitempty"`
+	MaxEntries    int    `json:"max_entries,omitempty" schema:"minimum=0"`    // of the file, defaults to 10000
+	MaxBodyBytes  int    `json:"max_body_bytes,omitempty" schema:"minimum=0"` // bodies are truncated to it, defaults to 1 MiB
+	// RedactHeaders defaults to Authorization, Proxy-Authorization, Cookie and Set-Cookie.
+	RedactHeaders []string `json:"redact_headers,omitempty"`
+}
+
+func (c *RecordHandlerConfig) createHandler(b *HandlerBuilder) (Handler, error) {
+	var errs []error
+	if c.Path == "" {
+		errs = append(errs, fmt.Errorf("no path set"))
+	}
+	flushInterval, err := parseDuration(c.FlushInterval, time.Second)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("invalid flush interval: %w", err))
+	}
+	if c.MaxEntries < 0 {
+		errs = append(errs, fmt.Errorf("max_entries must not be negative, got %d", c.MaxEntries))
+	}
+	if c.MaxBodyBytes < 0 {
+		errs = append(errs, fmt.Errorf("max_body_bytes must not be negative, got %d", c.MaxBodyBytes))
+	}
+	handler, err := b.Create("handler", &c.Handler)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	recordHandler, err := NewRecordHandler(handler, c.Path)
+	if err != nil {
+		return nil, err
+	}
+	recordHandler.FlushInterval = flushInterval
+	if c.MaxEntries > 0 {
+		recordHandler.MaxEntries = c.MaxEntries
+	}
+	if c.MaxBodyBytes > 0 {
+		recordHandler.MaxBodySize = c.MaxBodyBytes
+	}
+	if c.RedactHeaders != nil {
+		recordHandler.Redact = c.RedactHeaders
+	}
+	recordHandler.Logger = b.logger
+	return recordHandler, nil
+}
+
+func (c *RecordHandlerConfig) describe() string {
+	return fmt.Sprintf("record(path=%s) > %s", c.Path, c.Handler.Describe())
+}
+
+// ReplayHandlerConfig configures serving the responses of a HAR file.
+type ReplayHandlerConfig struct {
+	Path      string `json:"path"`                 // e.g., "recordings/api.har"
+	MatchBody bool   `json:"match_body,omitempty"` // match requests by body, besides method, path and query
+}
+
+func (c *ReplayHandlerConfig) createHandler(b *HandlerBuilder) (Handler, error) {
+	if c.Path == "" {
+		return nil, fmt.Errorf("no path set")
+	}
+	handler, err := NewReplayHandler(c.Path, c.MatchBody)
+	if err != nil {
+		return nil, err
+	}
+	handler.Logger = b.logger
+	return handler, nil
+}
+
+func (c *ReplayHandlerConfig) describe() string {
+	return fmt.Sprintf("replay(path=%s)", c.Path)
+}
+
+// RateLimitHandlerConfig configures limiting the requests of every key, e.g.
+// client IP, with a token bucket.
+type RateLimitHandlerConfig struct {
+	Handler  HandlerConfig `json:"handler"`
+	Requests int           `json:"requests"`           // refilled per period
+	Period   string        `json:"period,omitempty"`   // defaults to 1s
+	Burst    int           `json:"burst,omitempty"`    // requests allowed at once, defaults to requests
+	Key      string        `json:"key,omitempty"`      // "ip" (default), "header:<name>", "path:<variable>" or "global"
+	MaxKeys  int           `json:"max_keys,omitempty"` // keys tracked in memory, defaults to 10000
+	// Redis keeps the buckets in Redis instead of memory, to share them between replicas.
+	Redis *RedisRateLimitConfig `json:"redis,omitempty"`
+}
+
+// RedisRateLimitConfig configures keeping rate limit buckets in Redis.
+type RedisRateLimitConfig struct {
+	Address  string `json:"address"` // e.g., "localhost:6379"
+	Password string `json:"password,omitempty" secret:"true"`
+	DB       int    `json:"db,omitempty"`
+	Timeout  string `json:"timeout,omitempty"` // of every command, defaults to 100ms
+	// Prefix is prepended to keys. It defaults to "rate_limit:" followed by
+	// where the rate limiter is configured, e.g. "rate_limit:/api:" for the
+	// handler of route /api, so that rate limiters have their own buckets.
+	Prefix string `json:"prefix,omitempty"`
+	// Fallback is "local" (default) to limit requests in memory while Redis
+	// is unavailable, or "allow" to let them through.
+	Fallback string `json:"fallback,omitempty"`
+}
+
+func (c *RedisRateLimitConfig) createStore(maxKeys int, location string) (*RedisRateLimitStore, error) {
+	var errs []error
+	if c.Address == "" {
+		errs = append(errs, fmt.Errorf("no redis address set"))
+	}
+	if c.DB < 0 {
+		errs = append(errs, fmt.Errorf("redis db must not be negative, got %d", c.DB))
+	}
+	timeout, err := parseDuration(c.Timeout, 100*time.Millisecond)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("invalid redis timeout: %w", err))
+	}
+	store := &RedisRateLimitStore{Prefix: c.Prefix}
+	if store.Prefix == "" {
+		store.Prefix = "rate_limit:" + location + ":"
+	}
+	switch c.Fallback {
+	case "", "local":
+		store.Fallback = NewMemoryRateLimitStore(maxKeys)
+	case "allow":
+	default:
+		errs = append(errs, fmt.Errorf("unknown redis fallback %q, expected local or allow", c.Fallback))
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	store.Client = NewRedisClient(c.Address)
+	store.Client.Password = c.Password
+	store.Client.DB = c.DB
+	store.Client.Timeout = timeout
+	return store, nil
+}
+
+func (c *RateLimitHandlerConfig) createHandler(b *HandlerBuilder) (Handler, error) {
+	var errs []error
+	limit := RateLimit{Requests: c.Requests, Burst: c.burst()}
+	var err error
+	if limit.Period, err = parseDuration(c.Period, time.Second); err != nil {
+		errs = append(errs, fmt.Errorf("invalid period: %w", err))
+	} else if limit.Period <= 0 {
+		errs = append(errs, fmt.Errorf("period must be positive, got %s", c.Period))
+	}
+	if c.Requests <= 0 {
+		errs = append(errs, fmt.Errorf("requests must be positive, got %d", c.Requests))
+	}
+	if c.Burst < 0 || c.MaxKeys < 0 {
+		errs = append(errs, fmt.Errorf("burst and max_keys must not be negative"))
+	}
+	key, err := ParseRateLimitKey(c.key())
+	if err != nil {
+		errs = append(errs, err)
+	}
+	handler, err := b.Create("handler", &c.Handler)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	maxKeys := c.MaxKeys
+	if maxKeys == 0 {
+		maxKeys = 10000
+	}
+	var store RateLimitStore
+	if c.Redis != nil {
+		redisStore, err := c.Redis.createStore(maxKeys, b.location())
+		if err != nil {
+			errs = append(errs, err)
+		} else {
+			redisStore.Logger = b.logger
+			store = redisStore
+		}
+	} else {
+		store = NewMemoryRateLimitStore(maxKeys)
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	return &RateLimitHandler{
+		Handler: handler,
+		Limit:   limit,
+		Key:     key,
+		Store:   store,
+		Logger:  b.logger,
+	}, nil
+}
+
+func (c *RateLimitHandlerConfig) burst() int {
+	if c.Burst == 0 {
+		return c.Requests
+	}
+	return c.Burst
+}
+
+func (c *RateLimitHandlerConfig) key() string {
+	if c.Key == "" {
+		return "ip"
+	}
+	return c.Key
+}
+
+func (c *RateLimitHandlerConfig) describe() string {
+	period := c.Period
+	if period == "" {
+		period = "1s"
+	}
+	store := ""
+	if c.Redis != nil {
+		store = ", store=redis"
+	}
+	return fmt.Sprintf("rate_limit(requests=%d, period=%s, key=%s%s) > %s", c.Requests, period, c.key(), store, c.Handler.Describe())
+}
+
+type ForwardProxyHandlerConfig struct {
+	Allow          []string         `json:"allow,omitempty"` // e.g., ["*.example.com", "localhost"]
+	Deny           []string         `json:"deny,omitempty"`
+	Auth           *BasicAuthConfig `json:"auth,omitempty"`
+	ConnectTimeout string           `json:"connect_timeout,omitempty"` // e.g., "10s"
+}
+
+type BasicAuthConfig struct {
+	Username string `json:"username"`
+	Password string `json:"password" secret:"true"`
+}
+
+func (c *ForwardProxyHandlerConfig) createHandler(b *HandlerBuilder) (Handler, error) {
+	handler := NewForwardProxyHandler()
+	handler.Logger = b.logger
+	handler.Allow = c.Allow
+	handler.Deny = c.Deny
+	if c.Auth != nil {
+		if c.Auth.Username == "" {
+			return nil, fmt.Errorf("forward proxy auth username is empty")
+		}
+		handler.Username = c.Auth.Username
+		handler.Password = c.Auth.Password
+	}
+
+	var err error
+	handler.ConnectTimeout, err = parseDuration(c.ConnectTimeout, handler.ConnectTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("invalid connect timeout for forward proxy: %w", err)
+	}
+	return handler, nil
+}
+
+func (c *ForwardProxyHandlerConfig) describe() string {
+	return "forward_proxy"
+}
+
+// CreateRouter creates a PathRouter from the configuration. It reports the
+// errors of every route and named handler, closing the handlers it created
+// if there are any.
 func (c *Config) CreateRouter() (*PathRouter, error) {
+	var errs []error
 	router := NewPathRouter()
+	builder := newHandlerBuilder(c.Handlers, c.logger(LogHandlers))
 
-	for _, route := range c.Routes {
-		handler, err := route.Handler.createHandler()
+	for i := range c.Routes {
+		route := &c.Routes[i]
+		handler, err := builder.createRoute(i, route)
 		if err != nil {
-			return nil, fmt.Errorf("failed to create handler for route %s: %w", route.Matcher.Path, err)
+			errs = append(errs, flattenErrors(err)...)
+			continue
+		}
+		if handler, err = route.Matcher.createHandler(handler); err != nil {
+			errs = append(errs, atConfigPath(fmt.Sprintf("routes[%d].matcher", i), err))
+			_ = closeHandler(handler)
+			continue
+		}
+		if err := router.addRoute(route.Matcher.Pattern(), handler); err != nil {
+			errs = append(errs, atConfigPath(fmt.Sprintf("routes[%d].matcher.path", i), err))
+			_ = closeHandler(handler)
 		}
-		router.AddRoute(route.Matcher.Path, handler)
+	}
+	// named handlers no route references are only created to report their errors
+	for _, name := range slices.Sorted(maps.Keys(c.Handlers)) {
+		if _, created := builder.instances[name]; created || builder.failed[name] {
+			continue
+		}
+		handler, err := builder.named(name)
+		if err != nil {
+			errs = append(errs, flattenErrors(err)...)
+			continue
+		}
+		_ = closeHandler(handler)
 	}
 
+	if err := errors.Join(errs...); err != nil {
+		// named handlers of failed routes aren't part of the router, they
+		// close only once if they are
+		_ = closeHandlers(slices.Collect(maps.Values(builder.instances)))
+		_ = router.Close()
+		return nil, err
+	}
 	return router, nil
 }
+
+// Validate creates every route and listener of the configuration without
+// starting anything, returning all errors found instead of only the first.
+func (c *Config) Validate() error {
+	var errs []error
+
+	if c.Server != nil {
+		if err := c.Server.ApplyTo(server.DefaultConfig()); err != nil {
+			errs = append(errs, atConfigPath("server", err))
+		}
+	}
+
+	if router, err := c.CreateRouter(); err != nil {
+		errs = append(errs, flattenErrors(err)...)
+	} else if err := router.Close(); err != nil {
+		errs = append(errs, err)
+	}
+	for i := range c.TCP {
+		if _, err := c.TCP[i].createTCPProxy(); err != nil {
+			errs = append(errs, atConfigPath(fmt.Sprintf("tcp[%d]", i), err))
+		}
+	}
+	for i := range c.UDP {
+		if _, err := c.UDP[i].createUDPProxy(); err != nil {
+			errs = append(errs, atConfigPath(fmt.Sprintf("udp[%d]", i), err))
+		}
+	}
+	if c.SOCKS5 != nil {
+		if _, err := c.SOCKS5.createSOCKS5Proxy(); err != nil {
+			errs = append(errs, atConfigPath("socks5", err))
+		}
+	}
+	if c.Admin != nil {
+		if err := c.Admin.validate(); err != nil {
+			errs = append(errs, atConfigPath("admin", err))
+		}
+	}
+	if c.Tracing != nil {
+		if _, err := c.Tracing.createExporter(); err != nil {
+			errs = append(errs, atConfigPath("tracing", err))
+		}
+	}
+	if c.AccessLog != nil {
+		if _, err := c.AccessLog.format(); err != nil {
+			errs = append(errs, atConfigPath("access_log", err))
+		}
+	}
+	if c.RequestID != nil {
+		if _, err := c.RequestID.createRequestIDs(); err != nil {
+			errs = append(errs, atConfigPath("request_id", err))
+		}
+	}
+	if c.Log != nil {
+		if _, err := c.Log.createLoggers(io.Discard); err != nil {
+			errs = append(errs, flattenErrors(atConfigPath("log", err))...)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+type TCPProxyConfig struct {
+	Listen         string             `json:"listen" schema:"required"`
+	Upstreams      []string           `json:"upstreams"`
+	ConnectTimeout string             `json:"connect_timeout,omitempty"` // e.g., "5s"
+	IdleTimeout    string             `json:"idle_timeout,omitempty"`    // e.g., "5m"
+	HealthCheck    *HealthCheckConfig `json:"health_check,omitempty"`
+}
+
+type HealthCheckConfig struct {
+	Interval string `json:"interval,omitempty"` // e.g., "10s"
+	Timeout  string `json:"timeout,omitempty"`  // e.g., "2s"
+}
+
+func (c *TCPProxyConfig) createTCPProxy() (*TCPProxy, error) {
+	if c.Listen == "" {
+		return nil, fmt.Errorf("no listen address set")
+	}
+	pool, err := NewUpstreamPool(c.Upstreams)
+	if err != nil {
+		return nil, fmt.Errorf("invalid upstreams: %w", err)
+	}
+
+	tcpProxy := NewTCPProxy(c.Listen, pool)
+	tcpProxy.ConnectTimeout, err = parseDuration(c.ConnectTimeout, tcpProxy.ConnectTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("invalid connect timeout: %w", err)
+	}
+	tcpProxy.IdleTimeout, err = parseDuration(c.IdleTimeout, 0)
+	if err != nil {
+		return nil, fmt.Errorf("invalid idle timeout: %w", err)
+	}
+	if c.HealthCheck != nil {
+		tcpProxy.HealthChecker, err = c.HealthCheck.createHealthChecker(pool, TCPHealthCheck)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return tcpProxy, nil
+}
+
+func (c *HealthCheckConfig) createHealthChecker(pool *UpstreamPool, check HealthCheck) (*HealthChecker, error) {
+	interval, err := parseDuration(c.Interval, 10*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("invalid health check interval: %w", err)
+	}
+	if interval <= 0 {
+		return nil, fmt.Errorf("health check interval must be positive")
+	}
+	timeout, err := parseDuration(c.Timeout, 2*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("invalid health check timeout: %w", err)
+	}
+	return NewHealthChecker(pool, check, interval, timeout), nil
+}
+
+// CreateTCPProxies creates the TCP proxies from the configuration
+func (c *Config) CreateTCPProxies() ([]*TCPProxy, error) {
+	proxies := make([]*TCPProxy, 0, len(c.TCP))
+	for i := range c.TCP {
+		tcpProxy, err := c.TCP[i].createTCPProxy()
+		if err != nil {
+			return nil, atConfigPath(fmt.Sprintf("tcp[%d]", i), err)
+		}
+		tcpProxy.Logger = c.logger(LogTCP)
+		proxies = append(proxies, tcpProxy)
+	}
+	return proxies, nil
+}
+
+type UDPProxyConfig struct {
+	Listen         string   `json:"listen" schema:"required"`
+	Upstreams      []string `json:"upstreams"`
+	SessionTimeout string   `json:"session_timeout,omitempty"`                 // e.g., "30s"
+	MaxSessions    int      `json:"max_sessions,omitempty" schema:"minimum=0"` // defaults to 10000
+}
+
+func (c *UDPProxyConfig) createUDPProxy() (*UDPProxy, error) {
+	if c.Listen == "" {
+		return nil, fmt.Errorf("no listen address set")
+	}
+	pool, err := NewUpstreamPool(c.Upstreams)
+	if err != nil {
+		return nil, fmt.Errorf("invalid upstreams: %w", err)
+	}
+
+	udpProxy := NewUDPProxy(c.Listen, pool)
+	udpProxy.SessionTimeout, err = parseDuration(c.SessionTimeout, udpProxy.SessionTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("invalid session timeout: %w", err)
+	}
+	if udpProxy.SessionTimeout <= 0 {
+		return nil, fmt.Errorf("session timeout must be positive")
+	}
+	if c.MaxSessions < 0 {
+		return nil, fmt.Errorf("max_sessions must not be negative, got %d", c.MaxSessions)
+	}
+	if c.MaxSessions > 0 {
+		udpProxy.MaxSessions = c.MaxSessions
+	}
+	return udpProxy, nil
+}
+
+// CreateUDPProxies creates the UDP proxies from the configuration
+func (c *Config) CreateUDPProxies() ([]*UDPProxy, error) {
+	proxies := make([]*UDPProxy, 0, len(c.UDP))
+	for i := range c.UDP {
+		udpProxy, err := c.UDP[i].createUDPProxy()
+		if err != nil {
+			return nil, atConfigPath(fmt.Sprintf("udp[%d]", i), err)
+		}
+		udpProxy.Logger = c.logger(LogUDP)
+		proxies = append(proxies, udpProxy)
+	}
+	return proxies, nil
+}
+
+type SOCKS5Config struct {
+	Listen         string           `json:"listen" schema:"required"`
+	Allow          []string         `json:"allow,omitempty"` // e.g., ["*.example.com"]
+	Deny           []string         `json:"deny,omitempty"`
+	Auth           *BasicAuthConfig `json:"auth,omitempty"`
+	ConnectTimeout string           `json:"connect_timeout,omitempty"` // e.g., "10s"
+	IdleTimeout    string           `json:"idle_timeout,omitempty"`    // e.g., "5m"
+	FailureChance  float64          `json:"failure_chance,omitempty" schema:"minimum=0,maximum=1"`
+}
+
+func (c *SOCKS5Config) createSOCKS5Proxy() (*SOCKS5Proxy, error) {
+	if c.Listen == "" {
+		return nil, fmt.Errorf("no listen address set")
+	}
+
+	if err := checkChance("failure_chance", c.FailureChance); err != nil {
+		return nil, err
+	}
+
+	socksProxy := NewSOCKS5Proxy(c.Listen)
+	socksProxy.Allow = c.Allow
+	socksProxy.Deny = c.Deny
+	socksProxy.FailureChance = c.FailureChance
+	if c.Auth != nil {
+		if c.Auth.Username == "" {
+			return nil, fmt.Errorf("socks5 auth username is empty")
+		}
+		socksProxy.Username = c.Auth.Username
+		socksProxy.Password = c.Auth.Password
+	}
+
+	var err error
+	socksProxy.ConnectTimeout, err = parseDuration(c.ConnectTimeout, socksProxy.ConnectTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("invalid connect timeout: %w", err)
+	}
+	socksProxy.IdleTimeout, err = parseDuration(c.IdleTimeout, 0)
+	if err != nil {
+		return nil, fmt.Errorf("invalid idle timeout: %w", err)
+	}
+	return socksProxy, nil
+}
+
+// CreateSOCKS5Proxy creates the SOCKS5 proxy from the configuration, nil if it isn't configured
+func (c *Config) CreateSOCKS5Proxy() (*SOCKS5Proxy, error) {
+	if c.SOCKS5 == nil {
+		return nil, nil
+	}
+	socksProxy, err := c.SOCKS5.createSOCKS5Proxy()
+	if err != nil {
+		return nil, atConfigPath("socks5", err)
+	}
+	socksProxy.Logger = c.logger(LogSOCKS5)
+	return socksProxy, nil
+}
+
+// AdminConfig configures the listener of the admin API.
+type AdminConfig struct {
+	Listen string `json:"listen" schema:"required"`      // e.g., "127.0.0.1:9901"
+	Token  string `json:"token,omitempty" secret:"true"` // required as bearer token if set
+}
+
+func (c *AdminConfig) validate() error {
+	if c.Listen == "" {
+		return fmt.Errorf("no listen address set")
+	}
+	// the admin API changes the proxy at runtime, so only local clients may
+	// use it without a token
+	if c.Token == "" && !isLoopbackAddress(c.Listen) {
+		return fmt.Errorf("a token is required to listen on %s, which isn't a loopback address", c.Listen)
+	}
+	return nil
+}
+
+// isLoopbackAddress reports whether address only accepts local connections.
+// An empty host listens on all interfaces.
+func isLoopbackAddress(address string) bool {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// CreateAdmin creates the admin API configured by the admin section, nil if
+// there is none.
+func (c *Config) CreateAdmin(router *ReloadableRouter, reloader *ConfigReloader, buildInfo BuildInfo) (*Admin, error) {
+	if c.Admin == nil {
+		return nil, nil
+	}
+	if err := c.Admin.validate(); err != nil {
+		return nil, atConfigPath("admin", err)
+	}
+	admin := NewAdmin(router, reloader, buildInfo)
+	admin.Token = c.Admin.Token
+	admin.Logger = c.logger(LogAdmin)
+	return admin, nil
+}
+
+// TracingConfig configures exporting spans of requests.
+type TracingConfig struct {
+	Exporter    string            `json:"exporter" schema:"required"`                          // "otlp" or "stdout"
+	Endpoint    string            `json:"endpoint,omitempty"`                                  // e.g., "http://localhost:4318/v1/traces"
+	Headers     map[string]string `json:"headers,omitempty" secret:"true"`                     // sent to the collector, e.g. for auth
+	ServiceName string            `json:"service_name,omitempty"`                              // defaults to "proxy"
+	SampleRatio *float64          `json:"sample_ratio,omitempty" schema:"minimum=0,maximum=1"` // chance to sample new traces, defaults to 1
+}
+
+func (c *TracingConfig) createExporter() (SpanExporter, error) {
+	if c.SampleRatio != nil {
+		if err := checkChance("sample_ratio", *c.SampleRatio); err != nil {
+			return nil, err
+		}
+	}
+	switch c.Exporter {
+	case "otlp":
+		if c.Endpoint == "" {
+			return nil, fmt.Errorf("no endpoint set")
+		}
+		if _, err := url.ParseRequestURI(c.Endpoint); err != nil {
+			return nil, fmt.Errorf("invalid endpoint: %w", err)
+		}
+		serviceName := c.ServiceName
+		if serviceName == "" {
+			serviceName = "proxy"
+		}
+		exporter := NewOTLPExporter(c.Endpoint, serviceName)
+		exporter.Headers = c.Headers
+		return exporter, nil
+	case "stdout":
+		return NewStdoutExporter(os.Stdout), nil
+	default:
+		return nil, fmt.Errorf("unknown exporter %q, expected otlp or stdout", c.Exporter)
+	}
+}
+
+// CreateTracer creates the tracer configured by the tracing section, nil if
+// there is none.
+func (c *Config) CreateTracer() (*Tracer, error) {
+	if c.Tracing == nil {
+		return nil, nil
+	}
+	exporter, err := c.Tracing.createExporter()
+	if err != nil {
+		return nil, atConfigPath("tracing", err)
+	}
+	sampleRatio := 1.0
+	if c.Tracing.SampleRatio != nil {
+		sampleRatio = *c.Tracing.SampleRatio
+	}
+	tracer := NewTracer(exporter, sampleRatio)
+	tracer.Logger = c.logger(LogTracing)
+	return tracer, nil
+}
+
+// AccessLogConfig configures the access log.
+type AccessLogConfig struct {
+	Format     string `json:"format,omitempty"`      // "common", "combined", "json" (default) or "template"
+	Template   string `json:"template,omitempty"`    // for the template format, e.g. "{{.Method}} {{.URI}} {{.Status}}"
+	Output     string `json:"output,omitempty"`      // "stdout" (default) or the path of a file
+	MaxSizeMB  int    `json:"max_size_mb,omitempty"` // size at which the file is rotated, defaults to 100
+	MaxBackups int    `json:"max_backups,omitempty"` // rotated files to keep, defaults to 5
+}
+
+func (c *AccessLogConfig) format() (AccessLogFormat, error) {
+	if c.Template != "" && c.Format != "template" {
+		return nil, fmt.Errorf("template is only used by the template format")
+	}
+	if c.MaxSizeMB < 0 || c.MaxBackups < 0 {
+		return nil, fmt.Errorf("max_size_mb and max_backups must not be negative")
+	}
+	switch c.Format {
+	case "common":
+		return CommonLogFormat, nil
+	case "combined":
+		return CombinedLogFormat, nil
+	case "json", "":
+		return JSONLogFormat, nil
+	case "template":
+		if c.Template == "" {
+			return nil, fmt.Errorf("no template set")
+		}
+		format, err := TemplateLogFormat(c.Template)
+		if err != nil {
+			return nil, fmt.Errorf("invalid template: %w", err)
+		}
+		return format, nil
+	default:
+		return nil, fmt.Errorf("unknown format %q, expected common, combined, json or template", c.Format)
+	}
+}
+
+func (c *AccessLogConfig) createAccessLog() (*AccessLog, error) {
+	format, err := c.format()
+	if err != nil {
+		return nil, err
+	}
+	if c.Output == "" || c.Output == "stdout" {
+		return NewAccessLog(format, os.Stdout), nil
+	}
+	maxSizeMB, maxBackups := c.MaxSizeMB, c.MaxBackups
+	if maxSizeMB == 0 {
+		maxSizeMB = 100
+	}
+	if maxBackups == 0 {
+		maxBackups = 5
+	}
+	file, err := OpenRotatingFile(c.Output, int64(maxSizeMB)<<20, maxBackups)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open access log: %w", err)
+	}
+	return NewAccessLog(format, file), nil
+}
+
+// CreateAccessLog creates the access log configured by the access_log
+// section, nil if there is none.
+func (c *Config) CreateAccessLog() (*AccessLog, error) {
+	if c.AccessLog == nil {
+		return nil, nil
+	}
+	accessLog, err := c.AccessLog.createAccessLog()
+	if err != nil {
+		return nil, atConfigPath("access_log", err)
+	}
+	accessLog.Logger = c.logger(LogAccessLog)
+	return accessLog, nil
+}
+
+// RequestIDConfig configures the IDs assigned to requests.
+type RequestIDConfig struct {
+	Header    string `json:"header,omitempty"`    // defaults to "X-Request-ID"
+	Generator string `json:"generator,omitempty"` // "uuid" (default) or "ulid"
+}
+
+func (c *RequestIDConfig) createRequestIDs() (*RequestIDs, error) {
+	header := c.Header
+	if header == "" {
+		header = DefaultRequestIDHeader
+	}
+	if !validHeaderName(header) {
+		return nil, fmt.Errorf("invalid header %q", header)
+	}
+	switch c.Generator {
+	case "", "uuid":
+		return NewRequestIDs(header, NewUUID), nil
+	case "ulid":
+		return NewRequestIDs(header, NewULID), nil
+	default:
+		return nil, fmt.Errorf("unknown generator %q, expected uuid or ulid", c.Generator)
+	}
+}
+
+// CreateRequestIDs creates the request IDs configured by the request_id
+// section, nil if there is none.
+func (c *Config) CreateRequestIDs() (*RequestIDs, error) {
+	if c.RequestID == nil {
+		return nil, nil
+	}
+	requestIDs, err := c.RequestID.createRequestIDs()
+	if err != nil {
+		return nil, atConfigPath("request_id", err)
+	}
+	return requestIDs, nil
+}
+
+// validHeaderName reports whether name is a valid header field name, which
+// is a token as defined by RFC 9110.
+func validHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || strings.ContainsRune("!#$%&'*+-.^_`|~", c)) {
+			return false
+		}
+	}
+	return true
+}
+
+// parseDuration parses a duration like "30s", returning defaultValue if it's empty.
+func parseDuration(value string, defaultValue time.Duration) (time.Duration, error) {
+	if value == "" {
+		return defaultValue, nil
+	}
+	return time.ParseDuration(value)
+}
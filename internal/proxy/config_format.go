@@ -0,0 +1,322 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+type ConfigFormat string
+
+const (
+	ConfigFormatJSON ConfigFormat = "json"
+	ConfigFormatYAML ConfigFormat = "yaml"
+)
+
+// ConfigFormatFromPath detects the config format from the file extension.
+// TOML isn't supported: its lack of null and its table syntax don't map onto
+// the JSON semantics which YAML shares, and it would add a dependency.
+func ConfigFormatFromPath(path string) (ConfigFormat, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return ConfigFormatJSON, nil
+	case ".yaml", ".yml":
+		return ConfigFormatYAML, nil
+	default:
+		return "", fmt.Errorf("unsupported config file extension %q, expected .json, .yaml or .yml", filepath.Ext(path))
+	}
+}
+
+// ReadConfig reads a config in the given format.
+func ReadConfig(content []byte, format ConfigFormat) (*Config, error) {
+	switch format {
+	case ConfigFormatJSON:
+		return ReadConfigFromBytes(content)
+	case ConfigFormatYAML:
+		return ReadConfigFromYAML(content)
+	default:
+		return nil, fmt.Errorf("unsupported config format %q", format)
+	}
+}
+
+// ReadConfigFromYAML reads a YAML config. The YAML document is translated to
+// JSON first, so both formats share the exact same semantics.
+func ReadConfigFromYAML(yamlBytes []byte) (*Config, error) {
+	var document yaml.Node
+	if err := yaml.Unmarshal(yamlBytes, &document); err != nil {
+		return nil, err
+	}
+
+	converter := yamlToJSON{}
+	if len(document.Content) > 0 {
+		if err := converter.convert(&document); err != nil {
+			return nil, err
+		}
+	} else {
+		converter.buf.WriteString("{}")
+	}
+
+	return decodeConfig(converter.buf.Bytes(), converter.locate)
+}
+
+// yamlToJSON writes a YAML node tree as JSON, remembering the YAML position
+// every JSON value originates from to report errors against the YAML source.
+type yamlToJSON struct {
+	buf   bytes.Buffer
+	spans []yamlSpan
+	// aliases is the depth of aliases being expanded, and expanded the number
+	// of nodes expanded from aliases so far
+	aliases, expanded int
+}
+
+// maxAliasExpansion bounds the nodes expanded from aliases, so that a small
+// document of nested aliases ("billion laughs") can't exhaust memory.
+const maxAliasExpansion = 100_000
+
+type yamlSpan struct {
+	start, end   int
+	line, column int
+}
+
+func (c *yamlToJSON) convert(node *yaml.Node) error {
+	if err := c.expand(node); err != nil {
+		return err
+	}
+	start := c.buf.Len()
+	var err error
+	switch node.Kind {
+	case yaml.DocumentNode:
+		return c.convert(node.Content[0])
+	case yaml.AliasNode:
+		c.aliases++
+		defer func() { c.aliases-- }()
+		return c.convert(node.Alias)
+	case yaml.MappingNode:
+		err = c.convertMapping(node)
+	case yaml.SequenceNode:
+		err = c.convertSequence(node)
+	case yaml.ScalarNode:
+		err = c.convertScalar(node)
+	default:
+		err = fmt.Errorf("unsupported YAML node")
+	}
+	if err != nil {
+		return err
+	}
+	c.spans = append(c.spans, yamlSpan{start: start, end: c.buf.Len(), line: node.Line, column: node.Column})
+	return nil
+}
+
+// expand counts node if it's expanded from an alias.
+func (c *yamlToJSON) expand(node *yaml.Node) error {
+	if c.aliases == 0 {
+		return nil
+	}
+	c.expanded++
+	if c.expanded > maxAliasExpansion {
+		return positionError{line: node.Line, column: node.Column, err: fmt.Errorf("aliases expand to more than %d nodes", maxAliasExpansion)}
+	}
+	return nil
+}
+
+func (c *yamlToJSON) convertMapping(node *yaml.Node) error {
+	pairs, err := c.mappingPairs(node)
+	if err != nil {
+		return err
+	}
+	c.buf.WriteByte('{')
+	for i, pair := range pairs {
+		if i > 0 {
+			c.buf.WriteByte(',')
+		}
+		encodedKey, _ := json.Marshal(pair[0].Value)
+		c.buf.Write(encodedKey)
+		c.buf.WriteByte(':')
+		if err := c.convert(pair[1]); err != nil {
+			return err
+		}
+	}
+	c.buf.WriteByte('}')
+	return nil
+}
+
+// mappingPairs returns the key and value nodes of a mapping, resolving merge
+// keys ("<<: *defaults"). Keys of the mapping itself override merged ones, and
+// mappings merged first override those merged later.
+func (c *yamlToJSON) mappingPairs(node *yaml.Node) ([][2]*yaml.Node, error) {
+	var pairs, merged [][2]*yaml.Node
+	seen := make(map[string]bool)
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		if err := c.expand(key); err != nil {
+			return nil, err
+		}
+		if key.Kind != yaml.ScalarNode {
+			return nil, positionError{line: key.Line, column: key.Column, err: errors.New("mapping keys must be scalars")}
+		}
+		if key.ShortTag() == "!!merge" {
+			mergedPairs, err := c.mergedPairs(value)
+			if err != nil {
+				return nil, err
+			}
+			merged = append(merged, mergedPairs...)
+			continue
+		}
+		seen[key.Value] = true
+		pairs = append(pairs, [2]*yaml.Node{key, value})
+	}
+	for _, pair := range merged {
+		if !seen[pair[0].Value] {
+			seen[pair[0].Value] = true
+			pairs = append(pairs, pair)
+		}
+	}
+	return pairs, nil
+}
+
+// mergedPairs returns the pairs merged by the value of a merge key, which is a
+// mapping or a sequence of mappings, usually aliases. Merged pairs count as
+// expanded from aliases.
+func (c *yamlToJSON) mergedPairs(value *yaml.Node) ([][2]*yaml.Node, error) {
+	c.aliases++
+	defer func() { c.aliases-- }()
+	if value.Kind == yaml.AliasNode {
+		value = value.Alias
+	}
+	switch value.Kind {
+	case yaml.MappingNode:
+		return c.mappingPairs(value)
+	case yaml.SequenceNode:
+		var pairs [][2]*yaml.Node
+		for _, item := range value.Content {
+			if item.Kind == yaml.AliasNode {
+				item = item.Alias
+			}
+			if item.Kind != yaml.MappingNode {
+				return nil, positionError{line: item.Line, column: item.Column, err: errors.New("merge key values must be mappings")}
+			}
+			itemPairs, err := c.mappingPairs(item)
+			if err != nil {
+				return nil, err
+			}
+			pairs = append(pairs, itemPairs...)
+		}
+		return pairs, nil
+	default:
+		return nil, positionError{line: value.Line, column: value.Column, err: errors.New("merge key values must be mappings")}
+	}
+}
+
+func (c *yamlToJSON) convertSequence(node *yaml.Node) error {
+	c.buf.WriteByte('[')
+	for i, item := range node.Content {
+		if i > 0 {
+			c.buf.WriteByte(',')
+		}
+		if err := c.convert(item); err != nil {
+			return err
+		}
+	}
+	c.buf.WriteByte(']')
+	return nil
+}
+
+func (c *yamlToJSON) convertScalar(node *yaml.Node) error {
+	var value any
+	switch node.ShortTag() {
+	case "!!null":
+		value = nil
+	case "!!bool":
+		var b bool
+		if err := node.Decode(&b); err != nil {
+			return positionError{line: node.Line, column: node.Column, err: err}
+		}
+		value = b
+	case "!!int":
+		var i int64
+		if err := node.Decode(&i); err != nil {
+			return positionError{line: node.Line, column: node.Column, err: err}
+		}
+		value = i
+	case "!!float":
+		var f float64
+		if err := node.Decode(&f); err != nil {
+			return positionError{line: node.Line, column: node.Column, err: err}
+		}
+		if math.IsInf(f, 0) || math.IsNaN(f) {
+			return positionError{line: node.Line, column: node.Column, err: fmt.Errorf("invalid number %s", node.Value)}
+		}
+		value = f
+	default:
+		value = node.Value
+	}
+
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return positionError{line: node.Line, column: node.Column, err: err}
+	}
+	c.buf.Write(encoded)
+	return nil
+}
+
+// locate attaches the YAML position of the value a JSON decoding error refers to.
+func (c *yamlToJSON) locate(err error) error {
+	offset, ok := jsonErrorOffset(err)
+	if !ok {
+		return err
+	}
+	// spans are appended innermost first, so the first match is the most precise
+	for _, span := range c.spans {
+		if span.start <= offset && offset <= span.end {
+			return positionError{line: span.line, column: span.column, err: err}
+		}
+	}
+	return err
+}
+
+// positionError is an error at a line and column of a config file.
+type positionError struct {
+	line, column int
+	err          error
+}
+
+func (e positionError) Error() string {
+	return fmt.Sprintf("line %d, column %d: %v", e.line, e.column, e.err)
+}
+
+func (e positionError) Unwrap() error {
+	return e.err
+}
+
+// jsonErrorOffset returns the input offset a JSON decoding error occurred at.
+func jsonErrorOffset(err error) (int, bool) {
+	// both offsets point right after the offending byte or value
+	var syntaxErr *json.SyntaxError
+	if errors.As(err, &syntaxErr) {
+		return int(syntaxErr.Offset) - 1, true
+	}
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		return int(typeErr.Offset) - 1, true
+	}
+	return 0, false
+}
+
+// locateJSONError attaches the line and column of a JSON decoding error.
+func locateJSONError(jsonBytes []byte, err error) error {
+	offset, ok := jsonErrorOffset(err)
+	if !ok {
+		return err
+	}
+	offset = max(0, min(offset, len(jsonBytes)))
+	before := jsonBytes[:offset]
+	line := bytes.Count(before, []byte("\n")) + 1
+	column := offset - bytes.LastIndexByte(before, '\n')
+	return positionError{line: line, column: column, err: err}
+}
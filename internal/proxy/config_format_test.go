@@ -0,0 +1,243 @@
+package proxy
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadConfigFromYAML(t *testing.T) {
+	t.Run("nested handler config", func(t *testing.T) {
+		// language=YAML
+		configYaml := `
+routes:
+  - matcher:
+      path: /retrier
+    handler:
+      retrier:
+        retries: 3
+        retry_policy: non_2xx_retry
+        handler:
+          chaos:
+            failure_chance: 0.5
+            handler:
+              static:
+                message: Hello there!
+`
+		config, err := ReadConfigFromYAML([]byte(configYaml))
+		require.NoError(t, err)
+
+		assert.Len(t, config.Routes, 1)
+		route := config.Routes[0]
+		assert.Equal(t, "/retrier", route.Matcher.Path)
+		assert.Equal(t, &RetrierHandlerConfig{
+			Handler: HandlerConfig{Configs: map[string]any{"chaos": &ChaosHandlerConfig{
+				Handler:       HandlerConfig{Configs: map[string]any{"static": &StaticHandlerConfig{Message: "Hello there!"}}},
+				FailureChance: 0.5,
+			}}},
+			RetryPolicy: "non_2xx_retry",
+			Retries:     3,
+		}, route.Handler.Configs["retrier"])
+	})
+
+	t.Run("unknown fields should fail", func(t *testing.T) {
+		// language=YAML
+		configYaml := `
+routes:
+  - matcher: {path: /}
+    handler:
+      static: {mesage: Hello}
+`
+		_, err := ReadConfigFromYAML([]byte(configYaml))
+		assert.EqualError(t, err, `routes[0].handler.static: unknown field "mesage"`)
+	})
+
+	t.Run("empty handler configs and anchors", func(t *testing.T) {
+		// language=YAML
+		configYaml := `
+routes:
+  - matcher: {path: /debug}
+    handler: &debug
+      debug: {}
+  - matcher: {path: /debug2}
+    handler: *debug
+`
+		config, err := ReadConfigFromYAML([]byte(configYaml))
+		require.NoError(t, err)
+
+		assert.Len(t, config.Routes, 2)
+		assert.Equal(t, &DebugHandlerConfig{}, config.Routes[0].Handler.Configs["debug"])
+		assert.Equal(t, &DebugHandlerConfig{}, config.Routes[1].Handler.Configs["debug"])
+	})
+
+	t.Run("merge keys", func(t *testing.T) {
+		// language=YAML
+		configYaml := `
+routes:
+  - matcher: {path: /a}
+    handler:
+      forward: &forward
+        url: http://localhost:9000
+        timeout: 5s
+  - matcher: {path: /b}
+    handler:
+      forward:
+        <<: *forward
+        timeout: 10s
+  - matcher: {path: /c}
+    handler:
+      forward: &http2
+        <<: *forward
+        http2: true
+        timeout: 1s
+  - matcher: {path: /d}
+    handler:
+      forward:
+        <<: [*http2, *forward]
+        url: http://localhost:9001
+`
+		config, err := ReadConfigFromYAML([]byte(configYaml))
+		require.NoError(t, err)
+
+		assert.Equal(t, &ForwardHandlerConfig{URL: "http://localhost:9000", Timeout: "10s"}, config.Routes[1].Handler.Configs["forward"])
+		assert.Equal(t, &ForwardHandlerConfig{URL: "http://localhost:9000", Timeout: "1s", HTTP2: true}, config.Routes[2].Handler.Configs["forward"])
+		assert.Equal(t, &ForwardHandlerConfig{URL: "http://localhost:9001", Timeout: "1s", HTTP2: true}, config.Routes[3].Handler.Configs["forward"])
+	})
+
+	t.Run("merge key of a scalar should fail", func(t *testing.T) {
+		// language=YAML
+		configYaml := `
+routes:
+  - matcher: {path: /a}
+    handler:
+      <<: static
+`
+		_, err := ReadConfigFromYAML([]byte(configYaml))
+		assert.ErrorContains(t, err, "line 5, column 11: merge key values must be mappings")
+	})
+
+	t.Run("nested aliases should fail", func(t *testing.T) {
+		// language=YAML
+		configYaml := `
+routes:
+  - matcher: {path: /a}
+    handler:
+      static:
+        message: &a [x, x, x, x, x, x, x, x, x, x]
+        headers:
+          b: &b [*a, *a, *a, *a, *a, *a, *a, *a, *a, *a]
+          c: &c [*b, *b, *b, *b, *b, *b, *b, *b, *b, *b]
+          d: &d [*c, *c, *c, *c, *c, *c, *c, *c, *c, *c]
+          e: &e [*d, *d, *d, *d, *d, *d, *d, *d, *d, *d]
+          f: &f [*e, *e, *e, *e, *e, *e, *e, *e, *e, *e]
+          g: &g [*f, *f, *f, *f, *f, *f, *f, *f, *f, *f]
+          h: &h [*g, *g, *g, *g, *g, *g, *g, *g, *g, *g]
+          i: &i [*h, *h, *h, *h, *h, *h, *h, *h, *h, *h]
+`
+		_, err := ReadConfigFromYAML([]byte(configYaml))
+		assert.ErrorContains(t, err, "aliases expand to more than 100000 nodes")
+	})
+
+	t.Run("nested merge keys should fail", func(t *testing.T) {
+		// language=YAML
+		configYaml := `
+routes:
+  - matcher: {path: /a}
+    handler:
+      static:
+        headers:
+          a: &a {x: y}
+          b: &b {<<: [*a, *a, *a, *a, *a, *a, *a, *a, *a, *a]}
+          c: &c {<<: [*b, *b, *b, *b, *b, *b, *b, *b, *b, *b]}
+          d: &d {<<: [*c, *c, *c, *c, *c, *c, *c, *c, *c, *c]}
+          e: &e {<<: [*d, *d, *d, *d, *d, *d, *d, *d, *d, *d]}
+          f: &f {<<: [*e, *e, *e, *e, *e, *e, *e, *e, *e, *e]}
+          g: &g {<<: [*f, *f, *f, *f, *f, *f, *f, *f, *f, *f]}
+          h: &h {<<: [*g, *g, *g, *g, *g, *g, *g, *g, *g, *g]}
+`
+		_, err := ReadConfigFromYAML([]byte(configYaml))
+		assert.ErrorContains(t, err, "aliases expand to more than 100000 nodes")
+	})
+
+	t.Run("yields the same config as json", func(t *testing.T) {
+		jsonBytes, err := os.ReadFile("../../cmd/proxy/config.json")
+		require.NoError(t, err)
+
+		// JSON is a subset of YAML
+		fromYaml, err := ReadConfigFromYAML(jsonBytes)
+		require.NoError(t, err)
+		fromJson, err := ReadConfigFromBytes(jsonBytes)
+		require.NoError(t, err)
+
+		assert.Equal(t, fromJson, fromYaml)
+	})
+
+	t.Run("type error reports yaml position", func(t *testing.T) {
+		// language=YAML
+		configYaml := `
+routes:
+  - matcher:
+      path: /chaos
+    handler:
+      chaos:
+        failure_chance: often
+`
+		_, err := ReadConfigFromYAML([]byte(configYaml))
+		assert.ErrorContains(t, err, "line 7, column 25")
+		assert.ErrorContains(t, err, "failure_chance")
+	})
+
+	t.Run("syntax error reports yaml position", func(t *testing.T) {
+		_, err := ReadConfigFromYAML([]byte("routes:\n  - matcher: [\n"))
+		assert.ErrorContains(t, err, "line")
+	})
+
+	t.Run("empty document", func(t *testing.T) {
+		config, err := ReadConfigFromYAML([]byte(""))
+		require.NoError(t, err)
+		assert.Empty(t, config.Routes)
+	})
+}
+
+func TestReadConfigFromBytes_errorPosition(t *testing.T) {
+	t.Run("type error", func(t *testing.T) {
+		configJson := "{\n  \"routes\": [\n    {\"matcher\": {\"path\": 42}}\n  ]\n}"
+
+		_, err := ReadConfigFromBytes([]byte(configJson))
+		assert.ErrorContains(t, err, "line 3, column 27")
+	})
+
+	t.Run("syntax error", func(t *testing.T) {
+		configJson := "{\n  \"routes\": [\n    {\"matcher\": }\n  ]\n}"
+
+		_, err := ReadConfigFromBytes([]byte(configJson))
+		assert.ErrorContains(t, err, "line 3, column 17")
+	})
+}
+
+func TestConfigFormatFromPath(t *testing.T) {
+	tests := []struct {
+		path    string
+		want    ConfigFormat
+		wantErr bool
+	}{
+		{path: "config.json", want: ConfigFormatJSON},
+		{path: "config.yaml", want: ConfigFormatYAML},
+		{path: "/etc/proxy/config.YML", want: ConfigFormatYAML},
+		{path: "config.toml", wantErr: true},
+		{path: "config", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			got, err := ConfigFormatFromPath(tt.path)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -0,0 +1,153 @@
+package proxy
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// ReadConfigFile reads a config file in the format given by its extension,
+// along with all files it includes. Include paths are relative to the file
+// containing them and may be glob patterns. Routes and listeners of included
+// files are appended in order, while named handlers, server, admin, tracing,
+// access_log, log, request_id and socks5 must be defined in one file only.
+func ReadConfigFile(path string) (*Config, error) {
+	return readConfigFile(path, nil)
+}
+
+// readConfigFile reads the config at path, which is included by the files in
+// including, outermost first.
+func readConfigFile(path string, including []string) (*Config, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	if slices.Contains(including, absPath) {
+		return nil, fmt.Errorf("include cycle: %s > %s", strings.Join(including, " > "), absPath)
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+	format, err := ConfigFormatFromPath(path)
+	if err != nil {
+		return nil, err
+	}
+	config, err := ReadConfig(content, format)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	config.files = map[string][]byte{absPath: content}
+
+	including = append(including, absPath)
+	for _, pattern := range config.Include {
+		paths, err := includedPaths(filepath.Dir(path), pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid include %q in %s: %w", pattern, path, err)
+		}
+		if isGlob(pattern) {
+			config.globs = append(config.globs, resolveInclude(filepath.Dir(absPath), pattern))
+		}
+		for _, includedPath := range paths {
+			included, err := readConfigFile(includedPath, including)
+			if err != nil {
+				return nil, err
+			}
+			if err := config.merge(included); err != nil {
+				return nil, fmt.Errorf("failed to include %s in %s: %w", includedPath, path, err)
+			}
+		}
+	}
+	return config, nil
+}
+
+// includedPaths resolves an include pattern relative to dir. A pattern
+// without glob characters must name an existing file, while a glob pattern
+// may match nothing.
+func includedPaths(dir, pattern string) ([]string, error) {
+	pattern = resolveInclude(dir, pattern)
+	if !isGlob(pattern) {
+		return []string{pattern}, nil
+	}
+	// Glob returns matches in lexical order, making the merge order stable
+	return filepath.Glob(pattern)
+}
+
+// resolveInclude resolves an include pattern relative to dir.
+func resolveInclude(dir, pattern string) string {
+	if filepath.IsAbs(pattern) {
+		return pattern
+	}
+	return filepath.Join(dir, pattern)
+}
+
+func isGlob(pattern string) bool {
+	return strings.ContainsAny(pattern, "*?[")
+}
+
+// merge adds the sections of an included config.
+func (c *Config) merge(included *Config) error {
+	if included.Server != nil {
+		if c.Server != nil {
+			return fmt.Errorf("server is already defined")
+		}
+		c.Server = included.Server
+	}
+	if included.Admin != nil {
+		if c.Admin != nil {
+			return fmt.Errorf("admin is already defined")
+		}
+		c.Admin = included.Admin
+	}
+	if included.AccessLog != nil {
+		if c.AccessLog != nil {
+			return fmt.Errorf("access_log is already defined")
+		}
+		c.AccessLog = included.AccessLog
+	}
+	if included.RequestID != nil {
+		if c.RequestID != nil {
+			return fmt.Errorf("request_id is already defined")
+		}
+		c.RequestID = included.RequestID
+	}
+	if included.Log != nil {
+		if c.Log != nil {
+			return fmt.Errorf("log is already defined")
+		}
+		c.Log = included.Log
+	}
+	if included.Tracing != nil {
+		if c.Tracing != nil {
+			return fmt.Errorf("tracing is already defined")
+		}
+		c.Tracing = included.Tracing
+	}
+	if included.SOCKS5 != nil {
+		if c.SOCKS5 != nil {
+			return fmt.Errorf("socks5 is already defined")
+		}
+		c.SOCKS5 = included.SOCKS5
+	}
+	for name, handler := range included.Handlers {
+		if _, exists := c.Handlers[name]; exists {
+			return fmt.Errorf("handler %s is already defined", name)
+		}
+		if c.Handlers == nil {
+			c.Handlers = make(map[string]HandlerConfig)
+		}
+		c.Handlers[name] = handler
+	}
+	c.Routes = append(c.Routes, included.Routes...)
+	c.TCP = append(c.TCP, included.TCP...)
+	c.UDP = append(c.UDP, included.UDP...)
+	c.secrets = append(c.secrets, included.secrets...)
+	for path, content := range included.files {
+		c.files[path] = content
+	}
+	c.globs = append(c.globs, included.globs...)
+	return nil
+}
@@ -0,0 +1,82 @@
+package proxy
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadConfigFile(t *testing.T) {
+	t.Run("merges included files", func(t *testing.T) {
+		dir := t.TempDir()
+		// language=JSON
+		writeFile(t, filepath.Join(dir, "config.json"), `{
+			"include": ["handlers.yaml", "routes/*.json"],
+			"routes": [{"matcher": {"path": "/"}, "handler": {"not_found": {}}}]
+		}`)
+		// language=YAML
+		writeFile(t, filepath.Join(dir, "handlers.yaml"), `
+handlers:
+  hello:
+    static: {message: Hello}
+`)
+		// language=JSON
+		writeFile(t, filepath.Join(dir, "routes", "a.json"), `{"routes": [{"matcher": {"path": "/a"}, "handler": {"ref": "hello"}}]}`)
+		// language=JSON
+		writeFile(t, filepath.Join(dir, "routes", "b.json"), `{"routes": [{"matcher": {"path": "/b"}, "handler": {"ref": "hello"}}]}`)
+
+		config, err := ReadConfigFile(filepath.Join(dir, "config.json"))
+		require.NoError(t, err)
+
+		paths := make([]string, len(config.Routes))
+		for i, route := range config.Routes {
+			paths[i] = route.Matcher.Path
+		}
+		assert.Equal(t, []string{"/", "/a", "/b"}, paths)
+		assert.Contains(t, config.Handlers, "hello")
+		assert.Len(t, config.files, 4)
+
+		_, err = config.CreateRouter()
+		assert.NoError(t, err)
+	})
+
+	t.Run("include cycle should fail", func(t *testing.T) {
+		dir := t.TempDir()
+		// language=JSON
+		writeFile(t, filepath.Join(dir, "a.json"), `{"include": ["b.json"], "routes": []}`)
+		// language=JSON
+		writeFile(t, filepath.Join(dir, "b.json"), `{"include": ["a.json"], "routes": []}`)
+
+		_, err := ReadConfigFile(filepath.Join(dir, "a.json"))
+		assert.ErrorContains(t, err, "include cycle: ")
+		assert.ErrorContains(t, err, filepath.Join(dir, "b.json")+" > "+filepath.Join(dir, "a.json"))
+	})
+
+	t.Run("missing include should fail", func(t *testing.T) {
+		dir := t.TempDir()
+		// language=JSON
+		writeFile(t, filepath.Join(dir, "config.json"), `{"include": ["missing.json"], "routes": []}`)
+
+		_, err := ReadConfigFile(filepath.Join(dir, "config.json"))
+		assert.ErrorContains(t, err, "failed to read config file")
+	})
+
+	t.Run("handler defined twice should fail", func(t *testing.T) {
+		dir := t.TempDir()
+		// language=JSON
+		writeFile(t, filepath.Join(dir, "config.json"), `{"include": ["other.json"], "handlers": {"x": {"echo": {}}}, "routes": []}`)
+		// language=JSON
+		writeFile(t, filepath.Join(dir, "other.json"), `{"handlers": {"x": {"debug": {}}}, "routes": []}`)
+
+		_, err := ReadConfigFile(filepath.Join(dir, "config.json"))
+		assert.ErrorContains(t, err, "handler x is already defined")
+	})
+}
+
+func writeFile(t *testing.T, path, content string) {
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+}
@@ -0,0 +1,414 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+const redacted = "[REDACTED]"
+
+// interpolate resolves references in every string value of the config:
+//
+//	${NAME}            the environment variable NAME, which must be set
+//	${NAME:-default}   the environment variable NAME, or default if it's unset or empty
+//	${secret:NAME}     the environment variable NAME, redacted like values of files, also with a :-default
+//	${file:/path}      the content of a file, e.g. a mounted secret, without trailing newlines
+//	$${                a literal ${
+//
+// Values of other environment variables aren't redacted, so secrets in them
+// must be referenced with ${secret:NAME}. References in number and boolean
+// fields were resolved before decoding already, see interpolateDocument.
+func (c *Config) interpolate(i *interpolator) error {
+	walkConfigStrings(reflect.ValueOf(c).Elem(), "", false, func(path string, _ bool, value string) string {
+		return i.interpolate(path, value)
+	})
+	c.secrets = append(c.secrets, i.secrets...)
+	return errors.Join(i.errs...)
+}
+
+type interpolator struct {
+	lookupEnv func(string) (string, bool)
+	readFile  func(string) ([]byte, error)
+	secrets   []string
+	errs      []error
+}
+
+func newInterpolator() *interpolator {
+	return &interpolator{
+		lookupEnv: os.LookupEnv,
+		readFile:  os.ReadFile,
+	}
+}
+
+// interpolateDocument resolves the references of number and boolean fields
+// in a JSON config, which can't be resolved after decoding, e.g.
+// "failure_chance": "${CHANCE}". It returns the document with the resolved
+// values, and locate adjusted to report errors in the original document.
+func (i *interpolator) interpolateDocument(jsonBytes []byte, locate func(error) error) ([]byte, func(error) error) {
+	d := documentInterpolator{interpolator: i, data: jsonBytes, decoder: json.NewDecoder(bytes.NewReader(jsonBytes))}
+	if err := d.value(reflect.TypeFor[Config](), ""); err != nil || len(d.edits) == 0 {
+		// syntax errors are reported by decoding the document
+		return jsonBytes, locate
+	}
+
+	var result []byte
+	last := 0
+	for _, edit := range d.edits {
+		result = append(result, jsonBytes[last:edit.start]...)
+		result = append(result, edit.value...)
+		last = edit.end
+	}
+	result = append(result, jsonBytes[last:]...)
+	return result, func(err error) error {
+		shiftJSONErrorOffset(err, d.edits)
+		return locate(err)
+	}
+}
+
+// documentEdit replaces the bytes from start to end of a document by value.
+type documentEdit struct {
+	start, end int
+	value      string
+}
+
+// documentInterpolator walks the tokens of a JSON document along the types
+// they are decoded into.
+type documentInterpolator struct {
+	*interpolator
+	data    []byte
+	decoder *json.Decoder
+	edits   []documentEdit
+}
+
+// value resolves references in the next value of the document, which is
+// decoded into t. t is nil if the type isn't known, e.g. for unknown fields.
+func (d *documentInterpolator) value(t reflect.Type, path string) error {
+	for t != nil && t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	start := int(d.decoder.InputOffset())
+	token, err := d.decoder.Token()
+	if err != nil {
+		return err
+	}
+
+	switch token := token.(type) {
+	case json.Delim:
+		for i := 0; d.decoder.More(); i++ {
+			if token == '[' {
+				if err := d.value(documentElemType(t), path+"["+strconv.Itoa(i)+"]"); err != nil {
+					return err
+				}
+				continue
+			}
+			key, err := d.decoder.Token()
+			if err != nil {
+				return err
+			}
+			name, _ := key.(string)
+			if err := d.value(documentFieldType(t, name), joinConfigPath(path, name)); err != nil {
+				return err
+			}
+		}
+		// the closing delimiter
+		_, err := d.decoder.Token()
+		return err
+	case string:
+		if t == nil || !strings.Contains(token, "${") {
+			return nil
+		}
+		literal, kind := "", ""
+		switch t.Kind() {
+		case reflect.Bool:
+			kind = "boolean"
+			literal, err = boolLiteral(d.interpolate(path, token))
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+			reflect.Float32, reflect.Float64:
+			kind = "number"
+			literal, err = numberLiteral(t.Kind(), d.interpolate(path, token))
+		default:
+			// strings are resolved after decoding
+			return nil
+		}
+		if err != nil {
+			// the reference is named rather than its value, which may be a secret
+			d.errs = append(d.errs, fmt.Errorf("%s: %s doesn't resolve to a %s", path, token, kind))
+			literal = "0"
+			if kind == "boolean" {
+				literal = "false"
+			}
+		}
+		end := int(d.decoder.InputOffset())
+		// the offset before the token may include the preceding separator
+		start += bytes.IndexByte(d.data[start:end], '"')
+		d.edits = append(d.edits, documentEdit{start: start, end: end, value: literal})
+	}
+	return nil
+}
+
+func documentFieldType(t reflect.Type, name string) reflect.Type {
+	if t == nil {
+		return nil
+	}
+	switch t.Kind() {
+	case reflect.Map:
+		return t.Elem()
+	case reflect.Struct:
+		if i, ok := jsonFields(t)[name]; ok {
+			return t.Field(i).Type
+		}
+		if t == reflect.TypeFor[HandlerConfig]() {
+			if handlerType, ok := lookupHandlerType(name); ok {
+				return handlerType.ConfigType
+			}
+		}
+	}
+	return nil
+}
+
+func documentElemType(t reflect.Type) reflect.Type {
+	if t == nil || (t.Kind() != reflect.Slice && t.Kind() != reflect.Array) {
+		return nil
+	}
+	return t.Elem()
+}
+
+func boolLiteral(value string) (string, error) {
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return "", err
+	}
+	return strconv.FormatBool(b), nil
+}
+
+func numberLiteral(kind reflect.Kind, value string) (string, error) {
+	switch kind {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, 64)
+		return strconv.FormatInt(n, 10), err
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(value, 10, 64)
+		return strconv.FormatUint(n, 10), err
+	default:
+		f, err := strconv.ParseFloat(value, 64)
+		if err == nil && (math.IsInf(f, 0) || math.IsNaN(f)) {
+			err = fmt.Errorf("%s is not finite", value)
+		}
+		return strconv.FormatFloat(f, 'g', -1, 64), err
+	}
+}
+
+// shiftJSONErrorOffset moves the offset of a JSON decoding error in an
+// edited document to the same position in the original document.
+func shiftJSONErrorOffset(err error, edits []documentEdit) {
+	var offset *int64
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntaxErr):
+		offset = &syntaxErr.Offset
+	case errors.As(err, &typeErr):
+		offset = &typeErr.Offset
+	default:
+		return
+	}
+	shift := 0
+	for _, edit := range edits {
+		if int(*offset)-shift <= edit.start {
+			break
+		}
+		shift += len(edit.value) - (edit.end - edit.start)
+	}
+	*offset -= int64(shift)
+}
+
+func (i *interpolator) interpolate(path, value string) string {
+	if !strings.Contains(value, "${") {
+		return value
+	}
+
+	var result strings.Builder
+	rest := value
+	for {
+		start := strings.Index(rest, "${")
+		if start < 0 {
+			result.WriteString(rest)
+			return result.String()
+		}
+		if start > 0 && rest[start-1] == '$' {
+			result.WriteString(rest[:start-1])
+			result.WriteString("${")
+			rest = rest[start+2:]
+			continue
+		}
+		end := strings.IndexByte(rest[start:], '}')
+		if end < 0 {
+			i.errs = append(i.errs, fmt.Errorf("%s: unterminated reference", path))
+			return value
+		}
+		result.WriteString(rest[:start])
+		result.WriteString(i.resolve(path, rest[start+2:start+end]))
+		rest = rest[start+end+1:]
+	}
+}
+
+func (i *interpolator) resolve(path, reference string) string {
+	if file, ok := strings.CutPrefix(reference, "file:"); ok {
+		content, err := i.readFile(file)
+		if err != nil {
+			i.errs = append(i.errs, fmt.Errorf("%s: failed to read secret file: %w", path, err))
+			return ""
+		}
+		secret := strings.TrimRight(string(content), "\r\n")
+		if secret != "" {
+			i.secrets = append(i.secrets, secret)
+		}
+		return secret
+	}
+
+	if name, ok := strings.CutPrefix(reference, "secret:"); ok {
+		secret := i.resolveEnv(path, name)
+		if secret != "" {
+			i.secrets = append(i.secrets, secret)
+		}
+		return secret
+	}
+	return i.resolveEnv(path, reference)
+}
+
+// resolveEnv resolves a reference to an environment variable, like NAME or
+// NAME:-default.
+func (i *interpolator) resolveEnv(path, reference string) string {
+	name, defaultValue, hasDefault := strings.Cut(reference, ":-")
+	if name == "" {
+		i.errs = append(i.errs, fmt.Errorf("%s: empty reference", path))
+		return ""
+	}
+	value, ok := i.lookupEnv(name)
+	if hasDefault && value == "" {
+		return defaultValue
+	}
+	if !ok {
+		i.errs = append(i.errs, fmt.Errorf("%s: environment variable %s is not set", path, name))
+		return ""
+	}
+	return value
+}
+
+// Redact replaces secret values resolved during loading in s.
+func (c *Config) Redact(s string) string {
+	for _, secret := range c.secrets {
+		s = strings.ReplaceAll(s, secret, redacted)
+	}
+	return s
+}
+
+// RedactError returns err with secret values in its message redacted.
+func (c *Config) RedactError(err error) error {
+	if err == nil {
+		return nil
+	}
+	message := c.Redact(err.Error())
+	if message == err.Error() {
+		return err
+	}
+	return &redactedError{message: message, err: err}
+}
+
+type redactedError struct {
+	message string
+	err     error
+}
+
+func (e *redactedError) Error() string {
+	return e.message
+}
+
+func (e *redactedError) Unwrap() error {
+	return e.err
+}
+
+// Redacted returns a copy of the config safe to show, with secret values and
+// fields tagged `secret:"true"`, like passwords, redacted.
+func (c *Config) Redacted() *Config {
+	content, err := json.Marshal(c)
+	if err != nil {
+		// the config was decoded from JSON, so it always encodes back to it
+		panic(fmt.Sprintf("failed to copy config: %v", err))
+	}
+	copied, err := unmarshalConfig(content, func(err error) error { return err })
+	if err != nil {
+		panic(fmt.Sprintf("failed to copy config: %v", err))
+	}
+
+	walkConfigStrings(reflect.ValueOf(copied).Elem(), "", false, func(_ string, secret bool, value string) string {
+		if secret && value != "" {
+			return redacted
+		}
+		return c.Redact(value)
+	})
+	return copied
+}
+
+// walkConfigStrings calls fn for every string reachable from v, replacing it
+// with the result. path is the JSON path of the value, and secret tells
+// whether it's within a field tagged `secret:"true"`.
+func walkConfigStrings(v reflect.Value, path string, secret bool, fn func(path string, secret bool, value string) string) {
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if !v.IsNil() {
+			walkConfigStrings(v.Elem(), path, secret, fn)
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			fieldSecret := secret || field.Tag.Get("secret") == "true"
+			if field.Tag.Get("config") == "inline" {
+				// the field holds values which are encoded as fields of v
+				walkConfigStrings(v.Field(i), path, fieldSecret, fn)
+				continue
+			}
+			name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+			if name == "-" {
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+			walkConfigStrings(v.Field(i), joinConfigPath(path, name), fieldSecret, fn)
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			walkConfigStrings(v.Index(i), path+"["+strconv.Itoa(i)+"]", secret, fn)
+		}
+	case reflect.Map:
+		for _, key := range v.MapKeys() {
+			value := reflect.New(v.Type().Elem()).Elem()
+			value.Set(v.MapIndex(key))
+			walkConfigStrings(value, joinConfigPath(path, fmt.Sprint(key.Interface())), secret, fn)
+			v.SetMapIndex(key, value)
+		}
+	case reflect.String:
+		if v.CanSet() {
+			v.SetString(fn(path, secret, v.String()))
+		}
+	}
+}
+
+func joinConfigPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
@@ -0,0 +1,192 @@
+package proxy
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigInterpolation(t *testing.T) {
+	t.Run("environment variables and defaults", func(t *testing.T) {
+		t.Setenv("UPSTREAM_URL", "http://backend:9000")
+		t.Setenv("EMPTY", "")
+
+		// language=JSON
+		configJson := `{
+			"routes": [
+				{
+					"matcher": {"path": "/api"},
+					"handler": {"forward": {"url": "${UPSTREAM_URL}/v1", "timeout": "${TIMEOUT:-5s}"}}
+				},
+				{
+					"matcher": {"path": "/static"},
+					"handler": {"static": {"message": "${EMPTY:-fallback} costs $${PRICE}"}}
+				}
+			]
+		}`
+		config, err := ReadConfigFromString(configJson)
+		require.NoError(t, err)
+
+		assert.Equal(t, "http://backend:9000/v1", handlerConfigOf[ForwardHandlerConfig](t, config.Routes[0].Handler, "forward").URL)
+		assert.Equal(t, "5s", handlerConfigOf[ForwardHandlerConfig](t, config.Routes[0].Handler, "forward").Timeout)
+		assert.Equal(t, "fallback costs ${PRICE}", handlerConfigOf[StaticHandlerConfig](t, config.Routes[1].Handler, "static").Message)
+	})
+
+	t.Run("numbers and booleans", func(t *testing.T) {
+		t.Setenv("CHANCE", "0.25")
+		t.Setenv("RETRIES", "3")
+		t.Setenv("KEEP_ALIVE", "false")
+
+		// language=YAML
+		configYaml := `
+server:
+  keep_alive: ${KEEP_ALIVE}
+routes:
+  - matcher: {path: /}
+    handler:
+      retrier:
+        retries: ${RETRIES}
+        handler: {chaos: {failure_chance: "${CHANCE}", handler: {echo: {}}}}
+`
+		config, err := ReadConfigFromYAML([]byte(configYaml))
+		require.NoError(t, err)
+
+		assert.False(t, *config.Server.KeepAlive)
+		assert.Equal(t, 3, handlerConfigOf[RetrierHandlerConfig](t, config.Routes[0].Handler, "retrier").Retries)
+		assert.Equal(t, 0.25, handlerConfigOf[ChaosHandlerConfig](t, handlerConfigOf[RetrierHandlerConfig](t, config.Routes[0].Handler, "retrier").Handler, "chaos").FailureChance)
+	})
+
+	t.Run("numbers and booleans are checked", func(t *testing.T) {
+		// language=JSON
+		configJson := `{
+			"server": {"keep_alive": "${PROXY_TEST_UNSET_BOOL:-maybe}"},
+			"routes": [{"matcher": {"path": "/"}, "handler": {"retrier": {"retries": "${PROXY_TEST_UNSET_RETRIES}", "handler": {"echo": {}}}}}]
+		}`
+		_, err := ReadConfigFromString(configJson)
+
+		assert.EqualError(t, err, "server.keep_alive: ${PROXY_TEST_UNSET_BOOL:-maybe} doesn't resolve to a boolean\n"+
+			"routes[0].handler.retrier.retries: environment variable PROXY_TEST_UNSET_RETRIES is not set\n"+
+			"routes[0].handler.retrier.retries: ${PROXY_TEST_UNSET_RETRIES} doesn't resolve to a number")
+	})
+
+	t.Run("errors are located in the original document", func(t *testing.T) {
+		t.Setenv("RETRIES", "12345")
+		configJson := "{\"routes\": [\n  {\"matcher\": {\"path\": \"/\"}, \"handler\": {\"retrier\": {\"retries\": \"${RETRIES}\", \"handler\": {\"echo\": {}}}}},\n  {\"matcher\": {\"path\": 42}}\n]}"
+
+		_, err := ReadConfigFromString(configJson)
+
+		assert.ErrorContains(t, err, "line 3, column 25")
+	})
+
+	t.Run("secret environment variables", func(t *testing.T) {
+		t.Setenv("API_TOKEN", "t0ken")
+
+		// language=JSON
+		config, err := ReadConfigFromString(`{"routes": [{"matcher": {"path": "/"}, "handler": {"forward": {"url": "http://backend/?token=${secret:API_TOKEN}&env=${ENV:-dev}"}}}]}`)
+		require.NoError(t, err)
+
+		assert.Equal(t, "http://backend/?token=t0ken&env=dev", handlerConfigOf[ForwardHandlerConfig](t, config.Routes[0].Handler, "forward").URL)
+		assert.Equal(t, "http://backend/?token=[REDACTED]&env=dev", handlerConfigOf[ForwardHandlerConfig](t, config.Redacted().Routes[0].Handler, "forward").URL)
+	})
+
+	t.Run("secret files", func(t *testing.T) {
+		secretFile := filepath.Join(t.TempDir(), "password")
+		require.NoError(t, os.WriteFile(secretFile, []byte("s3cr3t\n"), 0o600))
+
+		// language=YAML
+		configYaml := `
+routes:
+  - matcher: {path: /}
+    handler:
+      forward_proxy:
+        auth:
+          username: admin
+          password: ${file:` + secretFile + `}
+`
+		config, err := ReadConfigFromYAML([]byte(configYaml))
+		require.NoError(t, err)
+
+		assert.Equal(t, "s3cr3t", handlerConfigOf[ForwardProxyHandlerConfig](t, config.Routes[0].Handler, "forward_proxy").Auth.Password)
+	})
+
+	t.Run("missing values are reported with their path", func(t *testing.T) {
+		// language=JSON
+		configJson := `{
+			"routes": [
+				{
+					"matcher": {"path": "/api"},
+					"handler": {"forward": {"url": "${PROXY_TEST_UNSET_URL}"}}
+				}
+			],
+			"socks5": {"listen": ":1080", "auth": {"username": "${PROXY_TEST_UNSET_USER}", "password": "${file:/nonexistent/secret}"}}
+		}`
+		_, err := ReadConfigFromString(configJson)
+		require.Error(t, err)
+
+		assert.ErrorContains(t, err, "routes[0].handler.forward.url: environment variable PROXY_TEST_UNSET_URL is not set")
+		assert.ErrorContains(t, err, "socks5.auth.username: environment variable PROXY_TEST_UNSET_USER is not set")
+		assert.ErrorContains(t, err, "socks5.auth.password: failed to read secret file: open /nonexistent/secret")
+	})
+
+	t.Run("malformed references", func(t *testing.T) {
+		// language=JSON
+		configJson := `{
+			"routes": [
+				{"matcher": {"path": "/a"}, "handler": {"static": {"message": "${}"}}},
+				{"matcher": {"path": "/b"}, "handler": {"static": {"message": "${UNTERMINATED"}}}
+			]
+		}`
+		_, err := ReadConfigFromString(configJson)
+		require.Error(t, err)
+
+		assert.ErrorContains(t, err, "routes[0].handler.static.message: empty reference")
+		assert.ErrorContains(t, err, "routes[1].handler.static.message: unterminated reference")
+	})
+}
+
+func TestConfigRedaction(t *testing.T) {
+	secretFile := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(secretFile, []byte("tok3n"), 0o600))
+
+	// language=JSON
+	configJson := `{
+		"routes": [
+			{
+				"matcher": {"path": "/api"},
+				"handler": {"forward": {"url": "http://backend/?token=${file:` + secretFile + `}"}}
+			},
+			{
+				"matcher": {"path": "/"},
+				"handler": {"forward_proxy": {"auth": {"username": "admin", "password": "inline"}}}
+			}
+		]
+	}`
+	config, err := ReadConfigFromString(configJson)
+	require.NoError(t, err)
+
+	t.Run("redacted copy", func(t *testing.T) {
+		redactedConfig := config.Redacted()
+
+		assert.Equal(t, "http://backend/?token=[REDACTED]", handlerConfigOf[ForwardHandlerConfig](t, redactedConfig.Routes[0].Handler, "forward").URL)
+		assert.Equal(t, "admin", handlerConfigOf[ForwardProxyHandlerConfig](t, redactedConfig.Routes[1].Handler, "forward_proxy").Auth.Username)
+		assert.Equal(t, "[REDACTED]", handlerConfigOf[ForwardProxyHandlerConfig](t, redactedConfig.Routes[1].Handler, "forward_proxy").Auth.Password)
+		assert.Equal(t, "forward(url=http://backend/?token=[REDACTED])", redactedConfig.Routes[0].Handler.Describe())
+
+		// the original is left untouched
+		assert.Equal(t, "http://backend/?token=tok3n", handlerConfigOf[ForwardHandlerConfig](t, config.Routes[0].Handler, "forward").URL)
+		assert.Equal(t, "inline", handlerConfigOf[ForwardProxyHandlerConfig](t, config.Routes[1].Handler, "forward_proxy").Auth.Password)
+	})
+
+	t.Run("redacted errors", func(t *testing.T) {
+		cause := errors.New("invalid url http://backend/?token=tok3n")
+		err := config.RedactError(cause)
+
+		assert.EqualError(t, err, "invalid url http://backend/?token=[REDACTED]")
+		assert.ErrorIs(t, err, cause)
+		assert.Nil(t, config.RedactError(nil))
+	})
+}
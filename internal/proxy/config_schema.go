@@ -0,0 +1,162 @@
+package proxy
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const schemaDraft = "https://json-schema.org/draft/2020-12/schema"
+
+// ConfigSchema returns a JSON Schema describing Config, including every
+// registered handler type, for editors and linters to validate config files.
+func ConfigSchema() map[string]any {
+	generator := schemaGenerator{
+		defs:  make(map[string]any),
+		names: make(map[reflect.Type]string),
+	}
+	schema := generator.schema(reflect.TypeFor[Config]())
+	schema["$schema"] = schemaDraft
+	schema["title"] = "Proxy configuration"
+	schema["$defs"] = generator.defs
+	return schema
+}
+
+// schemaGenerator generates JSON Schemas from config types, defining every
+// struct type once in defs so recursive types like HandlerConfig work.
+type schemaGenerator struct {
+	defs  map[string]any
+	names map[reflect.Type]string
+}
+
+func (g *schemaGenerator) schema(t reflect.Type) map[string]any {
+	switch t.Kind() {
+	case reflect.Pointer:
+		return g.schema(t.Elem())
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": g.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": g.schema(t.Elem())}
+	case reflect.Struct:
+		return g.ref(t)
+	default:
+		// e.g. interfaces, which may hold anything
+		return map[string]any{}
+	}
+}
+
+// ref returns a reference to the definition of struct type t, defining it
+// on first use.
+func (g *schemaGenerator) ref(t reflect.Type) map[string]any {
+	name, ok := g.names[t]
+	if !ok {
+		name = g.defName(t)
+		g.names[t] = name
+		// reserve the name before generating, t may reference itself
+		g.defs[name] = nil
+		if t == reflect.TypeFor[HandlerConfig]() {
+			g.defs[name] = g.handlerConfigSchema()
+		} else {
+			g.defs[name] = g.structSchema(t)
+		}
+	}
+	return map[string]any{"$ref": "#/$defs/" + name}
+}
+
+func (g *schemaGenerator) defName(t reflect.Type) string {
+	name := t.Name()
+	if name == "" {
+		name = "Object"
+	}
+	// types of other packages may share a name with ours
+	unique := name
+	for i := 2; ; i++ {
+		if _, exists := g.defs[unique]; !exists {
+			return unique
+		}
+		unique = fmt.Sprintf("%s%d", name, i)
+	}
+}
+
+// structSchema describes struct type t. The schema tag of its fields adds
+// constraints, separated by commas:
+//
+//	required            the field must be set
+//	one_of              exactly one of the fields tagged one_of must be set
+//	minimum=N, maximum=N bounds of a number
+func (g *schemaGenerator) structSchema(t reflect.Type) map[string]any {
+	properties := make(map[string]any)
+	var required, oneOf []string
+	for name, i := range jsonFields(t) {
+		field := t.Field(i)
+		property := g.schema(field.Type)
+		for _, constraint := range strings.Split(field.Tag.Get("schema"), ",") {
+			keyword, value, _ := strings.Cut(constraint, "=")
+			switch keyword {
+			case "":
+			case "required":
+				required = append(required, name)
+			case "one_of":
+				oneOf = append(oneOf, name)
+			case "minimum", "maximum":
+				bound, err := strconv.ParseFloat(value, 64)
+				if err != nil {
+					panic(fmt.Sprintf("proxy: invalid %s of %s.%s: %v", keyword, t.Name(), field.Name, err))
+				}
+				property[keyword] = bound
+			default:
+				panic(fmt.Sprintf("proxy: unknown schema constraint %q of %s.%s", constraint, t.Name(), field.Name))
+			}
+		}
+		properties[name] = property
+	}
+	schema := map[string]any{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+	if len(required) > 0 {
+		slices.Sort(required)
+		schema["required"] = required
+	}
+	if len(oneOf) > 0 {
+		slices.Sort(oneOf)
+		alternatives := make([]any, len(oneOf))
+		for i, name := range oneOf {
+			alternatives[i] = map[string]any{"required": []string{name}}
+		}
+		schema["oneOf"] = alternatives
+	}
+	return schema
+}
+
+// handlerConfigSchema describes HandlerConfig, which has a property for every
+// registered handler type, of which exactly one must be set.
+func (g *schemaGenerator) handlerConfigSchema() map[string]any {
+	properties := make(map[string]any)
+	for _, handlerType := range registeredHandlerTypes() {
+		if handlerType.ConfigType == nil {
+			properties[handlerType.Name] = map[string]any{}
+			continue
+		}
+		properties[handlerType.Name] = g.schema(handlerType.ConfigType)
+	}
+	return map[string]any{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+		"minProperties":        1,
+		"maxProperties":        1,
+	}
+}
@@ -0,0 +1,85 @@
+package proxy
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigSchema(t *testing.T) {
+	schema := ConfigSchema()
+	defs := schema["$defs"].(map[string]any)
+
+	t.Run("describes the config", func(t *testing.T) {
+		assert.Equal(t, "https://json-schema.org/draft/2020-12/schema", schema["$schema"])
+		assert.Equal(t, "#/$defs/Config", schema["$ref"])
+
+		config := defs["Config"].(map[string]any)
+		assert.Equal(t, false, config["additionalProperties"])
+		properties := config["properties"].(map[string]any)
+		assert.Equal(t, map[string]any{
+			"type":  "array",
+			"items": map[string]any{"$ref": "#/$defs/RouteConfig"},
+		}, properties["routes"])
+
+		route := defs["RouteConfig"].(map[string]any)
+		assert.Equal(t, map[string]any{
+			"matcher": map[string]any{"$ref": "#/$defs/MatcherConfig"},
+			"handler": map[string]any{"$ref": "#/$defs/HandlerConfig"},
+		}, route["properties"])
+	})
+
+	t.Run("handler config allows exactly one registered handler type", func(t *testing.T) {
+		handler := defs["HandlerConfig"].(map[string]any)
+		assert.Equal(t, 1, handler["minProperties"])
+		assert.Equal(t, 1, handler["maxProperties"])
+		assert.Equal(t, false, handler["additionalProperties"])
+
+		properties := handler["properties"].(map[string]any)
+		assert.Equal(t, map[string]any{"$ref": "#/$defs/ChaosHandlerConfig"}, properties["chaos"])
+		assert.Equal(t, map[string]any{"type": "string"}, properties["ref"])
+		// registered by handler_registry_test.go
+		assert.Equal(t, map[string]any{"$ref": "#/$defs/SetHeaderHandlerConfig"}, properties["set_header"])
+
+		chaos := defs["ChaosHandlerConfig"].(map[string]any)
+		assert.Equal(t, map[string]any{
+			"failure_chance": map[string]any{"type": "number", "minimum": 0.0, "maximum": 1.0},
+			"handler":        map[string]any{"$ref": "#/$defs/HandlerConfig"},
+		}, chaos["properties"])
+	})
+
+	t.Run("constrains fields", func(t *testing.T) {
+		route := defs["RouteConfig"].(map[string]any)
+		assert.Equal(t, []string{"handler", "matcher"}, route["required"])
+		assert.Equal(t, []string{"url"}, defs["ForwardHandlerConfig"].(map[string]any)["required"])
+		assert.Equal(t, []string{"listen"}, defs["TCPProxyConfig"].(map[string]any)["required"])
+		assert.Equal(t, []string{"listen"}, defs["UDPProxyConfig"].(map[string]any)["required"])
+
+		matcher := defs["MatcherConfig"].(map[string]any)
+		assert.Nil(t, matcher["required"])
+		assert.Equal(t, []any{
+			map[string]any{"required": []string{"grpc"}},
+			map[string]any{"required": []string{"path"}},
+		}, matcher["oneOf"])
+
+		retrier := defs["RetrierHandlerConfig"].(map[string]any)["properties"].(map[string]any)
+		assert.Equal(t, map[string]any{"type": "integer", "minimum": 0.0}, retrier["retries"])
+	})
+
+	t.Run("allows referencing the schema", func(t *testing.T) {
+		properties := defs["Config"].(map[string]any)["properties"].(map[string]any)
+		assert.Equal(t, map[string]any{"type": "string"}, properties["$schema"])
+
+		// language=JSON
+		config, err := ReadConfigFromString(`{"$schema": "./config.schema.json", "routes": []}`)
+		require.NoError(t, err)
+		assert.NoError(t, config.Validate())
+	})
+
+	t.Run("encodes to JSON", func(t *testing.T) {
+		_, err := json.Marshal(schema)
+		require.NoError(t, err)
+	})
+}
@@ -1,9 +1,15 @@
 package proxy
 
 import (
+	"net/http"
+	"net/http/httptest"
+	"proxy-server/internal/server"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestConfigUnMarshalling(t *testing.T) {
@@ -18,7 +24,7 @@ func TestConfigUnMarshalling(t *testing.T) {
 
 		route := config.Routes[0]
 		assert.Equal(t, "/hello", route.Matcher.Path)
-		assert.Equal(t, &StaticHandlerConfig{"Hello there!"}, route.Handler.Static)
+		assert.Equal(t, &StaticHandlerConfig{"Hello there!"}, route.Handler.Configs["static"])
 	})
 
 	t.Run("debug handler config", func(t *testing.T) {
@@ -31,7 +37,7 @@ func TestConfigUnMarshalling(t *testing.T) {
 		assert.Len(t, config.Routes, 1)
 		route := config.Routes[0]
 		assert.Equal(t, "/debug", route.Matcher.Path)
-		assert.Equal(t, &DebugHandlerConfig{}, route.Handler.Debug)
+		assert.Equal(t, &DebugHandlerConfig{}, route.Handler.Configs["debug"])
 	})
 
 	t.Run("echo handler config", func(t *testing.T) {
@@ -44,7 +50,7 @@ func TestConfigUnMarshalling(t *testing.T) {
 		assert.Len(t, config.Routes, 1)
 		route := config.Routes[0]
 		assert.Equal(t, "/echo", route.Matcher.Path)
-		assert.Equal(t, &EchoHandlerConfig{}, route.Handler.Echo)
+		assert.Equal(t, &EchoHandlerConfig{}, route.Handler.Configs["echo"])
 	})
 
 	t.Run("chaos handler config", func(t *testing.T) {
@@ -57,7 +63,7 @@ func TestConfigUnMarshalling(t *testing.T) {
 		assert.Len(t, config.Routes, 1)
 		route := config.Routes[0]
 		assert.Equal(t, "/chaos", route.Matcher.Path)
-		assert.Equal(t, &ChaosHandlerConfig{HandlerConfig{Static: &StaticHandlerConfig{"Hello there!"}}, 0.5}, route.Handler.Chaos)
+		assert.Equal(t, &ChaosHandlerConfig{HandlerConfig{Configs: map[string]any{"static": &StaticHandlerConfig{"Hello there!"}}}, 0.5}, route.Handler.Configs["chaos"])
 	})
 
 	t.Run("not found handler config", func(t *testing.T) {
@@ -70,7 +76,7 @@ func TestConfigUnMarshalling(t *testing.T) {
 		assert.Len(t, config.Routes, 1)
 		route := config.Routes[0]
 		assert.Equal(t, "/notfound", route.Matcher.Path)
-		assert.Equal(t, &NotFoundHandlerConfig{}, route.Handler.NotFound)
+		assert.Equal(t, &NotFoundHandlerConfig{}, route.Handler.Configs["not_found"])
 	})
 
 	t.Run("forward handler config", func(t *testing.T) {
@@ -85,7 +91,7 @@ func TestConfigUnMarshalling(t *testing.T) {
 		assert.Equal(t, "/forward", route.Matcher.Path)
 		assert.Equal(t, &ForwardHandlerConfig{
 			URL: "https://example.com",
-		}, route.Handler.Forward)
+		}, route.Handler.Configs["forward"])
 	})
 
 	t.Run("retrier handler config", func(t *testing.T) {
@@ -99,13 +105,21 @@ func TestConfigUnMarshalling(t *testing.T) {
 		route := config.Routes[0]
 		assert.Equal(t, "/retrier", route.Matcher.Path)
 		assert.Equal(t, &RetrierHandlerConfig{
-			Handler:     HandlerConfig{Static: &StaticHandlerConfig{Message: "Hello there!"}},
+			Handler:     HandlerConfig{Configs: map[string]any{"static": &StaticHandlerConfig{Message: "Hello there!"}}},
 			RetryPolicy: "non_2xx_retry",
 			Retries:     3,
-		}, route.Handler.Retrier)
+		}, route.Handler.Configs["retrier"])
 	})
 }
 
+// handlerConfigOf returns the config of the handler type name in h.
+func handlerConfigOf[C any](t *testing.T, h HandlerConfig, name string) *C {
+	t.Helper()
+	config, ok := h.Configs[name].(*C)
+	require.Truef(t, ok, "expected a %T handler config, got %#v", config, h.Configs)
+	return config
+}
+
 func TestConfig_CreateRouter(t *testing.T) {
 	t.Run("create router with static handler from json", func(t *testing.T) {
 		// language=JSON
@@ -210,6 +224,101 @@ func TestConfig_CreateRouter(t *testing.T) {
 		assert.Equal(t, router.Routes["/forward"], handler)
 	})
 
+	t.Run("create router with forward handler flush interval from json", func(t *testing.T) {
+		// language=JSON
+		configJson := `{"routes": [{"matcher": {"path": "/events"}, "handler": {"forward": {"url": "https://example.com", "flush_interval": "100ms"}}}]}`
+
+		config, err := ReadConfigFromString(configJson)
+		assert.NoError(t, err)
+
+		router, err := config.CreateRouter()
+		assert.NoError(t, err)
+
+		assert.Equal(t, 100*time.Millisecond, router.Routes["/events"].(*ForwardHandler).FlushInterval)
+	})
+
+	t.Run("create router with http2 forward handler from json", func(t *testing.T) {
+		// language=JSON
+		configJson := `{"routes": [{"matcher": {"path": "/helloworld.Greeter/"}, "handler": {"forward": {"url": "http://localhost:50051", "http2": true}}}]}`
+
+		config, err := ReadConfigFromString(configJson)
+		assert.NoError(t, err)
+
+		router, err := config.CreateRouter()
+		assert.NoError(t, err)
+
+		transport := router.Routes["/helloworld.Greeter/"].(*ForwardHandler).Client.Transport.(*http.Transport)
+		assert.True(t, transport.Protocols.UnencryptedHTTP2(), "Expected h2c to be enabled")
+	})
+
+	t.Run("invalid flush interval should fail", func(t *testing.T) {
+		// language=JSON
+		configJson := `{"routes": [{"matcher": {"path": "/events"}, "handler": {"forward": {"url": "https://example.com", "flush_interval": "soon"}}}]}`
+
+		config, err := ReadConfigFromString(configJson)
+		assert.NoError(t, err)
+
+		_, err = config.CreateRouter()
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "invalid flush interval")
+	})
+
+	t.Run("create router with forward proxy handler from json", func(t *testing.T) {
+		// language=JSON
+		configJson := `{"routes": [{"matcher": {"path": "/"}, "handler": {"forward_proxy": {"allow": ["*.example.com"], "deny": ["db.example.com"], "auth": {"username": "user", "password": "secret"}, "connect_timeout": "5s"}}}]}`
+
+		config, err := ReadConfigFromString(configJson)
+		assert.NoError(t, err)
+
+		router, err := config.CreateRouter()
+		assert.NoError(t, err)
+
+		handler := router.Routes["/"].(*ForwardProxyHandler)
+		assert.Equal(t, []string{"*.example.com"}, handler.Allow)
+		assert.Equal(t, []string{"db.example.com"}, handler.Deny)
+		assert.Equal(t, "user", handler.Username)
+		assert.Equal(t, "secret", handler.Password)
+		assert.Equal(t, 5*time.Second, handler.ConnectTimeout)
+	})
+
+	t.Run("grpc matcher should route calls by service and method", func(t *testing.T) {
+		// language=JSON
+		configJson := `{"routes": [
+			{"matcher": {"grpc": {"service": "helloworld.Greeter", "method": "SayHello"}}, "handler": {"static": {"message": "hello"}}},
+			{"matcher": {"grpc": {"service": "helloworld.Greeter"}}, "handler": {"static": {"message": "greeter"}}},
+			{"matcher": {"path": "/"}, "handler": {"static": {"message": "fallback"}}}
+		]}`
+		config, err := ReadConfigFromString(configJson)
+		require.NoError(t, err)
+
+		router, err := config.CreateRouter()
+		require.NoError(t, err)
+
+		serve := func(r *http.Request) string {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r)
+			return w.Body.String()
+		}
+		assert.Equal(t, "hello", serve(newGRPCRequest("/helloworld.Greeter/SayHello")))
+		assert.Equal(t, "greeter", serve(newGRPCRequest("/helloworld.Greeter/SayGoodbye")))
+		assert.Equal(t, "fallback", serve(newGRPCRequest("/helloworld.Other/SayHello")))
+		assert.Equal(t, "404 page not found\n", serve(newGetRequest("/helloworld.Greeter/SayHello")), "non-gRPC requests don't match")
+		assert.Equal(t, "/helloworld.Greeter/", config.Routes[1].Matcher.Pattern())
+	})
+
+	t.Run("invalid grpc matchers should fail", func(t *testing.T) {
+		// language=JSON
+		configJson := `{"routes": [
+			{"matcher": {"grpc": {"method": "SayHello"}}, "handler": {"echo": {}}},
+			{"matcher": {"path": "/", "grpc": {"service": "helloworld.Greeter"}}, "handler": {"echo": {}}}
+		]}`
+		config, err := ReadConfigFromString(configJson)
+		require.NoError(t, err)
+
+		assert.EqualError(t, config.Validate(), "routes[0].matcher: grpc service is empty\n"+
+			"routes[1].matcher: path and grpc can't both be set")
+	})
+
 	t.Run("multiple handlers in handler config should fail", func(t *testing.T) {
 		// language=JSON
 		configJson := `{"routes": [{"matcher": {"path": "/forward"}, "handler": {"forward": {"url": "https://example.com"}, "static": {"message": "Hello there!"}}}]}`
@@ -218,6 +327,359 @@ func TestConfig_CreateRouter(t *testing.T) {
 
 		_, err = config.CreateRouter()
 		assert.Error(t, err)
-		assert.Contains(t, err.Error(), "exactly one handler must be set")
+		assert.EqualError(t, err, "routes[0].handler: exactly one handler must be set, got [forward static]")
+	})
+
+	t.Run("unknown fields should fail", func(t *testing.T) {
+		// language=JSON
+		configJson := `{"routes": [{"matcher": {"path": "/"}, "handler": {"retrier": {"retires": 3, "handler": {"chaos": {"failure_chanse": 0.5, "handler": {"echo": {}}}}}}}], "tpc": []}`
+		_, err := ReadConfigFromString(configJson)
+
+		assert.ErrorContains(t, err, `routes[0].handler.retrier: unknown field "retires"`)
+		assert.ErrorContains(t, err, `routes[0].handler.retrier.handler.chaos: unknown field "failure_chanse"`)
+		assert.ErrorContains(t, err, `unknown field "tpc"`)
+	})
+}
+
+// closedTestHandlers counts how often handlers of the test_closer type,
+// registered by the tests, were closed.
+var closedTestHandlers atomic.Int32
+
+type testCloserHandlerConfig struct{}
+
+type testCloserHandler struct{ http.Handler }
+
+func (h *testCloserHandler) Close() error {
+	closedTestHandlers.Add(1)
+	return nil
+}
+
+func init() {
+	RegisterHandlerType(NewHandlerType("test_closer", func(*testCloserHandlerConfig, *HandlerBuilder) (Handler, error) {
+		return &testCloserHandler{Handler: http.NotFoundHandler()}, nil
+	}))
+}
+
+func TestConfig_NamedHandlers(t *testing.T) {
+	t.Run("routes referencing a handler share one instance", func(t *testing.T) {
+		// language=JSON
+		configJson := `{
+			"handlers": {
+				"api": {"retrier": {"retries": 2, "handler": {"forward": {"url": "http://backend"}}}}
+			},
+			"routes": [
+				{"matcher": {"path": "/v1/"}, "handler": {"ref": "api"}},
+				{"matcher": {"path": "/v2/"}, "handler": {"chaos": {"failure_chance": 0.1, "handler": {"ref": "api"}}}}
+			]
+		}`
+		config, err := ReadConfigFromString(configJson)
+		require.NoError(t, err)
+
+		router, err := config.CreateRouter()
+		require.NoError(t, err)
+
+		shared := router.Routes["/v1/"].(*sharedHandler)
+		assert.IsType(t, &RetrierHandler{}, shared.Handler)
+		assert.Same(t, shared, router.Routes["/v2/"].(*ChaosHandler).Handler)
+		assert.NoError(t, router.Close())
+		assert.Equal(t, "chaos(failure_chance=0.1) > ref(api)", config.Routes[1].Handler.Describe())
+	})
+
+	t.Run("unknown reference should fail", func(t *testing.T) {
+		// language=JSON
+		configJson := `{"routes": [{"matcher": {"path": "/"}, "handler": {"ref": "missing"}}]}`
+		config, err := ReadConfigFromString(configJson)
+		require.NoError(t, err)
+
+		_, err = config.CreateRouter()
+		assert.ErrorContains(t, err, `unknown handler "missing"`)
+	})
+
+	t.Run("reference cycle should fail", func(t *testing.T) {
+		// language=JSON
+		configJson := `{
+			"handlers": {
+				"a": {"retrier": {"handler": {"ref": "b"}}},
+				"b": {"chaos": {"handler": {"ref": "a"}}}
+			},
+			"routes": [{"matcher": {"path": "/"}, "handler": {"ref": "a"}}]
+		}`
+		config, err := ReadConfigFromString(configJson)
+		require.NoError(t, err)
+
+		_, err = config.CreateRouter()
+		assert.ErrorContains(t, err, "handler reference cycle: a > b > a")
+	})
+
+	t.Run("unreferenced handlers are closed", func(t *testing.T) {
+		// language=JSON
+		configJson := `{
+			"handlers": {"used": {"test_closer": {}}, "unused": {"test_closer": {}}},
+			"routes": [{"matcher": {"path": "/"}, "handler": {"ref": "used"}}]
+		}`
+		config, err := ReadConfigFromString(configJson)
+		require.NoError(t, err)
+		closed := closedTestHandlers.Load()
+
+		router, err := config.CreateRouter()
+		require.NoError(t, err)
+		assert.Equal(t, closed+1, closedTestHandlers.Load())
+
+		require.NoError(t, router.Close())
+		assert.Equal(t, closed+2, closedTestHandlers.Load())
+	})
+
+	t.Run("created handlers are closed if a route fails", func(t *testing.T) {
+		// language=JSON
+		configJson := `{
+			"handlers": {"shared": {"test_closer": {}}},
+			"routes": [
+				{"matcher": {"path": "/a"}, "handler": {"ref": "shared"}},
+				{"matcher": {"path": "/b"}, "handler": {"retrier": {"retries": -1, "handler": {"ref": "shared"}}}},
+				{"matcher": {"path": "/c"}, "handler": {"test_closer": {}}},
+				{"matcher": {"path": "/d"}, "handler": {}}
+			]
+		}`
+		config, err := ReadConfigFromString(configJson)
+		require.NoError(t, err)
+		closed := closedTestHandlers.Load()
+
+		_, err = config.CreateRouter()
+
+		assert.EqualError(t, err, "routes[1].handler.retrier: retries must not be negative, got -1\n"+
+			"routes[3].handler: no handler set")
+		assert.Equal(t, closed+2, closedTestHandlers.Load(), "the shared handler closes once")
+	})
+
+	t.Run("validate reports invalid unreferenced handlers", func(t *testing.T) {
+		// language=JSON
+		configJson := `{"handlers": {"unused": {}}, "routes": []}`
+		config, err := ReadConfigFromString(configJson)
+		require.NoError(t, err)
+
+		assert.ErrorContains(t, config.Validate(), "handlers.unused: no handler set")
+	})
+}
+
+func TestConfig_CreateTCPProxies(t *testing.T) {
+	t.Run("create tcp proxy from json", func(t *testing.T) {
+		// language=JSON
+		configJson := `{"routes": [], "tcp": [{"listen": ":5432", "upstreams": ["db1:5432", "db2:5432"], "connect_timeout": "5s", "idle_timeout": "5m", "health_check": {"interval": "15s"}}]}`
+
+		config, err := ReadConfigFromString(configJson)
+		assert.NoError(t, err)
+
+		proxies, err := config.CreateTCPProxies()
+		assert.NoError(t, err)
+
+		assert.Len(t, proxies, 1)
+		tcpProxy := proxies[0]
+		assert.Equal(t, ":5432", tcpProxy.Address)
+		assert.Len(t, tcpProxy.Pool.Upstreams, 2)
+		assert.Equal(t, 5*time.Second, tcpProxy.ConnectTimeout)
+		assert.Equal(t, 5*time.Minute, tcpProxy.IdleTimeout)
+		assert.Equal(t, 15*time.Second, tcpProxy.HealthChecker.Interval)
+		assert.Equal(t, 2*time.Second, tcpProxy.HealthChecker.Timeout)
+	})
+
+	t.Run("tcp proxy without upstreams should fail", func(t *testing.T) {
+		// language=JSON
+		configJson := `{"routes": [], "tcp": [{"listen": ":5432", "upstreams": []}]}`
+
+		config, err := ReadConfigFromString(configJson)
+		assert.NoError(t, err)
+
+		_, err = config.CreateTCPProxies()
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "no upstreams configured")
+	})
+}
+
+func TestConfig_CreateUDPProxies(t *testing.T) {
+	t.Run("create udp proxy from json", func(t *testing.T) {
+		// language=JSON
+		configJson := `{"routes": [], "udp": [{"listen": ":53", "upstreams": ["10.0.0.1:53"], "session_timeout": "30s"}]}`
+
+		config, err := ReadConfigFromString(configJson)
+		assert.NoError(t, err)
+
+		proxies, err := config.CreateUDPProxies()
+		assert.NoError(t, err)
+
+		assert.Len(t, proxies, 1)
+		assert.Equal(t, ":53", proxies[0].Address)
+		assert.Equal(t, 30*time.Second, proxies[0].SessionTimeout)
+		assert.Equal(t, 10000, proxies[0].MaxSessions)
+	})
+
+	t.Run("max sessions", func(t *testing.T) {
+		// language=JSON
+		configJson := `{"routes": [], "udp": [{"listen": ":53", "upstreams": ["10.0.0.1:53"], "max_sessions": 100}, {"listen": ":54", "upstreams": ["10.0.0.1:53"], "max_sessions": -1}]}`
+
+		config, err := ReadConfigFromString(configJson)
+		assert.NoError(t, err)
+
+		proxy, err := config.UDP[0].createUDPProxy()
+		assert.NoError(t, err)
+		assert.Equal(t, 100, proxy.MaxSessions)
+		assert.EqualError(t, config.Validate(), "udp[1]: max_sessions must not be negative, got -1")
+	})
+
+	t.Run("udp proxy with non-positive session timeout should fail", func(t *testing.T) {
+		// language=JSON
+		configJson := `{"routes": [], "udp": [{"listen": ":53", "upstreams": ["10.0.0.1:53"], "session_timeout": "0s"}]}`
+
+		config, err := ReadConfigFromString(configJson)
+		assert.NoError(t, err)
+
+		_, err = config.CreateUDPProxies()
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "session timeout must be positive")
+	})
+}
+
+func TestConfig_CreateSOCKS5Proxy(t *testing.T) {
+	t.Run("create socks5 proxy from json", func(t *testing.T) {
+		// language=JSON
+		configJson := `{"routes": [], "socks5": {"listen": ":1080", "deny": ["*.internal"], "auth": {"username": "user", "password": "secret"}, "failure_chance": 0.1}}`
+
+		config, err := ReadConfigFromString(configJson)
+		assert.NoError(t, err)
+
+		socksProxy, err := config.CreateSOCKS5Proxy()
+		assert.NoError(t, err)
+
+		assert.Equal(t, ":1080", socksProxy.Address)
+		assert.Equal(t, []string{"*.internal"}, socksProxy.Deny)
+		assert.Equal(t, "user", socksProxy.Username)
+		assert.Equal(t, 0.1, socksProxy.FailureChance)
+		assert.Equal(t, 10*time.Second, socksProxy.ConnectTimeout)
+	})
+
+	t.Run("socks5 proxy is optional", func(t *testing.T) {
+		config, err := ReadConfigFromString(`{"routes": []}`)
+		assert.NoError(t, err)
+
+		socksProxy, err := config.CreateSOCKS5Proxy()
+		assert.NoError(t, err)
+		assert.Nil(t, socksProxy)
+	})
+}
+
+func TestConfig_Validate(t *testing.T) {
+	t.Run("valid config", func(t *testing.T) {
+		// language=JSON
+		configJson := `{"routes": [{"matcher": {"path": "/hello"}, "handler": {"static": {"message": "Hello there!"}}}]}`
+
+		config, err := ReadConfigFromString(configJson)
+		assert.NoError(t, err)
+
+		assert.NoError(t, config.Validate())
+	})
+
+	t.Run("reports all errors", func(t *testing.T) {
+		// language=JSON
+		configJson := `{"routes": [{"matcher": {"path": "/a"}, "handler": {}}, {"matcher": {"path": "/b"}, "handler": {"static": {}}}, {"matcher": {"path": "/b"}, "handler": {"static": {}}}], "udp": [{"listen": ":53", "upstreams": ["invalid"]}]}`
+
+		config, err := ReadConfigFromString(configJson)
+		assert.NoError(t, err)
+
+		err = config.Validate()
+		assert.ErrorContains(t, err, "routes[0].handler: no handler set")
+		assert.ErrorContains(t, err, "routes[2].matcher.path: duplicate route /b")
+		assert.ErrorContains(t, err, "udp[0]: invalid upstreams")
+	})
+
+	t.Run("reports errors at their path", func(t *testing.T) {
+		// language=JSON
+		configJson := `{
+			"routes": [
+				{"matcher": {"path": "/a"}, "handler": {"static": {}}},
+				{"matcher": {"path": "/b"}, "handler": {"retrier": {"retries": -1, "handler": {"chaos": {"failure_chance": 1.5, "handler": {"echo": {}}}}}}},
+				{"matcher": {"path": "/c"}, "handler": {"fanout": {"response_strategy": "first_successful", "handlers": [{"echo": {}}, {}]}}}
+			],
+			"socks5": {"listen": ":1080", "failure_chance": -0.1}
+		}`
+
+		config, err := ReadConfigFromString(configJson)
+		assert.NoError(t, err)
+
+		err = config.Validate()
+		assert.ErrorContains(t, err, "routes[1].handler.retrier: retries must not be negative, got -1")
+		assert.ErrorContains(t, err, "routes[1].handler.retrier.handler.chaos: failure_chance must be between 0 and 1, got 1.5")
+		assert.ErrorContains(t, err, "routes[2].handler.fanout.handlers[1]: no handler set")
+		assert.ErrorContains(t, err, "socks5: failure_chance must be between 0 and 1, got -0.1")
+		assert.Len(t, flattenErrors(err), 4)
+	})
+
+	t.Run("create router fails on duplicate route instead of panicking", func(t *testing.T) {
+		// language=JSON
+		configJson := `{"routes": [{"matcher": {"path": "/b"}, "handler": {"static": {}}}, {"matcher": {"path": "/b"}, "handler": {"static": {}}}]}`
+
+		config, err := ReadConfigFromString(configJson)
+		assert.NoError(t, err)
+
+		_, err = config.CreateRouter()
+		assert.ErrorContains(t, err, "duplicate route /b")
+	})
+}
+
+func TestHandlerConfig_Describe(t *testing.T) {
+	// language=JSON
+	configJson := `{"routes": [
+		{"matcher": {"path": "/retrier"}, "handler": {"retrier": {"handler": {"chaos": {"failure_chance": 0.3, "handler": {"static": {"message": "Hello there!"}}}}, "retries": 1}}},
+		{"matcher": {"path": "/fanout"}, "handler": {"fanout": {"handlers": [{"echo": {}}, {"forward": {"url": "https://example.com"}}], "response_strategy": "first_successful"}}},
+		{"matcher": {"path": "/invalid"}, "handler": {}}
+	]}`
+
+	config, err := ReadConfigFromString(configJson)
+	assert.NoError(t, err)
+
+	assert.Equal(t, "retrier(retries=1) > chaos(failure_chance=0.3) > static", config.Routes[0].Handler.Describe())
+	assert.Equal(t, "fanout(response_strategy=first_successful)[echo, forward(url=https://example.com)]", config.Routes[1].Handler.Describe())
+	assert.Equal(t, "invalid(no handler set)", config.Routes[2].Handler.Describe())
+}
+
+func TestConfig_CreateServerConfig(t *testing.T) {
+	t.Run("defaults without server section", func(t *testing.T) {
+		config, err := ReadConfigFromString(`{"routes": []}`)
+		assert.NoError(t, err)
+
+		serverConfig, err := config.CreateServerConfig()
+		assert.NoError(t, err)
+		assert.Equal(t, server.DefaultConfig(), serverConfig)
+	})
+
+	t.Run("server section overrides defaults", func(t *testing.T) {
+		// language=JSON
+		configJson := `{"server": {"listen": [":9000", ":9001"], "read_timeout": "5s", "read_header_timeout": "2s", "write_timeout": "10s", "idle_timeout": "2m", "max_header_bytes": 4096, "shutdown_timeout": "1m", "keep_alive": false}, "routes": []}`
+
+		config, err := ReadConfigFromString(configJson)
+		assert.NoError(t, err)
+
+		serverConfig, err := config.CreateServerConfig()
+		assert.NoError(t, err)
+		assert.Equal(t, &server.Config{
+			Port:              ":9000",
+			Listeners:         []string{":9001"},
+			ReadTimeout:       5 * time.Second,
+			ReadHeaderTimeout: 2 * time.Second,
+			WriteTimeout:      10 * time.Second,
+			IdleTimeout:       2 * time.Minute,
+			MaxHeaderBytes:    4096,
+			ShutdownTimeout:   time.Minute,
+			DisableKeepAlives: true,
+		}, serverConfig)
+	})
+
+	t.Run("invalid timeout should fail", func(t *testing.T) {
+		// language=JSON
+		configJson := `{"server": {"shutdown_timeout": "-1s"}, "routes": []}`
+
+		config, err := ReadConfigFromString(configJson)
+		assert.NoError(t, err)
+
+		_, err = config.CreateServerConfig()
+		assert.ErrorContains(t, err, "shutdown timeout must not be negative")
 	})
 }
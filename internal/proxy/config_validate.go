@@ -0,0 +1,247 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// configPathError is an error at a JSON path of the configuration, e.g.
+// "routes[3].handler.retrier.handler.chaos".
+type configPathError struct {
+	path string
+	err  error
+}
+
+func (e *configPathError) Error() string {
+	return e.path + ": " + e.err.Error()
+}
+
+func (e *configPathError) Unwrap() error {
+	return e.err
+}
+
+// atConfigPath attaches path to err, or to every error joined in err, unless
+// an error was reported at a more precise path already.
+func atConfigPath(path string, err error) error {
+	if path == "" {
+		return err
+	}
+	errs := flattenErrors(err)
+	for i, e := range errs {
+		var pathErr *configPathError
+		if !errors.As(e, &pathErr) {
+			errs[i] = &configPathError{path: path, err: e}
+		}
+	}
+	if len(errs) == 1 {
+		return errs[0]
+	}
+	return errors.Join(errs...)
+}
+
+// flattenErrors returns the errors joined in err, recursively.
+func flattenErrors(err error) []error {
+	joined, ok := err.(interface{ Unwrap() []error })
+	if !ok {
+		return []error{err}
+	}
+	var errs []error
+	for _, e := range joined.Unwrap() {
+		errs = append(errs, flattenErrors(e)...)
+	}
+	return errs
+}
+
+// decodeFields reports every field of a JSON document which doesn't exist in
+// the value v it was decoded into, which encoding/json silently ignores. It
+// also decodes the configs of registered handler types into their handler
+// configs, which encoding/json can't do on its own. locate attaches the
+// position in the source to type errors of handler configs.
+func decodeFields(jsonBytes []byte, v reflect.Value, locate func(error) error) error {
+	decoder := json.NewDecoder(bytes.NewReader(jsonBytes))
+	// keep numbers as written for decoding registered handler types
+	decoder.UseNumber()
+	var document any
+	if err := decoder.Decode(&document); err != nil {
+		return err
+	}
+	errs := decodeDocumentFields(document, v, "")
+	for i, err := range errs {
+		errs[i] = locateTypeError(jsonBytes, err, locate)
+	}
+	return errors.Join(errs...)
+}
+
+// locateTypeError locates the value of a type error in a handler config,
+// which was decoded from a copy of the document, in the document itself.
+func locateTypeError(jsonBytes []byte, err error, locate func(error) error) error {
+	var pathErr *configPathError
+	var typeErr *json.UnmarshalTypeError
+	if !errors.As(err, &pathErr) || !errors.As(pathErr.err, &typeErr) {
+		return err
+	}
+	path := pathErr.path
+	if typeErr.Field != "" {
+		path = joinConfigPath(path, typeErr.Field)
+	}
+	end, ok := jsonValueEnd(jsonBytes, path)
+	if !ok {
+		return err
+	}
+	located := *typeErr
+	located.Offset = int64(end)
+	return &configPathError{path: pathErr.path, err: locate(&located)}
+}
+
+// jsonValueEnd returns the offset right after the value at path in a JSON
+// document, which is where encoding/json reports type errors.
+func jsonValueEnd(jsonBytes []byte, path string) (int, bool) {
+	decoder := json.NewDecoder(bytes.NewReader(jsonBytes))
+	end := -1
+	var value func(current string) error
+	value = func(current string) error {
+		token, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+		if delim, ok := token.(json.Delim); ok {
+			for i := 0; decoder.More(); i++ {
+				elemPath := current + "[" + strconv.Itoa(i) + "]"
+				if delim == '{' {
+					key, err := decoder.Token()
+					if err != nil {
+						return err
+					}
+					elemPath = joinConfigPath(current, key.(string))
+				}
+				if err := value(elemPath); err != nil {
+					return err
+				}
+			}
+			// the closing delimiter
+			if _, err := decoder.Token(); err != nil {
+				return err
+			}
+		}
+		if current == path && end < 0 {
+			end = int(decoder.InputOffset())
+		}
+		return nil
+	}
+	if err := value(""); err != nil || end < 0 {
+		return 0, false
+	}
+	return end, true
+}
+
+func decodeDocumentFields(document any, v reflect.Value, path string) []error {
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+
+	var errs []error
+	switch document := document.(type) {
+	case map[string]any:
+		keys := slices.Sorted(maps.Keys(document))
+		switch v.Kind() {
+		case reflect.Struct:
+			fields := jsonFields(v.Type())
+			for _, key := range keys {
+				fieldPath := joinConfigPath(path, key)
+				if i, ok := fields[key]; ok {
+					errs = append(errs, decodeDocumentFields(document[key], v.Field(i), fieldPath)...)
+					continue
+				}
+				if handlerConfig, ok := addressOf(v).(*HandlerConfig); ok {
+					errs = append(errs, decodeHandlerType(handlerConfig, key, document[key], fieldPath)...)
+					continue
+				}
+				errs = append(errs, atConfigPath(path, fmt.Errorf("unknown field %q", key)))
+			}
+		case reflect.Map:
+			for _, key := range keys {
+				// map values aren't addressable, so they're decoded into a copy
+				mapKey := reflect.ValueOf(key).Convert(v.Type().Key())
+				value := reflect.New(v.Type().Elem()).Elem()
+				value.Set(v.MapIndex(mapKey))
+				errs = append(errs, decodeDocumentFields(document[key], value, joinConfigPath(path, key))...)
+				v.SetMapIndex(mapKey, value)
+			}
+		}
+	case []any:
+		if v.Kind() == reflect.Slice {
+			for i, item := range document {
+				errs = append(errs, decodeDocumentFields(item, v.Index(i), path+"["+strconv.Itoa(i)+"]")...)
+			}
+		}
+	}
+	return errs
+}
+
+// addressOf returns a pointer to v, or nil if it isn't addressable.
+func addressOf(v reflect.Value) any {
+	if !v.CanAddr() {
+		return nil
+	}
+	return v.Addr().Interface()
+}
+
+// decodeHandlerType decodes the config of the registered handler type name
+// into h.
+func decodeHandlerType(h *HandlerConfig, name string, document any, path string) []error {
+	handlerType, ok := lookupHandlerType(name)
+	if !ok {
+		return []error{atConfigPath(path, fmt.Errorf("unknown handler type"))}
+	}
+	data, err := json.Marshal(document)
+	if err != nil {
+		return []error{atConfigPath(path, err)}
+	}
+	config, err := handlerType.Decode(data)
+	if err != nil {
+		return []error{atConfigPath(path, err)}
+	}
+	if h.Configs == nil {
+		h.Configs = make(map[string]any)
+	}
+	h.Configs[name] = config
+	return decodeDocumentFields(document, reflect.ValueOf(config), path)
+}
+
+// jsonFields returns the indexes of the fields of struct type t by JSON name.
+func jsonFields(t reflect.Type) map[string]int {
+	fields := make(map[string]int, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		fields[name] = i
+	}
+	return fields
+}
+
+// checkChance checks that the probability named name is between 0 and 1.
+func checkChance(name string, chance float64) error {
+	if chance < 0 || chance > 1 {
+		return fmt.Errorf("%s must be between 0 and 1, got %v", name, chance)
+	}
+	return nil
+}
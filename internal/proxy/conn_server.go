@@ -0,0 +1,126 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+)
+
+// connServer accepts connections from a listener and tracks them, so they can
+// be drained on shutdown. It's embedded by the connection based proxies.
+type connServer struct {
+	mu       sync.Mutex
+	listener net.Listener
+	conns    map[net.Conn]struct{}
+	closed   bool
+	wg       sync.WaitGroup
+}
+
+// serve accepts connections and handles each in its own goroutine, closing
+// the connection once handle returns. It returns nil after shutdown.
+func (s *connServer) serve(listener net.Listener, handle func(conn net.Conn)) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		_ = listener.Close()
+		return nil
+	}
+	s.listener = listener
+	s.conns = make(map[net.Conn]struct{})
+	s.mu.Unlock()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if s.isClosed() {
+				return nil
+			}
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				continue
+			}
+			return err
+		}
+		if !s.track(conn) {
+			_ = conn.Close()
+			return nil
+		}
+		go func() {
+			defer s.untrack(conn)
+			defer conn.Close()
+			handle(conn)
+		}()
+	}
+}
+
+func (s *connServer) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
+
+func (s *connServer) track(conn net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	s.conns[conn] = struct{}{}
+	s.wg.Add(1)
+	return true
+}
+
+func (s *connServer) untrack(conn net.Conn) {
+	s.mu.Lock()
+	delete(s.conns, conn)
+	s.mu.Unlock()
+	s.wg.Done()
+}
+
+func (s *connServer) activeConns() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.conns)
+}
+
+// Addr returns the address the server listens on, or nil when it isn't serving.
+func (s *connServer) Addr() net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Addr()
+}
+
+// shutdown stops accepting connections and waits for active ones to finish.
+// Connections still active when ctx is done are closed forcibly.
+func (s *connServer) shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.closed = true
+	var err error
+	if s.listener != nil {
+		err = s.listener.Close()
+	}
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return err
+	case <-ctx.Done():
+		s.mu.Lock()
+		for conn := range s.conns {
+			_ = conn.Close()
+		}
+		s.mu.Unlock()
+		<-done
+		return ctx.Err()
+	}
+}
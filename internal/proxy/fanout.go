@@ -1,15 +1,18 @@
 package proxy
 
 import (
-	"log"
+	"log/slog"
 	"net/http"
+	"strconv"
 	"sync"
+	"sync/atomic"
 )
 
 // FanOutHandler executes multiple handlers concurrently
 type FanOutHandler struct {
 	Handlers         []Handler
 	ResponseStrategy ResponseStrategy
+	Logger           *slog.Logger
 }
 
 func (h *FanOutHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -19,33 +22,54 @@ func (h *FanOutHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var wg sync.WaitGroup
+	var streamed atomic.Bool
 	responses := make([]bufferedResponse, len(h.Handlers))
 
+	// the first handler to produce a streaming response is written through to the client
+	claimStream := func() bool {
+		return streamed.CompareAndSwap(false, true)
+	}
+
+	route := routeFromContext(r.Context())
 	for i, handler := range h.Handlers {
 		wg.Add(1)
 		go func(index int, h Handler) {
 			defer wg.Done()
 
-			brw := NewBufferedResponseWriter()
-			h.ServeHTTP(brw, r)
+			ctx, span := startSpan(r.Context(), "fanout branch", SpanKindInternal)
+			span.SetAttribute("fanout.branch", index)
+			brw := NewStreamingResponseWriter(w, claimStream)
+			h.ServeHTTP(brw, requestWithContext(r, ctx))
+			span.setStatusCode(brw.statusCode)
+			span.Finish()
 			responses[index] = bufferedResponse{
 				statusCode: brw.statusCode,
 				header:     brw.header,
 				body:       brw.buffer.Bytes(),
 			}
+			fanoutBranches.Inc(route, strconv.Itoa(index), statusClass(brw.statusCode))
 		}(i, handler)
 	}
 
 	wg.Wait()
 
-	h.ResponseStrategy.write(w, responses)
+	if streamed.Load() {
+		return
+	}
+	if err := h.ResponseStrategy.write(w, responses); err != nil {
+		loggerOrDefault(h.Logger).ErrorContext(r.Context(), "Error writing response", "error", err)
+	}
+}
+
+func (h *FanOutHandler) Close() error {
+	return closeHandlers(h.Handlers)
 }
 
 type ResponseStrategy interface {
 	write(
 		w http.ResponseWriter,
 		responses []bufferedResponse,
-	)
+	) error
 }
 
 type bufferedResponse struct {
@@ -59,7 +83,7 @@ type FirstSuccessfulResponseStrategy struct{}
 func (s *FirstSuccessfulResponseStrategy) write(
 	w http.ResponseWriter,
 	responses []bufferedResponse,
-) {
+) error {
 	// Write the first successful response
 	for _, result := range responses {
 		if result.statusCode >= 200 && result.statusCode < 300 {
@@ -70,10 +94,8 @@ func (s *FirstSuccessfulResponseStrategy) write(
 				}
 			}
 			w.WriteHeader(result.statusCode)
-			if _, err := w.Write(result.body); err != nil {
-				log.Printf("Error writing response: %v", err)
-			}
-			return
+			_, err := w.Write(result.body)
+			return err
 		}
 	}
 
@@ -86,8 +108,8 @@ func (s *FirstSuccessfulResponseStrategy) write(
 			}
 		}
 		w.WriteHeader(result.statusCode)
-		if _, err := w.Write(result.body); err != nil {
-			log.Printf("Error writing response: %v", err)
-		}
+		_, err := w.Write(result.body)
+		return err
 	}
+	return nil
 }
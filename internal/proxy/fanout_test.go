@@ -31,6 +31,27 @@ func TestFanOutHandler_ServeHTTP(t *testing.T) {
 		assert.Equal(t, 2, capturingHandler.Invocations, "Expected handler to be invoked twice")
 	})
 
+	t.Run("streaming response is passed through", func(t *testing.T) {
+		handler := FanOutHandler{
+			Handlers: []Handler{
+				&MockHandler{statusCodes: []int{http.StatusOK}},
+				&StreamingMockHandler{statusCode: http.StatusOK, message: "data: hello\n\n"},
+			},
+			ResponseStrategy: &FirstSuccessfulResponseStrategy{},
+		}
+
+		responseRecorder := httptest.NewRecorder()
+
+		req, err := http.NewRequest("GET", "/stream", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		handler.ServeHTTP(responseRecorder, req)
+
+		assert.Equal(t, "text/event-stream", responseRecorder.Header().Get("Content-Type"))
+		assert.Equal(t, "data: hello\n\n", responseRecorder.Body.String())
+	})
 }
 
 type CapturingHandler struct {
@@ -3,16 +3,41 @@ package proxy
 import (
 	"bytes"
 	"context"
+	"errors"
+	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
+	"net/http/httptrace"
 	"net/url"
+	"strconv"
+	"strings"
 	"time"
 )
 
 type ForwardHandler struct {
 	URL    url.URL
 	Client *http.Client
+	// Timeout bounds forwarding a request until its response is read. Streaming
+	// responses are only bounded until their header arrives, as they last as
+	// long as the upstream keeps them open. Zero disables the timeout.
+	Timeout time.Duration
+	// FlushInterval is the period in which response data is flushed to the client.
+	// Zero disables periodic flushing and a negative value flushes after every write.
+	// Streaming responses, like text/event-stream, are always flushed immediately.
+	FlushInterval time.Duration
+	Logger        *slog.Logger
+}
+
+// NewHTTP2Transport creates a transport which only speaks HTTP/2 to upstreams,
+// using prior knowledge h2c for plain http URLs, as needed e.g. by gRPC.
+func NewHTTP2Transport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	protocols := new(http.Protocols)
+	protocols.SetHTTP2(true)
+	protocols.SetUnencryptedHTTP2(true)
+	transport.Protocols = protocols
+	return transport
 }
 
 func NewForwardHandler(targetURL string) (*ForwardHandler, error) {
@@ -22,10 +47,9 @@ func NewForwardHandler(targetURL string) (*ForwardHandler, error) {
 	}
 
 	forwardHandler := ForwardHandler{
-		URL: *u,
-		Client: &http.Client{
-			Timeout: 30 * time.Second,
-		},
+		URL:     *u,
+		Client:  &http.Client{},
+		Timeout: 30 * time.Second,
 	}
 	return &forwardHandler, nil
 }
@@ -34,23 +58,56 @@ func (h *ForwardHandler) ServeHTTP(
 	w http.ResponseWriter,
 	r *http.Request,
 ) {
-	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
-	defer cancel()
+	ctx, cancel := context.WithCancelCause(r.Context())
+	defer cancel(nil)
+	// unlike a context deadline, the timer can be stopped once a response
+	// turns out to be a stream
+	var timeout *time.Timer
+	limit := h.Timeout
+	// gRPC calls may carry a shorter deadline of their own
+	if grpcLimit, ok := grpcTimeout(r.Header); ok && isGRPCRequest(r) && (limit <= 0 || grpcLimit < limit) {
+		limit = grpcLimit
+	}
+	if limit > 0 {
+		timeout = time.AfterFunc(limit, func() {
+			cancel(fmt.Errorf("no response within %s: %w", limit, context.DeadlineExceeded))
+		})
+		defer timeout.Stop()
+	}
 
-	body, err := io.ReadAll(r.Body)
-	if err != nil {
-		log.Printf("Error reading body: %v", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
-		return
+	// gRPC calls stream messages both ways, so their body is passed through
+	// as it arrives instead of being read first
+	var body io.Reader = r.Body
+	if !isGRPCRequest(r) {
+		content, err := io.ReadAll(r.Body)
+		if err != nil {
+			loggerOrDefault(h.Logger).ErrorContext(r.Context(), "Error reading body", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		body = bytes.NewReader(content)
 	}
 	defer r.Body.Close()
 
-	newReq, err := http.NewRequestWithContext(ctx, r.Method, h.URL.String(), bytes.NewBuffer(body))
+	upstream := h.URL.Host
+	upstreamInFlight.Inc(upstream)
+	defer upstreamInFlight.Dec(upstream)
+	// reports whether connections of the client's pool are reused
+	ctx = httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			upstreamConnections.Inc(upstream, strconv.FormatBool(info.Reused))
+		},
+	})
+
+	newReq, err := http.NewRequestWithContext(ctx, r.Method, h.targetURL(r), body)
 	if err != nil {
-		log.Printf("Error creating request: %v", err)
+		loggerOrDefault(h.Logger).ErrorContext(r.Context(), "Error creating request", "error", err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
+	if isGRPCRequest(r) {
+		newReq.ContentLength = r.ContentLength
+	}
 
 	for name, values := range r.Header {
 		for _, value := range values {
@@ -58,22 +115,91 @@ func (h *ForwardHandler) ServeHTTP(
 		}
 	}
 
+	_, span := startSpan(ctx, "forward", SpanKindClient)
+	defer span.Finish()
+	span.SetAttribute("http.request.method", r.Method)
+	span.SetAttribute("server.address", upstream)
+	span.SetAttribute("url.full", newReq.URL.String())
+	span.injectHeaders(newReq.Header)
+	injectRequestID(r.Context(), newReq.Header)
+	defer func(start time.Time) {
+		detailsFromContext(ctx).addUpstream(upstream, time.Since(start))
+	}(time.Now())
+
 	resp, err := h.Client.Do(newReq)
 	if err != nil {
-		log.Printf("Error forwarding request: %v", err)
+		if cause := context.Cause(ctx); cause != nil && !errors.Is(err, cause) {
+			err = fmt.Errorf("%w: %w", cause, err)
+		}
+		upstreamErrors.Inc(upstream)
+		span.SetError(err.Error())
+		loggerOrDefault(h.Logger).ErrorContext(r.Context(), "Error forwarding request", "upstream", upstream, "error", err)
+		if isGRPCRequest(r) {
+			writeGRPCError(w, err)
+			return
+		}
 		http.Error(w, "Bad Gateway", http.StatusBadGateway)
 		return
 	}
 	defer resp.Body.Close()
+	span.setStatusCode(resp.StatusCode)
+
+	copyHeader(w.Header(), resp.Header)
 
-	for name, values := range resp.Header {
+	// announce trailers so they can be sent after the body, gRPC relies on
+	// them to report grpc-status and grpc-message
+	for name := range resp.Trailer {
+		w.Header().Add("Trailer", name)
+	}
+
+	streaming := isStreamingResponse(resp.Header)
+	if streaming && timeout != nil {
+		timeout.Stop()
+	}
+	w.WriteHeader(resp.StatusCode)
+	if streaming {
+		// streams outlive the write timeout of the server
+		err := http.NewResponseController(w).SetWriteDeadline(time.Time{})
+		if err != nil && !errors.Is(err, http.ErrNotSupported) {
+			loggerOrDefault(h.Logger).WarnContext(r.Context(), "Error clearing write deadline", "error", err)
+		}
+		// the header is sent right away, as bidirectional streams may only
+		// produce a message once the client has sent one
+		_ = flush(w)
+	}
+	if err := copyResponse(w, resp.Body, h.flushInterval(resp)); err != nil {
+		loggerOrDefault(h.Logger).ErrorContext(r.Context(), "Error copying response", "upstream", upstream, "error", err)
+	}
+
+	// trailers are only known once the body has been read
+	for name, values := range resp.Trailer {
 		for _, value := range values {
-			w.Header().Add(name, value)
+			w.Header().Add(http.TrailerPrefix+name, value)
 		}
 	}
+}
 
-	w.WriteHeader(resp.StatusCode)
-	if _, err := io.Copy(w, resp.Body); err != nil {
-		log.Printf("Error copying response: %v", err)
+// Close closes idle upstream connections.
+func (h *ForwardHandler) Close() error {
+	h.Client.CloseIdleConnections()
+	return nil
+}
+
+// targetURL returns the URL the request is forwarded to. gRPC requests keep
+// their /package.Service/Method path, as it identifies the called method.
+func (h *ForwardHandler) targetURL(r *http.Request) string {
+	if !isGRPCRequest(r) {
+		return h.URL.String()
+	}
+	target := h.URL
+	target.Path = strings.TrimSuffix(target.Path, "/") + r.URL.Path
+	target.RawPath = ""
+	return target.String()
+}
+
+func (h *ForwardHandler) flushInterval(resp *http.Response) time.Duration {
+	if isStreamingResponse(resp.Header) {
+		return -1
 	}
+	return h.FlushInterval
 }
@@ -0,0 +1,223 @@
+package proxy
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ForwardProxyHandler acts as an HTTP forward proxy, e.g. for clients using it
+// through HTTP_PROXY. It tunnels CONNECT requests and forwards requests with
+// absolute-form targets to the host they name.
+type ForwardProxyHandler struct {
+	// Allow lists hosts which may be reached, empty allows every host. Patterns
+	// are either exact host names or wildcards like "*.example.com".
+	Allow []string
+	// Deny lists hosts which may not be reached, it takes precedence over Allow.
+	Deny []string
+	// Username and Password enable Basic authentication via Proxy-Authorization.
+	Username       string
+	Password       string
+	ConnectTimeout time.Duration
+	Client         *http.Client
+	Logger         *slog.Logger
+}
+
+func NewForwardProxyHandler() *ForwardProxyHandler {
+	return &ForwardProxyHandler{
+		ConnectTimeout: 10 * time.Second,
+		Client: &http.Client{
+			Timeout: 30 * time.Second,
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				// redirects are for the client to follow
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+}
+
+// hopByHopHeaders are meaningful only for a single connection and aren't forwarded.
+var hopByHopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Proxy-Connection",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// removeHopByHopHeaders removes the hop-by-hop headers from header, including
+// those listed by its Connection header.
+func removeHopByHopHeaders(header http.Header) {
+	for _, value := range header.Values("Connection") {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				header.Del(name)
+			}
+		}
+	}
+	for _, name := range hopByHopHeaders {
+		header.Del(name)
+	}
+}
+
+func (h *ForwardProxyHandler) ServeHTTP(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	if !h.authorized(r) {
+		w.Header().Set("Proxy-Authenticate", `Basic realm="proxy"`)
+		http.Error(w, "Proxy authentication required", http.StatusProxyAuthRequired)
+		return
+	}
+
+	if r.Method == http.MethodConnect {
+		h.tunnel(w, r)
+		return
+	}
+	if !r.URL.IsAbs() {
+		http.Error(w, "Absolute-form request target required", http.StatusBadRequest)
+		return
+	}
+	if !h.allowed(r.URL.Hostname()) {
+		http.Error(w, "Host not allowed", http.StatusForbidden)
+		return
+	}
+	h.forward(w, r)
+}
+
+// Close closes idle outbound connections.
+func (h *ForwardProxyHandler) Close() error {
+	h.Client.CloseIdleConnections()
+	return nil
+}
+
+func (h *ForwardProxyHandler) authorized(r *http.Request) bool {
+	if h.Username == "" && h.Password == "" {
+		return true
+	}
+	scheme, encoded, ok := strings.Cut(r.Header.Get("Proxy-Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Basic") {
+		return false
+	}
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return false
+	}
+	username, password, ok := strings.Cut(string(decoded), ":")
+	if !ok {
+		return false
+	}
+	usernameMatches := subtle.ConstantTimeCompare([]byte(username), []byte(h.Username)) == 1
+	passwordMatches := subtle.ConstantTimeCompare([]byte(password), []byte(h.Password)) == 1
+	return usernameMatches && passwordMatches
+}
+
+func (h *ForwardProxyHandler) allowed(host string) bool {
+	return hostAllowed(host, h.Allow, h.Deny)
+}
+
+// hostAllowed checks host against allow and deny lists, deny taking precedence.
+// An empty allow list allows every host which isn't denied.
+func hostAllowed(host string, allow, deny []string) bool {
+	for _, pattern := range deny {
+		if hostMatches(pattern, host) {
+			return false
+		}
+	}
+	if len(allow) == 0 {
+		return true
+	}
+	for _, pattern := range allow {
+		if hostMatches(pattern, host) {
+			return true
+		}
+	}
+	return false
+}
+
+func hostMatches(pattern, host string) bool {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	pattern = strings.ToLower(pattern)
+	if suffix, ok := strings.CutPrefix(pattern, "*."); ok {
+		return strings.HasSuffix(host, "."+suffix)
+	}
+	return host == pattern
+}
+
+func (h *ForwardProxyHandler) tunnel(w http.ResponseWriter, r *http.Request) {
+	host, _, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		http.Error(w, "Invalid CONNECT target", http.StatusBadRequest)
+		return
+	}
+	if !h.allowed(host) {
+		http.Error(w, "Host not allowed", http.StatusForbidden)
+		return
+	}
+
+	target, err := net.DialTimeout("tcp", r.Host, h.ConnectTimeout)
+	if err != nil {
+		loggerOrDefault(h.Logger).ErrorContext(r.Context(), "Error connecting to target", "target", r.Host, "error", err)
+		http.Error(w, "Bad Gateway", http.StatusBadGateway)
+		return
+	}
+	defer target.Close()
+
+	client, buffered, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		loggerOrDefault(h.Logger).ErrorContext(r.Context(), "Error hijacking connection", "error", err)
+		http.Error(w, "Tunneling not supported", http.StatusInternalServerError)
+		return
+	}
+	defer client.Close()
+	// deadlines set by the server for the CONNECT request don't apply to the tunnel
+	_ = client.SetDeadline(time.Time{})
+
+	if _, err := client.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n")); err != nil {
+		loggerOrDefault(h.Logger).ErrorContext(r.Context(), "Error writing CONNECT response", "error", err)
+		return
+	}
+	if n := buffered.Reader.Buffered(); n > 0 {
+		data, _ := buffered.Reader.Peek(n)
+		if _, err := target.Write(data); err != nil {
+			loggerOrDefault(h.Logger).ErrorContext(r.Context(), "Error writing to target", "target", r.Host, "error", err)
+			return
+		}
+	}
+
+	pipe(client, target, 0)
+}
+
+func (h *ForwardProxyHandler) forward(w http.ResponseWriter, r *http.Request) {
+	outReq := r.Clone(r.Context())
+	outReq.RequestURI = ""
+	removeHopByHopHeaders(outReq.Header)
+
+	resp, err := h.Client.Do(outReq)
+	if err != nil {
+		loggerOrDefault(h.Logger).ErrorContext(r.Context(), "Error forwarding request", "target", r.URL.Host, "error", err)
+		http.Error(w, "Bad Gateway", http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+
+	removeHopByHopHeaders(resp.Header)
+	copyHeader(w.Header(), resp.Header)
+	w.WriteHeader(resp.StatusCode)
+
+	var flushInterval time.Duration
+	if isStreamingResponse(resp.Header) {
+		flushInterval = -1
+	}
+	if err := copyResponse(w, resp.Body, flushInterval); err != nil {
+		loggerOrDefault(h.Logger).ErrorContext(r.Context(), "Error copying response", "target", r.URL.Host, "error", err)
+	}
+}
@@ -0,0 +1,250 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestForwardProxyHandler_ServeHTTP(t *testing.T) {
+	t.Run("forwards absolute-form requests", func(t *testing.T) {
+		targetServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "/hello", r.URL.Path)
+			assert.Empty(t, r.Header.Get("Proxy-Authorization"), "Proxy credentials must not be forwarded")
+			_, _ = w.Write([]byte("Hello there!"))
+		}))
+		defer targetServer.Close()
+
+		handler := NewForwardProxyHandler()
+		client := newProxiedClient(t, handler, nil)
+
+		resp, err := client.Get(targetServer.URL + "/hello")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		body, _ := io.ReadAll(resp.Body)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "Hello there!", string(body))
+	})
+
+	t.Run("removes headers listed in Connection", func(t *testing.T) {
+		targetServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Empty(t, r.Header.Get("X-Hop"), "Headers listed in Connection must not be forwarded")
+			assert.Equal(t, "kept", r.Header.Get("X-End-To-End"))
+			w.Header().Set("Connection", "X-Response-Hop")
+			w.Header().Set("X-Response-Hop", "hop")
+		}))
+		defer targetServer.Close()
+
+		handler := NewForwardProxyHandler()
+		req := httptest.NewRequest("GET", targetServer.URL, nil)
+		req.Header.Set("Connection", "keep-alive, X-Hop")
+		req.Header.Set("X-Hop", "hop")
+		req.Header.Set("X-End-To-End", "kept")
+		w := httptest.NewRecorder()
+
+		handler.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Empty(t, w.Header().Get("X-Response-Hop"))
+	})
+
+	t.Run("tunnels CONNECT requests", func(t *testing.T) {
+		targetServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte("Hello over TLS!"))
+		}))
+		defer targetServer.Close()
+
+		handler := NewForwardProxyHandler()
+		client := targetServer.Client()
+		proxyServer := httptest.NewServer(newForwardProxyRouter(handler))
+		defer proxyServer.Close()
+		proxyURL, _ := url.Parse(proxyServer.URL)
+		client.Transport.(*http.Transport).Proxy = http.ProxyURL(proxyURL)
+
+		resp, err := client.Get(targetServer.URL)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		body, _ := io.ReadAll(resp.Body)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "Hello over TLS!", string(body))
+	})
+
+	t.Run("tunnels CONNECT requests behind a retrier", func(t *testing.T) {
+		targetServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte("Hello over TLS!"))
+		}))
+		defer targetServer.Close()
+
+		retrier := &RetrierHandler{Handler: NewForwardProxyHandler(), RetryPolicy: &RetryOnNon2xxRetryPolicy{}, Retries: 2}
+		client := targetServer.Client()
+		proxyServer := httptest.NewServer(retrier)
+		defer proxyServer.Close()
+		proxyURL, _ := url.Parse(proxyServer.URL)
+		client.Transport.(*http.Transport).Proxy = http.ProxyURL(proxyURL)
+
+		resp, err := client.Get(targetServer.URL)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		body, _ := io.ReadAll(resp.Body)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "Hello over TLS!", string(body))
+	})
+
+	t.Run("requires proxy authentication", func(t *testing.T) {
+		handler := NewForwardProxyHandler()
+		handler.Username = "user"
+		handler.Password = "secret"
+
+		req := httptest.NewRequest("GET", "http://example.com/", nil)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusProxyAuthRequired, w.Code)
+		assert.Equal(t, `Basic realm="proxy"`, w.Header().Get("Proxy-Authenticate"))
+	})
+
+	t.Run("accepts valid proxy credentials", func(t *testing.T) {
+		targetServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		defer targetServer.Close()
+
+		handler := NewForwardProxyHandler()
+		handler.Username = "user"
+		handler.Password = "secret"
+		client := newProxiedClient(t, handler, url.UserPassword("user", "secret"))
+
+		resp, err := client.Get(targetServer.URL)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	})
+
+	t.Run("rejects denied hosts", func(t *testing.T) {
+		handler := NewForwardProxyHandler()
+		handler.Deny = []string{"*.internal"}
+
+		req := httptest.NewRequest("GET", "http://db.internal/", nil)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	})
+
+	t.Run("rejects CONNECT to hosts not allowed", func(t *testing.T) {
+		handler := NewForwardProxyHandler()
+		handler.Allow = []string{"example.com"}
+
+		req := httptest.NewRequest("CONNECT", "http://other.com:443", nil)
+		req.Host = "other.com:443"
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	})
+
+	t.Run("rejects origin-form requests", func(t *testing.T) {
+		handler := NewForwardProxyHandler()
+
+		req := httptest.NewRequest("GET", "/relative", nil)
+		req.URL.Host = ""
+		req.URL.Scheme = ""
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
+
+func TestPathRouter_proxyRequests(t *testing.T) {
+	serve := func(router *PathRouter, r *http.Request) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w
+	}
+
+	t.Run("routes proxy requests to the forward proxy route", func(t *testing.T) {
+		router := NewPathRouter()
+		router.AddRoute("/", &StaticHandler{message: "root"})
+		proxy := NewForwardProxyHandler()
+		proxy.Deny = []string{"example.com"}
+		router.AddRoute("/proxy", proxy)
+
+		req := httptest.NewRequest("CONNECT", "http://example.com:443", nil)
+		req.Host = "example.com:443"
+		w := serve(router, req)
+
+		assert.Equal(t, "/proxy", router.ProxyRoute)
+		assert.Equal(t, http.StatusForbidden, w.Code, "CONNECT is handled by the forward proxy")
+		assert.Equal(t, "/proxy", router.pattern(httptest.NewRequest("GET", "http://example.com/", nil)))
+		assert.Equal(t, "/", router.pattern(httptest.NewRequest("GET", "/", nil)))
+	})
+
+	t.Run("rejects proxy requests without a forward proxy route", func(t *testing.T) {
+		router := NewPathRouter()
+		router.AddRoute("/", &StaticHandler{message: "root"})
+
+		assert.Equal(t, http.StatusMethodNotAllowed, serve(router, httptest.NewRequest("CONNECT", "http://example.com:443", nil)).Code)
+		assert.Equal(t, http.StatusNotFound, serve(router, httptest.NewRequest("GET", "http://example.com/", nil)).Code)
+		assert.Equal(t, "root", serve(router, httptest.NewRequest("GET", "/", nil)).Body.String())
+	})
+
+	t.Run("rejects a second forward proxy route", func(t *testing.T) {
+		router := NewPathRouter()
+		require.NoError(t, router.addRoute("/", NewForwardProxyHandler()))
+
+		err := router.addRoute("/other", NewForwardProxyHandler())
+
+		assert.EqualError(t, err, "forward proxy requests are already served by route /")
+	})
+}
+
+func TestHostAllowed(t *testing.T) {
+	tests := []struct {
+		name  string
+		host  string
+		allow []string
+		deny  []string
+		want  bool
+	}{
+		{name: "noLists", host: "example.com", want: true},
+		{name: "exactAllow", host: "example.com", allow: []string{"example.com"}, want: true},
+		{name: "notInAllow", host: "other.com", allow: []string{"example.com"}, want: false},
+		{name: "wildcardAllow", host: "api.example.com", allow: []string{"*.example.com"}, want: true},
+		{name: "wildcardDoesNotMatchApex", host: "example.com", allow: []string{"*.example.com"}, want: false},
+		{name: "caseInsensitive", host: "API.Example.com", allow: []string{"*.example.com"}, want: true},
+		{name: "denyTakesPrecedence", host: "api.example.com", allow: []string{"*.example.com"}, deny: []string{"api.example.com"}, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equalf(t, tt.want, hostAllowed(tt.host, tt.allow, tt.deny), "hostAllowed(%v, %v, %v)", tt.host, tt.allow, tt.deny)
+		})
+	}
+}
+
+func newForwardProxyRouter(handler Handler) *PathRouter {
+	router := NewPathRouter()
+	router.AddRoute("/", handler)
+	return router
+}
+
+func newProxiedClient(t *testing.T, handler Handler, user *url.Userinfo) *http.Client {
+	proxyServer := httptest.NewServer(newForwardProxyRouter(handler))
+	t.Cleanup(proxyServer.Close)
+
+	proxyURL, err := url.Parse(proxyServer.URL)
+	require.NoError(t, err)
+	proxyURL.User = user
+	return &http.Client{
+		Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)},
+	}
+}
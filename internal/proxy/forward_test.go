@@ -1,6 +1,7 @@
 package proxy
 
 import (
+	"bufio"
 	"io"
 	"net/http"
 	"net/http/httptest"
@@ -151,8 +152,9 @@ func TestForwardHandler_ServeHTTP(t *testing.T) {
 			assert.NoError(t, err, "Failed to parse target URL")
 		}
 		handler := &ForwardHandler{
-			URL:    *targetUrl,
-			Client: &http.Client{Timeout: 200 * time.Millisecond},
+			URL:     *targetUrl,
+			Client:  &http.Client{},
+			Timeout: 200 * time.Millisecond,
 		}
 
 		req := httptest.NewRequest("GET", "/test", nil)
@@ -197,6 +199,308 @@ func TestForwardHandler_ServeHTTP(t *testing.T) {
 	})
 }
 
+func TestForwardHandler_Streaming(t *testing.T) {
+	t.Run("flushes server-sent events immediately", func(t *testing.T) {
+		release := make(chan struct{})
+		targetServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.WriteHeader(http.StatusOK)
+			_, _ = w.Write([]byte("data: first\n\n"))
+			w.(http.Flusher).Flush()
+			<-release
+			_, _ = w.Write([]byte("data: second\n\n"))
+		}))
+		defer targetServer.Close()
+		defer close(release)
+
+		handler, err := NewForwardHandler(targetServer.URL)
+		require.NoError(t, err, "Failed to create ForwardHandler")
+		proxyServer := httptest.NewServer(handler)
+		defer proxyServer.Close()
+
+		resp, err := http.Get(proxyServer.URL)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		line, err := bufio.NewReader(resp.Body).ReadString('\n')
+		require.NoError(t, err)
+		assert.Equal(t, "data: first\n", line, "Expected first event before upstream completes")
+	})
+
+	t.Run("flushes periodically with flush interval", func(t *testing.T) {
+		release := make(chan struct{})
+		targetServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain")
+			w.WriteHeader(http.StatusOK)
+			_, _ = w.Write([]byte("first\n"))
+			w.(http.Flusher).Flush()
+			<-release
+		}))
+		defer targetServer.Close()
+		defer close(release)
+
+		handler, err := NewForwardHandler(targetServer.URL)
+		require.NoError(t, err, "Failed to create ForwardHandler")
+		handler.FlushInterval = 10 * time.Millisecond
+		proxyServer := httptest.NewServer(handler)
+		defer proxyServer.Close()
+
+		resp, err := http.Get(proxyServer.URL)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		line, err := bufio.NewReader(resp.Body).ReadString('\n')
+		require.NoError(t, err)
+		assert.Equal(t, "first\n", line, "Expected data to be flushed before upstream completes")
+	})
+
+	t.Run("streams for longer than the timeouts", func(t *testing.T) {
+		targetServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.WriteHeader(http.StatusOK)
+			for _, event := range []string{"first", "second", "third"} {
+				_, _ = w.Write([]byte("data: " + event + "\n\n"))
+				w.(http.Flusher).Flush()
+				time.Sleep(150 * time.Millisecond)
+			}
+		}))
+		defer targetServer.Close()
+
+		handler, err := NewForwardHandler(targetServer.URL)
+		require.NoError(t, err, "Failed to create ForwardHandler")
+		handler.Timeout = 100 * time.Millisecond
+		proxyServer := httptest.NewUnstartedServer(handler)
+		proxyServer.Config.WriteTimeout = 100 * time.Millisecond
+		proxyServer.Start()
+		defer proxyServer.Close()
+
+		resp, err := http.Get(proxyServer.URL)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+
+		require.NoError(t, err)
+		assert.Equal(t, "data: first\n\ndata: second\n\ndata: third\n\n", string(body))
+	})
+
+	t.Run("times out responses which aren't streams", func(t *testing.T) {
+		targetServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain")
+			w.WriteHeader(http.StatusOK)
+			w.(http.Flusher).Flush()
+			time.Sleep(300 * time.Millisecond)
+			_, _ = w.Write([]byte("late"))
+		}))
+		defer targetServer.Close()
+
+		handler, err := NewForwardHandler(targetServer.URL)
+		require.NoError(t, err, "Failed to create ForwardHandler")
+		handler.Timeout = 100 * time.Millisecond
+		w := httptest.NewRecorder()
+
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+
+		assert.Empty(t, w.Body.String())
+	})
+}
+
+func TestForwardHandler_GRPC(t *testing.T) {
+	t.Run("forwards trailers and TE header over h2c", func(t *testing.T) {
+		targetServer := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, 2, r.ProtoMajor, "Expected HTTP/2 request")
+			assert.Equal(t, "/helloworld.Greeter/SayHello", r.URL.Path, "Expected gRPC path to be preserved")
+			assert.Equal(t, "trailers", r.Header.Get("TE"), "Expected TE header to be forwarded")
+
+			w.Header().Set("Content-Type", "application/grpc")
+			w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+			w.WriteHeader(http.StatusOK)
+			_, _ = w.Write([]byte("message"))
+			w.Header().Set("Grpc-Status", "0")
+			w.Header().Set("Grpc-Message", "ok")
+		}))
+		targetServer.Config.Protocols = new(http.Protocols)
+		targetServer.Config.Protocols.SetUnencryptedHTTP2(true)
+		targetServer.Start()
+		defer targetServer.Close()
+
+		handler, err := NewForwardHandler(targetServer.URL)
+		require.NoError(t, err, "Failed to create ForwardHandler")
+		handler.Client.Transport = NewHTTP2Transport()
+
+		req := httptest.NewRequest("POST", "/helloworld.Greeter/SayHello", strings.NewReader("request"))
+		req.Header.Set("Content-Type", "application/grpc")
+		req.Header.Set("TE", "trailers")
+		w := httptest.NewRecorder()
+
+		handler.ServeHTTP(w, req)
+
+		resp := w.Result()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "message", w.Body.String())
+		assert.Equal(t, "0", resp.Trailer.Get("Grpc-Status"), "Expected grpc-status trailer to be forwarded")
+		assert.Equal(t, "ok", resp.Trailer.Get("Grpc-Message"), "Expected grpc-message trailer to be forwarded")
+	})
+
+	t.Run("streams requests and responses both ways", func(t *testing.T) {
+		targetServer := newH2CServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/grpc")
+			w.Header().Set("Trailer", "Grpc-Status")
+			w.WriteHeader(http.StatusOK)
+			w.(http.Flusher).Flush()
+			scanner := bufio.NewScanner(r.Body)
+			for scanner.Scan() {
+				_, _ = w.Write([]byte("pong " + scanner.Text() + "\n"))
+				w.(http.Flusher).Flush()
+			}
+			w.Header().Set("Grpc-Status", "0")
+		}))
+		handler, err := NewForwardHandler(targetServer.URL)
+		require.NoError(t, err, "Failed to create ForwardHandler")
+		handler.Client.Transport = NewHTTP2Transport()
+		proxyServer := newH2CServer(t, handler)
+
+		body, messages := io.Pipe()
+		req, err := http.NewRequest("POST", proxyServer.URL+"/helloworld.Greeter/Chat", body)
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/grpc")
+		resp, err := (&http.Client{Transport: NewHTTP2Transport()}).Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		responses := bufio.NewReader(resp.Body)
+
+		// every message is answered before the next one is sent
+		for _, message := range []string{"1", "2"} {
+			_, err := messages.Write([]byte(message + "\n"))
+			require.NoError(t, err)
+			line, err := responses.ReadString('\n')
+			require.NoError(t, err)
+			assert.Equal(t, "pong "+message+"\n", line)
+		}
+		require.NoError(t, messages.Close())
+		_, err = io.ReadAll(responses)
+		require.NoError(t, err)
+		assert.Equal(t, "0", resp.Trailer.Get("Grpc-Status"))
+	})
+
+	t.Run("passes trailers through a retrier", func(t *testing.T) {
+		targetServer := newH2CServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/grpc+proto")
+			w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+			w.WriteHeader(http.StatusOK)
+			_, _ = w.Write([]byte("message"))
+			w.Header().Set("Grpc-Status", "5")
+			w.Header().Set("Grpc-Message", "not found")
+		}))
+		forward, err := NewForwardHandler(targetServer.URL)
+		require.NoError(t, err, "Failed to create ForwardHandler")
+		forward.Client.Transport = NewHTTP2Transport()
+		handler := &RetrierHandler{Handler: forward, RetryPolicy: &RetryOnNon2xxRetryPolicy{}, Retries: 1}
+
+		req := httptest.NewRequest("POST", "/helloworld.Greeter/SayHello", strings.NewReader("request"))
+		req.Header.Set("Content-Type", "application/grpc")
+		w := httptest.NewRecorder()
+
+		handler.ServeHTTP(w, req)
+
+		resp := w.Result()
+		assert.True(t, w.Flushed, "Expected gRPC response to be streamed")
+		assert.Equal(t, "message", w.Body.String())
+		assert.Equal(t, "5", resp.Trailer.Get("Grpc-Status"), "Expected grpc-status trailer to be forwarded")
+		assert.Equal(t, "not found", resp.Trailer.Get("Grpc-Message"), "Expected grpc-message trailer to be forwarded")
+	})
+
+	t.Run("maps unreachable target to grpc unavailable", func(t *testing.T) {
+		handler, err := NewForwardHandler("http://localhost:99999")
+		require.NoError(t, err, "Failed to create ForwardHandler")
+
+		req := httptest.NewRequest("POST", "/helloworld.Greeter/SayHello", nil)
+		req.Header.Set("Content-Type", "application/grpc")
+		w := httptest.NewRecorder()
+
+		handler.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code, "Expected gRPC errors to use status 200")
+		assert.Equal(t, "application/grpc", w.Header().Get("Content-Type"))
+		assert.Equal(t, "14", w.Header().Get("Grpc-Status"), "Expected UNAVAILABLE status")
+		assert.Contains(t, w.Header().Get("Grpc-Message"), "upstream unavailable: Post \"http://localhost:99999/helloworld.Greeter/SayHello\": dial tcp")
+	})
+
+	t.Run("maps timeout to grpc deadline exceeded", func(t *testing.T) {
+		targetServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(500 * time.Millisecond)
+		}))
+		defer targetServer.Close()
+
+		handler, err := NewForwardHandler(targetServer.URL)
+		require.NoError(t, err, "Failed to create ForwardHandler")
+
+		req := httptest.NewRequest("POST", "/helloworld.Greeter/SayHello", nil)
+		req.Header.Set("Content-Type", "application/grpc")
+		req.Header.Set("Grpc-Timeout", "100m")
+		w := httptest.NewRecorder()
+
+		handler.ServeHTTP(w, req)
+
+		assert.Equal(t, "4", w.Header().Get("Grpc-Status"), "Expected DEADLINE_EXCEEDED status")
+		assert.Equal(t, `deadline exceeded: Post "`+targetServer.URL+`/helloworld.Greeter/SayHello": no response within 100ms: context deadline exceeded`,
+			w.Header().Get("Grpc-Message"))
+	})
+
+	t.Run("bounds calls by the route timeout", func(t *testing.T) {
+		targetServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(5 * time.Second):
+			}
+		}))
+		defer targetServer.Close()
+
+		handler, err := NewForwardHandler(targetServer.URL)
+		require.NoError(t, err, "Failed to create ForwardHandler")
+		handler.Timeout = 100 * time.Millisecond
+
+		for _, grpcTimeout := range []string{"", "10S"} {
+			req := httptest.NewRequest("POST", "/helloworld.Greeter/SayHello", nil)
+			req.Header.Set("Content-Type", "application/grpc")
+			if grpcTimeout != "" {
+				req.Header.Set("Grpc-Timeout", grpcTimeout)
+			}
+			w := httptest.NewRecorder()
+
+			handler.ServeHTTP(w, req)
+
+			assert.Equal(t, "4", w.Header().Get("Grpc-Status"), "Expected DEADLINE_EXCEEDED status with Grpc-Timeout %q", grpcTimeout)
+			assert.Contains(t, w.Header().Get("Grpc-Message"), "no response within 100ms")
+		}
+	})
+}
+
+func TestGRPCTimeout(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected time.Duration
+		ok       bool
+	}{
+		{"100m", 100 * time.Millisecond, true},
+		{"2S", 2 * time.Second, true},
+		{"1H", time.Hour, true},
+		{"99999999u", 99999999 * time.Microsecond, true},
+		{"", 0, false},
+		{"100", 0, false},
+		{"1s", 0, false},
+		{"123456789m", 0, false},
+		{"-1m", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			timeout, ok := grpcTimeout(http.Header{"Grpc-Timeout": {tt.value}})
+
+			assert.Equal(t, tt.expected, timeout)
+			assert.Equal(t, tt.ok, ok)
+		})
+	}
+}
+
 func TestNewForwardHandler(t *testing.T) {
 	t.Run("creates handler with valid URL", func(t *testing.T) {
 		handler, err := NewForwardHandler("https://example.com")
@@ -204,7 +508,7 @@ func TestNewForwardHandler(t *testing.T) {
 
 		assert.Equal(t, "https://example.com", handler.URL.String(), "Expected URL to be set correctly")
 		assert.NotNil(t, handler.Client, "Expected client to be initialized")
-		assert.Equal(t, 30*time.Second, handler.Client.Timeout, "Expected timeout to be 30 seconds")
+		assert.Equal(t, 30*time.Second, handler.Timeout, "Expected timeout to be 30 seconds")
 	})
 
 	t.Run("returns error for invalid URL", func(t *testing.T) {
@@ -212,3 +516,18 @@ func TestNewForwardHandler(t *testing.T) {
 		assert.Error(t, err, "Expected error for invalid URL")
 	})
 }
+
+// newH2CServer starts a server speaking HTTP/2 without TLS, like gRPC servers.
+func newH2CServer(t *testing.T, handler http.Handler) *httptest.Server {
+	server := httptest.NewUnstartedServer(handler)
+	server.Config.Protocols = new(http.Protocols)
+	server.Config.Protocols.SetUnencryptedHTTP2(true)
+	server.Start()
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestEncodeGRPCMessage(t *testing.T) {
+	assert.Equal(t, "dial tcp: 100%25 lost%0A", encodeGRPCMessage("dial tcp: 100% lost\n"))
+	assert.Equal(t, "caf%C3%A9", encodeGRPCMessage("café"))
+}
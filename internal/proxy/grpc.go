@@ -0,0 +1,104 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// gRPC status codes used when the upstream can't produce a status itself,
+// see https://grpc.github.io/grpc/core/md_doc_statuscodes.html
+const (
+	grpcStatusCanceled         = 1
+	grpcStatusDeadlineExceeded = 4
+	grpcStatusUnavailable      = 14
+)
+
+func isGRPCRequest(r *http.Request) bool {
+	contentType := r.Header.Get("Content-Type")
+	return contentType == "application/grpc" || strings.HasPrefix(contentType, "application/grpc+")
+}
+
+// parseGRPCPath splits a gRPC request path of form /package.Service/Method
+// into its fully qualified service name and method name.
+func parseGRPCPath(path string) (service, method string, ok bool) {
+	if !strings.HasPrefix(path, "/") {
+		return "", "", false
+	}
+	service, method, ok = strings.Cut(path[1:], "/")
+	if !ok || service == "" || method == "" || strings.Contains(method, "/") {
+		return "", "", false
+	}
+	return service, method, true
+}
+
+// grpcTimeout returns the deadline of a gRPC call, set by its grpc-timeout
+// header as a number of up to 8 digits followed by a unit, e.g. "100m".
+func grpcTimeout(header http.Header) (time.Duration, bool) {
+	value := header.Get("Grpc-Timeout")
+	if len(value) < 2 || len(value) > 9 {
+		return 0, false
+	}
+	units := map[byte]time.Duration{
+		'H': time.Hour, 'M': time.Minute, 'S': time.Second,
+		'm': time.Millisecond, 'u': time.Microsecond, 'n': time.Nanosecond,
+	}
+	unit, ok := units[value[len(value)-1]]
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseUint(value[:len(value)-1], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return time.Duration(n) * unit, true
+}
+
+func grpcStatusFromError(err error) int {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, context.Canceled):
+		return grpcStatusCanceled
+	case errors.Is(err, context.DeadlineExceeded),
+		errors.As(err, &netErr) && netErr.Timeout():
+		return grpcStatusDeadlineExceeded
+	default:
+		return grpcStatusUnavailable
+	}
+}
+
+// writeGRPCError writes a trailers-only gRPC response, which is how gRPC
+// servers report an error before any message was sent. The status is mapped
+// from err, and the message names it along with err, e.g.
+// "deadline exceeded: no response within 1s: context deadline exceeded".
+func writeGRPCError(w http.ResponseWriter, err error) {
+	status := grpcStatusFromError(err)
+	names := map[int]string{
+		grpcStatusCanceled:         "canceled",
+		grpcStatusDeadlineExceeded: "deadline exceeded",
+		grpcStatusUnavailable:      "upstream unavailable",
+	}
+	w.Header().Set("Content-Type", "application/grpc")
+	w.Header().Set("Grpc-Status", strconv.Itoa(status))
+	w.Header().Set("Grpc-Message", encodeGRPCMessage(names[status]+": "+err.Error()))
+	w.WriteHeader(http.StatusOK)
+}
+
+// encodeGRPCMessage percent-encodes a grpc-message, as its value is limited
+// to printable ASCII besides '%'.
+func encodeGRPCMessage(message string) string {
+	var b strings.Builder
+	for i := range len(message) {
+		if c := message[i]; c < ' ' || c > '~' || c == '%' {
+			_, _ = fmt.Fprintf(&b, "%%%02X", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
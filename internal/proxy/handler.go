@@ -1,9 +1,10 @@
 package proxy
 
 import (
+	"context"
 	"encoding/json"
 	"io"
-	"log"
+	"log/slog"
 	"math/rand"
 	"net/http"
 	"time"
@@ -18,21 +19,24 @@ type Handler interface {
 
 type StaticHandler struct {
 	message string
+	Logger  *slog.Logger
 }
 
 func (h *StaticHandler) ServeHTTP(
 	w http.ResponseWriter,
-	_ *http.Request,
+	r *http.Request,
 ) {
 	_, err := w.Write([]byte(h.message))
 	if err != nil {
-		log.Printf("Error writing response: %v", err)
+		loggerOrDefault(h.Logger).ErrorContext(r.Context(), "Error writing response", "error", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 	}
 	return
 }
 
-type DebugHandler struct{}
+type DebugHandler struct {
+	Logger *slog.Logger
+}
 
 func (h *DebugHandler) ServeHTTP(
 	w http.ResponseWriter,
@@ -40,14 +44,15 @@ func (h *DebugHandler) ServeHTTP(
 ) {
 	body, err := readBody(r)
 	if err != nil {
-		log.Printf("Error reading body: %v", err)
+		loggerOrDefault(h.Logger).ErrorContext(r.Context(), "Error reading body", "error", err)
 		return
 	}
 	response := debugResponse{
-		Method:  r.Method,
-		URL:     r.URL.String(),
-		Headers: r.Header,
-		Body:    string(body),
+		Method:    r.Method,
+		URL:       r.URL.String(),
+		Headers:   r.Header,
+		Body:      string(body),
+		RequestID: requestIDFromContext(r.Context()),
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -55,7 +60,7 @@ func (h *DebugHandler) ServeHTTP(
 
 	err = encoder.Encode(response)
 	if err != nil {
-		log.Printf("Error encoding response: %v", err)
+		loggerOrDefault(h.Logger).ErrorContext(r.Context(), "Error encoding response", "error", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 	}
 	return
@@ -69,13 +74,16 @@ func readBody(r *http.Request) ([]byte, error) {
 }
 
 type debugResponse struct {
-	Method  string              `json:"method"`
-	URL     string              `json:"url"`
-	Headers map[string][]string `json:"headers"`
-	Body    string              `json:"body"`
+	Method    string              `json:"method"`
+	URL       string              `json:"url"`
+	Headers   map[string][]string `json:"headers"`
+	Body      string              `json:"body"`
+	RequestID string              `json:"request_id,omitempty"`
 }
 
-type EchoHandler struct{}
+type EchoHandler struct {
+	Logger *slog.Logger
+}
 
 func (h *EchoHandler) ServeHTTP(
 	w http.ResponseWriter,
@@ -83,22 +91,24 @@ func (h *EchoHandler) ServeHTTP(
 ) {
 	err := r.Write(w)
 	if err != nil {
-		log.Printf("Error writing response: %v", err)
+		loggerOrDefault(h.Logger).ErrorContext(r.Context(), "Error writing response", "error", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 	}
 	return
 }
 
-type NotFoundHandler struct{}
+type NotFoundHandler struct {
+	Logger *slog.Logger
+}
 
 func (f *NotFoundHandler) ServeHTTP(
 	w http.ResponseWriter,
-	_ *http.Request,
+	r *http.Request,
 ) {
 	w.WriteHeader(http.StatusNotFound)
 	_, err := w.Write([]byte("Not found"))
 	if err != nil {
-		log.Printf("Error writing response: %v", err)
+		loggerOrDefault(f.Logger).ErrorContext(r.Context(), "Error writing response", "error", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 	}
 	return
@@ -107,6 +117,7 @@ func (f *NotFoundHandler) ServeHTTP(
 type ChaosHandler struct {
 	Handler       Handler
 	FailureChance float64
+	Logger        *slog.Logger
 	rand          *rand.Rand
 }
 
@@ -124,14 +135,35 @@ func (h *ChaosHandler) ServeHTTP(
 	w http.ResponseWriter,
 	r *http.Request,
 ) {
-	if h.rand.Float64() <= h.FailureChance {
+	if injectChaos(r.Context(), h.rand, h.FailureChance, routeFromContext(r.Context())) {
 		w.WriteHeader(http.StatusInternalServerError)
 		_, err := w.Write([]byte("Chaos"))
 		if err != nil {
-			log.Printf("Error writing response: %v", err)
+			loggerOrDefault(h.Logger).ErrorContext(r.Context(), "Error writing response", "error", err)
 		}
 		return
 	}
 
 	h.Handler.ServeHTTP(w, r)
 }
+
+func (h *ChaosHandler) Close() error {
+	return closeHandler(h.Handler)
+}
+
+// injectChaos decides whether to fail on purpose with the given chance, and
+// records an injected failure for route, or the listen address of a
+// listener, on the metrics and on the span of ctx. A nil rnd uses the
+// global source.
+func injectChaos(ctx context.Context, rnd *rand.Rand, chance float64, route string) bool {
+	roll := rand.Float64
+	if rnd != nil {
+		roll = rnd.Float64
+	}
+	if roll() > chance {
+		return false
+	}
+	chaosInjections.Inc(route)
+	SpanFromContext(ctx).SetAttribute("chaos.injected", true)
+	return true
+}
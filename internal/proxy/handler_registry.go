@@ -0,0 +1,145 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"slices"
+	"sync"
+)
+
+// HandlerType is a kind of handler which can be configured, e.g. "static".
+// Other packages add their own handler types with RegisterHandlerType.
+type HandlerType struct {
+	// Name is the key selecting the handler type in a handler config.
+	Name string
+	// Decode decodes the config of the handler type from JSON. Unknown fields
+	// are rejected before decoding if the config is a struct.
+	Decode func(data json.RawMessage) (any, error)
+	// Create creates a handler from a config returned by Decode. Handlers it
+	// wraps are created with the builder.
+	Create func(config any, b *HandlerBuilder) (Handler, error)
+	// Describe returns a short description of the handler, see
+	// HandlerConfig.Describe. It's optional and defaults to the name.
+	Describe func(config any) string
+	// ConfigType is the type Decode decodes into, which describes the config
+	// in the schema returned by ConfigSchema. It's optional, without it any
+	// config is allowed by the schema.
+	ConfigType reflect.Type
+}
+
+// NewHandlerType creates a handler type whose config is decoded from JSON
+// into a C. The handler is described by the Describe method of *C if it
+// has one.
+func NewHandlerType[C any](name string, create func(config *C, b *HandlerBuilder) (Handler, error)) HandlerType {
+	return HandlerType{
+		Name: name,
+		Decode: func(data json.RawMessage) (any, error) {
+			config := new(C)
+			if err := json.Unmarshal(data, config); err != nil {
+				return nil, err
+			}
+			return config, nil
+		},
+		Create: func(config any, b *HandlerBuilder) (Handler, error) {
+			return create(config.(*C), b)
+		},
+		Describe: func(config any) string {
+			if describer, ok := config.(interface{ Describe() string }); ok {
+				return describer.Describe()
+			}
+			return name
+		},
+		ConfigType: reflect.TypeFor[C](),
+	}
+}
+
+// RegisterHandlerType makes a handler type available to handler configs. It
+// panics if the name is empty or already registered, and is meant to be
+// called during initialization.
+func RegisterHandlerType(handlerType HandlerType) {
+	if handlerType.Name == "" {
+		panic("proxy: handler type name is empty")
+	}
+	if handlerType.Decode == nil || handlerType.Create == nil {
+		panic("proxy: handler type " + handlerType.Name + " has no Decode or Create")
+	}
+	handlerTypes.register(&handlerType)
+}
+
+func (t *HandlerType) describe(config any) string {
+	if t.Describe == nil {
+		return t.Name
+	}
+	return t.Describe(config)
+}
+
+type handlerRegistry struct {
+	mu     sync.RWMutex
+	byName map[string]*HandlerType
+	// ordered holds the handler types in registration order
+	ordered []*HandlerType
+}
+
+var handlerTypes = newHandlerRegistry(
+	builtinHandlerType[StaticHandlerConfig]("static"),
+	builtinHandlerType[ForwardHandlerConfig]("forward"),
+	builtinHandlerType[DebugHandlerConfig]("debug"),
+	builtinHandlerType[EchoHandlerConfig]("echo"),
+	builtinHandlerType[NotFoundHandlerConfig]("not_found"),
+	builtinHandlerType[ChaosHandlerConfig]("chaos"),
+	builtinHandlerType[FanOutHandlerConfig]("fanout"),
+	builtinHandlerType[RetrierHandlerConfig]("retrier"),
+	builtinHandlerType[CaptureHandlerConfig]("capture"),
+	builtinHandlerType[RecordHandlerConfig]("record"),
+	builtinHandlerType[ReplayHandlerConfig]("replay"),
+	builtinHandlerType[RateLimitHandlerConfig]("rate_limit"),
+	builtinHandlerType[ForwardProxyHandlerConfig]("forward_proxy"),
+	builtinHandlerType[HandlerRef]("ref"),
+)
+
+func newHandlerRegistry(builtins ...*HandlerType) *handlerRegistry {
+	registry := &handlerRegistry{byName: make(map[string]*HandlerType)}
+	for _, handlerType := range builtins {
+		registry.register(handlerType)
+	}
+	return registry
+}
+
+func (r *handlerRegistry) register(handlerType *HandlerType) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exists := r.byName[handlerType.Name]; exists {
+		panic(fmt.Sprintf("proxy: handler type %s is already registered", handlerType.Name))
+	}
+	r.byName[handlerType.Name] = handlerType
+	r.ordered = append(r.ordered, handlerType)
+}
+
+func registeredHandlerTypes() []*HandlerType {
+	handlerTypes.mu.RLock()
+	defer handlerTypes.mu.RUnlock()
+	return slices.Clone(handlerTypes.ordered)
+}
+
+func lookupHandlerType(name string) (*HandlerType, bool) {
+	handlerTypes.mu.RLock()
+	defer handlerTypes.mu.RUnlock()
+	handlerType, ok := handlerTypes.byName[name]
+	return handlerType, ok
+}
+
+// builtinHandlerType creates the handler type of a built-in handler config,
+// which is described by its describe method.
+func builtinHandlerType[C any, P interface {
+	*C
+	handlerConfig
+}](name string) *HandlerType {
+	handlerType := NewHandlerType(name, func(config *C, b *HandlerBuilder) (Handler, error) {
+		return P(config).createHandler(b)
+	})
+	handlerType.Describe = func(config any) string {
+		return P(config.(*C)).describe()
+	}
+	return &handlerType
+}
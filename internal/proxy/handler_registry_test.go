@@ -0,0 +1,133 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// SetHeaderHandlerConfig configures a handler type registered by the tests,
+// like one of another package would be.
+type SetHeaderHandlerConfig struct {
+	Name    string        `json:"name"`
+	Value   string        `json:"value"`
+	Handler HandlerConfig `json:"handler"`
+}
+
+func (c *SetHeaderHandlerConfig) Describe() string {
+	return fmt.Sprintf("set_header(name=%s) > %s", c.Name, c.Handler.Describe())
+}
+
+func init() {
+	RegisterHandlerType(NewHandlerType("set_header", func(config *SetHeaderHandlerConfig, b *HandlerBuilder) (Handler, error) {
+		if config.Name == "" {
+			return nil, fmt.Errorf("name is empty")
+		}
+		handler, err := b.Create("handler", &config.Handler)
+		if err != nil {
+			return nil, err
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set(config.Name, config.Value)
+			handler.ServeHTTP(w, r)
+		}), nil
+	}))
+}
+
+func TestRegisterHandlerType(t *testing.T) {
+	t.Run("registered handler type is created from config", func(t *testing.T) {
+		t.Setenv("HEADER_VALUE", "yes")
+		// language=JSON
+		configJson := `{"routes": [{"matcher": {"path": "/"}, "handler": {"set_header": {"name": "X-Custom", "value": "${HEADER_VALUE}", "handler": {"static": {"message": "Hello"}}}}}]}`
+
+		config, err := ReadConfigFromString(configJson)
+		require.NoError(t, err)
+		assert.Equal(t, "set_header(name=X-Custom) > static", config.Routes[0].Handler.Describe())
+
+		router, err := config.CreateRouter()
+		require.NoError(t, err)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, newGetRequest("/"))
+		assert.Equal(t, "yes", w.Header().Get("X-Custom"))
+		assert.Equal(t, "Hello", w.Body.String())
+	})
+
+	t.Run("registered handler type from yaml", func(t *testing.T) {
+		// language=YAML
+		configYaml := `
+routes:
+  - matcher: {path: /}
+    handler:
+      retrier:
+        handler:
+          set_header: {name: X-Custom, value: "1", handler: {echo: {}}}
+`
+		config, err := ReadConfigFromYAML([]byte(configYaml))
+		require.NoError(t, err)
+
+		assert.Equal(t, &SetHeaderHandlerConfig{
+			Name:    "X-Custom",
+			Value:   "1",
+			Handler: HandlerConfig{Configs: map[string]any{"echo": &EchoHandlerConfig{}}},
+		}, handlerConfigOf[RetrierHandlerConfig](t, config.Routes[0].Handler, "retrier").Handler.Configs["set_header"])
+	})
+
+	t.Run("errors are reported at their path", func(t *testing.T) {
+		// language=JSON
+		configJson := `{"routes": [{"matcher": {"path": "/"}, "handler": {"set_header": {"nmae": "X", "handler": {"unknown": {}}}}}]}`
+
+		_, err := ReadConfigFromString(configJson)
+		assert.ErrorContains(t, err, `routes[0].handler.set_header: unknown field "nmae"`)
+		assert.ErrorContains(t, err, "routes[0].handler.set_header.handler.unknown: unknown handler type")
+
+		// language=JSON
+		configJson = `{"routes": [{"matcher": {"path": "/"}, "handler": {"set_header": {"handler": {}}}}]}`
+		config, err := ReadConfigFromString(configJson)
+		require.NoError(t, err)
+		assert.EqualError(t, config.Validate(), "routes[0].handler.set_header: name is empty")
+	})
+
+	t.Run("exactly one handler type must be set", func(t *testing.T) {
+		// language=JSON
+		configJson := `{"routes": [{"matcher": {"path": "/"}, "handler": {"echo": {}, "set_header": {"name": "X", "handler": {"echo": {}}}}}]}`
+		config, err := ReadConfigFromString(configJson)
+		require.NoError(t, err)
+
+		_, err = config.CreateRouter()
+		assert.EqualError(t, err, "routes[0].handler: exactly one handler must be set, got [echo set_header]")
+	})
+
+	t.Run("redacted copy keeps registered handler types", func(t *testing.T) {
+		// language=JSON
+		configJson := `{"routes": [{"matcher": {"path": "/"}, "handler": {"set_header": {"name": "X", "handler": {"echo": {}}}}}]}`
+		config, err := ReadConfigFromString(configJson)
+		require.NoError(t, err)
+
+		assert.Equal(t, config.Routes[0].Handler.Configs, config.Redacted().Routes[0].Handler.Configs)
+	})
+
+	t.Run("built-in handler types are registered", func(t *testing.T) {
+		handlerType, ok := lookupHandlerType("chaos")
+		require.True(t, ok)
+		assert.Equal(t, reflect.TypeFor[ChaosHandlerConfig](), handlerType.ConfigType)
+
+		types := registeredHandlerTypes()
+		assert.Equal(t, "static", types[0].Name)
+		types[0] = nil
+		assert.NotNil(t, registeredHandlerTypes()[0], "Expected a copy of the registered handler types")
+	})
+
+	t.Run("registering a name twice panics", func(t *testing.T) {
+		assert.Panics(t, func() {
+			RegisterHandlerType(NewHandlerType("static", func(*StaticHandlerConfig, *HandlerBuilder) (Handler, error) {
+				return nil, nil
+			}))
+		})
+	})
+}
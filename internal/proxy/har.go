@@ -0,0 +1,486 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// The types below are the parts of the HTTP Archive format used to record
+// and replay traffic, see http://www.softwareishard.com/blog/har-12-spec/
+
+type harFile struct {
+	Log harLog `json:"log"`
+}
+
+type harLog struct {
+	Version string     `json:"version"`
+	Creator harCreator `json:"creator"`
+	Entries []harEntry `json:"entries"`
+}
+
+type harCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harEntry struct {
+	StartedDateTime time.Time   `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         harTimings  `json:"timings"`
+	Comment         string      `json:"comment,omitempty"`
+}
+
+type harRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	QueryString []harNameValue `json:"queryString"`
+	PostData    *harPostData   `json:"postData,omitempty"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type harResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	Content     harContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type harNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+	// Encoding is a non-standard field, also written by browsers, which is
+	// "base64" for binary bodies.
+	Encoding string `json:"encoding,omitempty"`
+}
+
+type harContent struct {
+	Size     int    `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+	Encoding string `json:"encoding,omitempty"` // "base64" for binary bodies
+}
+
+type harTimings struct {
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+// harText encodes a body as HAR text, base64 encoded unless it's valid UTF-8.
+func harText(body []byte) (text, encoding string) {
+	if utf8.Valid(body) {
+		return string(body), ""
+	}
+	return base64.StdEncoding.EncodeToString(body), "base64"
+}
+
+func harBody(text, encoding string) ([]byte, error) {
+	if encoding == "base64" {
+		return base64.StdEncoding.DecodeString(text)
+	}
+	return []byte(text), nil
+}
+
+func harHeaders(header http.Header) []harNameValue {
+	pairs := []harNameValue{}
+	for name, values := range header {
+		for _, value := range values {
+			pairs = append(pairs, harNameValue{Name: name, Value: value})
+		}
+	}
+	return pairs
+}
+
+func harQuery(query url.Values) []harNameValue {
+	pairs := []harNameValue{}
+	for name, values := range query {
+		for _, value := range values {
+			pairs = append(pairs, harNameValue{Name: name, Value: value})
+		}
+	}
+	return pairs
+}
+
+// readHARFile reads the entries of a HAR file.
+func readHARFile(path string) ([]harEntry, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file harFile
+	if err := json.Unmarshal(content, &file); err != nil {
+		return nil, fmt.Errorf("invalid HAR file %s: %w", path, err)
+	}
+	return file.Log.Entries, nil
+}
+
+// RecordHandler records the requests served by its handler, along with
+// their responses, to a HAR file which ReplayHandler can serve.
+type RecordHandler struct {
+	Handler Handler
+	// Path is the HAR file. Recorded entries are added to it every
+	// FlushInterval and on Close, keeping the entries it already holds. As
+	// a HAR file is a single JSON document, every flush rewrites all of it,
+	// MaxEntries and MaxBodySize bound its size.
+	Path          string
+	FlushInterval time.Duration
+	// MaxEntries limits the entries of the file, requests beyond it aren't
+	// recorded. Zero means no limit.
+	MaxEntries int
+	// MaxBodySize truncates recorded request and response bodies, which are
+	// kept in memory until flushed and make up most of the file. Truncated
+	// entries say so in their comment. Zero means no limit.
+	MaxBodySize int
+	// Redact lists headers whose values are replaced, as recordings are
+	// often shared. Replayed responses carry the replaced values.
+	Redact []string
+	Logger *slog.Logger
+
+	mu      sync.Mutex
+	pending []harEntry
+	// recorded counts the entries of the file, including pending ones
+	recorded int
+	flushing *time.Timer
+	full     bool
+}
+
+// harFilesMu serializes writing HAR files, so that handlers recording to the
+// same file, e.g. before and after a reload, don't drop each other's entries.
+var harFilesMu sync.Mutex
+
+// NewRecordHandler creates a handler recording to the HAR file at path,
+// appending to the entries it already holds.
+func NewRecordHandler(handler Handler, path string) (*RecordHandler, error) {
+	entries, err := readHARFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	return &RecordHandler{
+		Handler:       handler,
+		Path:          path,
+		FlushInterval: time.Second,
+		MaxEntries:    10000,
+		MaxBodySize:   1 << 20,
+		Redact:        DefaultRedactedHeaders,
+		recorded:      len(entries),
+	}, nil
+}
+
+func (h *RecordHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	body, err := readBody(r)
+	if err != nil {
+		loggerOrDefault(h.Logger).ErrorContext(r.Context(), "Error reading body", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	limit := h.MaxBodySize
+	if limit <= 0 {
+		limit = math.MaxInt
+	}
+	// the request may be changed by the handler, so it's recorded as received
+	requestBody := captureBuffer{limit: limit}
+	requestBody.capture(body)
+	entry := harEntry{StartedDateTime: start, Request: h.request(r, requestBody.buf.Bytes(), len(body))}
+
+	// headers set before, e.g. by a request ID middleware, aren't part of the response
+	headerBefore := w.Header().Clone()
+	rw := &recordingWriter{statusWriter: statusWriter{ResponseWriter: w}, captureBuffer: captureBuffer{limit: limit}}
+	h.Handler.ServeHTTP(rw, r)
+	header := http.Header{}
+	for name, values := range w.Header() {
+		if !slices.Equal(values, headerBefore[name]) {
+			header[name] = values
+		}
+	}
+
+	elapsed := float64(time.Since(start).Microseconds()) / 1000
+	entry.Time = elapsed
+	entry.Timings = harTimings{Wait: elapsed}
+	text, encoding := harText(rw.buf.Bytes())
+	entry.Response = harResponse{
+		Status:      rw.status(),
+		StatusText:  http.StatusText(rw.status()),
+		HTTPVersion: r.Proto,
+		Cookies:     []harNameValue{},
+		Headers:     harHeaders(redactHeader(header, h.Redact)),
+		Content: harContent{
+			Size:     rw.size,
+			MimeType: header.Get("Content-Type"),
+			Text:     text,
+			Encoding: encoding,
+		},
+		HeadersSize: -1,
+		BodySize:    rw.size,
+	}
+	entry.Comment = truncationComment(requestBody.truncated, rw.truncated, h.MaxBodySize)
+	h.add(r.Context(), entry)
+}
+
+// request records r with its body, which is truncated from size bytes.
+func (h *RecordHandler) request(r *http.Request, body []byte, size int) harRequest {
+	u := url.URL{Scheme: "http", Host: r.Host, Path: r.URL.Path, RawPath: r.URL.RawPath, RawQuery: r.URL.RawQuery}
+	if r.TLS != nil {
+		u.Scheme = "https"
+	}
+	request := harRequest{
+		Method:      r.Method,
+		URL:         u.String(),
+		HTTPVersion: r.Proto,
+		Cookies:     []harNameValue{},
+		Headers:     harHeaders(redactHeader(r.Header, h.Redact)),
+		QueryString: harQuery(r.URL.Query()),
+		HeadersSize: -1,
+		BodySize:    size,
+	}
+	if len(body) > 0 {
+		text, encoding := harText(body)
+		request.PostData = &harPostData{MimeType: r.Header.Get("Content-Type"), Text: text, Encoding: encoding}
+	}
+	return request
+}
+
+// add queues an entry to be written by the next flush.
+func (h *RecordHandler) add(ctx context.Context, entry harEntry) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.MaxEntries > 0 && h.recorded >= h.MaxEntries {
+		if !h.full {
+			h.full = true
+			loggerOrDefault(h.Logger).WarnContext(ctx, "HAR file is full, requests aren't recorded anymore",
+				"path", h.Path, "max_entries", h.MaxEntries)
+		}
+		return
+	}
+	h.recorded++
+	h.pending = append(h.pending, entry)
+	if h.flushing == nil {
+		h.flushing = time.AfterFunc(h.FlushInterval, func() {
+			if err := h.flush(); err != nil {
+				loggerOrDefault(h.Logger).Error("Error recording requests", "path", h.Path, "error", err)
+			}
+		})
+	}
+}
+
+// flush adds the pending entries to the file, replacing it atomically so it
+// always holds a complete archive.
+func (h *RecordHandler) flush() error {
+	harFilesMu.Lock()
+	defer harFilesMu.Unlock()
+	h.mu.Lock()
+	pending := h.pending
+	h.pending = nil
+	if h.flushing != nil {
+		h.flushing.Stop()
+		h.flushing = nil
+	}
+	h.mu.Unlock()
+	if len(pending) == 0 {
+		return nil
+	}
+
+	// the file is read again, as another handler may have written to it
+	entries, err := readHARFile(h.Path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	entries = append(entries, pending...)
+	if h.MaxEntries > 0 && len(entries) > h.MaxEntries {
+		entries = entries[:h.MaxEntries]
+	}
+	content, err := json.MarshalIndent(harFile{Log: harLog{
+		Version: "1.2",
+		Creator: harCreator{Name: "proxy-server", Version: "1"},
+		Entries: entries,
+	}}, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(h.Path), filepath.Base(h.Path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(content); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), h.Path)
+}
+
+// Close writes the pending entries and closes the handler.
+func (h *RecordHandler) Close() error {
+	return errors.Join(h.flush(), closeHandler(h.Handler))
+}
+
+// truncationComment returns the comment of an entry whose request or
+// response body was truncated to maxBodySize, empty if neither was.
+func truncationComment(request, response bool, maxBodySize int) string {
+	var bodies []string
+	if request {
+		bodies = append(bodies, "request")
+	}
+	if response {
+		bodies = append(bodies, "response")
+	}
+	if len(bodies) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%s body truncated to %d bytes", strings.Join(bodies, " and "), maxBodySize)
+}
+
+// recordingWriter keeps a copy of the response body, up to its limit.
+type recordingWriter struct {
+	statusWriter
+	captureBuffer
+	// size is the size of the whole body
+	size int
+}
+
+func (w *recordingWriter) Write(p []byte) (int, error) {
+	n, err := w.statusWriter.Write(p)
+	w.capture(p[:n])
+	w.size += n
+	return n, err
+}
+
+// ReplayHandler serves the responses of a HAR file to the requests they were
+// recorded for. Requests match by method, path and query, and optionally by
+// body. Repeated requests are served the matching entries in recorded order,
+// the last one being repeated.
+type ReplayHandler struct {
+	MatchBody bool
+	Logger    *slog.Logger
+
+	mu      sync.Mutex
+	entries map[harKey][]harEntry
+	served  map[harKey]int
+}
+
+// harKey identifies the requests an entry is replayed for.
+type harKey struct {
+	method string
+	path   string
+	query  string
+	// body is the SHA-256 hash of the body, if bodies are matched
+	body [sha256.Size]byte
+}
+
+// NewReplayHandler creates a handler replaying the HAR file at path.
+func NewReplayHandler(path string, matchBody bool) (*ReplayHandler, error) {
+	entries, err := readHARFile(path)
+	if err != nil {
+		return nil, err
+	}
+	h := &ReplayHandler{
+		MatchBody: matchBody,
+		entries:   make(map[harKey][]harEntry),
+		served:    make(map[harKey]int),
+	}
+	for i, entry := range entries {
+		u, err := url.Parse(entry.Request.URL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid URL of entry %d: %w", i, err)
+		}
+		var body []byte
+		if entry.Request.PostData != nil {
+			body, err = harBody(entry.Request.PostData.Text, entry.Request.PostData.Encoding)
+			if err != nil {
+				return nil, fmt.Errorf("invalid request body of entry %d: %w", i, err)
+			}
+		}
+		if _, err := harBody(entry.Response.Content.Text, entry.Response.Content.Encoding); err != nil {
+			return nil, fmt.Errorf("invalid response body of entry %d: %w", i, err)
+		}
+		key := h.key(entry.Request.Method, u, body)
+		h.entries[key] = append(h.entries[key], entry)
+	}
+	return h, nil
+}
+
+func (h *ReplayHandler) key(method string, u *url.URL, body []byte) harKey {
+	key := harKey{method: method, path: u.Path, query: u.Query().Encode()}
+	if h.MatchBody {
+		key.body = sha256.Sum256(body)
+	}
+	return key
+}
+
+func (h *ReplayHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := readBody(r)
+	if err != nil {
+		loggerOrDefault(h.Logger).ErrorContext(r.Context(), "Error reading body", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	entry, ok := h.next(h.key(r.Method, r.URL, body))
+	if !ok {
+		http.Error(w, "No recorded response", http.StatusNotFound)
+		return
+	}
+
+	for _, header := range entry.Response.Headers {
+		// the length is set for the body actually written
+		if http.CanonicalHeaderKey(header.Name) != "Content-Length" {
+			w.Header().Add(header.Name, header.Value)
+		}
+	}
+	w.WriteHeader(entry.Response.Status)
+	// bodies were validated when loading the file
+	content, _ := harBody(entry.Response.Content.Text, entry.Response.Content.Encoding)
+	if _, err := w.Write(content); err != nil {
+		loggerOrDefault(h.Logger).ErrorContext(r.Context(), "Error writing response", "error", err)
+	}
+}
+
+func (h *ReplayHandler) next(key harKey) (harEntry, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	entries := h.entries[key]
+	if len(entries) == 0 {
+		return harEntry{}, false
+	}
+	i := min(h.served[key], len(entries)-1)
+	h.served[key]++
+	return entries[i], true
+}
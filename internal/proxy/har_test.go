@@ -0,0 +1,271 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "recording.har")
+	calls := 0
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("X-Call", strings.Repeat("i", calls))
+		if r.URL.Path == "/binary" {
+			_, _ = w.Write([]byte{0xff, 0x00, 0xfe})
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+		_, _ = w.Write([]byte(r.Method + " " + r.URL.RequestURI() + " " + string(body)))
+	})
+	record, err := NewRecordHandler(upstream, path)
+	require.NoError(t, err)
+	serve := func(handler http.Handler, method, target, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		w.Header().Set("X-Request-ID", "set-before")
+		handler.ServeHTTP(w, httptest.NewRequest(method, target, strings.NewReader(body)))
+		return w
+	}
+
+	serve(record, "GET", "/items?b=2&a=1", "")
+	serve(record, "GET", "/items?b=2&a=1", "")
+	serve(record, "POST", "/items", `{"name": "one"}`)
+	serve(record, "POST", "/items", `{"name": "two"}`)
+	serve(record, "GET", "/binary", "")
+	require.NoError(t, record.Close())
+
+	t.Run("writes a HAR file", func(t *testing.T) {
+		content, err := os.ReadFile(path)
+		require.NoError(t, err)
+		var file harFile
+		require.NoError(t, json.Unmarshal(content, &file))
+		assert.Equal(t, "1.2", file.Log.Version)
+		require.Len(t, file.Log.Entries, 5)
+		entry := file.Log.Entries[2]
+		assert.Equal(t, "POST", entry.Request.Method)
+		assert.Equal(t, "http://example.com/items", entry.Request.URL)
+		assert.Equal(t, `{"name": "one"}`, entry.Request.PostData.Text)
+		assert.Equal(t, http.StatusAccepted, entry.Response.Status)
+		assert.Equal(t, `POST /items {"name": "one"}`, entry.Response.Content.Text)
+		assert.NotContains(t, entry.Response.Headers, harNameValue{Name: "X-Request-Id", Value: "set-before"})
+		assert.Equal(t, "base64", file.Log.Entries[4].Response.Content.Encoding)
+	})
+
+	t.Run("appends to an existing file", func(t *testing.T) {
+		copied := filepath.Join(t.TempDir(), "copied.har")
+		content, err := os.ReadFile(path)
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(copied, content, 0o644))
+		handler, err := NewRecordHandler(upstream, copied)
+		require.NoError(t, err)
+
+		serve(handler, "GET", "/more", "")
+		require.NoError(t, handler.Close())
+
+		entries, err := readHARFile(copied)
+		require.NoError(t, err)
+		assert.Len(t, entries, 6)
+	})
+
+	t.Run("replays by method, path and query", func(t *testing.T) {
+		replay, err := NewReplayHandler(path, false)
+		require.NoError(t, err)
+
+		first := serve(replay, "GET", "/items?a=1&b=2", "")
+		second := serve(replay, "GET", "/items?a=1&b=2", "")
+		third := serve(replay, "GET", "/items?a=1&b=2", "")
+
+		assert.Equal(t, http.StatusAccepted, first.Code)
+		assert.Equal(t, "GET /items?b=2&a=1 ", first.Body.String())
+		assert.Equal(t, "i", first.Header().Get("X-Call"))
+		assert.Equal(t, "ii", second.Header().Get("X-Call"))
+		assert.Equal(t, "ii", third.Header().Get("X-Call"), "the last entry is repeated")
+		assert.Equal(t, []string{"set-before"}, first.Header().Values("X-Request-ID"))
+		assert.Equal(t, []byte{0xff, 0x00, 0xfe}, serve(replay, "GET", "/binary", "").Body.Bytes())
+		assert.Equal(t, http.StatusNotFound, serve(replay, "GET", "/items?a=1", "").Code)
+	})
+
+	t.Run("replays by body", func(t *testing.T) {
+		replay, err := NewReplayHandler(path, true)
+		require.NoError(t, err)
+
+		assert.Equal(t, `POST /items {"name": "two"}`, serve(replay, "POST", "/items", `{"name": "two"}`).Body.String())
+		assert.Equal(t, `POST /items {"name": "one"}`, serve(replay, "POST", "/items", `{"name": "one"}`).Body.String())
+		assert.Equal(t, http.StatusNotFound, serve(replay, "POST", "/items", `{"name": "three"}`).Code)
+	})
+}
+
+func TestRecordHandler(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("OK"))
+	})
+	entries := func(t *testing.T, path string) int {
+		entries, err := readHARFile(path)
+		require.NoError(t, err)
+		return len(entries)
+	}
+
+	t.Run("writes entries on an interval", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "recording.har")
+		handler, err := NewRecordHandler(ok, path)
+		require.NoError(t, err)
+		handler.FlushInterval = 50 * time.Millisecond
+		defer handler.Close()
+
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+		_, err = os.Stat(path)
+		assert.ErrorIs(t, err, os.ErrNotExist, "entries are written together")
+
+		assert.Eventually(t, func() bool {
+			_, err := os.Stat(path)
+			return err == nil
+		}, time.Second, 10*time.Millisecond)
+		assert.Equal(t, 2, entries(t, path))
+	})
+
+	t.Run("limits the entries of the file", func(t *testing.T) {
+		var buf bytes.Buffer
+		path := filepath.Join(t.TempDir(), "recording.har")
+		handler, err := NewRecordHandler(ok, path)
+		require.NoError(t, err)
+		handler.MaxEntries = 2
+		handler.Logger = slog.New(slog.NewJSONHandler(&buf, nil))
+
+		for range 4 {
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+		}
+		require.NoError(t, handler.Close())
+
+		assert.Equal(t, 2, entries(t, path))
+		records := logRecords(t, &buf)
+		require.Len(t, records, 1, "the limit is logged once")
+		assert.Equal(t, "HAR file is full, requests aren't recorded anymore", records[0]["msg"])
+	})
+
+	t.Run("redacts headers", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "recording.har")
+		handler, err := NewRecordHandler(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.Header().Set("Set-Cookie", "session=secret")
+			w.Header().Set("X-Token", "secret")
+		}), path)
+		require.NoError(t, err)
+		handler.Redact = []string{"Authorization", "Set-Cookie", "X-Token"}
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Authorization", "Bearer secret")
+		r.Header.Set("Accept", "text/plain")
+
+		handler.ServeHTTP(httptest.NewRecorder(), r)
+		require.NoError(t, handler.Close())
+
+		content, err := os.ReadFile(path)
+		require.NoError(t, err)
+		assert.NotContains(t, string(content), "secret")
+		recorded, err := readHARFile(path)
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []harNameValue{{"Authorization", "[REDACTED]"}, {"Accept", "text/plain"}}, recorded[0].Request.Headers)
+		assert.ElementsMatch(t, []harNameValue{{"Set-Cookie", "[REDACTED]"}, {"X-Token", "[REDACTED]"}}, recorded[0].Response.Headers)
+	})
+
+	t.Run("keeps entries written by other handlers", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "recording.har")
+		before, err := NewRecordHandler(ok, path)
+		require.NoError(t, err)
+		after, err := NewRecordHandler(ok, path)
+		require.NoError(t, err)
+
+		before.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/before", nil))
+		after.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/after", nil))
+		require.NoError(t, after.Close())
+		require.NoError(t, before.Close())
+
+		assert.Equal(t, 2, entries(t, path), "a reload doesn't drop entries of the previous router")
+	})
+
+	t.Run("truncates bodies", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "recording.har")
+		handler, err := NewRecordHandler(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			_, _ = w.Write([]byte("Hello there!"))
+		}), path)
+		require.NoError(t, err)
+		handler.MaxBodySize = 5
+		w := httptest.NewRecorder()
+
+		handler.ServeHTTP(w, httptest.NewRequest("POST", "/", strings.NewReader("abc")))
+		require.NoError(t, handler.Close())
+
+		assert.Equal(t, "Hello there!", w.Body.String(), "the response isn't truncated")
+		recorded, err := readHARFile(path)
+		require.NoError(t, err)
+		require.Len(t, recorded, 1)
+		assert.Equal(t, "abc", recorded[0].Request.PostData.Text)
+		assert.Equal(t, "Hello", recorded[0].Response.Content.Text)
+		assert.Equal(t, 12, recorded[0].Response.Content.Size)
+		assert.Equal(t, "response body truncated to 5 bytes", recorded[0].Comment)
+	})
+}
+
+func TestConfig_recordAndReplay(t *testing.T) {
+	dir := t.TempDir()
+	invalid := filepath.Join(dir, "invalid.har")
+	require.NoError(t, os.WriteFile(invalid, []byte("{"), 0o644))
+
+	tests := []struct {
+		name    string
+		handler string
+		err     string
+	}{
+		{"record without path", `{"record": {"handler": {"debug": {}}}}`, "routes[0].handler.record: no path set"},
+		{"record with invalid settings", `{"record": {"path": "` + filepath.Join(dir, "new.har") + `", "flush_interval": "often", "max_entries": -1, "max_body_bytes": -1, "handler": {"debug": {}}}}`,
+			"routes[0].handler.record: invalid flush interval: time: invalid duration \"often\"\n" +
+				"routes[0].handler.record: max_entries must not be negative, got -1\n" +
+				"routes[0].handler.record: max_body_bytes must not be negative, got -1"},
+		{"record to invalid file", `{"record": {"path": "` + invalid + `", "handler": {"debug": {}}}}`, "routes[0].handler.record: invalid HAR file " + invalid + ": unexpected end of JSON input"},
+		{"replay without path", `{"replay": {}}`, "routes[0].handler.replay: no path set"},
+		{"replay of missing file", `{"replay": {"path": "` + filepath.Join(dir, "missing.har") + `"}}`, "routes[0].handler.replay: open " + filepath.Join(dir, "missing.har") + ": no such file or directory"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := ReadConfigFromString(`{"routes": [{"matcher": {"path": "/"}, "handler": ` + tt.handler + `}]}`)
+			require.NoError(t, err)
+
+			assert.EqualError(t, config.Validate(), tt.err)
+		})
+	}
+
+	t.Run("record and replay", func(t *testing.T) {
+		path := filepath.Join(dir, "static.har")
+		// language=JSON
+		config, err := ReadConfigFromString(`{"routes": [
+			{"matcher": {"path": "/record"}, "handler": {"record": {"path": "` + path + `", "handler": {"static": {"message": "recorded"}}}}}
+		]}`)
+		require.NoError(t, err)
+		router, err := config.CreateRouter()
+		require.NoError(t, err)
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/record", nil))
+		require.NoError(t, router.Close())
+
+		config, err = ReadConfigFromString(`{"routes": [{"matcher": {"path": "/record"}, "handler": {"replay": {"path": "` + path + `"}}}]}`)
+		require.NoError(t, err)
+		router, err = config.CreateRouter()
+		require.NoError(t, err)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", "/record", nil))
+
+		assert.Equal(t, "recorded", w.Body.String())
+	})
+}
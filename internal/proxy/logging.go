@@ -0,0 +1,187 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// Subsystems which have their own logger, and log level.
+const (
+	LogServer    = "server"
+	LogHandlers  = "handlers"
+	LogTCP       = "tcp"
+	LogUDP       = "udp"
+	LogSOCKS5    = "socks5"
+	LogAdmin     = "admin"
+	LogReload    = "reload"
+	LogTracing   = "tracing"
+	LogAccessLog = "access_log"
+)
+
+var logSubsystems = []string{LogServer, LogHandlers, LogTCP, LogUDP, LogSOCKS5, LogAdmin, LogReload, LogTracing, LogAccessLog}
+
+// Loggers creates the loggers of subsystems, each logging at its own level.
+// Records logged with the context of a request carry its route and IDs.
+type Loggers struct {
+	handler slog.Handler
+	level   slog.Level
+	levels  map[string]slog.Level
+}
+
+// NewLoggers creates loggers writing to handler, which must be enabled for
+// the lowest level in use. Subsystems without a level in levels log at level.
+func NewLoggers(handler slog.Handler, level slog.Level, levels map[string]slog.Level) *Loggers {
+	return &Loggers{handler: handler, level: level, levels: levels}
+}
+
+// DefaultLoggers creates loggers writing to the default slog logger at info level.
+func DefaultLoggers() *Loggers {
+	return NewLoggers(slog.Default().Handler(), slog.LevelInfo, nil)
+}
+
+// Logger returns the logger of a subsystem, adding it as attribute to all
+// records. An empty subsystem returns the root logger.
+func (l *Loggers) Logger(subsystem string) *slog.Logger {
+	level, ok := l.levels[subsystem]
+	if !ok {
+		level = l.level
+	}
+	logger := slog.New(&contextHandler{next: l.handler, level: level})
+	if subsystem != "" {
+		logger = logger.With("subsystem", subsystem)
+	}
+	return logger
+}
+
+// loggerOrDefault returns logger, or the default logger if it's nil, as for
+// handlers which were not created from a config.
+func loggerOrDefault(logger *slog.Logger) *slog.Logger {
+	if logger == nil {
+		return slog.Default()
+	}
+	return logger
+}
+
+// contextHandler filters records by level and adds the attributes of the
+// request they were logged for.
+type contextHandler struct {
+	next  slog.Handler
+	level slog.Leveler
+}
+
+func (h *contextHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= h.level.Level() && h.next.Enabled(ctx, level)
+}
+
+func (h *contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if route := routeFromContext(ctx); route != "" {
+		record.AddAttrs(slog.String("route", route))
+	}
+	if requestID := requestIDFromContext(ctx); requestID != "" {
+		record.AddAttrs(slog.String("request_id", requestID))
+	}
+	if span := SpanFromContext(ctx); span != nil {
+		record.AddAttrs(slog.String("trace_id", span.SpanContext.TraceID.String()))
+	}
+	return h.next.Handle(ctx, record)
+}
+
+func (h *contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &contextHandler{next: h.next.WithAttrs(attrs), level: h.level}
+}
+
+func (h *contextHandler) WithGroup(name string) slog.Handler {
+	return &contextHandler{next: h.next.WithGroup(name), level: h.level}
+}
+
+// LogConfig configures the log output and the levels of subsystems.
+type LogConfig struct {
+	Level  string `json:"level,omitempty"`  // "debug", "info" (default), "warn" or "error"
+	Format string `json:"format,omitempty"` // "text" (default) or "json"
+	// Subsystems overrides the level of subsystems, e.g. {"tcp": "debug"}
+	Subsystems map[string]string `json:"subsystems,omitempty"`
+}
+
+func (c *LogConfig) createLoggers(w io.Writer) (*Loggers, error) {
+	var errs []error
+	level, err := parseLogLevel(c.Level)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	minLevel := level
+	levels := make(map[string]slog.Level, len(c.Subsystems))
+	for _, subsystem := range slices.Sorted(maps.Keys(c.Subsystems)) {
+		if !slices.Contains(logSubsystems, subsystem) {
+			errs = append(errs, fmt.Errorf("unknown subsystem %q, expected one of %s", subsystem, strings.Join(logSubsystems, ", ")))
+			continue
+		}
+		subsystemLevel, err := parseLogLevel(c.Subsystems[subsystem])
+		if err != nil {
+			errs = append(errs, fmt.Errorf("subsystem %s: %w", subsystem, err))
+			continue
+		}
+		levels[subsystem] = subsystemLevel
+		minLevel = min(minLevel, subsystemLevel)
+	}
+
+	options := &slog.HandlerOptions{Level: minLevel}
+	var handler slog.Handler
+	switch c.Format {
+	case "", "text":
+		handler = slog.NewTextHandler(w, options)
+	case "json":
+		handler = slog.NewJSONHandler(w, options)
+	default:
+		errs = append(errs, fmt.Errorf("unknown format %q, expected text or json", c.Format))
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return NewLoggers(handler, level, levels), nil
+}
+
+func parseLogLevel(value string) (slog.Level, error) {
+	var level slog.Level
+	if value == "" {
+		return slog.LevelInfo, nil
+	}
+	if err := level.UnmarshalText([]byte(value)); err != nil {
+		return level, fmt.Errorf("invalid level %q", value)
+	}
+	return level, nil
+}
+
+// SetLoggers sets the loggers of everything created from the config, which
+// otherwise logs to the default slog logger.
+func (c *Config) SetLoggers(loggers *Loggers) {
+	c.loggers = loggers
+}
+
+// logger returns the logger of a subsystem, nil without loggers so that
+// the default logger is used.
+func (c *Config) logger(subsystem string) *slog.Logger {
+	if c.loggers == nil {
+		return nil
+	}
+	return c.loggers.Logger(subsystem)
+}
+
+// CreateLoggers creates the loggers configured by the log section, writing
+// to w. Without log section, they log text at info level.
+func (c *Config) CreateLoggers(w io.Writer) (*Loggers, error) {
+	logConfig := c.Log
+	if logConfig == nil {
+		logConfig = &LogConfig{}
+	}
+	loggers, err := logConfig.createLoggers(w)
+	if err != nil {
+		return nil, atConfigPath("log", err)
+	}
+	return loggers, nil
+}
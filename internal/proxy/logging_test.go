@@ -0,0 +1,135 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// logRecords decodes the records written by a JSON handler.
+func logRecords(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	t.Helper()
+	var records []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var record map[string]any
+		require.NoError(t, json.Unmarshal([]byte(line), &record))
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestLoggers(t *testing.T) {
+	var buf bytes.Buffer
+	handler := slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})
+	loggers := NewLoggers(handler, slog.LevelWarn, map[string]slog.Level{LogTCP: slog.LevelDebug})
+
+	t.Run("logs at the level of the subsystem", func(t *testing.T) {
+		buf.Reset()
+
+		loggers.Logger(LogTCP).Debug("tcp debug")
+		loggers.Logger(LogUDP).Info("udp info")
+		loggers.Logger(LogUDP).Warn("udp warn")
+
+		records := logRecords(t, &buf)
+		require.Len(t, records, 2)
+		assert.Equal(t, "tcp debug", records[0]["msg"])
+		assert.Equal(t, "tcp", records[0]["subsystem"])
+		assert.Equal(t, "udp warn", records[1]["msg"])
+		assert.Equal(t, "udp", records[1]["subsystem"])
+	})
+
+	t.Run("adds route, request ID and trace ID of the request", func(t *testing.T) {
+		buf.Reset()
+		exporter := useTracer(t, 1)
+		router := NewPathRouter()
+		router.AddRoute("/logged", http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+			loggers.Logger(LogHandlers).WarnContext(r.Context(), "handled")
+		}))
+		r := httptest.NewRequest("GET", "/logged", nil)
+		r.Header.Set("X-Request-Id", "request-1")
+
+		NewRequestIDs(DefaultRequestIDHeader, NewUUID).Handler(router).ServeHTTP(httptest.NewRecorder(), r)
+
+		records := logRecords(t, &buf)
+		require.Len(t, records, 1)
+		assert.Equal(t, "handled", records[0]["msg"])
+		assert.Equal(t, "/logged", records[0]["route"])
+		assert.Equal(t, "request-1", records[0]["request_id"])
+		spans := exporter.finish(t)
+		require.Len(t, spans, 1)
+		assert.Equal(t, spans[0].SpanContext.TraceID.String(), records[0]["trace_id"])
+	})
+}
+
+func TestConfig_CreateLoggers(t *testing.T) {
+	tests := []struct {
+		name string
+		log  string
+		err  string
+	}{
+		{"defaults", `{}`, ""},
+		{"json with subsystem levels", `{"level": "warn", "format": "json", "subsystems": {"tcp": "debug", "handlers": "error"}}`, ""},
+		{"invalid level", `{"level": "loud"}`, `log: invalid level "loud"`},
+		{"unknown format", `{"format": "xml"}`, `log: unknown format "xml", expected text or json`},
+		{"unknown subsystem", `{"subsystems": {"http": "debug"}}`, `log: unknown subsystem "http", expected one of server, handlers, tcp, udp, socks5, admin, reload, tracing, access_log`},
+		{"invalid subsystem level", `{"subsystems": {"tcp": "loud"}}`, `log: subsystem tcp: invalid level "loud"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := ReadConfigFromString(`{"routes": [], "log": ` + tt.log + `}`)
+			require.NoError(t, err)
+
+			loggers, err := config.CreateLoggers(&bytes.Buffer{})
+
+			if tt.err != "" {
+				assert.EqualError(t, err, tt.err)
+				assert.EqualError(t, config.Validate(), tt.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.NotNil(t, loggers)
+			assert.NoError(t, config.Validate())
+		})
+	}
+
+	t.Run("handlers log to the configured loggers", func(t *testing.T) {
+		// language=JSON
+		config, err := ReadConfigFromString(`{
+			"log": {"format": "json", "level": "error"},
+			"routes": [{"matcher": {"path": "/debug"}, "handler": {"debug": {}}}]
+		}`)
+		require.NoError(t, err)
+		var buf bytes.Buffer
+		loggers, err := config.CreateLoggers(&buf)
+		require.NoError(t, err)
+		config.SetLoggers(loggers)
+		router, err := config.CreateRouter()
+		require.NoError(t, err)
+		r := httptest.NewRequest("POST", "/debug", failingReader{})
+		r.ContentLength = 1
+
+		router.ServeHTTP(httptest.NewRecorder(), r)
+
+		records := logRecords(t, &buf)
+		require.Len(t, records, 1)
+		assert.Equal(t, "Error reading body", records[0]["msg"])
+		assert.Equal(t, "handlers", records[0]["subsystem"])
+		assert.Equal(t, "/debug", records[0]["route"])
+	})
+}
+
+type failingReader struct{}
+
+func (failingReader) Read([]byte) (int, error) {
+	return 0, assert.AnError
+}
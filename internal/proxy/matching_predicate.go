@@ -15,6 +15,7 @@ type RequestPredicate struct {
 	Path   *PathPredicate
 	Header *HeaderPredicate
 	Query  *QueryPredicate
+	GRPC   *GRPCPredicate
 }
 
 func (p *RequestPredicate) match(r *http.Request) bool {
@@ -22,7 +23,8 @@ func (p *RequestPredicate) match(r *http.Request) bool {
 		p.Method != nil && !p.Method.match(r) ||
 			p.Path != nil && !p.Path.match(r) ||
 			p.Header != nil && !p.Header.match(r) ||
-			p.Query != nil && !p.Query.match(r)
+			p.Query != nil && !p.Query.match(r) ||
+			p.GRPC != nil && !p.GRPC.match(r)
 	return !hasNonMatchingPredicate
 }
 
@@ -158,3 +160,34 @@ func (p *QueryPredicate) match(r *http.Request) bool {
 	}
 	return false
 }
+
+// GRPCPredicate matches gRPC requests by the service and method encoded in
+// their /package.Service/Method path. An empty Method matches every method.
+type GRPCPredicate struct {
+	Service string
+	Method  string
+}
+
+func NewGRPCPredicate(service, method string) (*GRPCPredicate, error) {
+	if service == "" {
+		return nil, fmt.Errorf("grpc service is empty")
+	}
+	if strings.Contains(service, "/") || strings.Contains(method, "/") {
+		return nil, fmt.Errorf("grpc service and method must not contain /")
+	}
+	return &GRPCPredicate{
+		Service: service,
+		Method:  method,
+	}, nil
+}
+
+func (p *GRPCPredicate) match(r *http.Request) bool {
+	if !isGRPCRequest(r) {
+		return false
+	}
+	service, method, ok := parseGRPCPath(r.URL.Path)
+	if !ok || service != p.Service {
+		return false
+	}
+	return p.Method == "" || method == p.Method
+}
@@ -712,6 +712,90 @@ func TestRequestPredicate_match(t *testing.T) {
 	}
 }
 
+func TestGRPCPredicate_match(t *testing.T) {
+	type fields struct {
+		Service string
+		Method  string
+	}
+	type args struct {
+		r *http.Request
+	}
+	tests := []struct {
+		name   string
+		fields fields
+		args   args
+		want   bool
+	}{
+		{
+			name:   "matchingServiceAndMethod",
+			fields: fields{Service: "helloworld.Greeter", Method: "SayHello"},
+			args:   args{r: newGRPCRequest("/helloworld.Greeter/SayHello")},
+			want:   true,
+		},
+		{
+			name:   "matchingServiceAnyMethod",
+			fields: fields{Service: "helloworld.Greeter"},
+			args:   args{r: newGRPCRequest("/helloworld.Greeter/SayGoodbye")},
+			want:   true,
+		},
+		{
+			name:   "nonMatchingMethod",
+			fields: fields{Service: "helloworld.Greeter", Method: "SayHello"},
+			args:   args{r: newGRPCRequest("/helloworld.Greeter/SayGoodbye")},
+			want:   false,
+		},
+		{
+			name:   "nonMatchingService",
+			fields: fields{Service: "helloworld.Greeter"},
+			args:   args{r: newGRPCRequest("/helloworld.Other/SayHello")},
+			want:   false,
+		},
+		{
+			name:   "invalidGRPCPath",
+			fields: fields{Service: "helloworld.Greeter"},
+			args:   args{r: newGRPCRequest("/helloworld.Greeter")},
+			want:   false,
+		},
+		{
+			name:   "nonGRPCRequest",
+			fields: fields{Service: "helloworld.Greeter"},
+			args:   args{r: newRequest("POST", "/helloworld.Greeter/SayHello")},
+			want:   false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &GRPCPredicate{
+				Service: tt.fields.Service,
+				Method:  tt.fields.Method,
+			}
+			assert.Equalf(t, tt.want, p.match(tt.args.r), "match(%v)", tt.args.r)
+		})
+	}
+}
+
+func TestNewGRPCPredicate(t *testing.T) {
+	t.Run("validGRPCPredicate", func(t *testing.T) {
+		got, err := NewGRPCPredicate("helloworld.Greeter", "SayHello")
+		assert.NoError(t, err)
+		assert.Equal(t, &GRPCPredicate{Service: "helloworld.Greeter", Method: "SayHello"}, got)
+	})
+	t.Run("emptyService", func(t *testing.T) {
+		_, err := NewGRPCPredicate("", "SayHello")
+		assert.EqualError(t, err, "grpc service is empty")
+	})
+	t.Run("slashInMethod", func(t *testing.T) {
+		_, err := NewGRPCPredicate("helloworld.Greeter", "Say/Hello")
+		assert.EqualError(t, err, "grpc service and method must not contain /")
+	})
+}
+
+func newGRPCRequest(path string) *http.Request {
+	req := newRequest("POST", path)
+	req.Header.Set("Content-Type", "application/grpc+proto")
+	return req
+}
+
 func newGetRequest(path string) *http.Request {
 	return newRequest("GET", path)
 }
@@ -30,3 +30,22 @@ func (mr *MatchingRouter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 }
+
+// predicateHandler serves the requests matching Predicate by Handler, and
+// responds 404 Not Found to any others.
+type predicateHandler struct {
+	Predicate MatchingPredicate
+	Handler   Handler
+}
+
+func (h *predicateHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !h.Predicate.match(r) {
+		http.NotFound(w, r)
+		return
+	}
+	h.Handler.ServeHTTP(w, r)
+}
+
+func (h *predicateHandler) Close() error {
+	return closeHandler(h.Handler)
+}
@@ -0,0 +1,342 @@
+package proxy
+
+import (
+	"bufio"
+	"fmt"
+	"log/slog"
+	"maps"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultMetrics holds the metrics of the proxy. It serves them in the
+// Prometheus text format and is mounted at /metrics of the admin API.
+var DefaultMetrics = NewMetrics()
+
+// DefaultBuckets are the upper bounds, in seconds, of latency histograms.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+var (
+	routeRequests = DefaultMetrics.Counter("proxy_http_requests_total",
+		"HTTP requests served by a route, by status class.", "route", "code")
+	routeDuration = DefaultMetrics.Histogram("proxy_http_request_duration_seconds",
+		"Latency of HTTP requests served by a route.", DefaultBuckets, "route")
+	routeInFlight = DefaultMetrics.Gauge("proxy_http_requests_in_flight",
+		"HTTP requests currently served by a route.", "route")
+	retrierAttempts = DefaultMetrics.Counter("proxy_retrier_attempts_total",
+		"Attempts made by retrier handlers, including the first one.", "route")
+	retrierExhausted = DefaultMetrics.Counter("proxy_retrier_exhausted_total",
+		"Requests for which retrier handlers ran out of retries.", "route")
+	chaosInjections = DefaultMetrics.Counter("proxy_chaos_injections_total",
+		"Failures injected by chaos handlers, and by SOCKS5 listeners labelled with their address.", "route")
+	rateLimitRejections = DefaultMetrics.Counter("proxy_rate_limit_rejections_total",
+		"Requests rejected by rate_limit handlers.", "route")
+	fanoutBranches = DefaultMetrics.Counter("proxy_fanout_branch_responses_total",
+		"Responses of fanout handler branches, by status class.", "route", "branch", "code")
+	upstreamConnections = DefaultMetrics.Counter("proxy_upstream_connections_total",
+		"Connections used by forward handlers, by whether they were reused from the pool.", "upstream", "reused")
+	upstreamInFlight = DefaultMetrics.Gauge("proxy_upstream_requests_in_flight",
+		"Requests currently forwarded to an upstream.", "upstream")
+	upstreamErrors = DefaultMetrics.Counter("proxy_upstream_errors_total",
+		"Requests which failed to reach an upstream.", "upstream")
+	udpDroppedDatagrams = DefaultMetrics.Counter("proxy_udp_dropped_datagrams_total",
+		"Datagrams of new clients dropped by UDP proxies at their session limit.", "listen")
+)
+
+// Metrics is a registry of metric families, which writes them in the
+// Prometheus text exposition format.
+type Metrics struct {
+	Logger *slog.Logger
+
+	mu       sync.Mutex
+	families []*metricFamily
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{}
+}
+
+// Counter registers a counter, which only ever increases.
+func (m *Metrics) Counter(name, help string, labels ...string) *CounterVec {
+	return &CounterVec{m.register(name, help, "counter", labels, nil)}
+}
+
+// Gauge registers a gauge, which goes up and down.
+func (m *Metrics) Gauge(name, help string, labels ...string) *GaugeVec {
+	return &GaugeVec{m.register(name, help, "gauge", labels, nil)}
+}
+
+// Histogram registers a histogram counting observations into buckets with
+// the given upper bounds.
+func (m *Metrics) Histogram(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	return &HistogramVec{m.register(name, help, "histogram", labels, slices.Sorted(slices.Values(buckets)))}
+}
+
+func (m *Metrics) register(name, help, kind string, labels []string, buckets []float64) *metricFamily {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, family := range m.families {
+		if family.name == name {
+			panic(fmt.Sprintf("metric %s is already registered", name))
+		}
+	}
+	family := &metricFamily{
+		name:    name,
+		help:    help,
+		kind:    kind,
+		labels:  labels,
+		buckets: buckets,
+		series:  make(map[string]*metricSeries),
+	}
+	m.families = append(m.families, family)
+	return family
+}
+
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	bw := bufio.NewWriter(w)
+	m.write(bw)
+	if err := bw.Flush(); err != nil {
+		loggerOrDefault(m.Logger).ErrorContext(r.Context(), "Error writing metrics", "error", err)
+	}
+}
+
+func (m *Metrics) write(w *bufio.Writer) {
+	m.mu.Lock()
+	families := slices.Clone(m.families)
+	m.mu.Unlock()
+	for _, family := range families {
+		family.write(w)
+	}
+}
+
+// deleteSeries removes the series of every family labelled with label which
+// have one of values, like those of routes removed by a reload.
+func (m *Metrics) deleteSeries(label string, values []string) {
+	m.mu.Lock()
+	families := slices.Clone(m.families)
+	m.mu.Unlock()
+	for _, family := range families {
+		if i := slices.Index(family.labels, label); i >= 0 {
+			family.deleteSeries(i, values)
+		}
+	}
+}
+
+// CounterVec is a counter partitioned by label values.
+type CounterVec struct {
+	family *metricFamily
+}
+
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *CounterVec) Add(delta float64, labelValues ...string) {
+	c.family.update(labelValues, func(s *metricSeries) { s.value += delta })
+}
+
+// GaugeVec is a gauge partitioned by label values.
+type GaugeVec struct {
+	family *metricFamily
+}
+
+func (g *GaugeVec) Inc(labelValues ...string) {
+	g.Add(1, labelValues...)
+}
+
+func (g *GaugeVec) Dec(labelValues ...string) {
+	g.Add(-1, labelValues...)
+}
+
+func (g *GaugeVec) Add(delta float64, labelValues ...string) {
+	g.family.update(labelValues, func(s *metricSeries) { s.value += delta })
+}
+
+func (g *GaugeVec) Set(value float64, labelValues ...string) {
+	g.family.update(labelValues, func(s *metricSeries) { s.value = value })
+}
+
+// HistogramVec is a histogram partitioned by label values.
+type HistogramVec struct {
+	family *metricFamily
+}
+
+func (h *HistogramVec) Observe(value float64, labelValues ...string) {
+	h.family.update(labelValues, func(s *metricSeries) {
+		if s.buckets == nil {
+			s.buckets = make([]uint64, len(h.family.buckets))
+		}
+		for i, bound := range h.family.buckets {
+			if value <= bound {
+				s.buckets[i]++
+			}
+		}
+		s.count++
+		s.value += value
+	})
+}
+
+type metricFamily struct {
+	name    string
+	help    string
+	kind    string
+	labels  []string
+	buckets []float64
+
+	mu     sync.Mutex
+	series map[string]*metricSeries
+}
+
+// metricSeries is the state of a family for one set of label values. For
+// histograms, value is the sum of observations and buckets are cumulative.
+type metricSeries struct {
+	labelValues []string
+	value       float64
+	buckets     []uint64
+	count       uint64
+}
+
+func (f *metricFamily) update(labelValues []string, fn func(s *metricSeries)) {
+	if len(labelValues) != len(f.labels) {
+		panic(fmt.Sprintf("metric %s has labels %v, got values %v", f.name, f.labels, labelValues))
+	}
+	key := strings.Join(labelValues, "\xff")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s, ok := f.series[key]
+	if !ok {
+		s = &metricSeries{labelValues: slices.Clone(labelValues)}
+		f.series[key] = s
+	}
+	fn(s)
+}
+
+// value returns the value of the series with the given label values.
+func (f *metricFamily) value(labelValues ...string) float64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if s, ok := f.series[strings.Join(labelValues, "\xff")]; ok {
+		return s.value
+	}
+	return 0
+}
+
+// deleteSeries removes the series whose label at index has one of values.
+func (f *metricFamily) deleteSeries(index int, values []string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	maps.DeleteFunc(f.series, func(_ string, s *metricSeries) bool {
+		return slices.Contains(values, s.labelValues[index])
+	})
+}
+
+func (f *metricFamily) write(w *bufio.Writer) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, _ = fmt.Fprintf(w, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+	_, _ = fmt.Fprintf(w, "# TYPE %s %s\n", f.name, f.kind)
+	for _, key := range slices.Sorted(maps.Keys(f.series)) {
+		s := f.series[key]
+		if f.kind != "histogram" {
+			f.writeSample(w, "", s.labelValues, "", "", s.value)
+			continue
+		}
+		for i, bound := range f.buckets {
+			f.writeSample(w, "_bucket", s.labelValues, "le", formatFloat(bound), float64(s.buckets[i]))
+		}
+		f.writeSample(w, "_bucket", s.labelValues, "le", "+Inf", float64(s.count))
+		f.writeSample(w, "_sum", s.labelValues, "", "", s.value)
+		f.writeSample(w, "_count", s.labelValues, "", "", float64(s.count))
+	}
+}
+
+func (f *metricFamily) writeSample(w *bufio.Writer, suffix string, labelValues []string, extraLabel, extraValue string, value float64) {
+	_, _ = w.WriteString(f.name + suffix)
+	pairs := make([]string, 0, len(labelValues)+1)
+	for i, label := range f.labels {
+		pairs = append(pairs, label+`="`+escapeLabelValue(labelValues[i])+`"`)
+	}
+	if extraLabel != "" {
+		pairs = append(pairs, extraLabel+`="`+extraValue+`"`)
+	}
+	if len(pairs) > 0 {
+		_, _ = w.WriteString("{" + strings.Join(pairs, ",") + "}")
+	}
+	_, _ = w.WriteString(" " + formatFloat(value) + "\n")
+}
+
+func formatFloat(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+var (
+	helpReplacer       = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelValueReplacer = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpReplacer.Replace(s)
+}
+
+func escapeLabelValue(s string) string {
+	return labelValueReplacer.Replace(s)
+}
+
+// statusClass groups status codes into 1xx to 5xx.
+func statusClass(statusCode int) string {
+	if statusCode < 100 || statusCode > 599 {
+		return "unknown"
+	}
+	return strconv.Itoa(statusCode/100) + "xx"
+}
+
+// statusWriter records the status code and the number of bytes written to
+// the wrapped writer.
+type statusWriter struct {
+	http.ResponseWriter
+	statusCode int
+	written    int64
+}
+
+func (w *statusWriter) WriteHeader(statusCode int) {
+	// informational responses precede the final one
+	if w.statusCode == 0 && statusCode >= 200 {
+		w.statusCode = statusCode
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *statusWriter) Write(data []byte) (int, error) {
+	if w.statusCode == 0 {
+		w.statusCode = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(data)
+	w.written += int64(n)
+	return n, err
+}
+
+// Unwrap gives http.ResponseController access to the wrapped writer, e.g.
+// for flushing and hijacking.
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// status returns the written status code, which is 200 if the handler
+// wrote nothing, as net/http would send.
+func (w *statusWriter) status() int {
+	if w.statusCode == 0 {
+		return http.StatusOK
+	}
+	return w.statusCode
+}
@@ -0,0 +1,206 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetrics_ServeHTTP(t *testing.T) {
+	metrics := NewMetrics()
+	requests := metrics.Counter("requests_total", "Requests.", "route", "code")
+	inFlight := metrics.Gauge("in_flight", "In flight.")
+	latency := metrics.Histogram("latency_seconds", "Latency.", []float64{1, 0.1}, "route")
+
+	requests.Inc("/b", "2xx")
+	requests.Add(2, "/a\"\n", "5xx")
+	inFlight.Inc()
+	inFlight.Inc()
+	inFlight.Dec()
+	latency.Observe(0.05, "/a")
+	latency.Observe(0.5, "/a")
+	latency.Observe(5, "/a")
+
+	w := httptest.NewRecorder()
+	metrics.ServeHTTP(w, newGetRequest("/metrics"))
+
+	assert.Equal(t, "text/plain; version=0.0.4; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.Equal(t, `# HELP requests_total Requests.
+# TYPE requests_total counter
+requests_total{route="/a\"\n",code="5xx"} 2
+requests_total{route="/b",code="2xx"} 1
+# HELP in_flight In flight.
+# TYPE in_flight gauge
+in_flight 1
+# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{route="/a",le="0.1"} 1
+latency_seconds_bucket{route="/a",le="1"} 2
+latency_seconds_bucket{route="/a",le="+Inf"} 3
+latency_seconds_sum{route="/a"} 5.55
+latency_seconds_count{route="/a"} 3
+`, w.Body.String())
+}
+
+func TestMetrics_register(t *testing.T) {
+	metrics := NewMetrics()
+	counter := metrics.Counter("requests_total", "Requests.", "route")
+
+	assert.Panics(t, func() { metrics.Gauge("requests_total", "Again.") })
+	assert.Panics(t, func() { counter.Inc("/a", "2xx") })
+}
+
+func TestMetrics_deleteSeries(t *testing.T) {
+	metrics := NewMetrics()
+	requests := metrics.Counter("requests_total", "Requests.", "route", "code")
+	connections := metrics.Counter("connections_total", "Connections.", "upstream")
+	requests.Inc("/a", "2xx")
+	requests.Inc("/a", "5xx")
+	requests.Inc("/b", "2xx")
+	connections.Inc("/a")
+
+	metrics.deleteSeries("route", []string{"/a"})
+
+	w := httptest.NewRecorder()
+	metrics.ServeHTTP(w, newGetRequest("/metrics"))
+	assert.Equal(t, `# HELP requests_total Requests.
+# TYPE requests_total counter
+requests_total{route="/b",code="2xx"} 1
+# HELP connections_total Connections.
+# TYPE connections_total counter
+connections_total{upstream="/a"} 1
+`, w.Body.String())
+}
+
+func TestRouteMetrics_reload(t *testing.T) {
+	t.Run("drops series of routes removed by a reload", func(t *testing.T) {
+		// language=JSON
+		path := writeConfigFile(t, `{"routes": [
+			{"matcher": {"path": "/metrics-reload/kept"}, "handler": {"static": {}}},
+			{"matcher": {"path": "/metrics-reload/removed"}, "handler": {"static": {}}}
+		]}`)
+		config := mustReadConfigFile(t, path)
+		router, err := config.CreateRouter()
+		require.NoError(t, err)
+		rr := NewReloadableRouter(router)
+		reloader := NewConfigReloader(path, config, rr)
+		rr.ServeHTTP(httptest.NewRecorder(), newGetRequest("/metrics-reload/kept"))
+		rr.ServeHTTP(httptest.NewRecorder(), newGetRequest("/metrics-reload/removed"))
+		require.Contains(t, writeMetrics(t), `route="/metrics-reload/removed"`)
+
+		// language=JSON
+		newConfig := `{"routes": [{"matcher": {"path": "/metrics-reload/kept"}, "handler": {"static": {}}}]}`
+		require.NoError(t, os.WriteFile(path, []byte(newConfig), 0o644))
+		require.NoError(t, reloader.Reload())
+
+		assert.Eventually(t, func() bool {
+			return !strings.Contains(writeMetrics(t), `route="/metrics-reload/removed"`)
+		}, time.Second, time.Millisecond)
+		assert.Contains(t, writeMetrics(t), `proxy_http_requests_total{route="/metrics-reload/kept",code="2xx"}`)
+	})
+}
+
+func TestRouteMetrics(t *testing.T) {
+	router := NewPathRouter()
+	router.AddRoute("/metrics-test/ok", &StaticHandler{message: "ok"})
+	router.AddRoute("/metrics-test/retry", &RetrierHandler{
+		Handler:     &MockHandler{statusCodes: []int{http.StatusInternalServerError}},
+		RetryPolicy: &RetryOnNon2xxRetryPolicy{},
+		Retries:     2,
+	})
+	router.AddRoute("/metrics-test/chaos", NewChaosHandler(&StaticHandler{message: "ok"}, 1))
+	router.AddRoute("/metrics-test/fanout", &FanOutHandler{
+		Handlers:         []Handler{&StaticHandler{message: "ok"}, &MockHandler{statusCodes: []int{http.StatusBadGateway}}},
+		ResponseStrategy: &FirstSuccessfulResponseStrategy{},
+	})
+
+	serve := func(path string) {
+		router.ServeHTTP(httptest.NewRecorder(), newGetRequest(path))
+	}
+
+	t.Run("counts requests by status class", func(t *testing.T) {
+		requests := metricDelta(routeRequests.family, "/metrics-test/ok", "2xx")
+
+		serve("/metrics-test/ok")
+		serve("/metrics-test/ok")
+
+		assert.Equal(t, 2.0, requests())
+		assert.Equal(t, 0.0, routeInFlight.family.value("/metrics-test/ok"))
+		assert.Contains(t, writeMetrics(t), `proxy_http_request_duration_seconds_bucket{route="/metrics-test/ok",le="+Inf"}`)
+	})
+
+	t.Run("counts retries", func(t *testing.T) {
+		attempts := metricDelta(retrierAttempts.family, "/metrics-test/retry")
+		exhausted := metricDelta(retrierExhausted.family, "/metrics-test/retry")
+		requests := metricDelta(routeRequests.family, "/metrics-test/retry", "5xx")
+
+		serve("/metrics-test/retry")
+
+		assert.Equal(t, 3.0, attempts())
+		assert.Equal(t, 1.0, exhausted())
+		assert.Equal(t, 1.0, requests())
+	})
+
+	t.Run("counts chaos injections", func(t *testing.T) {
+		injections := metricDelta(chaosInjections.family, "/metrics-test/chaos")
+
+		serve("/metrics-test/chaos")
+
+		assert.Equal(t, 1.0, injections())
+	})
+
+	t.Run("counts fanout branch outcomes", func(t *testing.T) {
+		succeeded := metricDelta(fanoutBranches.family, "/metrics-test/fanout", "0", "2xx")
+		failed := metricDelta(fanoutBranches.family, "/metrics-test/fanout", "1", "5xx")
+
+		serve("/metrics-test/fanout")
+
+		assert.Equal(t, 1.0, succeeded())
+		assert.Equal(t, 1.0, failed())
+	})
+}
+
+func TestForwardHandler_metrics(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer upstream.Close()
+	h, err := NewForwardHandler(upstream.URL)
+	require.NoError(t, err)
+	host := h.URL.Host
+
+	for i := 0; i < 2; i++ {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	}
+
+	assert.Equal(t, 1.0, upstreamConnections.family.value(host, "false"))
+	assert.Equal(t, 1.0, upstreamConnections.family.value(host, "true"))
+	assert.Equal(t, 0.0, upstreamInFlight.family.value(host))
+
+	upstream.Close()
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	assert.Equal(t, 1.0, upstreamErrors.family.value(host))
+}
+
+// metricDelta returns a function reporting how much a series changed since
+// metricDelta was called, as other tests share the default metrics.
+func metricDelta(family *metricFamily, labelValues ...string) func() float64 {
+	before := family.value(labelValues...)
+	return func() float64 {
+		return family.value(labelValues...) - before
+	}
+}
+
+func writeMetrics(t *testing.T) string {
+	w := httptest.NewRecorder()
+	DefaultMetrics.ServeHTTP(w, newGetRequest("/metrics"))
+	require.Equal(t, http.StatusOK, w.Code)
+	return w.Body.String()
+}
@@ -0,0 +1,205 @@
+package proxy
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateLimit is a token bucket limit: Burst requests are allowed at once, and
+// the bucket refills at Requests per Period.
+type RateLimit struct {
+	Requests int
+	Period   time.Duration
+	Burst    int
+}
+
+// interval returns the time it takes to refill a single token.
+func (l RateLimit) interval() time.Duration {
+	return l.Period / time.Duration(l.Requests)
+}
+
+// RateLimitResult is the outcome of taking a token for a key.
+type RateLimitResult struct {
+	Allowed bool
+	// Remaining is the number of requests allowed right away after this one.
+	Remaining int
+	// RetryAfter is the time until a request is allowed again, zero if it's allowed.
+	RetryAfter time.Duration
+	// Reset is the time until the bucket is full again.
+	Reset time.Duration
+}
+
+// RateLimitStore keeps the buckets of rate limited keys.
+type RateLimitStore interface {
+	// Take takes a token from the bucket of key, if one is left.
+	Take(ctx context.Context, key string, limit RateLimit) (RateLimitResult, error)
+}
+
+// RateLimitKey returns the key a request is rate limited by.
+type RateLimitKey func(r *http.Request) string
+
+// ParseRateLimitKey parses a key like "ip", "header:X-API-Key",
+// "path:tenant" or "global". Requests without the header or path variable
+// share the bucket of the empty value.
+func ParseRateLimitKey(key string) (RateLimitKey, error) {
+	kind, name, _ := strings.Cut(key, ":")
+	switch {
+	case key == "ip":
+		return func(r *http.Request) string { return remoteHost(r.RemoteAddr) }, nil
+	case key == "global":
+		return func(*http.Request) string { return "" }, nil
+	case kind == "header" && validHeaderName(name):
+		return func(r *http.Request) string { return r.Header.Get(name) }, nil
+	case kind == "path" && name != "":
+		return func(r *http.Request) string { return r.PathValue(name) }, nil
+	default:
+		return nil, fmt.Errorf("invalid key %q, expected ip, header:<name>, path:<variable> or global", key)
+	}
+}
+
+// RateLimitHandler rejects requests exceeding the limit of their key with
+// 429 Too Many Requests. Responses carry RateLimit-Limit, RateLimit-Remaining
+// and RateLimit-Reset headers, and rejections a Retry-After header.
+type RateLimitHandler struct {
+	Handler Handler
+	Limit   RateLimit
+	Key     RateLimitKey
+	Store   RateLimitStore
+	Logger  *slog.Logger
+}
+
+func (h *RateLimitHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	result, err := h.Store.Take(r.Context(), h.Key(r), h.Limit)
+	if err != nil {
+		// an unavailable store shouldn't take the route down with it
+		loggerOrDefault(h.Logger).ErrorContext(r.Context(), "Error taking rate limit token", "error", err)
+		h.Handler.ServeHTTP(w, r)
+		return
+	}
+
+	header := w.Header()
+	header.Set("RateLimit-Limit", strconv.Itoa(h.Limit.Burst))
+	header.Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	header.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.Reset)))
+	if !result.Allowed {
+		rateLimitRejections.Inc(routeFromContext(r.Context()))
+		SpanFromContext(r.Context()).SetAttribute("rate_limit.rejected", true)
+		header.Set("Retry-After", strconv.Itoa(ceilSeconds(result.RetryAfter)))
+		http.Error(w, "Too many requests", http.StatusTooManyRequests)
+		return
+	}
+
+	h.Handler.ServeHTTP(w, r)
+}
+
+func (h *RateLimitHandler) Close() error {
+	var errs []error
+	if err := closeHandler(h.Handler); err != nil {
+		errs = append(errs, err)
+	}
+	if closer, ok := h.Store.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// MemoryRateLimitStore keeps buckets in memory. It holds at most MaxKeys
+// buckets, evicting the least recently used ones, and drops buckets once
+// they are full again, as they are then the same as new ones.
+type MemoryRateLimitStore struct {
+	MaxKeys int
+
+	mu      sync.Mutex
+	buckets map[string]*list.Element
+	// lru holds the buckets, most recently used first
+	lru *list.List
+	now func() time.Time
+}
+
+type tokenBucket struct {
+	key    string
+	tokens float64
+	last   time.Time
+	// full is when the bucket is full again
+	full time.Time
+}
+
+func NewMemoryRateLimitStore(maxKeys int) *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{
+		MaxKeys: maxKeys,
+		buckets: make(map[string]*list.Element),
+		lru:     list.New(),
+		now:     time.Now,
+	}
+}
+
+func (s *MemoryRateLimitStore) Take(_ context.Context, key string, limit RateLimit) (RateLimitResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	s.expire(now)
+
+	var bucket *tokenBucket
+	if element, ok := s.buckets[key]; ok {
+		s.lru.MoveToFront(element)
+		bucket = element.Value.(*tokenBucket)
+		elapsed := now.Sub(bucket.last)
+		bucket.tokens = min(float64(limit.Burst), bucket.tokens+float64(elapsed)/float64(limit.interval()))
+	} else {
+		bucket = &tokenBucket{key: key, tokens: float64(limit.Burst)}
+		s.buckets[key] = s.lru.PushFront(bucket)
+		if s.lru.Len() > s.MaxKeys {
+			s.remove(s.lru.Back())
+		}
+	}
+	bucket.last = now
+
+	result := RateLimitResult{}
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = time.Duration((1 - bucket.tokens) * float64(limit.interval()))
+	}
+	result.Remaining = int(bucket.tokens)
+	result.Reset = time.Duration((float64(limit.Burst) - bucket.tokens) * float64(limit.interval()))
+	bucket.full = now.Add(result.Reset)
+	return result, nil
+}
+
+// expire drops the least recently used buckets as long as they are full
+// again. It stops at the first one which isn't, leaving any others to a
+// later call or to eviction, so it takes constant time on average.
+func (s *MemoryRateLimitStore) expire(now time.Time) {
+	for element := s.lru.Back(); element != nil && !element.Value.(*tokenBucket).full.After(now); element = s.lru.Back() {
+		s.remove(element)
+	}
+}
+
+func (s *MemoryRateLimitStore) remove(element *list.Element) {
+	s.lru.Remove(element)
+	delete(s.buckets, element.Value.(*tokenBucket).key)
+}
+
+// Len returns the number of buckets held.
+func (s *MemoryRateLimitStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lru.Len()
+}
@@ -0,0 +1,211 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeClock is a time source for rate limit stores which only advances when told to.
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func TestMemoryRateLimitStore(t *testing.T) {
+	limit := RateLimit{Requests: 2, Period: time.Second, Burst: 3}
+	newStore := func(maxKeys int) (*MemoryRateLimitStore, *fakeClock) {
+		clock := &fakeClock{now: time.Unix(1700000000, 0)}
+		store := NewMemoryRateLimitStore(maxKeys)
+		store.now = clock.Now
+		return store, clock
+	}
+	take := func(t *testing.T, store *MemoryRateLimitStore, key string) RateLimitResult {
+		result, err := store.Take(context.Background(), key, limit)
+		require.NoError(t, err)
+		return result
+	}
+
+	t.Run("allows the burst, then refills", func(t *testing.T) {
+		store, clock := newStore(10)
+
+		assert.Equal(t, RateLimitResult{Allowed: true, Remaining: 2, Reset: 500 * time.Millisecond}, take(t, store, "a"))
+		take(t, store, "a")
+		assert.Equal(t, RateLimitResult{Allowed: true, Remaining: 0, Reset: 1500 * time.Millisecond}, take(t, store, "a"))
+		assert.Equal(t, RateLimitResult{RetryAfter: 500 * time.Millisecond, Reset: 1500 * time.Millisecond}, take(t, store, "a"))
+		assert.True(t, take(t, store, "b").Allowed, "keys have their own bucket")
+
+		clock.advance(250 * time.Millisecond)
+		assert.Equal(t, 250*time.Millisecond, take(t, store, "a").RetryAfter)
+		clock.advance(250 * time.Millisecond)
+		assert.True(t, take(t, store, "a").Allowed)
+	})
+
+	t.Run("drops full buckets", func(t *testing.T) {
+		store, clock := newStore(10)
+		take(t, store, "a")
+		take(t, store, "b")
+		take(t, store, "b")
+
+		clock.advance(500 * time.Millisecond)
+		take(t, store, "c")
+
+		assert.Equal(t, 2, store.Len())
+		clock.advance(time.Second)
+		take(t, store, "c")
+		assert.Equal(t, 1, store.Len())
+	})
+
+	t.Run("evicts the least recently used keys", func(t *testing.T) {
+		store, _ := newStore(2)
+		take(t, store, "a")
+		take(t, store, "a")
+		take(t, store, "a")
+		take(t, store, "b")
+		take(t, store, "c")
+
+		assert.Equal(t, 2, store.Len())
+		assert.True(t, take(t, store, "a").Allowed, "the bucket of a was evicted")
+	})
+}
+
+type failingRateLimitStore struct{}
+
+func (failingRateLimitStore) Take(context.Context, string, RateLimit) (RateLimitResult, error) {
+	return RateLimitResult{}, errors.New("store unavailable")
+}
+
+func TestRateLimitHandler(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("OK"))
+	})
+
+	t.Run("rejects requests over the limit", func(t *testing.T) {
+		key, err := ParseRateLimitKey("header:X-API-Key")
+		require.NoError(t, err)
+		handler := &RateLimitHandler{
+			Handler: ok,
+			Limit:   RateLimit{Requests: 1, Period: time.Minute, Burst: 1},
+			Key:     key,
+			Store:   NewMemoryRateLimitStore(10),
+		}
+		serve := func(apiKey string) *httptest.ResponseRecorder {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.Header.Set("X-API-Key", apiKey)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			return w
+		}
+
+		allowed := serve("one")
+		rejected := serve("one")
+
+		assert.Equal(t, http.StatusOK, allowed.Code)
+		assert.Equal(t, "1", allowed.Header().Get("RateLimit-Limit"))
+		assert.Equal(t, "0", allowed.Header().Get("RateLimit-Remaining"))
+		assert.Equal(t, "60", allowed.Header().Get("RateLimit-Reset"))
+		assert.Empty(t, allowed.Header().Get("Retry-After"))
+		assert.Equal(t, http.StatusTooManyRequests, rejected.Code)
+		assert.Equal(t, "60", rejected.Header().Get("Retry-After"))
+		assert.Equal(t, http.StatusOK, serve("two").Code)
+	})
+
+	t.Run("allows requests if the store fails", func(t *testing.T) {
+		var buf bytes.Buffer
+		handler := &RateLimitHandler{
+			Handler: ok,
+			Limit:   RateLimit{Requests: 1, Period: time.Second, Burst: 1},
+			Key:     func(*http.Request) string { return "" },
+			Store:   failingRateLimitStore{},
+			Logger:  slog.New(slog.NewJSONHandler(&buf, nil)),
+		}
+		w := httptest.NewRecorder()
+
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Empty(t, w.Header().Get("RateLimit-Limit"))
+		records := logRecords(t, &buf)
+		require.Len(t, records, 1)
+		assert.Equal(t, "store unavailable", records[0]["error"])
+	})
+}
+
+func TestParseRateLimitKey(t *testing.T) {
+	router := NewPathRouter()
+	var keys []string
+	add := func(key string) {
+		rateLimitKey, err := ParseRateLimitKey(key)
+		require.NoError(t, err)
+		keys = append(keys, rateLimitKey(httptest.NewRequest("GET", "/", nil)))
+	}
+	router.AddRoute("/tenants/{tenant}/", http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		key, err := ParseRateLimitKey("path:tenant")
+		require.NoError(t, err)
+		keys = append(keys, key(r))
+	}))
+
+	add("ip")
+	add("global")
+	add("header:X-API-Key")
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/tenants/acme/items", nil))
+
+	assert.Equal(t, []string{"192.0.2.1", "", "", "acme"}, keys)
+	for _, key := range []string{"", "client", "header:", "header:X Key", "path:"} {
+		_, err := ParseRateLimitKey(key)
+		assert.EqualError(t, err, `invalid key "`+key+`", expected ip, header:<name>, path:<variable> or global`)
+	}
+}
+
+func TestConfig_rateLimit(t *testing.T) {
+	t.Run("limits by client IP", func(t *testing.T) {
+		// language=JSON
+		config, err := ReadConfigFromString(`{"routes": [{"matcher": {"path": "/limited"}, "handler": {"rate_limit": {
+			"requests": 1, "period": "1m", "handler": {"static": {"message": "OK"}}
+		}}}]}`)
+		require.NoError(t, err)
+		router, err := config.CreateRouter()
+		require.NoError(t, err)
+		rejections := metricDelta(rateLimitRejections.family, "/limited")
+
+		codes := make([]int, 3)
+		for i, remoteAddr := range []string{"10.0.0.1:1000", "10.0.0.1:1001", "10.0.0.2:1000"} {
+			r := httptest.NewRequest("GET", "/limited", nil)
+			r.RemoteAddr = remoteAddr
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r)
+			codes[i] = w.Code
+		}
+
+		assert.Equal(t, []int{http.StatusOK, http.StatusTooManyRequests, http.StatusOK}, codes)
+		assert.Equal(t, 1.0, rejections())
+		assert.Equal(t, "rate_limit(requests=1, period=1m, key=ip) > static", config.Routes[0].Handler.Describe())
+	})
+
+	t.Run("rejects invalid limits", func(t *testing.T) {
+		config, err := ReadConfigFromString(`{"routes": [{"matcher": {"path": "/"}, "handler": {"rate_limit": {
+			"period": "0s", "burst": -1, "key": "cookie", "handler": {"debug": {}}
+		}}}]}`)
+		require.NoError(t, err)
+
+		assert.EqualError(t, config.Validate(), "routes[0].handler.rate_limit: period must be positive, got 0s\n"+
+			"routes[0].handler.rate_limit: requests must be positive, got 0\n"+
+			"routes[0].handler.rate_limit: burst and max_keys must not be negative\n"+
+			`routes[0].handler.rate_limit: invalid key "cookie", expected ip, header:<name>, path:<variable> or global`)
+	})
+}
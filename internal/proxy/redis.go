@@ -0,0 +1,372 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// RedisError is an error reply of a Redis server, e.g. "NOSCRIPT No matching script".
+type RedisError string
+
+func (e RedisError) Error() string {
+	return "redis: " + string(e)
+}
+
+// redisSetupError is an error setting up a connection, e.g. a failed AUTH,
+// which leaves Redis unusable although it replies.
+type redisSetupError struct {
+	command string
+	err     error
+}
+
+func (e *redisSetupError) Error() string {
+	return fmt.Sprintf("redis: %s failed: %v", e.command, e.err)
+}
+
+func (e *redisSetupError) Unwrap() error {
+	return e.err
+}
+
+// redisUnavailable tells whether err means Redis can't serve commands, as
+// opposed to an error reply of a single command.
+func redisUnavailable(err error) bool {
+	var redisErr RedisError
+	var setupErr *redisSetupError
+	if !errors.As(err, &redisErr) || errors.As(err, &setupErr) {
+		return true
+	}
+	kind, _, _ := strings.Cut(string(redisErr), " ")
+	switch kind {
+	case "LOADING", "READONLY", "MASTERDOWN":
+		// the server is starting, a replica or lost its master
+		return true
+	default:
+		return false
+	}
+}
+
+// RedisClient is a minimal client of the Redis protocol (RESP2), keeping a
+// small pool of connections.
+type RedisClient struct {
+	Address  string
+	Password string
+	DB       int
+	// Timeout bounds dialing and every command, unless the context ends earlier.
+	Timeout time.Duration
+
+	mu     sync.Mutex
+	idle   []*redisConn
+	closed bool
+}
+
+// maxIdleRedisConns is the number of connections kept for reuse.
+const maxIdleRedisConns = 8
+
+type redisConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func NewRedisClient(address string) *RedisClient {
+	return &RedisClient{Address: address, Timeout: time.Second}
+}
+
+// Do sends a command and returns its reply: a string, an int64, nil, a
+// []any or a RedisError.
+func (c *RedisClient) Do(ctx context.Context, args ...string) (any, error) {
+	conn, err := c.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	reply, err := conn.do(ctx, c.Timeout, args)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	c.release(conn)
+	if redisErr, ok := reply.(RedisError); ok {
+		return nil, redisErr
+	}
+	return reply, nil
+}
+
+func (c *RedisClient) conn(ctx context.Context) (*redisConn, error) {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil, errors.New("redis: client closed")
+	}
+	if n := len(c.idle); n > 0 {
+		conn := c.idle[n-1]
+		c.idle = c.idle[:n-1]
+		c.mu.Unlock()
+		return conn, nil
+	}
+	c.mu.Unlock()
+
+	dialer := net.Dialer{Timeout: c.Timeout}
+	netConn, err := dialer.DialContext(ctx, "tcp", c.Address)
+	if err != nil {
+		return nil, err
+	}
+	conn := &redisConn{Conn: netConn, reader: bufio.NewReader(netConn)}
+	var setup [][]string
+	if c.Password != "" {
+		setup = append(setup, []string{"AUTH", c.Password})
+	}
+	if c.DB != 0 {
+		setup = append(setup, []string{"SELECT", strconv.Itoa(c.DB)})
+	}
+	for _, args := range setup {
+		reply, err := conn.do(ctx, c.Timeout, args)
+		if redisErr, ok := reply.(RedisError); ok {
+			err = redisErr
+		}
+		if err != nil {
+			_ = conn.Close()
+			return nil, &redisSetupError{command: args[0], err: err}
+		}
+	}
+	return conn, nil
+}
+
+func (c *RedisClient) release(conn *redisConn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed || len(c.idle) >= maxIdleRedisConns {
+		_ = conn.Close()
+		return
+	}
+	c.idle = append(c.idle, conn)
+}
+
+// Close closes the idle connections, and connections in use once released.
+func (c *RedisClient) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	var errs []error
+	for _, conn := range c.idle {
+		if err := conn.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	c.idle = nil
+	return errors.Join(errs...)
+}
+
+func (conn *redisConn) do(ctx context.Context, timeout time.Duration, args []string) (any, error) {
+	deadline := time.Now().Add(timeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	if err := conn.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+	if _, err := conn.Write(encodeRedisCommand(args)); err != nil {
+		return nil, err
+	}
+	return readRedisReply(conn.reader)
+}
+
+// encodeRedisCommand encodes a command as an array of bulk strings.
+func encodeRedisCommand(args []string) []byte {
+	buf := []byte("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, arg := range args {
+		buf = append(buf, "$"+strconv.Itoa(len(arg))+"\r\n"...)
+		buf = append(buf, arg...)
+		buf = append(buf, "\r\n"...)
+	}
+	return buf
+}
+
+func readRedisReply(r *bufio.Reader) (any, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("redis: empty reply")
+	}
+	switch prefix, value := line[0], line[1:]; prefix {
+	case '+':
+		return value, nil
+	case '-':
+		return RedisError(value), nil
+	case ':':
+		return strconv.ParseInt(value, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil // null bulk string
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil // null array
+		}
+		elements := make([]any, n)
+		for i := range elements {
+			if elements[i], err = readRedisReply(r); err != nil {
+				return nil, err
+			}
+		}
+		return elements, nil
+	default:
+		return nil, fmt.Errorf("redis: invalid reply %q", line)
+	}
+}
+
+// redisRateLimitScript implements the generic cell rate algorithm (GCRA),
+// which is equivalent to a token bucket but only stores the theoretical
+// arrival time (TAT) of the next request. It uses the time of the server so
+// that proxy replicas share a single clock.
+//
+// KEYS[1] is the key of the bucket, ARGV[1] the interval between requests
+// and ARGV[2] the burst. It returns whether the request is allowed, the
+// remaining requests, and the retry after and reset durations, in microseconds.
+const redisRateLimitScript = `
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000000 + tonumber(time[2])
+local interval = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local tat = math.max(tonumber(redis.call('GET', KEYS[1]) or now), now)
+local new_tat = tat + interval
+local allow_at = new_tat - burst * interval
+if now < allow_at then
+  return {0, 0, allow_at - now, tat - now}
+end
+-- numbers are formatted explicitly, as they convert with 14 significant digits
+redis.call('SET', KEYS[1], string.format('%.0f', new_tat), 'PX', string.format('%.0f', math.ceil((new_tat - now) / 1000)))
+return {1, math.floor((now - allow_at) / interval), 0, new_tat - now}
+`
+
+var redisRateLimitScriptSHA = func() string {
+	sum := sha1.Sum([]byte(redisRateLimitScript))
+	return hex.EncodeToString(sum[:])
+}()
+
+// RedisRateLimitStore keeps buckets in Redis, so that they are shared by
+// proxy replicas. While Redis is unavailable, tokens are taken from
+// Fallback if it's set.
+type RedisRateLimitStore struct {
+	Client *RedisClient
+	// Prefix is prepended to keys, e.g. "rate_limit:/api:".
+	Prefix   string
+	Fallback RateLimitStore
+	// Backoff is the time Redis isn't tried for after it failed, so requests
+	// don't wait for its timeout while it's down. Defaults to 1s.
+	Backoff time.Duration
+	Logger  *slog.Logger
+
+	// unavailable is set while Redis fails, to log only when it changes
+	unavailable atomic.Bool
+	// retryAt is when Redis is tried again while unavailable, in Unix nanoseconds
+	retryAt atomic.Int64
+}
+
+// errRedisBackoff is returned without fallback while Redis isn't tried.
+var errRedisBackoff = errors.New("redis: unavailable, backing off")
+
+func (s *RedisRateLimitStore) Take(ctx context.Context, key string, limit RateLimit) (RateLimitResult, error) {
+	if s.unavailable.Load() && !s.retry() {
+		return s.fallback(ctx, key, limit, errRedisBackoff)
+	}
+	result, err := s.take(ctx, key, limit)
+	if err == nil || !redisUnavailable(err) {
+		if s.unavailable.CompareAndSwap(true, false) {
+			loggerOrDefault(s.Logger).InfoContext(ctx, "Redis is available again", "address", s.Client.Address)
+		}
+		return result, err
+	}
+	s.retryAt.Store(time.Now().Add(s.backoff()).UnixNano())
+	if s.unavailable.CompareAndSwap(false, true) {
+		loggerOrDefault(s.Logger).WarnContext(ctx, "Redis is unavailable", "address", s.Client.Address,
+			"fallback", s.Fallback != nil, "error", err)
+	}
+	return s.fallback(ctx, key, limit, err)
+}
+
+// retry tells whether to try Redis again while it's unavailable, which a
+// single request does once the backoff has passed.
+func (s *RedisRateLimitStore) retry() bool {
+	retryAt := s.retryAt.Load()
+	now := time.Now()
+	return now.UnixNano() >= retryAt && s.retryAt.CompareAndSwap(retryAt, now.Add(s.backoff()).UnixNano())
+}
+
+func (s *RedisRateLimitStore) backoff() time.Duration {
+	if s.Backoff <= 0 {
+		return time.Second
+	}
+	return s.Backoff
+}
+
+func (s *RedisRateLimitStore) fallback(ctx context.Context, key string, limit RateLimit, err error) (RateLimitResult, error) {
+	if s.Fallback == nil {
+		return RateLimitResult{}, err
+	}
+	return s.Fallback.Take(ctx, key, limit)
+}
+
+func (s *RedisRateLimitStore) take(ctx context.Context, key string, limit RateLimit) (RateLimitResult, error) {
+	args := []string{redisRateLimitScriptSHA, "1", s.Prefix + key,
+		strconv.FormatInt(limit.interval().Microseconds(), 10), strconv.Itoa(limit.Burst)}
+	reply, err := s.Client.Do(ctx, append([]string{"EVALSHA"}, args...)...)
+	var redisErr RedisError
+	if errors.As(err, &redisErr) && strings.HasPrefix(string(redisErr), "NOSCRIPT") {
+		// the script is cached by the server once it has been evaluated
+		args[0] = redisRateLimitScript
+		reply, err = s.Client.Do(ctx, append([]string{"EVAL"}, args...)...)
+	}
+	if err != nil {
+		return RateLimitResult{}, err
+	}
+
+	values, _ := reply.([]any)
+	if len(values) != 4 {
+		return RateLimitResult{}, fmt.Errorf("redis: unexpected rate limit reply %v", reply)
+	}
+	var numbers [4]int64
+	for i, value := range values {
+		number, ok := value.(int64)
+		if !ok {
+			return RateLimitResult{}, fmt.Errorf("redis: unexpected rate limit reply %v", reply)
+		}
+		numbers[i] = number
+	}
+	return RateLimitResult{
+		Allowed:    numbers[0] == 1,
+		Remaining:  int(numbers[1]),
+		RetryAfter: time.Duration(numbers[2]) * time.Microsecond,
+		Reset:      time.Duration(numbers[3]) * time.Microsecond,
+	}, nil
+}
+
+func (s *RedisRateLimitStore) Close() error {
+	return s.Client.Close()
+}
@@ -0,0 +1,57 @@
+//go:build redis
+
+package proxy
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestRedisRateLimitStore_script runs the rate limit script on a real Redis
+// server, as fakeRedis only mimics it. Run it with a server listening on
+// REDIS_ADDR, localhost:6379 by default:
+//
+//	go test -tags redis -run TestRedisRateLimitStore_script ./internal/proxy
+func TestRedisRateLimitStore_script(t *testing.T) {
+	address := os.Getenv("REDIS_ADDR")
+	if address == "" {
+		address = "localhost:6379"
+	}
+	client := NewRedisClient(address)
+	store := &RedisRateLimitStore{Client: client, Prefix: "proxy_test:" + strconv.FormatInt(time.Now().UnixNano(), 10) + ":"}
+	defer store.Close()
+	ctx := context.Background()
+	_, err := client.Do(ctx, "SCRIPT", "FLUSH")
+	require.NoError(t, err, "Redis must be listening on %s", address)
+	// a long period keeps the results exact, although the clock advances
+	limit := RateLimit{Requests: 2, Period: time.Hour, Burst: 3}
+	take := func(key string) RateLimitResult {
+		result, err := store.Take(ctx, key, limit)
+		require.NoError(t, err)
+		return result
+	}
+
+	results := []RateLimitResult{take("a"), take("a"), take("a"), take("a")}
+
+	assert.Equal(t, []bool{true, true, true, false},
+		[]bool{results[0].Allowed, results[1].Allowed, results[2].Allowed, results[3].Allowed})
+	assert.Equal(t, []int{2, 1, 0, 0},
+		[]int{results[0].Remaining, results[1].Remaining, results[2].Remaining, results[3].Remaining})
+	assert.InDelta(t, float64(30*time.Minute), float64(results[0].Reset), float64(time.Second))
+	assert.InDelta(t, float64(90*time.Minute), float64(results[2].Reset), float64(time.Second))
+	assert.InDelta(t, float64(30*time.Minute), float64(results[3].RetryAfter), float64(time.Second))
+	assert.True(t, take("b").Allowed, "keys have their own bucket")
+
+	ttl, err := client.Do(ctx, "PTTL", store.Prefix+"a")
+	require.NoError(t, err)
+	assert.InDelta(t, (90 * time.Minute).Milliseconds(), ttl, float64(time.Second.Milliseconds()),
+		"the key expires once the bucket is full again")
+	_, err = client.Do(ctx, "DEL", store.Prefix+"a", store.Prefix+"b")
+	require.NoError(t, err)
+}
@@ -0,0 +1,412 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeRedis is an in-process stand-in for a Redis server. It understands
+// the commands used by RedisClient and runs the rate limit script natively.
+type fakeRedis struct {
+	listener net.Listener
+	password string
+
+	mu    sync.Mutex
+	conns []net.Conn
+	// scriptError is replied to scripts instead of running them, if it's set
+	scriptError string
+	now         time.Time
+	values      map[string]string
+	scripts     map[string]string
+	commands    []string
+}
+
+func newFakeRedis(t *testing.T, password string) *fakeRedis {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	r := &fakeRedis{
+		listener: listener,
+		password: password,
+		now:      time.Unix(1700000000, 0),
+		values:   make(map[string]string),
+		scripts:  make(map[string]string),
+	}
+	go r.serve()
+	t.Cleanup(r.Close)
+	return r
+}
+
+func (r *fakeRedis) Addr() string {
+	return r.listener.Addr().String()
+}
+
+// Close stops the server, closing its connections.
+func (r *fakeRedis) Close() {
+	_ = r.listener.Close()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, conn := range r.conns {
+		_ = conn.Close()
+	}
+}
+
+func (r *fakeRedis) failScripts(reply string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.scriptError = reply
+}
+
+func (r *fakeRedis) advance(d time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.now = r.now.Add(d)
+}
+
+// Value returns the value of a key, if it's set.
+func (r *fakeRedis) Value(key string) (string, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	value, ok := r.values[key]
+	return value, ok
+}
+
+// Commands returns the names of the commands received.
+func (r *fakeRedis) Commands() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.commands...)
+}
+
+func (r *fakeRedis) serve() {
+	for {
+		conn, err := r.listener.Accept()
+		if err != nil {
+			return
+		}
+		r.mu.Lock()
+		r.conns = append(r.conns, conn)
+		r.mu.Unlock()
+		go r.serveConn(conn)
+	}
+}
+
+func (r *fakeRedis) serveConn(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	authenticated := r.password == ""
+	for {
+		command, err := readRedisReply(reader)
+		if err != nil {
+			return
+		}
+		elements, _ := command.([]any)
+		args := make([]string, len(elements))
+		for i, element := range elements {
+			args[i], _ = element.(string)
+		}
+		if len(args) == 0 {
+			return
+		}
+		name := strings.ToUpper(args[0])
+		var reply string
+		switch {
+		case name == "AUTH":
+			authenticated = args[1] == r.password
+			reply = "+OK\r\n"
+			if !authenticated {
+				reply = "-WRONGPASS invalid password\r\n"
+			}
+		case !authenticated:
+			reply = "-NOAUTH Authentication required.\r\n"
+		case name == "SELECT":
+			reply = "+OK\r\n"
+		case name == "EVAL" || name == "EVALSHA":
+			reply = r.eval(name, args[1:])
+		default:
+			reply = "-ERR unknown command '" + args[0] + "'\r\n"
+		}
+		r.mu.Lock()
+		r.commands = append(r.commands, name)
+		r.mu.Unlock()
+		if _, err := conn.Write([]byte(reply)); err != nil {
+			return
+		}
+	}
+}
+
+func (r *fakeRedis) eval(name string, args []string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	script, sha := args[0], args[0]
+	if name == "EVAL" {
+		sum := sha1.Sum([]byte(script))
+		sha = hex.EncodeToString(sum[:])
+		r.scripts[sha] = script
+	} else if script = r.scripts[sha]; script == "" {
+		return "-NOSCRIPT No matching script. Please use EVAL.\r\n"
+	}
+	if script != redisRateLimitScript {
+		return "-ERR unknown script\r\n"
+	}
+	if r.scriptError != "" {
+		return "-" + r.scriptError + "\r\n"
+	}
+
+	// the rate limit script, ignoring expiry which only frees memory
+	key := args[2]
+	interval, _ := strconv.ParseFloat(args[3], 64)
+	burst, _ := strconv.ParseFloat(args[4], 64)
+	now := float64(r.now.UnixMicro())
+	tat := now
+	if value, ok := r.values[key]; ok {
+		tat, _ = strconv.ParseFloat(value, 64)
+		tat = math.Max(tat, now)
+	}
+	newTAT := tat + interval
+	allowAt := newTAT - burst*interval
+	if now < allowAt {
+		return string(encodeRedisIntegers(0, 0, allowAt-now, tat-now))
+	}
+	r.values[key] = strconv.FormatFloat(newTAT, 'f', -1, 64)
+	return string(encodeRedisIntegers(1, math.Floor((now-allowAt)/interval), 0, newTAT-now))
+}
+
+// encodeRedisIntegers encodes an array of integers, as Lua numbers are returned.
+func encodeRedisIntegers(numbers ...float64) []byte {
+	buf := []byte("*" + strconv.Itoa(len(numbers)) + "\r\n")
+	for _, number := range numbers {
+		buf = append(buf, ":"+strconv.FormatInt(int64(number), 10)+"\r\n"...)
+	}
+	return buf
+}
+
+func TestReadRedisReply(t *testing.T) {
+	tests := []struct {
+		name     string
+		reply    string
+		expected any
+	}{
+		{"simple string", "+OK\r\n", "OK"},
+		{"error", "-ERR failed\r\n", RedisError("ERR failed")},
+		{"integer", ":-42\r\n", int64(-42)},
+		{"bulk string", "$5\r\nhe\r\no\r\n", "he\r\no"},
+		{"null bulk string", "$-1\r\n", nil},
+		{"array", "*3\r\n:1\r\n$1\r\na\r\n*-1\r\n", []any{int64(1), "a", nil}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reply, err := readRedisReply(bufio.NewReader(strings.NewReader(tt.reply)))
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, reply)
+		})
+	}
+
+	t.Run("invalid reply", func(t *testing.T) {
+		_, err := readRedisReply(bufio.NewReader(strings.NewReader("?\r\n")))
+
+		assert.EqualError(t, err, `redis: invalid reply "?"`)
+	})
+}
+
+func TestRedisRateLimitStore(t *testing.T) {
+	limit := RateLimit{Requests: 2, Period: time.Second, Burst: 3}
+	take := func(t *testing.T, store *RedisRateLimitStore, key string) RateLimitResult {
+		result, err := store.Take(context.Background(), key, limit)
+		require.NoError(t, err)
+		return result
+	}
+
+	t.Run("shares buckets between replicas", func(t *testing.T) {
+		redis := newFakeRedis(t, "")
+		replicas := []*RedisRateLimitStore{
+			{Client: NewRedisClient(redis.Addr()), Prefix: "test:"},
+			{Client: NewRedisClient(redis.Addr()), Prefix: "test:"},
+		}
+		defer replicas[0].Close()
+		defer replicas[1].Close()
+
+		assert.Equal(t, RateLimitResult{Allowed: true, Remaining: 2, Reset: 500 * time.Millisecond}, take(t, replicas[0], "a"))
+		take(t, replicas[1], "a")
+		assert.Equal(t, RateLimitResult{Allowed: true, Remaining: 0, Reset: 1500 * time.Millisecond}, take(t, replicas[0], "a"))
+		assert.Equal(t, RateLimitResult{RetryAfter: 500 * time.Millisecond, Reset: 1500 * time.Millisecond}, take(t, replicas[1], "a"))
+		assert.True(t, take(t, replicas[1], "b").Allowed)
+		redis.advance(500 * time.Millisecond)
+		assert.True(t, take(t, replicas[1], "a").Allowed)
+
+		assert.Equal(t, []string{"EVALSHA", "EVAL", "EVALSHA", "EVALSHA", "EVALSHA", "EVALSHA", "EVALSHA"}, redis.Commands())
+		_, ok := redis.Value("test:a")
+		assert.True(t, ok, "keys are prefixed")
+	})
+
+	t.Run("authenticates and selects the database", func(t *testing.T) {
+		redis := newFakeRedis(t, "secret")
+		client := NewRedisClient(redis.Addr())
+		client.Password = "secret"
+		client.DB = 2
+		store := &RedisRateLimitStore{Client: client}
+		defer store.Close()
+
+		take(t, store, "a")
+		take(t, store, "a")
+
+		assert.Equal(t, []string{"AUTH", "SELECT", "EVALSHA", "EVAL", "EVALSHA"}, redis.Commands(), "the connection is reused")
+	})
+
+	t.Run("reports error replies", func(t *testing.T) {
+		redis := newFakeRedis(t, "secret")
+		store := &RedisRateLimitStore{Client: NewRedisClient(redis.Addr()), Fallback: NewMemoryRateLimitStore(10)}
+		defer store.Close()
+
+		_, err := store.Take(context.Background(), "a", limit)
+
+		assert.EqualError(t, err, "redis: NOAUTH Authentication required.")
+	})
+
+	t.Run("falls back while Redis is unavailable", func(t *testing.T) {
+		redis := newFakeRedis(t, "")
+		var buf bytes.Buffer
+		store := &RedisRateLimitStore{
+			Client:   NewRedisClient(redis.Addr()),
+			Fallback: NewMemoryRateLimitStore(10),
+			Logger:   slog.New(slog.NewJSONHandler(&buf, nil)),
+		}
+		defer store.Close()
+		take(t, store, "a")
+		redis.Close()
+
+		results := []bool{take(t, store, "a").Allowed, take(t, store, "a").Allowed, take(t, store, "a").Allowed, take(t, store, "a").Allowed}
+
+		assert.Equal(t, []bool{true, true, true, false}, results, "the local bucket is full at first")
+		records := logRecords(t, &buf)
+		require.Len(t, records, 1)
+		assert.Equal(t, "Redis is unavailable", records[0]["msg"])
+	})
+
+	t.Run("falls back while Redis can't serve commands", func(t *testing.T) {
+		for _, reply := range []string{"LOADING Redis is loading the dataset in memory", "READONLY You can't write against a read only replica.", "MASTERDOWN Link with MASTER is down"} {
+			redis := newFakeRedis(t, "")
+			redis.failScripts(reply)
+			store := &RedisRateLimitStore{Client: NewRedisClient(redis.Addr()), Fallback: NewMemoryRateLimitStore(10),
+				Logger: slog.New(slog.NewJSONHandler(&bytes.Buffer{}, nil))}
+
+			assert.True(t, take(t, store, "a").Allowed, reply)
+			require.NoError(t, store.Close())
+		}
+	})
+
+	t.Run("backs off after connection setup errors", func(t *testing.T) {
+		redis := newFakeRedis(t, "secret")
+		client := NewRedisClient(redis.Addr())
+		client.Password = "wrong"
+		var buf bytes.Buffer
+		store := &RedisRateLimitStore{Client: client, Fallback: NewMemoryRateLimitStore(10), Backoff: 50 * time.Millisecond,
+			Logger: slog.New(slog.NewJSONHandler(&buf, nil))}
+		defer store.Close()
+
+		take(t, store, "a")
+		take(t, store, "a")
+		assert.Equal(t, []string{"AUTH"}, redis.Commands(), "Redis isn't tried while backing off")
+		time.Sleep(60 * time.Millisecond)
+		take(t, store, "a")
+
+		assert.Equal(t, []string{"AUTH", "AUTH"}, redis.Commands())
+		records := logRecords(t, &buf)
+		require.Len(t, records, 1)
+		assert.Equal(t, "redis: AUTH failed: redis: WRONGPASS invalid password", records[0]["error"])
+	})
+
+	t.Run("fails without fallback", func(t *testing.T) {
+		redis := newFakeRedis(t, "")
+		redis.Close()
+		store := &RedisRateLimitStore{Client: NewRedisClient(redis.Addr()), Logger: slog.New(slog.NewJSONHandler(&bytes.Buffer{}, nil))}
+
+		_, err := store.Take(context.Background(), "a", limit)
+
+		assert.ErrorContains(t, err, "connection refused")
+	})
+}
+
+func TestConfig_rateLimitRedis(t *testing.T) {
+	t.Run("limits across routers", func(t *testing.T) {
+		redis := newFakeRedis(t, "")
+		// language=JSON
+		config, err := ReadConfigFromString(`{"routes": [{"matcher": {"path": "/shared"}, "handler": {"rate_limit": {
+			"requests": 1, "period": "1m", "key": "global", "redis": {"address": "` + redis.Addr() + `"},
+			"handler": {"static": {"message": "OK"}}
+		}}}]}`)
+		require.NoError(t, err)
+		var codes []int
+		for range 2 {
+			router, err := config.CreateRouter()
+			require.NoError(t, err)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest("GET", "/shared", nil))
+			codes = append(codes, w.Code)
+			require.NoError(t, router.Close())
+		}
+
+		assert.Equal(t, []int{http.StatusOK, http.StatusTooManyRequests}, codes)
+		_, ok := redis.Value("rate_limit:/shared:")
+		assert.True(t, ok, "the prefix defaults to the route")
+		assert.Equal(t, "rate_limit(requests=1, period=1m, key=global, store=redis) > static", config.Routes[0].Handler.Describe())
+	})
+
+	t.Run("keeps buckets of rate limiters apart", func(t *testing.T) {
+		redis := newFakeRedis(t, "")
+		redisConfig := `"redis": {"address": "` + redis.Addr() + `"}`
+		// language=JSON
+		config, err := ReadConfigFromString(`{
+			"handlers": {"limited": {"rate_limit": {"requests": 1, "period": "1m", "key": "global", ` + redisConfig + `, "handler": {"echo": {}}}}},
+			"routes": [
+				{"matcher": {"path": "/a"}, "handler": {"rate_limit": {"requests": 1, "period": "1m", "key": "global", ` + redisConfig + `, "handler": {"echo": {}}}}},
+				{"matcher": {"path": "/b"}, "handler": {"retrier": {"handler": {"rate_limit": {"requests": 1, "period": "1m", "key": "global", ` + redisConfig + `, "handler": {"echo": {}}}}}}},
+				{"matcher": {"path": "/c"}, "handler": {"ref": "limited"}}
+			]}`)
+		require.NoError(t, err)
+		router, err := config.CreateRouter()
+		require.NoError(t, err)
+		defer router.Close()
+
+		var codes []int
+		for _, path := range []string{"/a", "/b", "/c"} {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+			codes = append(codes, w.Code)
+		}
+
+		assert.Equal(t, []int{http.StatusOK, http.StatusOK, http.StatusOK}, codes)
+		for _, key := range []string{"rate_limit:/a:", "rate_limit:/b.handler.retrier:", "rate_limit:handlers.limited:"} {
+			_, ok := redis.Value(key)
+			assert.True(t, ok, "expected key %q", key)
+		}
+	})
+
+	t.Run("rejects invalid settings", func(t *testing.T) {
+		config, err := ReadConfigFromString(`{"routes": [{"matcher": {"path": "/"}, "handler": {"rate_limit": {
+			"requests": 1, "redis": {"db": -1, "timeout": "soon", "fallback": "deny"}, "handler": {"debug": {}}
+		}}}]}`)
+		require.NoError(t, err)
+
+		assert.EqualError(t, config.Validate(), "routes[0].handler.rate_limit: no redis address set\n"+
+			"routes[0].handler.rate_limit: redis db must not be negative, got -1\n"+
+			`routes[0].handler.rate_limit: invalid redis timeout: time: invalid duration "soon"`+"\n"+
+			`routes[0].handler.rate_limit: unknown redis fallback "deny", expected local or allow`)
+	})
+}
@@ -0,0 +1,369 @@
+package proxy
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ReloadableRouter serves requests using a router which can be replaced at
+// runtime. A replaced router keeps serving the requests it already accepted,
+// and is closed once all of them have completed.
+type ReloadableRouter struct {
+	// Logger logs errors closing replaced routers.
+	Logger *slog.Logger
+
+	current atomic.Pointer[routerGeneration]
+	// closed is set by Close, after which requests aren't served anymore
+	closed atomic.Bool
+
+	// disabled holds the patterns of disabled routes, which outlive reloads
+	disabledMu sync.RWMutex
+	disabled   map[string]bool
+}
+
+func NewReloadableRouter(router *PathRouter) *ReloadableRouter {
+	rr := &ReloadableRouter{}
+	rr.current.Store(newRouterGeneration(router))
+	return rr
+}
+
+// Router returns the router currently serving new requests.
+func (rr *ReloadableRouter) Router() *PathRouter {
+	return rr.current.Load().router
+}
+
+func (rr *ReloadableRouter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	for {
+		gen := rr.current.Load()
+		// a generation which started draining has already been replaced, so
+		// loading the current one again yields its successor
+		if gen.acquire() {
+			defer gen.release()
+			if rr.disabledRoute(gen.router, r) {
+				http.Error(w, "Route disabled", http.StatusServiceUnavailable)
+				return
+			}
+			gen.router.ServeHTTP(w, r)
+			return
+		}
+		if rr.closed.Load() {
+			http.Error(w, "Server is shutting down", http.StatusServiceUnavailable)
+			return
+		}
+	}
+}
+
+// SetRouteDisabled disables or enables the route with the given pattern.
+// Disabled routes respond with 503 Service Unavailable, also after a reload.
+func (rr *ReloadableRouter) SetRouteDisabled(pattern string, disabled bool) error {
+	if _, ok := rr.Router().Routes[pattern]; !ok {
+		return fmt.Errorf("unknown route %s", pattern)
+	}
+	rr.disabledMu.Lock()
+	defer rr.disabledMu.Unlock()
+	if rr.disabled == nil {
+		rr.disabled = make(map[string]bool)
+	}
+	if disabled {
+		rr.disabled[pattern] = true
+	} else {
+		delete(rr.disabled, pattern)
+	}
+	return nil
+}
+
+// RouteDisabled tells whether the route with the given pattern is disabled.
+func (rr *ReloadableRouter) RouteDisabled(pattern string) bool {
+	rr.disabledMu.RLock()
+	defer rr.disabledMu.RUnlock()
+	return rr.disabled[pattern]
+}
+
+func (rr *ReloadableRouter) disabledRoute(router *PathRouter, r *http.Request) bool {
+	rr.disabledMu.RLock()
+	defer rr.disabledMu.RUnlock()
+	if len(rr.disabled) == 0 {
+		return false
+	}
+	return rr.disabled[router.pattern(r)]
+}
+
+// Swap replaces the router serving new requests. It returns a channel which
+// is closed once the previous router drained and was closed. After Close,
+// the router is closed right away instead.
+func (rr *ReloadableRouter) Swap(router *PathRouter) <-chan struct{} {
+	gen := newRouterGeneration(router)
+	old := rr.current.Swap(gen)
+	if rr.closed.Load() {
+		gen.retire(rr.Logger)
+	}
+	return old.retire(rr.Logger)
+}
+
+// Close drains and closes the current router. It blocks until in-flight
+// requests complete, later requests are answered with 503 Service Unavailable.
+func (rr *ReloadableRouter) Close() error {
+	rr.closed.Store(true)
+	<-rr.current.Load().retire(rr.Logger)
+	return nil
+}
+
+type routerGeneration struct {
+	router *PathRouter
+
+	mu       sync.Mutex
+	active   int
+	draining bool
+	drained  chan struct{}
+	// logger logs the error of closing the router once it drained
+	logger *slog.Logger
+}
+
+func newRouterGeneration(router *PathRouter) *routerGeneration {
+	return &routerGeneration{
+		router:  router,
+		drained: make(chan struct{}),
+	}
+}
+
+func (g *routerGeneration) acquire() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.draining {
+		return false
+	}
+	g.active++
+	return true
+}
+
+func (g *routerGeneration) release() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.active--
+	if g.draining && g.active == 0 {
+		g.close()
+	}
+}
+
+func (g *routerGeneration) retire(logger *slog.Logger) <-chan struct{} {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if !g.draining {
+		g.draining = true
+		g.logger = logger
+		if g.active == 0 {
+			g.close()
+		}
+	}
+	return g.drained
+}
+
+// close is called with mu held, exactly once after draining completes.
+func (g *routerGeneration) close() {
+	logger := loggerOrDefault(g.logger)
+	go func() {
+		if err := g.router.Close(); err != nil {
+			logger.Error("Error closing router", "error", err)
+		}
+		close(g.drained)
+	}()
+}
+
+// ConfigReloader reloads the routes of a ReloadableRouter from a config file.
+// Only the routes are reloaded, other sections require a restart.
+type ConfigReloader struct {
+	Path   string
+	Router *ReloadableRouter
+	Logger *slog.Logger
+
+	mu     sync.Mutex
+	config *Config
+	// started is the config read on start, whose sections besides the routes
+	// are still in effect
+	started *Config
+	// files holds the content of the config file and the files it includes
+	files map[string][]byte
+	// globs are the include patterns, matched again on every poll
+	globs []string
+}
+
+// NewConfigReloader creates a reloader for the config file at path, from which
+// the currently applied config was read by ReadConfigFile.
+func NewConfigReloader(path string, config *Config, router *ReloadableRouter) *ConfigReloader {
+	return &ConfigReloader{
+		Path:    path,
+		Router:  router,
+		Logger:  config.logger(LogReload),
+		config:  config,
+		started: config,
+		files:   config.files,
+		globs:   config.globs,
+	}
+}
+
+// Config returns the currently applied config.
+func (c *ConfigReloader) Config() *Config {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.config
+}
+
+// Running returns the config the proxy runs with: the routes and handlers of
+// the currently applied config, and the sections requiring a restart as they
+// were on start.
+func (c *ConfigReloader) Running() *Config {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	running := *c.config
+	running.Server = c.started.Server
+	running.TCP = c.started.TCP
+	running.UDP = c.started.UDP
+	running.SOCKS5 = c.started.SOCKS5
+	running.Admin = c.started.Admin
+	running.Tracing = c.started.Tracing
+	running.AccessLog = c.started.AccessLog
+	running.Log = c.started.Log
+	running.RequestID = c.started.RequestID
+	running.secrets = slices.Concat(c.config.secrets, c.started.secrets)
+	return &running
+}
+
+// Reload reads and validates the config file and swaps in a router created
+// from it. On any error the current router is kept.
+func (c *ConfigReloader) Reload() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.reload()
+}
+
+func (c *ConfigReloader) reload() error {
+	config, err := ReadConfigFile(c.Path)
+	if err != nil {
+		return c.redactError(err)
+	}
+	// the log section requires a restart, like all sections besides routes
+	config.loggers = c.config.loggers
+	router, err := config.CreateRouter()
+	if err != nil {
+		return c.redactError(fmt.Errorf("failed to create router: %w", config.RedactError(err)))
+	}
+
+	c.config = config
+	c.files = config.files
+	c.globs = config.globs
+	removed := removedRoutes(c.Router.Router(), router)
+	drained := c.Router.Swap(router)
+	// requests of the previous router may still capture entries of removed
+	// routes and record their metrics until it drained
+	go func() {
+		<-drained
+		// a later reload may have added them again meanwhile
+		current := c.Router.Router()
+		removed = slices.DeleteFunc(removed, func(pattern string) bool {
+			_, ok := current.Routes[pattern]
+			return ok
+		})
+		DefaultCaptures.dropRoutes(removed)
+		DefaultMetrics.deleteSeries("route", removed)
+	}()
+	loggerOrDefault(c.Logger).Info("Reloaded config", "path", c.Path)
+	return nil
+}
+
+// removedRoutes returns the patterns of the routes of previous missing in router.
+func removedRoutes(previous, router *PathRouter) []string {
+	var removed []string
+	for pattern := range previous.Routes {
+		if _, ok := router.Routes[pattern]; !ok {
+			removed = append(removed, pattern)
+		}
+	}
+	return removed
+}
+
+// redactError redacts the secrets of the applied config and of the config on
+// start in err, as errors of a new config may quote values they share.
+func (c *ConfigReloader) redactError(err error) error {
+	return c.started.RedactError(c.config.RedactError(err))
+}
+
+// Watch polls the config file and the files it includes every interval and
+// reloads them when their content changes or include patterns match other
+// files, until stop is closed. Failed
+// reloads are logged and retried on the next change.
+func (c *ConfigReloader) Watch(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		if err := c.reloadIfChanged(); err != nil {
+			loggerOrDefault(c.Logger).Error("Config reload failed, keeping current routes", "path", c.Path, "error", err)
+		}
+	}
+}
+
+func (c *ConfigReloader) reloadIfChanged() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	paths := slices.Collect(maps.Keys(c.files))
+	for _, pattern := range c.globs {
+		// files newly matching an include pattern are missing in c.files
+		matches, _ := filepath.Glob(pattern)
+		paths = append(paths, matches...)
+	}
+	files := readFiles(paths)
+	if maps.EqualFunc(files, c.files, bytes.Equal) {
+		return nil
+	}
+	err := c.reload()
+	if err != nil {
+		// remember the broken content, so it's reported only once
+		c.files = files
+	}
+	return err
+}
+
+// readFiles reads the content of files, missing or unreadable files have no content.
+func readFiles(paths []string) map[string][]byte {
+	files := make(map[string][]byte, len(paths))
+	for _, path := range paths {
+		content, _ := os.ReadFile(path)
+		files[path] = content
+	}
+	return files
+}
+
+// closeHandler releases resources held by a handler, like idle upstream
+// connections or health checkers, if it holds any.
+func closeHandler(h Handler) error {
+	if closer, ok := h.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+func closeHandlers(handlers []Handler) error {
+	var errs []error
+	for _, h := range handlers {
+		if err := closeHandler(h); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
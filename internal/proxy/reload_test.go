@@ -0,0 +1,209 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReloadableRouter(t *testing.T) {
+	t.Run("serves new requests with swapped router", func(t *testing.T) {
+		rr := NewReloadableRouter(newStaticRouter("/hello", "old"))
+
+		drained := rr.Swap(newStaticRouter("/hello", "new"))
+
+		w := httptest.NewRecorder()
+		rr.ServeHTTP(w, newGetRequest("/hello"))
+		assert.Equal(t, "new", w.Body.String())
+		assertClosed(t, drained, "Expected idle router to be drained immediately")
+	})
+
+	t.Run("drains in-flight requests before closing old router", func(t *testing.T) {
+		blocking := &BlockingHandler{started: make(chan struct{}), release: make(chan struct{})}
+		oldRouter := NewPathRouter()
+		oldRouter.AddRoute("/slow", blocking)
+		rr := NewReloadableRouter(oldRouter)
+
+		w := httptest.NewRecorder()
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			rr.ServeHTTP(w, newGetRequest("/slow"))
+		}()
+		<-blocking.started
+
+		drained := rr.Swap(newStaticRouter("/slow", "new"))
+		select {
+		case <-drained:
+			t.Fatal("Expected old router to wait for in-flight request")
+		case <-time.After(50 * time.Millisecond):
+		}
+		assert.False(t, blocking.closed.Load(), "Expected handler to stay open while serving")
+
+		close(blocking.release)
+		<-done
+		assertClosed(t, drained, "Expected old router to drain after request completed")
+		assert.True(t, blocking.closed.Load(), "Expected old handler to be closed")
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("rejects requests after close", func(t *testing.T) {
+		rr := NewReloadableRouter(newStaticRouter("/hello", "old"))
+		require.NoError(t, rr.Close())
+
+		w := httptest.NewRecorder()
+		rr.ServeHTTP(w, newGetRequest("/hello"))
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+
+		assertClosed(t, rr.Swap(newStaticRouter("/hello", "new")), "Expected replaced router to be closed")
+		w = httptest.NewRecorder()
+		rr.ServeHTTP(w, newGetRequest("/hello"))
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code, "Expected routers swapped in after close not to serve")
+	})
+}
+
+func TestConfigReloader(t *testing.T) {
+	// language=JSON
+	oldConfig := `{"routes": [{"matcher": {"path": "/hello"}, "handler": {"static": {"message": "old"}}}]}`
+	// language=JSON
+	newConfig := `{"routes": [{"matcher": {"path": "/hello"}, "handler": {"static": {"message": "new"}}}]}`
+
+	t.Run("reloads valid config", func(t *testing.T) {
+		path := writeConfigFile(t, oldConfig)
+		rr := NewReloadableRouter(newStaticRouter("/hello", "old"))
+		reloader := NewConfigReloader(path, mustReadConfigFile(t, path), rr)
+
+		require.NoError(t, os.WriteFile(path, []byte(newConfig), 0o644))
+		require.NoError(t, reloader.Reload())
+
+		w := httptest.NewRecorder()
+		rr.ServeHTTP(w, newGetRequest("/hello"))
+		assert.Equal(t, "new", w.Body.String())
+	})
+
+	t.Run("keeps current router on invalid config", func(t *testing.T) {
+		path := writeConfigFile(t, oldConfig)
+		rr := NewReloadableRouter(newStaticRouter("/hello", "old"))
+		reloader := NewConfigReloader(path, mustReadConfigFile(t, path), rr)
+
+		// language=JSON
+		invalidConfig := `{"routes": [{"matcher": {"path": "/hello"}, "handler": {}}]}`
+		require.NoError(t, os.WriteFile(path, []byte(invalidConfig), 0o644))
+		err := reloader.Reload()
+		assert.ErrorContains(t, err, "no handler set")
+
+		w := httptest.NewRecorder()
+		rr.ServeHTTP(w, newGetRequest("/hello"))
+		assert.Equal(t, "old", w.Body.String())
+	})
+
+	t.Run("reloads only when file content changes", func(t *testing.T) {
+		path := writeConfigFile(t, oldConfig)
+		rr := NewReloadableRouter(newStaticRouter("/hello", "old"))
+		reloader := NewConfigReloader(path, mustReadConfigFile(t, path), rr)
+		router := rr.Router()
+
+		require.NoError(t, reloader.reloadIfChanged())
+		assert.Same(t, router, rr.Router(), "Expected unchanged config to keep router")
+
+		require.NoError(t, os.WriteFile(path, []byte(newConfig), 0o644))
+		require.NoError(t, reloader.reloadIfChanged())
+		assert.NotSame(t, router, rr.Router(), "Expected changed config to swap router")
+	})
+
+	t.Run("reloads when an include pattern matches a new file", func(t *testing.T) {
+		dir := t.TempDir()
+		path := filepath.Join(dir, "config.json")
+		require.NoError(t, os.WriteFile(path, []byte(`{"include": ["routes/*.json"], "routes": []}`), 0o644))
+		require.NoError(t, os.Mkdir(filepath.Join(dir, "routes"), 0o755))
+		rr := NewReloadableRouter(NewPathRouter())
+		reloader := NewConfigReloader(path, mustReadConfigFile(t, path), rr)
+		router := rr.Router()
+
+		require.NoError(t, reloader.reloadIfChanged())
+		assert.Same(t, router, rr.Router(), "Expected unchanged config to keep router")
+
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "routes", "broken.json"), []byte(`{"routes": [{}]}`), 0o644))
+		assert.ErrorContains(t, reloader.reloadIfChanged(), "no handler set")
+		assert.NoError(t, reloader.reloadIfChanged(), "Expected a broken file to be reported once")
+
+		require.NoError(t, os.Remove(filepath.Join(dir, "routes", "broken.json")))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "routes", "hello.json"), []byte(newConfig), 0o644))
+		require.NoError(t, reloader.reloadIfChanged())
+
+		w := httptest.NewRecorder()
+		rr.ServeHTTP(w, newGetRequest("/hello"))
+		assert.Equal(t, "new", w.Body.String())
+	})
+
+	t.Run("reloads when an included file changes", func(t *testing.T) {
+		dir := t.TempDir()
+		path := filepath.Join(dir, "config.json")
+		includedPath := filepath.Join(dir, "routes.json")
+		require.NoError(t, os.WriteFile(path, []byte(`{"include": ["routes.json"]}`), 0o644))
+		require.NoError(t, os.WriteFile(includedPath, []byte(oldConfig), 0o644))
+		rr := NewReloadableRouter(newStaticRouter("/hello", "old"))
+		reloader := NewConfigReloader(path, mustReadConfigFile(t, path), rr)
+		router := rr.Router()
+
+		require.NoError(t, reloader.reloadIfChanged())
+		assert.Same(t, router, rr.Router(), "Expected unchanged config to keep router")
+
+		require.NoError(t, os.WriteFile(includedPath, []byte(newConfig), 0o644))
+		require.NoError(t, reloader.reloadIfChanged())
+
+		w := httptest.NewRecorder()
+		rr.ServeHTTP(w, newGetRequest("/hello"))
+		assert.Equal(t, "new", w.Body.String())
+	})
+}
+
+func newStaticRouter(path, message string) *PathRouter {
+	router := NewPathRouter()
+	router.AddRoute(path, &StaticHandler{message: message})
+	return router
+}
+
+func writeConfigFile(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "config.json")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	return path
+}
+
+func mustReadConfigFile(t *testing.T, path string) *Config {
+	config, err := ReadConfigFile(path)
+	require.NoError(t, err)
+	return config
+}
+
+func assertClosed(t *testing.T, ch <-chan struct{}, msg string) {
+	select {
+	case <-ch:
+	case <-time.After(time.Second):
+		t.Fatal(msg)
+	}
+}
+
+type BlockingHandler struct {
+	started chan struct{}
+	release chan struct{}
+	closed  atomic.Bool
+}
+
+func (h *BlockingHandler) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	close(h.started)
+	<-h.release
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *BlockingHandler) Close() error {
+	h.closed.Store(true)
+	return nil
+}
@@ -0,0 +1,111 @@
+package proxy
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// DefaultRequestIDHeader is the header carrying request IDs unless configured otherwise.
+const DefaultRequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength limits incoming IDs, longer ones are replaced.
+const maxRequestIDLength = 128
+
+// RequestIDs assigns every request an ID, which is added to logs, the
+// response and requests forwarded upstream, to correlate them.
+type RequestIDs struct {
+	// Header carries the ID of incoming requests, responses and forwarded requests.
+	Header string
+	// Generate creates IDs for requests without a valid one, e.g. NewUUID.
+	Generate func() string
+}
+
+func NewRequestIDs(header string, generate func() string) *RequestIDs {
+	return &RequestIDs{Header: header, Generate: generate}
+}
+
+// Handler serves requests with next, keeping the ID sent by the client if
+// it's valid and generating one otherwise.
+func (ids *RequestIDs) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(ids.Header)
+		if !validRequestID(id) {
+			id = ids.Generate()
+		}
+		w.Header().Set(ids.Header, id)
+		ctx := context.WithValue(r.Context(), requestIDContextKey{}, requestID{header: ids.Header, id: id})
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// validRequestID reports whether an incoming ID can be kept, which rules out
+// IDs which are empty, overly long or contain control characters.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+type requestIDContextKey struct{}
+
+type requestID struct {
+	header string
+	id     string
+}
+
+// requestIDFromContext returns the ID of the request being served.
+func requestIDFromContext(ctx context.Context) string {
+	return requestIDValue(ctx).id
+}
+
+func requestIDValue(ctx context.Context) requestID {
+	value, _ := ctx.Value(requestIDContextKey{}).(requestID)
+	return value
+}
+
+// injectRequestID sets the ID of the request being served on the header of
+// a request sent upstream.
+func injectRequestID(ctx context.Context, header http.Header) {
+	if value := requestIDValue(ctx); value.id != "" {
+		header.Set(value.header, value.id)
+	}
+}
+
+// NewUUID generates a random UUID (version 4).
+func NewUUID() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40 // version 4
+	b[8] = b[8]&0x3f | 0x80 // variant 10
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+const crockfordBase32 = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// NewULID generates a ULID, which sorts by the time it was generated in.
+// See https://github.com/ulid/spec.
+func NewULID() string {
+	var b [16]byte
+	binary.BigEndian.PutUint64(b[:8], uint64(time.Now().UnixMilli())<<16)
+	_, _ = rand.Read(b[6:])
+
+	// 128 bits are encoded as 26 characters of 5 bits, the first one holding 3 bits
+	hi, lo := binary.BigEndian.Uint64(b[:8]), binary.BigEndian.Uint64(b[8:])
+	var s [26]byte
+	for i := 25; i >= 0; i-- {
+		s[i] = crockfordBase32[lo&0x1f]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(s[:])
+}
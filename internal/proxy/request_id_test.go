@@ -0,0 +1,147 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestIDs_Handler(t *testing.T) {
+	var seen string
+	next := http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		seen = requestIDFromContext(r.Context())
+	})
+	handler := NewRequestIDs("X-Correlation-ID", func() string { return "generated" }).Handler(next)
+
+	tests := []struct {
+		name     string
+		incoming string
+		expected string
+	}{
+		{"keeps the incoming ID", "client-id-1", "client-id-1"},
+		{"generates a missing ID", "", "generated"},
+		{"replaces an ID with spaces", "client id", "generated"},
+		{"replaces an overly long ID", strings.Repeat("a", 129), "generated"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			if tt.incoming != "" {
+				r.Header.Set("X-Correlation-ID", tt.incoming)
+			}
+			w := httptest.NewRecorder()
+
+			handler.ServeHTTP(w, r)
+
+			assert.Equal(t, tt.expected, seen)
+			assert.Equal(t, tt.expected, w.Header().Get("X-Correlation-ID"))
+		})
+	}
+}
+
+func TestRequestIDs_propagation(t *testing.T) {
+	var upstreamID string
+	upstream := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		upstreamID = r.Header.Get("X-Correlation-ID")
+	}))
+	defer upstream.Close()
+	forward, err := NewForwardHandler(upstream.URL)
+	require.NoError(t, err)
+
+	router := NewPathRouter()
+	router.AddRoute("/forward", forward)
+	router.AddRoute("/debug", &DebugHandler{})
+	var buf bytes.Buffer
+	handler := NewRequestIDs("X-Correlation-ID", func() string { return "generated" }).
+		Handler(NewAccessLog(JSONLogFormat, &buf).Handler(router))
+
+	t.Run("forwards the ID upstream", func(t *testing.T) {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/forward", nil))
+
+		assert.Equal(t, "generated", upstreamID)
+	})
+
+	t.Run("adds the ID to debug output and the access log", func(t *testing.T) {
+		buf.Reset()
+		r := httptest.NewRequest("GET", "/debug", nil)
+		r.Header.Set("X-Correlation-ID", "client-id-1")
+		w := httptest.NewRecorder()
+
+		handler.ServeHTTP(w, r)
+
+		var response debugResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		assert.Equal(t, "client-id-1", response.RequestID)
+		var entry map[string]any
+		require.NoError(t, json.Unmarshal(buf.Bytes(), &entry))
+		assert.Equal(t, "client-id-1", entry["request_id"])
+	})
+}
+
+func TestNewUUID(t *testing.T) {
+	uuid := NewUUID()
+
+	assert.Regexp(t, regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`), uuid)
+	assert.NotEqual(t, uuid, NewUUID())
+}
+
+func TestNewULID(t *testing.T) {
+	ulid := NewULID()
+
+	assert.Regexp(t, regexp.MustCompile(`^[0-7][0-9A-HJKMNP-TV-Z]{25}$`), ulid)
+	assert.NotEqual(t, ulid, NewULID())
+	t.Run("sorts by time", func(t *testing.T) {
+		earlier := ulid[:10]
+		later := NewULID()[:10]
+
+		assert.LessOrEqual(t, earlier, later)
+	})
+}
+
+func TestConfig_CreateRequestIDs(t *testing.T) {
+	tests := []struct {
+		name      string
+		requestID string
+		header    string
+		err       string
+	}{
+		{"defaults", `{}`, "X-Request-ID", ""},
+		{"ulid with custom header", `{"header": "X-Correlation-ID", "generator": "ulid"}`, "X-Correlation-ID", ""},
+		{"invalid header", `{"header": "X Request"}`, "", `request_id: invalid header "X Request"`},
+		{"unknown generator", `{"generator": "random"}`, "", `request_id: unknown generator "random", expected uuid or ulid`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := ReadConfigFromString(`{"routes": [], "request_id": ` + tt.requestID + `}`)
+			require.NoError(t, err)
+
+			requestIDs, err := config.CreateRequestIDs()
+
+			if tt.err != "" {
+				assert.EqualError(t, err, tt.err)
+				assert.EqualError(t, config.Validate(), tt.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.header, requestIDs.Header)
+			assert.NotEmpty(t, requestIDs.Generate())
+		})
+	}
+
+	t.Run("without section", func(t *testing.T) {
+		config, err := ReadConfigFromString(`{"routes": []}`)
+		require.NoError(t, err)
+
+		requestIDs, err := config.CreateRequestIDs()
+
+		require.NoError(t, err)
+		assert.Nil(t, requestIDs)
+	})
+}
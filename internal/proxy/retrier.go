@@ -12,14 +12,28 @@ func (h *RetrierHandler) ServeHTTP(
 	w http.ResponseWriter,
 	r *http.Request,
 ) {
-	var brw *BufferedResponseWriter
+	var brw *StreamingResponseWriter
+	route := routeFromContext(r.Context())
 	maxTries := h.Retries + 1
 	for try := 0; try < maxTries; try++ {
-		brw = NewBufferedResponseWriter()
-		h.Handler.ServeHTTP(brw, r)
+		retrierAttempts.Inc(route)
+		detailsFromContext(r.Context()).addAttempt()
+		ctx, span := startSpan(r.Context(), "retrier attempt", SpanKindInternal)
+		span.SetAttribute("retry.attempt", try)
+		brw = NewStreamingResponseWriter(w, nil)
+		h.Handler.ServeHTTP(brw, requestWithContext(r, ctx))
+		span.setStatusCode(brw.statusCode)
+		span.Finish()
+		// a streamed response is already written to the client and can't be retried
+		if brw.Streaming() {
+			return
+		}
 		if !h.RetryPolicy.shouldRetry(brw.statusCode, brw.Header()) {
 			break
 		}
+		if try == maxTries-1 {
+			retrierExhausted.Inc(route)
+		}
 	}
 	if brw == nil {
 		panic("this should never happen")
@@ -38,6 +52,10 @@ func (h *RetrierHandler) ServeHTTP(
 	return
 }
 
+func (h *RetrierHandler) Close() error {
+	return closeHandler(h.Handler)
+}
+
 type RetryPolicy interface {
 	shouldRetry(
 		statusCode int,
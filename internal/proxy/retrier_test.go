@@ -3,6 +3,7 @@ package proxy
 import (
 	"github.com/stretchr/testify/assert"
 	"net/http"
+	"net/http/httptest"
 	"testing"
 )
 
@@ -45,6 +46,45 @@ func TestRetrierHandler_ServeHTTP(t *testing.T) {
 		assert.Equal(t, 2, h.Handler.(*MockHandler).invocations, "Expected handler to be invoked twice")
 		assert.Equal(t, http.StatusInternalServerError, brw.statusCode, "Expected status code to be 500")
 	})
+	t.Run("should pass through streaming response without retrying", func(t *testing.T) {
+		h := &RetrierHandler{
+			Handler:     &StreamingMockHandler{statusCode: http.StatusInternalServerError, message: "data: error\n\n"},
+			RetryPolicy: &RetryOnNon2xxRetryPolicy{},
+			Retries:     1,
+		}
+
+		recorder := httptest.NewRecorder()
+
+		request, err := http.NewRequest("GET", "http://localhost:8080", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		h.ServeHTTP(recorder, request)
+
+		assert.Equal(t, 1, h.Handler.(*StreamingMockHandler).invocations, "Expected handler to be invoked once")
+		assert.Equal(t, http.StatusInternalServerError, recorder.Code, "Expected status code to be 500")
+		assert.Equal(t, "text/event-stream", recorder.Header().Get("Content-Type"))
+		assert.Equal(t, "data: error\n\n", recorder.Body.String())
+		assert.True(t, recorder.Flushed, "Expected response to be flushed")
+	})
+}
+
+type StreamingMockHandler struct {
+	statusCode  int
+	message     string
+	invocations int
+}
+
+func (h *StreamingMockHandler) ServeHTTP(
+	w http.ResponseWriter,
+	_ *http.Request,
+) {
+	h.invocations++
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.WriteHeader(h.statusCode)
+	_, _ = w.Write([]byte(h.message))
+	http.NewResponseController(w).Flush()
 }
 
 type MockHandler struct {
@@ -0,0 +1,105 @@
+package proxy
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// RotatingFile is a file which is rotated once it grows beyond MaxSize:
+// path is renamed to path.1, path.1 to path.2 and so on, keeping MaxBackups
+// rotated files.
+type RotatingFile struct {
+	Path       string
+	MaxSize    int64
+	MaxBackups int
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+// OpenRotatingFile opens the file at path for appending, creating it if needed.
+func OpenRotatingFile(path string, maxSize int64, maxBackups int) (*RotatingFile, error) {
+	f := &RotatingFile{Path: path, MaxSize: maxSize, MaxBackups: maxBackups}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *RotatingFile) open() error {
+	file, err := os.OpenFile(f.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+	f.file = file
+	f.size = info.Size()
+	return nil
+}
+
+// Write writes p to the file, rotating it first if p would exceed MaxSize.
+// A single write is never split across files.
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return 0, os.ErrClosed
+	}
+	if f.MaxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.MaxSize {
+		if err := f.rotate(); err != nil {
+			return 0, fmt.Errorf("failed to rotate %s: %w", f.Path, err)
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+func (f *RotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	f.file = nil
+	shiftErr := f.shiftBackups()
+	// writing goes on in the current file if it couldn't be moved
+	if err := f.open(); err != nil {
+		return err
+	}
+	return shiftErr
+}
+
+// shiftBackups moves the file to the first backup, overwriting the oldest
+// backup with the next older one.
+func (f *RotatingFile) shiftBackups() error {
+	if f.MaxBackups <= 0 {
+		return os.Remove(f.Path)
+	}
+	for i := f.MaxBackups - 1; i >= 1; i-- {
+		err := os.Rename(f.backupPath(i), f.backupPath(i+1))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return os.Rename(f.Path, f.backupPath(1))
+}
+
+func (f *RotatingFile) backupPath(i int) string {
+	return fmt.Sprintf("%s.%d", f.Path, i)
+}
+
+func (f *RotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
@@ -1,7 +1,10 @@
 package proxy
 
 import (
+	"context"
+	"fmt"
 	"net/http"
+	"time"
 )
 
 type Router interface {
@@ -10,7 +13,11 @@ type Router interface {
 
 type PathRouter struct {
 	Routes map[string]Handler
-	mux    *http.ServeMux
+	// ProxyRoute is the pattern of the route serving forward proxy requests,
+	// CONNECT and absolute-form ones, which carry no path to be routed by.
+	// It's set by adding a route with a ForwardProxyHandler.
+	ProxyRoute string
+	mux        *http.ServeMux
 }
 
 func NewPathRouter() *PathRouter {
@@ -21,10 +28,99 @@ func NewPathRouter() *PathRouter {
 }
 
 func (pr *PathRouter) AddRoute(pattern string, handler Handler) {
+	pr.mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+		serveRoute(pattern, handler, w, r)
+	})
 	pr.Routes[pattern] = handler
-	pr.mux.HandleFunc(pattern, handler.ServeHTTP)
+	if _, ok := handler.(*ForwardProxyHandler); ok && pr.ProxyRoute == "" {
+		pr.ProxyRoute = pattern
+	}
+}
+
+// addRoute is AddRoute reporting invalid or conflicting patterns as an error
+// instead of a panic, so a bad config can't crash a running server.
+func (pr *PathRouter) addRoute(pattern string, handler Handler) (err error) {
+	if _, exists := pr.Routes[pattern]; exists {
+		return fmt.Errorf("duplicate route %s", pattern)
+	}
+	if _, ok := handler.(*ForwardProxyHandler); ok && pr.ProxyRoute != "" {
+		return fmt.Errorf("forward proxy requests are already served by route %s", pr.ProxyRoute)
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	pr.AddRoute(pattern, handler)
+	return nil
 }
 
 func (pr *PathRouter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if isProxyRequest(r) {
+		switch {
+		case pr.ProxyRoute != "":
+			serveRoute(pr.ProxyRoute, pr.Routes[pr.ProxyRoute], w, r)
+		case r.Method == http.MethodConnect:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		default:
+			http.NotFound(w, r)
+		}
+		return
+	}
 	pr.mux.ServeHTTP(w, r)
 }
+
+// isProxyRequest tells whether r is meant for a forward proxy, rather than
+// for this server.
+func isProxyRequest(r *http.Request) bool {
+	return r.Method == http.MethodConnect || r.URL.IsAbs()
+}
+
+type routeContextKey struct{}
+
+// serveRoute serves r by the handler of the route with the given pattern,
+// recording metrics and the server span of the request. The pattern is
+// stored on the request context, for handlers to label their own metrics.
+func serveRoute(pattern string, handler Handler, w http.ResponseWriter, r *http.Request) {
+	detailsFromContext(r.Context()).setRoute(pattern)
+	routeInFlight.Inc(pattern)
+	defer routeInFlight.Dec(pattern)
+	start := time.Now()
+	sw := &statusWriter{ResponseWriter: w}
+
+	ctx, span := startServerSpan(r, pattern)
+	span.SetAttribute("http.request.method", r.Method)
+	span.SetAttribute("http.route", pattern)
+	span.SetAttribute("url.path", r.URL.Path)
+	ctx = context.WithValue(ctx, routeContextKey{}, pattern)
+	handler.ServeHTTP(sw, r.WithContext(ctx))
+	span.setStatusCode(sw.status())
+	span.Finish()
+
+	routeDuration.Observe(time.Since(start).Seconds(), pattern)
+	routeRequests.Inc(pattern, statusClass(sw.status()))
+}
+
+// routeFromContext returns the pattern of the route serving a request.
+func routeFromContext(ctx context.Context) string {
+	pattern, _ := ctx.Value(routeContextKey{}).(string)
+	return pattern
+}
+
+// pattern returns the pattern of the route serving r, empty if none matches.
+func (pr *PathRouter) pattern(r *http.Request) string {
+	if isProxyRequest(r) {
+		return pr.ProxyRoute
+	}
+	_, pattern := pr.mux.Handler(r)
+	return pattern
+}
+
+// Close releases resources held by the route handlers.
+func (pr *PathRouter) Close() error {
+	handlers := make([]Handler, 0, len(pr.Routes))
+	for _, handler := range pr.Routes {
+		handlers = append(handlers, handler)
+	}
+	return closeHandlers(handlers)
+}
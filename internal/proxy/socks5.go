@@ -0,0 +1,289 @@
+package proxy
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// SOCKS5 protocol constants, see RFC 1928 and RFC 1929.
+const (
+	socks5Version = 0x05
+
+	socks5AuthNone         = 0x00
+	socks5AuthPassword     = 0x02
+	socks5AuthNoAcceptable = 0xff
+
+	socks5PasswordVersion = 0x01
+
+	socks5CmdConnect = 0x01
+
+	socks5AddrIPv4   = 0x01
+	socks5AddrDomain = 0x03
+	socks5AddrIPv6   = 0x04
+
+	socks5ReplySucceeded           = 0x00
+	socks5ReplyGeneralFailure      = 0x01
+	socks5ReplyNotAllowed          = 0x02
+	socks5ReplyHostUnreachable     = 0x04
+	socks5ReplyConnectionRefused   = 0x05
+	socks5ReplyCommandNotSupported = 0x07
+	socks5ReplyAddrNotSupported    = 0x08
+)
+
+// SOCKS5Proxy is a SOCKS5 listener supporting the CONNECT command. Outbound
+// connections are subject to the same allow and deny rules as the forward
+// proxy, and can be failed on purpose with FailureChance like ChaosHandler.
+type SOCKS5Proxy struct {
+	Address string
+	Allow   []string
+	Deny    []string
+	// Username and Password require username/password authentication,
+	// without them only clients offering no authentication are accepted.
+	Username       string
+	Password       string
+	ConnectTimeout time.Duration
+	IdleTimeout    time.Duration
+	FailureChance  float64
+	Logger         *slog.Logger
+	// rand decides on failures, the global source if nil
+	rand *rand.Rand
+
+	connServer
+}
+
+func NewSOCKS5Proxy(address string) *SOCKS5Proxy {
+	return &SOCKS5Proxy{
+		Address:        address,
+		ConnectTimeout: 10 * time.Second,
+	}
+}
+
+// ListenAndServe listens on the proxy address and serves connections until
+// Shutdown is called, in which case it returns nil.
+func (p *SOCKS5Proxy) ListenAndServe() error {
+	listener, err := net.Listen("tcp", p.Address)
+	if err != nil {
+		return err
+	}
+	return p.Serve(listener)
+}
+
+func (p *SOCKS5Proxy) Serve(listener net.Listener) error {
+	loggerOrDefault(p.Logger).Info("SOCKS5 proxy listening", "address", listener.Addr().String())
+	return p.serve(listener, p.handle)
+}
+
+// Shutdown stops accepting connections and waits for active ones to finish.
+// Connections still active when ctx is done are closed forcibly.
+func (p *SOCKS5Proxy) Shutdown(ctx context.Context) error {
+	return p.shutdown(ctx)
+}
+
+func (p *SOCKS5Proxy) handle(client net.Conn) {
+	// the handshake has to complete within the connect timeout
+	_ = client.SetDeadline(time.Now().Add(p.ConnectTimeout))
+
+	if err := p.authenticate(client); err != nil {
+		loggerOrDefault(p.Logger).Warn("SOCKS5 authentication failed", "client", client.RemoteAddr().String(), "error", err)
+		return
+	}
+
+	target, err := p.connect(client)
+	if err != nil {
+		loggerOrDefault(p.Logger).Warn("SOCKS5 connect failed", "client", client.RemoteAddr().String(), "error", err)
+		return
+	}
+	defer target.Close()
+
+	_ = client.SetDeadline(time.Time{})
+	pipe(client, target, p.IdleTimeout)
+}
+
+func (p *SOCKS5Proxy) authenticate(client net.Conn) error {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(client, header); err != nil {
+		return err
+	}
+	if header[0] != socks5Version {
+		return fmt.Errorf("unsupported version %d", header[0])
+	}
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(client, methods); err != nil {
+		return err
+	}
+
+	required := byte(socks5AuthNone)
+	if p.Username != "" {
+		required = socks5AuthPassword
+	}
+	offered := false
+	for _, method := range methods {
+		if method == required {
+			offered = true
+		}
+	}
+	if !offered {
+		_, _ = client.Write([]byte{socks5Version, socks5AuthNoAcceptable})
+		return errors.New("no acceptable authentication method")
+	}
+	if _, err := client.Write([]byte{socks5Version, required}); err != nil {
+		return err
+	}
+
+	if required == socks5AuthPassword {
+		return p.authenticatePassword(client)
+	}
+	return nil
+}
+
+func (p *SOCKS5Proxy) authenticatePassword(client net.Conn) error {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(client, header); err != nil {
+		return err
+	}
+	if header[0] != socks5PasswordVersion {
+		return fmt.Errorf("unsupported authentication version %d", header[0])
+	}
+	username := make([]byte, header[1])
+	if _, err := io.ReadFull(client, username); err != nil {
+		return err
+	}
+	passwordLength := make([]byte, 1)
+	if _, err := io.ReadFull(client, passwordLength); err != nil {
+		return err
+	}
+	password := make([]byte, passwordLength[0])
+	if _, err := io.ReadFull(client, password); err != nil {
+		return err
+	}
+
+	usernameMatches := subtle.ConstantTimeCompare(username, []byte(p.Username)) == 1
+	passwordMatches := subtle.ConstantTimeCompare(password, []byte(p.Password)) == 1
+	if !usernameMatches || !passwordMatches {
+		_, _ = client.Write([]byte{socks5PasswordVersion, 0x01})
+		return errors.New("invalid credentials")
+	}
+	_, err := client.Write([]byte{socks5PasswordVersion, 0x00})
+	return err
+}
+
+// connect reads the client's request, connects to the requested target and replies.
+func (p *SOCKS5Proxy) connect(client net.Conn) (net.Conn, error) {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(client, header); err != nil {
+		return nil, err
+	}
+	if header[0] != socks5Version {
+		return nil, fmt.Errorf("unsupported version %d", header[0])
+	}
+	if header[1] != socks5CmdConnect {
+		writeSOCKS5Reply(client, socks5ReplyCommandNotSupported, nil)
+		return nil, fmt.Errorf("unsupported command %d", header[1])
+	}
+
+	host, err := readSOCKS5Addr(client, header[3])
+	if err != nil {
+		writeSOCKS5Reply(client, socks5ReplyAddrNotSupported, nil)
+		return nil, err
+	}
+	portBytes := make([]byte, 2)
+	if _, err := io.ReadFull(client, portBytes); err != nil {
+		return nil, err
+	}
+	address := net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(portBytes))))
+
+	ctx, span := startSpan(context.Background(), "socks5 connect", SpanKindServer)
+	span.SetAttribute("server.address", address)
+	defer span.Finish()
+
+	if !hostAllowed(host, p.Allow, p.Deny) {
+		span.SetError("host not allowed")
+		writeSOCKS5Reply(client, socks5ReplyNotAllowed, nil)
+		return nil, fmt.Errorf("host %s not allowed", host)
+	}
+	if injectChaos(ctx, p.rand, p.FailureChance, p.Address) {
+		span.SetError("chaos")
+		writeSOCKS5Reply(client, socks5ReplyGeneralFailure, nil)
+		return nil, fmt.Errorf("chaos for %s", address)
+	}
+
+	target, err := net.DialTimeout("tcp", address, p.ConnectTimeout)
+	if err != nil {
+		span.SetError(err.Error())
+		if errors.Is(err, syscall.ECONNREFUSED) {
+			writeSOCKS5Reply(client, socks5ReplyConnectionRefused, nil)
+		} else {
+			writeSOCKS5Reply(client, socks5ReplyHostUnreachable, nil)
+		}
+		return nil, err
+	}
+
+	if err := writeSOCKS5Reply(client, socks5ReplySucceeded, target.LocalAddr()); err != nil {
+		_ = target.Close()
+		return nil, err
+	}
+	return target, nil
+}
+
+func readSOCKS5Addr(r io.Reader, addrType byte) (string, error) {
+	switch addrType {
+	case socks5AddrIPv4:
+		ip := make([]byte, net.IPv4len)
+		if _, err := io.ReadFull(r, ip); err != nil {
+			return "", err
+		}
+		return net.IP(ip).String(), nil
+	case socks5AddrIPv6:
+		ip := make([]byte, net.IPv6len)
+		if _, err := io.ReadFull(r, ip); err != nil {
+			return "", err
+		}
+		return net.IP(ip).String(), nil
+	case socks5AddrDomain:
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(r, length); err != nil {
+			return "", err
+		}
+		domain := make([]byte, length[0])
+		if _, err := io.ReadFull(r, domain); err != nil {
+			return "", err
+		}
+		return string(domain), nil
+	default:
+		return "", fmt.Errorf("unsupported address type %d", addrType)
+	}
+}
+
+// writeSOCKS5Reply writes a reply with the bound address, or an unspecified
+// IPv4 address when it isn't known.
+func writeSOCKS5Reply(w io.Writer, reply byte, bound net.Addr) error {
+	ip := net.IPv4zero.To4()
+	port := 0
+	if tcpAddr, ok := bound.(*net.TCPAddr); ok {
+		ip = tcpAddr.IP
+		port = tcpAddr.Port
+	}
+
+	msg := []byte{socks5Version, reply, 0x00}
+	if ip4 := ip.To4(); ip4 != nil {
+		msg = append(msg, socks5AddrIPv4)
+		msg = append(msg, ip4...)
+	} else {
+		msg = append(msg, socks5AddrIPv6)
+		msg = append(msg, ip.To16()...)
+	}
+	msg = binary.BigEndian.AppendUint16(msg, uint16(port))
+
+	_, err := w.Write(msg)
+	return err
+}
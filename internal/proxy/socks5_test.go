@@ -0,0 +1,233 @@
+package proxy
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"math/rand"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSOCKS5Proxy(t *testing.T) {
+	t.Run("connects to IPv4 address without authentication", func(t *testing.T) {
+		upstream := newEchoServer(t)
+		socksProxy := startSOCKS5Proxy(t, NewSOCKS5Proxy("127.0.0.1:0"))
+
+		conn := dialSOCKS5(t, socksProxy, nil)
+		reply := requestSOCKS5Connect(t, conn, socks5AddrIPv4, upstream)
+		require.Equal(t, byte(socks5ReplySucceeded), reply)
+
+		assertEcho(t, conn)
+	})
+
+	t.Run("connects to domain address", func(t *testing.T) {
+		upstream := newEchoServer(t)
+		_, port, _ := net.SplitHostPort(upstream)
+		socksProxy := startSOCKS5Proxy(t, NewSOCKS5Proxy("127.0.0.1:0"))
+
+		conn := dialSOCKS5(t, socksProxy, nil)
+		reply := requestSOCKS5Connect(t, conn, socks5AddrDomain, net.JoinHostPort("localhost", port))
+		require.Equal(t, byte(socks5ReplySucceeded), reply)
+
+		assertEcho(t, conn)
+	})
+
+	t.Run("connects to IPv6 address", func(t *testing.T) {
+		listener, err := net.Listen("tcp", "[::1]:0")
+		if err != nil {
+			t.Skip("IPv6 loopback not available")
+		}
+		_ = listener.Close()
+		socksProxy := startSOCKS5Proxy(t, NewSOCKS5Proxy("127.0.0.1:0"))
+
+		conn := dialSOCKS5(t, socksProxy, nil)
+		reply := requestSOCKS5Connect(t, conn, socks5AddrIPv6, listener.Addr().String())
+		assert.Equal(t, byte(socks5ReplyConnectionRefused), reply, "Expected closed IPv6 port to be refused")
+	})
+
+	t.Run("authenticates with username and password", func(t *testing.T) {
+		upstream := newEchoServer(t)
+		socksProxy := NewSOCKS5Proxy("127.0.0.1:0")
+		socksProxy.Username = "user"
+		socksProxy.Password = "secret"
+		startSOCKS5Proxy(t, socksProxy)
+
+		conn := dialSOCKS5(t, socksProxy, &[2]string{"user", "secret"})
+		reply := requestSOCKS5Connect(t, conn, socks5AddrIPv4, upstream)
+		require.Equal(t, byte(socks5ReplySucceeded), reply)
+
+		assertEcho(t, conn)
+	})
+
+	t.Run("rejects invalid credentials", func(t *testing.T) {
+		socksProxy := NewSOCKS5Proxy("127.0.0.1:0")
+		socksProxy.Username = "user"
+		socksProxy.Password = "secret"
+		startSOCKS5Proxy(t, socksProxy)
+
+		conn, err := net.Dial("tcp", socksProxy.Addr().String())
+		require.NoError(t, err)
+		defer conn.Close()
+
+		_, err = conn.Write([]byte{socks5Version, 1, socks5AuthPassword})
+		require.NoError(t, err)
+		readExactly(t, conn, []byte{socks5Version, socks5AuthPassword})
+		_, err = conn.Write(append(append([]byte{socks5PasswordVersion, 4}, "user"...), append([]byte{5}, "wrong"...)...))
+		require.NoError(t, err)
+		readExactly(t, conn, []byte{socks5PasswordVersion, 0x01})
+	})
+
+	t.Run("rejects clients without acceptable method", func(t *testing.T) {
+		socksProxy := NewSOCKS5Proxy("127.0.0.1:0")
+		socksProxy.Username = "user"
+		startSOCKS5Proxy(t, socksProxy)
+
+		conn, err := net.Dial("tcp", socksProxy.Addr().String())
+		require.NoError(t, err)
+		defer conn.Close()
+
+		_, err = conn.Write([]byte{socks5Version, 1, socks5AuthNone})
+		require.NoError(t, err)
+		readExactly(t, conn, []byte{socks5Version, socks5AuthNoAcceptable})
+	})
+
+	t.Run("rejects denied hosts", func(t *testing.T) {
+		upstream := newEchoServer(t)
+		socksProxy := NewSOCKS5Proxy("127.0.0.1:0")
+		socksProxy.Deny = []string{"127.0.0.1"}
+		startSOCKS5Proxy(t, socksProxy)
+
+		conn := dialSOCKS5(t, socksProxy, nil)
+		reply := requestSOCKS5Connect(t, conn, socks5AddrIPv4, upstream)
+		assert.Equal(t, byte(socks5ReplyNotAllowed), reply)
+	})
+
+	t.Run("injects failures", func(t *testing.T) {
+		upstream := newEchoServer(t)
+		socksProxy := NewSOCKS5Proxy("127.0.0.1:0")
+		socksProxy.FailureChance = 1
+		startSOCKS5Proxy(t, socksProxy)
+		injections := metricDelta(chaosInjections.family, socksProxy.Address)
+
+		conn := dialSOCKS5(t, socksProxy, nil)
+		reply := requestSOCKS5Connect(t, conn, socks5AddrIPv4, upstream)
+		assert.Equal(t, byte(socks5ReplyGeneralFailure), reply)
+		assert.Equal(t, 1.0, injections())
+	})
+
+	t.Run("decides on failures like chaos handlers", func(t *testing.T) {
+		upstream := newEchoServer(t)
+		socksProxy := NewSOCKS5Proxy("127.0.0.1:0")
+		socksProxy.FailureChance = 0.5
+		socksProxy.rand = rand.New(rand.NewSource(1))
+		startSOCKS5Proxy(t, socksProxy)
+		expected := rand.New(rand.NewSource(1))
+
+		for range 10 {
+			want := byte(socks5ReplySucceeded)
+			if expected.Float64() <= 0.5 {
+				want = socks5ReplyGeneralFailure
+			}
+			conn := dialSOCKS5(t, socksProxy, nil)
+			assert.Equal(t, want, requestSOCKS5Connect(t, conn, socks5AddrIPv4, upstream))
+			_ = conn.Close()
+		}
+	})
+}
+
+func startSOCKS5Proxy(t *testing.T, socksProxy *SOCKS5Proxy) *SOCKS5Proxy {
+	listener, err := net.Listen("tcp", socksProxy.Address)
+	require.NoError(t, err)
+	go func() {
+		_ = socksProxy.Serve(listener)
+	}()
+	require.Eventually(t, func() bool {
+		return socksProxy.Addr() != nil
+	}, time.Second, time.Millisecond)
+	t.Cleanup(func() {
+		_ = socksProxy.Shutdown(context.Background())
+	})
+	return socksProxy
+}
+
+// dialSOCKS5 connects to the proxy and completes the method negotiation,
+// authenticating with credentials if given.
+func dialSOCKS5(t *testing.T, socksProxy *SOCKS5Proxy, credentials *[2]string) net.Conn {
+	conn, err := net.Dial("tcp", socksProxy.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+	require.NoError(t, conn.SetDeadline(time.Now().Add(5*time.Second)))
+
+	if credentials == nil {
+		_, err = conn.Write([]byte{socks5Version, 1, socks5AuthNone})
+		require.NoError(t, err)
+		readExactly(t, conn, []byte{socks5Version, socks5AuthNone})
+		return conn
+	}
+
+	_, err = conn.Write([]byte{socks5Version, 2, socks5AuthNone, socks5AuthPassword})
+	require.NoError(t, err)
+	readExactly(t, conn, []byte{socks5Version, socks5AuthPassword})
+
+	username, password := credentials[0], credentials[1]
+	msg := []byte{socks5PasswordVersion, byte(len(username))}
+	msg = append(msg, username...)
+	msg = append(msg, byte(len(password)))
+	msg = append(msg, password...)
+	_, err = conn.Write(msg)
+	require.NoError(t, err)
+	readExactly(t, conn, []byte{socks5PasswordVersion, 0x00})
+	return conn
+}
+
+// requestSOCKS5Connect sends a CONNECT request and returns the reply code.
+func requestSOCKS5Connect(t *testing.T, conn net.Conn, addrType byte, address string) byte {
+	host, portString, err := net.SplitHostPort(address)
+	require.NoError(t, err)
+	port, err := strconv.Atoi(portString)
+	require.NoError(t, err)
+
+	msg := []byte{socks5Version, socks5CmdConnect, 0x00, addrType}
+	switch addrType {
+	case socks5AddrIPv4:
+		msg = append(msg, net.ParseIP(host).To4()...)
+	case socks5AddrIPv6:
+		msg = append(msg, net.ParseIP(host).To16()...)
+	case socks5AddrDomain:
+		msg = append(msg, byte(len(host)))
+		msg = append(msg, host...)
+	}
+	msg = binary.BigEndian.AppendUint16(msg, uint16(port))
+	_, err = conn.Write(msg)
+	require.NoError(t, err)
+
+	header := make([]byte, 4)
+	_, err = io.ReadFull(conn, header)
+	require.NoError(t, err)
+	_, err = readSOCKS5Addr(conn, header[3])
+	require.NoError(t, err)
+	_, err = io.ReadFull(conn, make([]byte, 2))
+	require.NoError(t, err)
+	return header[1]
+}
+
+func assertEcho(t *testing.T, conn net.Conn) {
+	_, err := conn.Write([]byte("ping"))
+	require.NoError(t, err)
+	readExactly(t, conn, []byte("ping"))
+}
+
+func readExactly(t *testing.T, conn net.Conn, expected []byte) {
+	actual := make([]byte, len(expected))
+	_, err := io.ReadFull(conn, actual)
+	require.NoError(t, err)
+	assert.Equal(t, expected, actual)
+}
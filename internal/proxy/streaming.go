@@ -0,0 +1,253 @@
+package proxy
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// isStreamingResponse reports whether a response with the given header is a stream
+// which has to reach the client as it is produced, instead of after it completes.
+// gRPC responses are streams, whose status is only known from their trailers.
+func isStreamingResponse(header http.Header) bool {
+	mediaType, _, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+	switch mediaType {
+	case "text/event-stream", "application/x-ndjson", "application/stream+json", "application/grpc":
+		return true
+	}
+	return strings.HasPrefix(mediaType, "application/grpc+")
+}
+
+// flush flushes w if it, or any writer it wraps, supports flushing.
+func flush(w http.ResponseWriter) error {
+	err := http.NewResponseController(w).Flush()
+	if err != nil && !errors.Is(err, http.ErrNotSupported) {
+		return err
+	}
+	return nil
+}
+
+// copyResponse copies body to w, flushing according to flushInterval:
+// a negative interval flushes after every write, zero never flushes and
+// a positive interval flushes periodically while data is being written.
+func copyResponse(w http.ResponseWriter, body io.Reader, flushInterval time.Duration) error {
+	if flushInterval == 0 {
+		_, err := io.Copy(w, body)
+		return err
+	}
+
+	mlw := &maxLatencyWriter{
+		dst:     w,
+		latency: flushInterval,
+	}
+
+	buf := make([]byte, 32*1024)
+	for {
+		n, readErr := body.Read(buf)
+		if n > 0 {
+			if _, err := mlw.Write(buf[:n]); err != nil {
+				mlw.stop()
+				return err
+			}
+		}
+		if readErr == io.EOF {
+			return mlw.stop()
+		}
+		if readErr != nil {
+			mlw.stop()
+			return readErr
+		}
+	}
+}
+
+// maxLatencyWriter makes sure written data is flushed to the client within latency.
+type maxLatencyWriter struct {
+	dst     http.ResponseWriter
+	latency time.Duration
+
+	mu           sync.Mutex
+	timer        *time.Timer
+	flushPending bool
+	// flushErr is the error of a delayed flush, returned by the next write
+	flushErr error
+}
+
+func (m *maxLatencyWriter) Write(p []byte) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.flushErr != nil {
+		return 0, m.flushErr
+	}
+	n, err := m.dst.Write(p)
+	if err != nil {
+		return n, err
+	}
+	if m.latency < 0 {
+		return n, flush(m.dst)
+	}
+	if m.flushPending {
+		return n, err
+	}
+	if m.timer == nil {
+		m.timer = time.AfterFunc(m.latency, m.delayedFlush)
+	} else {
+		m.timer.Reset(m.latency)
+	}
+	m.flushPending = true
+	return n, err
+}
+
+func (m *maxLatencyWriter) delayedFlush() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.flushPending {
+		return
+	}
+	m.flushErr = flush(m.dst)
+	m.flushPending = false
+}
+
+// stop stops flushing periodically and flushes what was written since the
+// last flush.
+func (m *maxLatencyWriter) stop() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.flushPending = false
+	if m.timer != nil {
+		m.timer.Stop()
+	}
+	if m.flushErr != nil {
+		return m.flushErr
+	}
+	return flush(m.dst)
+}
+
+// StreamingResponseWriter buffers a response like BufferedResponseWriter, unless
+// the response turns out to be a stream. Streaming responses are written through
+// to the target writer as soon as the header is written, provided claim allows it.
+type StreamingResponseWriter struct {
+	*BufferedResponseWriter
+	target      http.ResponseWriter
+	claim       func() bool
+	wroteHeader bool
+	streaming   bool
+}
+
+// NewStreamingResponseWriter creates a StreamingResponseWriter for target. claim
+// is called once a streaming response is detected and decides whether this
+// writer may take over target; nil always allows it.
+func NewStreamingResponseWriter(target http.ResponseWriter, claim func() bool) *StreamingResponseWriter {
+	if claim == nil {
+		claim = func() bool { return true }
+	}
+	return &StreamingResponseWriter{
+		BufferedResponseWriter: NewBufferedResponseWriter(),
+		target:                 target,
+		claim:                  claim,
+	}
+}
+
+// Header returns the header of the target once the response is streamed, so
+// trailers set after the body reach the client.
+func (w *StreamingResponseWriter) Header() http.Header {
+	if w.streaming {
+		return w.target.Header()
+	}
+	return w.header
+}
+
+func (w *StreamingResponseWriter) WriteHeader(statusCode int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	w.BufferedResponseWriter.WriteHeader(statusCode)
+
+	if !w.streaming {
+		if !isStreamingResponse(w.header) || !w.claim() {
+			return
+		}
+		w.streaming = true
+	}
+	copyHeader(w.target.Header(), w.header)
+	w.target.WriteHeader(statusCode)
+}
+
+func (w *StreamingResponseWriter) Write(data []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.streaming {
+		return w.target.Write(data)
+	}
+	return w.BufferedResponseWriter.Write(data)
+}
+
+func (w *StreamingResponseWriter) Flush() {
+	_ = w.FlushError()
+}
+
+// FlushError flushes a streaming response, and is used by http.ResponseController.
+func (w *StreamingResponseWriter) FlushError() error {
+	if w.streaming {
+		return flush(w.target)
+	}
+	return nil
+}
+
+// SetWriteDeadline sets the write deadline of a streaming response, and is
+// used by http.ResponseController. Buffered responses have none.
+func (w *StreamingResponseWriter) SetWriteDeadline(deadline time.Time) error {
+	if w.streaming {
+		return http.NewResponseController(w.target).SetWriteDeadline(deadline)
+	}
+	return nil
+}
+
+// Hijack takes over the connection of the target, e.g. for CONNECT tunnels and
+// upgrades, provided no buffered response was written yet and claim allows
+// it. The response then counts as streamed, so it isn't written again.
+func (w *StreamingResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if !w.streaming {
+		if w.wroteHeader || !w.claim() {
+			return nil, nil, fmt.Errorf("hijacking a buffered response: %w", http.ErrNotSupported)
+		}
+		w.streaming = true
+	}
+	return http.NewResponseController(w.target).Hijack()
+}
+
+// Unwrap gives http.ResponseController access to the target once the response
+// is streamed, e.g. to set the read deadline. Buffered responses have none.
+func (w *StreamingResponseWriter) Unwrap() http.ResponseWriter {
+	if w.streaming {
+		return w.target
+	}
+	return nil
+}
+
+// Streaming reports whether the response was written through to the target.
+func (w *StreamingResponseWriter) Streaming() bool {
+	return w.streaming
+}
+
+func copyHeader(dst, src http.Header) {
+	for name, values := range src {
+		for _, value := range values {
+			dst.Add(name, value)
+		}
+	}
+}
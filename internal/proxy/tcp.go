@@ -0,0 +1,187 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// TCPProxy accepts raw TCP connections and forwards them to upstreams of its pool.
+type TCPProxy struct {
+	Address        string
+	Pool           *UpstreamPool
+	HealthChecker  *HealthChecker
+	ConnectTimeout time.Duration
+	// IdleTimeout closes connections without traffic in either direction, zero disables it.
+	IdleTimeout time.Duration
+	Logger      *slog.Logger
+
+	bytesIn  atomic.Int64
+	bytesOut atomic.Int64
+
+	connServer
+}
+
+func NewTCPProxy(address string, pool *UpstreamPool) *TCPProxy {
+	return &TCPProxy{
+		Address:        address,
+		Pool:           pool,
+		ConnectTimeout: 10 * time.Second,
+	}
+}
+
+// TCPProxyStats is a snapshot of a TCP proxy's traffic.
+type TCPProxyStats struct {
+	Address           string          `json:"address"`
+	ActiveConnections int             `json:"active_connections"`
+	BytesIn           int64           `json:"bytes_in"`
+	BytesOut          int64           `json:"bytes_out"`
+	Upstreams         []UpstreamStats `json:"upstreams"`
+}
+
+func (p *TCPProxy) Stats() TCPProxyStats {
+	return TCPProxyStats{
+		Address:           p.Address,
+		ActiveConnections: p.activeConns(),
+		BytesIn:           p.bytesIn.Load(),
+		BytesOut:          p.bytesOut.Load(),
+		Upstreams:         p.Pool.Stats(),
+	}
+}
+
+// ListenAndServe listens on the proxy address and serves connections until
+// Shutdown is called, in which case it returns nil.
+func (p *TCPProxy) ListenAndServe() error {
+	listener, err := net.Listen("tcp", p.Address)
+	if err != nil {
+		return err
+	}
+	return p.Serve(listener)
+}
+
+func (p *TCPProxy) Serve(listener net.Listener) error {
+	if p.HealthChecker != nil {
+		p.HealthChecker.Start()
+	}
+	loggerOrDefault(p.Logger).Info("TCP proxy listening", "address", listener.Addr().String())
+	return p.serve(listener, p.handle)
+}
+
+// Shutdown stops accepting connections and waits for active ones to finish.
+// Connections still active when ctx is done are closed forcibly.
+func (p *TCPProxy) Shutdown(ctx context.Context) error {
+	if p.HealthChecker != nil {
+		_ = p.HealthChecker.Close()
+	}
+	return p.shutdown(ctx)
+}
+
+func (p *TCPProxy) handle(client net.Conn) {
+	upstream, conn, err := p.dial()
+	if err != nil {
+		loggerOrDefault(p.Logger).Error("Error connecting to upstream", "listen", p.Address, "client", client.RemoteAddr().String(), "error", err)
+		return
+	}
+	defer conn.Close()
+
+	upstream.active.Add(1)
+	defer upstream.active.Add(-1)
+
+	sent, received := pipe(client, conn, p.IdleTimeout)
+	p.bytesIn.Add(sent)
+	p.bytesOut.Add(received)
+	upstream.bytesSent.Add(sent)
+	upstream.bytesRecv.Add(received)
+}
+
+// dial connects to the next healthy upstream, trying each upstream at most once.
+func (p *TCPProxy) dial() (*Upstream, net.Conn, error) {
+	dialer := net.Dialer{Timeout: p.ConnectTimeout}
+	var errs []error
+	for range p.Pool.Upstreams {
+		upstream, err := p.Pool.Next()
+		if err != nil {
+			errs = append(errs, err)
+			break
+		}
+		conn, err := dialer.Dial("tcp", upstream.Address)
+		if err == nil {
+			return upstream, conn, nil
+		}
+		errs = append(errs, err)
+	}
+	return nil, nil, errors.Join(errs...)
+}
+
+// pipe copies data between client and upstream in both directions until both
+// sides are done, returning bytes sent to and received from the upstream.
+func pipe(client, upstream net.Conn, idleTimeout time.Duration) (sent, received int64) {
+	var lastActivity atomic.Int64
+	lastActivity.Store(time.Now().UnixNano())
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		sent = copyConn(upstream, client, idleTimeout, &lastActivity)
+	}()
+	go func() {
+		defer wg.Done()
+		received = copyConn(client, upstream, idleTimeout, &lastActivity)
+	}()
+	wg.Wait()
+	return sent, received
+}
+
+// copyConn copies from src to dst until src is exhausted or the connection
+// has been idle in both directions for longer than idleTimeout.
+func copyConn(dst, src net.Conn, idleTimeout time.Duration, lastActivity *atomic.Int64) int64 {
+	var written int64
+	buf := make([]byte, 32*1024)
+	for {
+		if idleTimeout > 0 {
+			_ = src.SetReadDeadline(time.Now().Add(idleTimeout))
+		}
+		n, err := src.Read(buf)
+		if n > 0 {
+			lastActivity.Store(time.Now().UnixNano())
+			if _, writeErr := dst.Write(buf[:n]); writeErr != nil {
+				_ = src.Close()
+				return written
+			}
+			written += int64(n)
+		}
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				idle := time.Since(time.Unix(0, lastActivity.Load()))
+				if idle < idleTimeout {
+					continue
+				}
+				_ = src.Close()
+				_ = dst.Close()
+				return written
+			}
+			if err != io.EOF {
+				_ = dst.Close()
+				return written
+			}
+			closeWrite(dst)
+			return written
+		}
+	}
+}
+
+// closeWrite half-closes a connection, signalling EOF while still allowing reads.
+func closeWrite(conn net.Conn) {
+	if c, ok := conn.(interface{ CloseWrite() error }); ok {
+		_ = c.CloseWrite()
+		return
+	}
+	_ = conn.Close()
+}
@@ -0,0 +1,138 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTCPProxy(t *testing.T) {
+	t.Run("forwards data in both directions", func(t *testing.T) {
+		upstream := newEchoServer(t)
+
+		tcpProxy := startTCPProxy(t, []string{upstream})
+
+		conn, err := net.Dial("tcp", tcpProxy.Addr().String())
+		require.NoError(t, err)
+
+		_, err = conn.Write([]byte("ping"))
+		require.NoError(t, err)
+		reply := make([]byte, 4)
+		_, err = io.ReadFull(conn, reply)
+		require.NoError(t, err)
+		assert.Equal(t, "ping", string(reply))
+		require.NoError(t, conn.Close())
+
+		assert.Eventually(t, func() bool {
+			stats := tcpProxy.Stats()
+			return stats.ActiveConnections == 0 && stats.BytesIn == 4 && stats.BytesOut == 4
+		}, time.Second, 10*time.Millisecond, "Expected byte counters to be updated")
+		assert.Equal(t, int64(4), tcpProxy.Stats().Upstreams[0].BytesSent)
+	})
+
+	t.Run("skips unreachable upstream", func(t *testing.T) {
+		upstream := newEchoServer(t)
+
+		tcpProxy := startTCPProxy(t, []string{"localhost:1", upstream})
+
+		for i := 0; i < 2; i++ {
+			conn, err := net.Dial("tcp", tcpProxy.Addr().String())
+			require.NoError(t, err)
+			_, err = conn.Write([]byte("ping"))
+			require.NoError(t, err)
+			reply := make([]byte, 4)
+			_, err = io.ReadFull(conn, reply)
+			require.NoError(t, err)
+			assert.Equal(t, "ping", string(reply))
+			require.NoError(t, conn.Close())
+		}
+	})
+
+	t.Run("closes idle connections", func(t *testing.T) {
+		upstream := newEchoServer(t)
+
+		tcpProxy := NewTCPProxy("127.0.0.1:0", mustUpstreamPool(t, []string{upstream}))
+		tcpProxy.IdleTimeout = 50 * time.Millisecond
+		serveTCPProxy(t, tcpProxy)
+
+		conn, err := net.Dial("tcp", tcpProxy.Addr().String())
+		require.NoError(t, err)
+		defer conn.Close()
+
+		require.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+		_, err = conn.Read(make([]byte, 1))
+		assert.ErrorIs(t, err, io.EOF, "Expected idle connection to be closed by proxy")
+	})
+
+	t.Run("shutdown closes remaining connections after deadline", func(t *testing.T) {
+		upstream := newEchoServer(t)
+
+		tcpProxy := startTCPProxy(t, []string{upstream})
+
+		conn, err := net.Dial("tcp", tcpProxy.Addr().String())
+		require.NoError(t, err)
+		defer conn.Close()
+		assert.Eventually(t, func() bool {
+			return tcpProxy.Stats().ActiveConnections == 1
+		}, time.Second, 10*time.Millisecond)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		err = tcpProxy.Shutdown(ctx)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.Equal(t, 0, tcpProxy.Stats().ActiveConnections)
+	})
+}
+
+func startTCPProxy(t *testing.T, upstreams []string) *TCPProxy {
+	tcpProxy := NewTCPProxy("127.0.0.1:0", mustUpstreamPool(t, upstreams))
+	serveTCPProxy(t, tcpProxy)
+	return tcpProxy
+}
+
+func serveTCPProxy(t *testing.T, tcpProxy *TCPProxy) {
+	listener, err := net.Listen("tcp", tcpProxy.Address)
+	require.NoError(t, err)
+	go func() {
+		_ = tcpProxy.Serve(listener)
+	}()
+	require.Eventually(t, func() bool {
+		return tcpProxy.Addr() != nil
+	}, time.Second, time.Millisecond)
+	t.Cleanup(func() {
+		_ = tcpProxy.Shutdown(context.Background())
+	})
+}
+
+func mustUpstreamPool(t *testing.T, addresses []string) *UpstreamPool {
+	pool, err := NewUpstreamPool(addresses)
+	require.NoError(t, err)
+	return pool
+}
+
+// newEchoServer starts a TCP server echoing everything back and returns its address.
+func newEchoServer(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = listener.Close()
+	})
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
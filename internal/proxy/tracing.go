@@ -0,0 +1,358 @@
+package proxy
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// defaultTracer records the spans of requests. Tracing is disabled while
+// it's nil, see SetTracer.
+var defaultTracer atomic.Pointer[Tracer]
+
+// SetTracer sets the tracer recording spans of all requests, nil disables tracing.
+func SetTracer(tracer *Tracer) {
+	defaultTracer.Store(tracer)
+}
+
+type TraceID [16]byte
+
+func (id TraceID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+func (id TraceID) IsValid() bool {
+	return id != TraceID{}
+}
+
+type SpanID [8]byte
+
+func (id SpanID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+func (id SpanID) IsValid() bool {
+	return id != SpanID{}
+}
+
+// SpanContext identifies a span as propagated by the W3C trace context
+// headers traceparent and tracestate.
+type SpanContext struct {
+	TraceID    TraceID
+	SpanID     SpanID
+	Sampled    bool
+	TraceState string
+}
+
+// parseTraceparent parses a traceparent header of the form
+// "00-<trace id>-<parent id>-<flags>". Headers of future versions may append
+// fields, which are ignored.
+func parseTraceparent(header string) (SpanContext, bool) {
+	var sc SpanContext
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return sc, false
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil || len(flags) != 1 ||
+		!decodeHexID(sc.TraceID[:], parts[1]) || !decodeHexID(sc.SpanID[:], parts[2]) ||
+		!sc.TraceID.IsValid() || !sc.SpanID.IsValid() {
+		return sc, false
+	}
+	sc.Sampled = flags[0]&1 == 1
+	return sc, true
+}
+
+// decodeHexID decodes a lowercase hex ID filling dst exactly.
+func decodeHexID(dst []byte, s string) bool {
+	if len(s) != hex.EncodedLen(len(dst)) || strings.ToLower(s) != s {
+		return false
+	}
+	_, err := hex.Decode(dst, []byte(s))
+	return err == nil
+}
+
+func (sc SpanContext) traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + sc.TraceID.String() + "-" + sc.SpanID.String() + "-" + flags
+}
+
+type SpanKind int
+
+const (
+	SpanKindInternal SpanKind = iota + 1
+	SpanKindServer
+	SpanKindClient
+)
+
+func (k SpanKind) String() string {
+	switch k {
+	case SpanKindServer:
+		return "server"
+	case SpanKindClient:
+		return "client"
+	default:
+		return "internal"
+	}
+}
+
+// Span is a timed operation of a trace. A nil span, as started while tracing
+// is disabled, ignores all calls.
+type Span struct {
+	Name         string
+	Kind         SpanKind
+	SpanContext  SpanContext
+	ParentSpanID SpanID
+	Start        time.Time
+	End          time.Time
+	Attributes   map[string]any
+	Error        bool
+	ErrorMessage string
+
+	tracer *Tracer
+}
+
+type spanContextKey struct{}
+
+// SpanFromContext returns the span of the current operation, nil if there is none.
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanContextKey{}).(*Span)
+	return span
+}
+
+// startSpan starts a span which is a child of the span in ctx, if any.
+func startSpan(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	var parent SpanContext
+	if span := SpanFromContext(ctx); span != nil {
+		parent = span.SpanContext
+	}
+	return startSpanWithParent(ctx, name, kind, parent)
+}
+
+// startServerSpan starts the span of a served request, which continues the
+// trace of the client if it sent a valid traceparent header.
+func startServerSpan(r *http.Request, name string) (context.Context, *Span) {
+	parent, ok := parseTraceparent(r.Header.Get("traceparent"))
+	if ok {
+		parent.TraceState = r.Header.Get("tracestate")
+	}
+	return startSpanWithParent(r.Context(), name, SpanKindServer, parent)
+}
+
+func startSpanWithParent(ctx context.Context, name string, kind SpanKind, parent SpanContext) (context.Context, *Span) {
+	tracer := defaultTracer.Load()
+	if tracer == nil {
+		return ctx, nil
+	}
+	span := &Span{
+		Name:       name,
+		Kind:       kind,
+		Start:      time.Now(),
+		Attributes: make(map[string]any),
+		tracer:     tracer,
+	}
+	if parent.TraceID.IsValid() {
+		span.SpanContext = parent
+		span.ParentSpanID = parent.SpanID
+	} else {
+		span.SpanContext.TraceID = newTraceID()
+		span.SpanContext.Sampled = rand.Float64() < tracer.sampleRatio
+	}
+	span.SpanContext.SpanID = newSpanID()
+	return context.WithValue(ctx, spanContextKey{}, span), span
+}
+
+func (s *Span) SetAttribute(key string, value any) {
+	if s == nil {
+		return
+	}
+	s.Attributes[key] = value
+}
+
+// SetError marks the operation of the span as failed.
+func (s *Span) SetError(message string) {
+	if s == nil {
+		return
+	}
+	s.Error = true
+	s.ErrorMessage = message
+}
+
+// setStatusCode records the status code of an HTTP response, marking
+// server errors as failures.
+func (s *Span) setStatusCode(statusCode int) {
+	if s == nil {
+		return
+	}
+	s.SetAttribute("http.response.status_code", statusCode)
+	if statusCode >= 500 {
+		s.SetError(http.StatusText(statusCode))
+	}
+}
+
+// Finish ends the span and hands it to the exporter if it's sampled. The
+// span must not be changed afterward.
+func (s *Span) Finish() {
+	if s == nil {
+		return
+	}
+	s.End = time.Now()
+	if s.SpanContext.Sampled {
+		s.tracer.record(s)
+	}
+}
+
+// requestWithContext returns r with ctx, avoiding a copy of r if ctx is
+// unchanged, as while tracing is disabled.
+func requestWithContext(r *http.Request, ctx context.Context) *http.Request {
+	if ctx == r.Context() {
+		return r
+	}
+	return r.WithContext(ctx)
+}
+
+// injectHeaders propagates the span to an upstream.
+func (s *Span) injectHeaders(header http.Header) {
+	if s == nil {
+		return
+	}
+	header.Set("traceparent", s.SpanContext.traceparent())
+	if s.SpanContext.TraceState != "" {
+		header.Set("tracestate", s.SpanContext.TraceState)
+	} else {
+		header.Del("tracestate")
+	}
+}
+
+func newTraceID() TraceID {
+	var id TraceID
+	for !id.IsValid() {
+		binary.LittleEndian.PutUint64(id[:8], rand.Uint64())
+		binary.LittleEndian.PutUint64(id[8:], rand.Uint64())
+	}
+	return id
+}
+
+func newSpanID() SpanID {
+	var id SpanID
+	for !id.IsValid() {
+		binary.LittleEndian.PutUint64(id[:], rand.Uint64())
+	}
+	return id
+}
+
+// SpanExporter sends finished spans to a tracing backend.
+type SpanExporter interface {
+	ExportSpans(ctx context.Context, spans []*Span) error
+}
+
+const (
+	maxQueuedSpans  = 2048
+	maxExportBatch  = 512
+	spanExportDelay = 5 * time.Second
+)
+
+// Tracer collects finished spans and exports them in batches in the
+// background, so exporting never delays requests. Spans are dropped if
+// the exporter can't keep up.
+type Tracer struct {
+	// Logger logs spans which couldn't be exported, set it before the tracer is used.
+	Logger *slog.Logger
+
+	exporter    SpanExporter
+	sampleRatio float64
+
+	mu     sync.RWMutex
+	closed bool
+	spans  chan *Span
+	done   chan struct{}
+}
+
+// NewTracer creates a tracer exporting spans with exporter. New traces are
+// sampled with probability sampleRatio, continued traces are sampled if
+// the caller sampled them.
+func NewTracer(exporter SpanExporter, sampleRatio float64) *Tracer {
+	t := &Tracer{
+		exporter:    exporter,
+		sampleRatio: sampleRatio,
+		spans:       make(chan *Span, maxQueuedSpans),
+		done:        make(chan struct{}),
+	}
+	go t.run()
+	return t
+}
+
+func (t *Tracer) record(span *Span) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.closed {
+		return
+	}
+	select {
+	case t.spans <- span:
+	default:
+		loggerOrDefault(t.Logger).Warn("Span queue is full, dropping span", "span", span.Name)
+	}
+}
+
+func (t *Tracer) run() {
+	defer close(t.done)
+	ticker := time.NewTicker(spanExportDelay)
+	defer ticker.Stop()
+
+	var batch []*Span
+	export := func() {
+		if len(batch) == 0 {
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := t.exporter.ExportSpans(ctx, batch); err != nil {
+			loggerOrDefault(t.Logger).Error("Error exporting spans", "spans", len(batch), "error", err)
+		}
+		batch = nil
+	}
+
+	for {
+		select {
+		case span, ok := <-t.spans:
+			if !ok {
+				export()
+				return
+			}
+			batch = append(batch, span)
+			if len(batch) >= maxExportBatch {
+				export()
+			}
+		case <-ticker.C:
+			export()
+		}
+	}
+}
+
+// Shutdown exports the remaining spans. Spans finished afterward are dropped.
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	t.mu.Lock()
+	if !t.closed {
+		t.closed = true
+		close(t.spans)
+	}
+	t.mu.Unlock()
+
+	select {
+	case <-t.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,221 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// StdoutExporter writes spans as JSON lines, e.g. to stdout for debugging.
+type StdoutExporter struct {
+	Writer io.Writer
+	mu     sync.Mutex
+}
+
+func NewStdoutExporter(w io.Writer) *StdoutExporter {
+	return &StdoutExporter{Writer: w}
+}
+
+type stdoutSpan struct {
+	TraceID      string         `json:"trace_id"`
+	SpanID       string         `json:"span_id"`
+	ParentSpanID string         `json:"parent_span_id,omitempty"`
+	Name         string         `json:"name"`
+	Kind         string         `json:"kind"`
+	Start        time.Time      `json:"start"`
+	DurationMs   float64        `json:"duration_ms"`
+	Attributes   map[string]any `json:"attributes,omitempty"`
+	Error        string         `json:"error,omitempty"`
+}
+
+func (e *StdoutExporter) ExportSpans(_ context.Context, spans []*Span) error {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	for _, span := range spans {
+		line := stdoutSpan{
+			TraceID:    span.SpanContext.TraceID.String(),
+			SpanID:     span.SpanContext.SpanID.String(),
+			Name:       span.Name,
+			Kind:       span.Kind.String(),
+			Start:      span.Start,
+			DurationMs: float64(span.End.Sub(span.Start).Microseconds()) / 1000,
+			Attributes: span.Attributes,
+		}
+		if span.ParentSpanID.IsValid() {
+			line.ParentSpanID = span.ParentSpanID.String()
+		}
+		if span.Error {
+			line.Error = span.ErrorMessage
+		}
+		if err := encoder.Encode(line); err != nil {
+			return err
+		}
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	_, err := e.Writer.Write(buf.Bytes())
+	return err
+}
+
+// OTLPExporter sends spans to an OpenTelemetry collector using OTLP/HTTP
+// with JSON encoding.
+type OTLPExporter struct {
+	// Endpoint is the URL spans are posted to, e.g. http://localhost:4318/v1/traces.
+	Endpoint    string
+	Headers     map[string]string
+	ServiceName string
+	Client      *http.Client
+}
+
+func NewOTLPExporter(endpoint, serviceName string) *OTLPExporter {
+	return &OTLPExporter{
+		Endpoint:    endpoint,
+		ServiceName: serviceName,
+		Client:      &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (e *OTLPExporter) ExportSpans(ctx context.Context, spans []*Span) error {
+	body, err := json.Marshal(e.request(spans))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range e.Headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := e.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("collector responded with %s", resp.Status)
+	}
+	return nil
+}
+
+// The types below are the JSON encoding of an OTLP ExportTraceServiceRequest,
+// see https://opentelemetry.io/docs/specs/otlp/#json-protobuf-encoding
+
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	TraceState        string          `json:"traceState,omitempty"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            otlpStatus      `json:"status"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+// otlpValue is an AnyValue, of which exactly one field is set. 64-bit
+// integers are encoded as strings.
+type otlpValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+const otlpStatusError = 2
+
+func (e *OTLPExporter) request(spans []*Span) otlpRequest {
+	otlpSpans := make([]otlpSpan, len(spans))
+	for i, span := range spans {
+		otlpSpans[i] = otlpSpan{
+			TraceID:           span.SpanContext.TraceID.String(),
+			SpanID:            span.SpanContext.SpanID.String(),
+			TraceState:        span.SpanContext.TraceState,
+			Name:              span.Name,
+			Kind:              int(span.Kind),
+			StartTimeUnixNano: strconv.FormatInt(span.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(span.End.UnixNano(), 10),
+			Attributes:        otlpAttributes(span.Attributes),
+		}
+		if span.ParentSpanID.IsValid() {
+			otlpSpans[i].ParentSpanID = span.ParentSpanID.String()
+		}
+		if span.Error {
+			otlpSpans[i].Status = otlpStatus{Code: otlpStatusError, Message: span.ErrorMessage}
+		}
+	}
+	return otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource:   otlpResource{Attributes: otlpAttributes(map[string]any{"service.name": e.ServiceName})},
+		ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{Name: "proxy-server"}, Spans: otlpSpans}},
+	}}}
+}
+
+func otlpAttributes(attributes map[string]any) []otlpAttribute {
+	otlp := make([]otlpAttribute, 0, len(attributes))
+	for _, key := range slices.Sorted(maps.Keys(attributes)) {
+		var value otlpValue
+		switch v := attributes[key].(type) {
+		case string:
+			value.StringValue = &v
+		case bool:
+			value.BoolValue = &v
+		case int:
+			s := strconv.Itoa(v)
+			value.IntValue = &s
+		case int64:
+			s := strconv.FormatInt(v, 10)
+			value.IntValue = &s
+		case float64:
+			value.DoubleValue = &v
+		default:
+			s := fmt.Sprint(v)
+			value.StringValue = &s
+		}
+		otlp = append(otlp, otlpAttribute{Key: key, Value: value})
+	}
+	return otlp
+}
@@ -0,0 +1,325 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  string
+		valid   bool
+		sampled bool
+	}{
+		{"sampled", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true, true},
+		{"not sampled", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", true, false},
+		{"future version with more fields", "01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", true, true},
+		{"empty", "", false, false},
+		{"invalid version", "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false, false},
+		{"version 00 with more fields", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", false, false},
+		{"zero trace id", "00-00000000000000000000000000000000-00f067aa0ba902b7-01", false, false},
+		{"zero span id", "00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", false, false},
+		{"uppercase", "00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", false, false},
+		{"short trace id", "00-4bf92f3577b34da6-00f067aa0ba902b7-01", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sc, ok := parseTraceparent(tt.header)
+
+			assert.Equal(t, tt.valid, ok)
+			if tt.valid {
+				assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", sc.TraceID.String())
+				assert.Equal(t, "00f067aa0ba902b7", sc.SpanID.String())
+				assert.Equal(t, tt.sampled, sc.Sampled)
+			}
+		})
+	}
+}
+
+func TestTracing(t *testing.T) {
+	const traceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+	var upstreamHeader http.Header
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamHeader = r.Header.Clone()
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer upstream.Close()
+	forward, err := NewForwardHandler(upstream.URL)
+	require.NoError(t, err)
+
+	router := NewPathRouter()
+	router.AddRoute("/retry", &RetrierHandler{Handler: forward, RetryPolicy: &RetryOnNon2xxRetryPolicy{}, Retries: 1})
+	router.AddRoute("/fanout", &FanOutHandler{
+		Handlers:         []Handler{&StaticHandler{message: "a"}, &StaticHandler{message: "b"}},
+		ResponseStrategy: &FirstSuccessfulResponseStrategy{},
+	})
+
+	t.Run("continues the trace of the client and propagates it upstream", func(t *testing.T) {
+		exporter := useTracer(t, 1)
+		r := httptest.NewRequest("GET", "/retry", nil)
+		r.Header.Set("traceparent", traceparent)
+		r.Header.Set("tracestate", "vendor=value")
+
+		router.ServeHTTP(httptest.NewRecorder(), r)
+
+		spans := exporter.finish(t)
+		require.Len(t, spans, 3)
+		forwardSpan, attempt, server := spans[0], spans[1], spans[2]
+
+		assert.Equal(t, "/retry", server.Name)
+		assert.Equal(t, SpanKindServer, server.Kind)
+		assert.Equal(t, "00f067aa0ba902b7", server.ParentSpanID.String())
+		assert.Equal(t, 200, server.Attributes["http.response.status_code"])
+		assert.Equal(t, "/retry", server.Attributes["http.route"])
+
+		assert.Equal(t, "retrier attempt", attempt.Name)
+		assert.Equal(t, server.SpanContext.SpanID, attempt.ParentSpanID)
+		assert.Equal(t, 0, attempt.Attributes["retry.attempt"])
+
+		assert.Equal(t, "forward", forwardSpan.Name)
+		assert.Equal(t, SpanKindClient, forwardSpan.Kind)
+		assert.Equal(t, attempt.SpanContext.SpanID, forwardSpan.ParentSpanID)
+
+		for _, span := range spans {
+			assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", span.SpanContext.TraceID.String())
+		}
+		assert.Equal(t, forwardSpan.SpanContext.traceparent(), upstreamHeader.Get("traceparent"))
+		assert.Equal(t, "vendor=value", upstreamHeader.Get("tracestate"))
+	})
+
+	t.Run("starts a trace without traceparent", func(t *testing.T) {
+		exporter := useTracer(t, 1)
+
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/fanout", nil))
+
+		spans := exporter.finish(t)
+		require.Len(t, spans, 3)
+		server := spans[2]
+		assert.False(t, server.ParentSpanID.IsValid())
+		branches := map[any]bool{}
+		for _, branch := range spans[:2] {
+			assert.Equal(t, "fanout branch", branch.Name)
+			assert.Equal(t, server.SpanContext.TraceID, branch.SpanContext.TraceID)
+			assert.Equal(t, server.SpanContext.SpanID, branch.ParentSpanID)
+			branches[branch.Attributes["fanout.branch"]] = true
+		}
+		assert.Equal(t, map[any]bool{0: true, 1: true}, branches)
+	})
+
+	t.Run("doesn't export unsampled traces but propagates them", func(t *testing.T) {
+		exporter := useTracer(t, 1)
+		r := httptest.NewRequest("GET", "/retry", nil)
+		r.Header.Set("traceparent", strings.TrimSuffix(traceparent, "01")+"00")
+
+		router.ServeHTTP(httptest.NewRecorder(), r)
+
+		assert.Empty(t, exporter.finish(t))
+		assert.True(t, strings.HasPrefix(upstreamHeader.Get("traceparent"), "00-4bf92f3577b34da6a3ce929d0e0e4736-"))
+		assert.True(t, strings.HasSuffix(upstreamHeader.Get("traceparent"), "-00"))
+	})
+
+	t.Run("samples new traces by ratio", func(t *testing.T) {
+		exporter := useTracer(t, 0)
+
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/fanout", nil))
+
+		assert.Empty(t, exporter.finish(t))
+	})
+
+	t.Run("marks server errors", func(t *testing.T) {
+		exporter := useTracer(t, 1)
+		router := NewPathRouter()
+		router.AddRoute("/chaos", NewChaosHandler(&StaticHandler{message: "ok"}, 1))
+
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/chaos", nil))
+
+		spans := exporter.finish(t)
+		require.Len(t, spans, 1)
+		assert.True(t, spans[0].Error)
+		assert.Equal(t, true, spans[0].Attributes["chaos.injected"])
+	})
+}
+
+func TestOTLPExporter(t *testing.T) {
+	span := &Span{
+		Name: "/hello",
+		Kind: SpanKindServer,
+		SpanContext: SpanContext{
+			TraceID:    TraceID{0x4b, 0xf9, 15: 0x36},
+			SpanID:     SpanID{0x01, 7: 0x02},
+			Sampled:    true,
+			TraceState: "vendor=value",
+		},
+		ParentSpanID: SpanID{0x03, 7: 0x04},
+		Start:        time.Unix(1, 0),
+		End:          time.Unix(2, 0),
+		Attributes:   map[string]any{"http.response.status_code": 502, "http.route": "/hello"},
+		Error:        true,
+		ErrorMessage: "Bad Gateway",
+	}
+
+	t.Run("posts spans to the collector", func(t *testing.T) {
+		var body []byte
+		var header http.Header
+		collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			header = r.Header
+			body, _ = io.ReadAll(r.Body)
+		}))
+		defer collector.Close()
+		exporter := NewOTLPExporter(collector.URL+"/v1/traces", "test")
+		exporter.Headers = map[string]string{"Authorization": "Bearer token"}
+
+		require.NoError(t, exporter.ExportSpans(context.Background(), []*Span{span}))
+
+		assert.Equal(t, "application/json", header.Get("Content-Type"))
+		assert.Equal(t, "Bearer token", header.Get("Authorization"))
+		// language=JSON
+		expected := `{"resourceSpans": [{
+			"resource": {"attributes": [{"key": "service.name", "value": {"stringValue": "test"}}]},
+			"scopeSpans": [{
+				"scope": {"name": "proxy-server"},
+				"spans": [{
+					"traceId": "4bf90000000000000000000000000036",
+					"spanId": "0100000000000002",
+					"traceState": "vendor=value",
+					"parentSpanId": "0300000000000004",
+					"name": "/hello",
+					"kind": 2,
+					"startTimeUnixNano": "1000000000",
+					"endTimeUnixNano": "2000000000",
+					"attributes": [
+						{"key": "http.response.status_code", "value": {"intValue": "502"}},
+						{"key": "http.route", "value": {"stringValue": "/hello"}}
+					],
+					"status": {"code": 2, "message": "Bad Gateway"}
+				}]
+			}]
+		}]}`
+		assert.JSONEq(t, expected, string(body))
+	})
+
+	t.Run("fails if the collector rejects spans", func(t *testing.T) {
+		collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusBadRequest)
+		}))
+		defer collector.Close()
+		exporter := NewOTLPExporter(collector.URL, "test")
+
+		err := exporter.ExportSpans(context.Background(), []*Span{span})
+
+		assert.EqualError(t, err, "collector responded with 400 Bad Request")
+	})
+}
+
+func TestStdoutExporter(t *testing.T) {
+	var buf bytes.Buffer
+	exporter := NewStdoutExporter(&buf)
+	span := &Span{
+		Name:        "forward",
+		Kind:        SpanKindClient,
+		SpanContext: SpanContext{TraceID: TraceID{15: 1}, SpanID: SpanID{7: 2}},
+		Start:       time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		End:         time.Date(2024, 1, 1, 0, 0, 0, 1500000, time.UTC),
+		Attributes:  map[string]any{"server.address": "localhost:8080"},
+	}
+
+	require.NoError(t, exporter.ExportSpans(context.Background(), []*Span{span, span}))
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 2)
+	// language=JSON
+	expected := `{
+		"trace_id": "00000000000000000000000000000001",
+		"span_id": "0000000000000002",
+		"name": "forward",
+		"kind": "client",
+		"start": "2024-01-01T00:00:00Z",
+		"duration_ms": 1.5,
+		"attributes": {"server.address": "localhost:8080"}
+	}`
+	assert.JSONEq(t, expected, lines[0])
+}
+
+func TestConfig_CreateTracer(t *testing.T) {
+	tests := []struct {
+		name    string
+		tracing string
+		err     string
+	}{
+		{"otlp", `{"exporter": "otlp", "endpoint": "http://localhost:4318/v1/traces", "sample_ratio": 0.5}`, ""},
+		{"stdout", `{"exporter": "stdout"}`, ""},
+		{"unknown exporter", `{"exporter": "zipkin"}`, `tracing: unknown exporter "zipkin", expected otlp or stdout`},
+		{"otlp without endpoint", `{"exporter": "otlp"}`, "tracing: no endpoint set"},
+		{"invalid sample ratio", `{"exporter": "stdout", "sample_ratio": 2}`, "tracing: sample_ratio must be between 0 and 1, got 2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := ReadConfigFromString(`{"routes": [], "tracing": ` + tt.tracing + `}`)
+			require.NoError(t, err)
+
+			tracer, err := config.CreateTracer()
+
+			if tt.err != "" {
+				assert.EqualError(t, err, tt.err)
+				assert.EqualError(t, config.Validate(), tt.err)
+				return
+			}
+			require.NoError(t, err)
+			require.NoError(t, tracer.Shutdown(context.Background()))
+		})
+	}
+
+	t.Run("without tracing section", func(t *testing.T) {
+		config, err := ReadConfigFromString(`{"routes": []}`)
+		require.NoError(t, err)
+
+		tracer, err := config.CreateTracer()
+
+		assert.NoError(t, err)
+		assert.Nil(t, tracer)
+	})
+}
+
+// recordingExporter keeps exported spans in memory.
+type recordingExporter struct {
+	tracer *Tracer
+	mu     sync.Mutex
+	spans  []*Span
+}
+
+func (e *recordingExporter) ExportSpans(_ context.Context, spans []*Span) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = append(e.spans, spans...)
+	return nil
+}
+
+// finish shuts down the tracer and returns the exported spans in the order
+// they finished.
+func (e *recordingExporter) finish(t *testing.T) []*Span {
+	require.NoError(t, e.tracer.Shutdown(context.Background()))
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.spans
+}
+
+// useTracer traces requests until the test ends.
+func useTracer(t *testing.T, sampleRatio float64) *recordingExporter {
+	exporter := &recordingExporter{}
+	exporter.tracer = NewTracer(exporter, sampleRatio)
+	SetTracer(exporter.tracer)
+	t.Cleanup(func() { SetTracer(nil) })
+	return exporter
+}
@@ -0,0 +1,332 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const maxDatagramSize = 64 * 1024
+
+// maxPendingDatagrams bounds the datagrams queued by a session while its
+// upstream is dialed, further ones are dropped.
+const maxPendingDatagrams = 64
+
+// UDPProxy forwards datagrams to upstreams of its pool. Each client address
+// gets a session bound to one upstream, so replies are routed back to it.
+// Sessions without traffic for SessionTimeout are expired.
+type UDPProxy struct {
+	Address        string
+	Pool           *UpstreamPool
+	SessionTimeout time.Duration
+	// MaxSessions bounds the sessions, datagrams of further clients are
+	// dropped until sessions expire. Zero disables the limit.
+	MaxSessions int
+	Logger      *slog.Logger
+
+	bytesIn  atomic.Int64
+	bytesOut atomic.Int64
+
+	mu       sync.Mutex
+	conn     net.PacketConn
+	sessions map[string]*udpSession
+	// full is set once datagrams are dropped at MaxSessions, so it's logged
+	// once until a session expires
+	full   bool
+	closed bool
+	wg     sync.WaitGroup
+	// dial connects sessions to their upstream, net.Dial if nil
+	dial func(network, address string) (net.Conn, error)
+}
+
+type udpSession struct {
+	clientAddr   net.Addr
+	upstream     *Upstream
+	lastActivity atomic.Int64
+
+	mu sync.Mutex
+	// conn is nil while the upstream is dialed, datagrams received meanwhile
+	// are pending
+	conn    net.Conn
+	pending [][]byte
+}
+
+func NewUDPProxy(address string, pool *UpstreamPool) *UDPProxy {
+	return &UDPProxy{
+		Address:        address,
+		Pool:           pool,
+		SessionTimeout: time.Minute,
+		MaxSessions:    10000,
+	}
+}
+
+// UDPProxyStats is a snapshot of a UDP proxy's traffic.
+type UDPProxyStats struct {
+	Address        string          `json:"address"`
+	ActiveSessions int             `json:"active_sessions"`
+	BytesIn        int64           `json:"bytes_in"`
+	BytesOut       int64           `json:"bytes_out"`
+	Upstreams      []UpstreamStats `json:"upstreams"`
+}
+
+func (p *UDPProxy) Stats() UDPProxyStats {
+	p.mu.Lock()
+	active := len(p.sessions)
+	p.mu.Unlock()
+
+	return UDPProxyStats{
+		Address:        p.Address,
+		ActiveSessions: active,
+		BytesIn:        p.bytesIn.Load(),
+		BytesOut:       p.bytesOut.Load(),
+		Upstreams:      p.Pool.Stats(),
+	}
+}
+
+// ListenAndServe listens on the proxy address and serves datagrams until
+// Shutdown is called, in which case it returns nil.
+func (p *UDPProxy) ListenAndServe() error {
+	conn, err := net.ListenPacket("udp", p.Address)
+	if err != nil {
+		return err
+	}
+	return p.Serve(conn)
+}
+
+func (p *UDPProxy) Serve(conn net.PacketConn) error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		_ = conn.Close()
+		return nil
+	}
+	p.conn = conn
+	p.sessions = make(map[string]*udpSession)
+	p.mu.Unlock()
+
+	loggerOrDefault(p.Logger).Info("UDP proxy listening", "address", conn.LocalAddr().String())
+	buf := make([]byte, maxDatagramSize)
+	for {
+		n, clientAddr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if p.isClosed() {
+				return nil
+			}
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				continue
+			}
+			return err
+		}
+
+		session, err := p.session(clientAddr)
+		if errors.Is(err, errUDPSessionLimit) {
+			udpDroppedDatagrams.Inc(p.Address)
+			continue
+		}
+		if err != nil {
+			loggerOrDefault(p.Logger).Error("Error creating session", "listen", p.Address, "client", clientAddr.String(), "error", err)
+			continue
+		}
+		session.lastActivity.Store(time.Now().UnixNano())
+		p.send(session, buf[:n])
+	}
+}
+
+// send forwards a datagram to the upstream of the session, or queues it while
+// the upstream is dialed.
+func (p *UDPProxy) send(session *udpSession, datagram []byte) {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	if session.conn != nil {
+		p.write(session, datagram)
+		return
+	}
+	if len(session.pending) >= maxPendingDatagrams {
+		udpDroppedDatagrams.Inc(p.Address)
+		return
+	}
+	session.pending = append(session.pending, bytes.Clone(datagram))
+}
+
+func (p *UDPProxy) write(session *udpSession, datagram []byte) {
+	if _, err := session.conn.Write(datagram); err != nil {
+		loggerOrDefault(p.Logger).Error("Error forwarding datagram", "upstream", session.upstream.Address, "error", err)
+		return
+	}
+	p.bytesIn.Add(int64(len(datagram)))
+	session.upstream.bytesSent.Add(int64(len(datagram)))
+}
+
+func (p *UDPProxy) isClosed() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.closed
+}
+
+// Addr returns the address the proxy listens on, or nil when it isn't serving.
+func (p *UDPProxy) Addr() net.Addr {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.conn == nil {
+		return nil
+	}
+	return p.conn.LocalAddr()
+}
+
+// errUDPSessionLimit is returned by session when MaxSessions is reached.
+var errUDPSessionLimit = errors.New("udp session limit reached")
+
+// session returns the session of the client address, creating it if it
+// doesn't exist. New sessions dial their upstream in the background, so a
+// slow DNS lookup doesn't hold up the datagrams of other sessions.
+func (p *UDPProxy) session(clientAddr net.Addr) (*udpSession, error) {
+	key := clientAddr.String()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if session, ok := p.sessions[key]; ok {
+		return session, nil
+	}
+	if p.closed {
+		return nil, net.ErrClosed
+	}
+	if p.MaxSessions > 0 && len(p.sessions) >= p.MaxSessions {
+		if !p.full {
+			p.full = true
+			loggerOrDefault(p.Logger).Warn("UDP session limit reached, datagrams of new clients are dropped",
+				"listen", p.Address, "max_sessions", p.MaxSessions)
+		}
+		return nil, errUDPSessionLimit
+	}
+
+	upstream, err := p.Pool.Next()
+	if err != nil {
+		return nil, err
+	}
+	session := &udpSession{
+		clientAddr: clientAddr,
+		upstream:   upstream,
+	}
+	session.lastActivity.Store(time.Now().UnixNano())
+	p.sessions[key] = session
+	upstream.active.Add(1)
+
+	p.wg.Add(1)
+	go p.connect(key, session)
+	return session, nil
+}
+
+// connect dials the upstream of a new session, forwards the datagrams which
+// are pending meanwhile and then routes replies back to the client.
+func (p *UDPProxy) connect(key string, session *udpSession) {
+	defer p.wg.Done()
+
+	dial := p.dial
+	if dial == nil {
+		dial = net.Dial
+	}
+	conn, err := dial("udp", session.upstream.Address)
+	if err != nil {
+		loggerOrDefault(p.Logger).Error("Error creating session", "listen", p.Address, "client", session.clientAddr.String(), "error", err)
+		p.removeSession(key, session)
+		return
+	}
+
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		_ = conn.Close()
+		p.removeSession(key, session)
+		return
+	}
+	// Shutdown closes the conn of sessions under p.mu
+	session.mu.Lock()
+	session.conn = conn
+	for _, datagram := range session.pending {
+		p.write(session, datagram)
+	}
+	session.pending = nil
+	session.mu.Unlock()
+	p.mu.Unlock()
+
+	p.replyLoop(key, session)
+}
+
+// replyLoop routes datagrams from the upstream back to the session's client,
+// until the session expires or the proxy is shut down.
+func (p *UDPProxy) replyLoop(key string, session *udpSession) {
+	defer p.removeSession(key, session)
+
+	buf := make([]byte, maxDatagramSize)
+	for {
+		_ = session.conn.SetReadDeadline(time.Now().Add(p.SessionTimeout))
+		n, err := session.conn.Read(buf)
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				idle := time.Since(time.Unix(0, session.lastActivity.Load()))
+				if idle < p.SessionTimeout {
+					continue
+				}
+			}
+			return
+		}
+		session.lastActivity.Store(time.Now().UnixNano())
+		if _, err := p.conn.WriteTo(buf[:n], session.clientAddr); err != nil {
+			loggerOrDefault(p.Logger).Error("Error writing reply", "client", session.clientAddr.String(), "error", err)
+			continue
+		}
+		p.bytesOut.Add(int64(n))
+		session.upstream.bytesRecv.Add(int64(n))
+	}
+}
+
+func (p *UDPProxy) removeSession(key string, session *udpSession) {
+	p.mu.Lock()
+	if p.sessions[key] == session {
+		delete(p.sessions, key)
+		p.full = false
+	}
+	p.mu.Unlock()
+
+	if session.conn != nil {
+		_ = session.conn.Close()
+	}
+	session.upstream.active.Add(-1)
+}
+
+// Shutdown stops reading datagrams and closes all sessions. UDP has no
+// connections to drain, so ctx only bounds waiting for session cleanup.
+func (p *UDPProxy) Shutdown(ctx context.Context) error {
+	p.mu.Lock()
+	p.closed = true
+	var err error
+	if p.conn != nil {
+		err = p.conn.Close()
+	}
+	for _, session := range p.sessions {
+		if session.conn != nil {
+			_ = session.conn.Close()
+		}
+	}
+	p.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,165 @@
+package proxy
+
+import (
+	"context"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUDPProxy(t *testing.T) {
+	t.Run("routes replies back to each client", func(t *testing.T) {
+		upstream := newUDPEchoServer(t)
+		udpProxy := startUDPProxy(t, []string{upstream}, time.Minute)
+
+		first := dialUDP(t, udpProxy)
+		second := dialUDP(t, udpProxy)
+
+		assert.Equal(t, "first", roundTrip(t, first, "first"))
+		assert.Equal(t, "second", roundTrip(t, second, "second"))
+		assert.Equal(t, "first again", roundTrip(t, first, "first again"))
+
+		stats := udpProxy.Stats()
+		assert.Equal(t, 2, stats.ActiveSessions, "Expected one session per client")
+		assert.Equal(t, int64(22), stats.BytesIn)
+		assert.Eventually(t, func() bool {
+			return udpProxy.Stats().BytesOut == 22
+		}, time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("expires idle sessions", func(t *testing.T) {
+		upstream := newUDPEchoServer(t)
+		udpProxy := startUDPProxy(t, []string{upstream}, 50*time.Millisecond)
+
+		client := dialUDP(t, udpProxy)
+		assert.Equal(t, "ping", roundTrip(t, client, "ping"))
+
+		assert.Eventually(t, func() bool {
+			return udpProxy.Stats().ActiveSessions == 0
+		}, time.Second, 10*time.Millisecond, "Expected idle session to expire")
+
+		assert.Equal(t, "ping", roundTrip(t, client, "ping"), "Expected new session to be created")
+	})
+
+	t.Run("keeps serving sessions while dialing the upstream of a new one", func(t *testing.T) {
+		upstream := newUDPEchoServer(t)
+		udpProxy := NewUDPProxy("127.0.0.1:0", mustUpstreamPool(t, []string{upstream}))
+		dialing, unblock := make(chan struct{}), make(chan struct{})
+		var dials atomic.Int32
+		udpProxy.dial = func(network, address string) (net.Conn, error) {
+			// the second session dials slowly, like on a slow DNS lookup
+			if dials.Add(1) == 2 {
+				close(dialing)
+				<-unblock
+			}
+			return net.Dial(network, address)
+		}
+		serveUDPProxy(t, udpProxy)
+		// cleanups run in reverse, so the dial is released before shutting down
+		release := sync.OnceFunc(func() { close(unblock) })
+		t.Cleanup(release)
+		first := dialUDP(t, udpProxy)
+		assert.Equal(t, "first", roundTrip(t, first, "first"))
+
+		slow := dialUDP(t, udpProxy)
+		_, err := slow.Write([]byte("slow"))
+		require.NoError(t, err)
+		<-dialing
+
+		assert.Equal(t, "first again", roundTrip(t, first, "first again"), "Existing sessions keep working")
+		release()
+		require.NoError(t, slow.SetReadDeadline(time.Now().Add(time.Second)))
+		buf := make([]byte, 1024)
+		n, err := slow.Read(buf)
+		require.NoError(t, err)
+		assert.Equal(t, "slow", string(buf[:n]), "Datagrams received while dialing are forwarded")
+	})
+
+	t.Run("drops datagrams of new clients at the session limit", func(t *testing.T) {
+		upstream := newUDPEchoServer(t)
+		udpProxy := NewUDPProxy("127.0.0.1:0", mustUpstreamPool(t, []string{upstream}))
+		udpProxy.MaxSessions = 1
+		serveUDPProxy(t, udpProxy)
+		dropped := metricDelta(udpDroppedDatagrams.family, udpProxy.Address)
+
+		first := dialUDP(t, udpProxy)
+		assert.Equal(t, "first", roundTrip(t, first, "first"))
+
+		second := dialUDP(t, udpProxy)
+		_, err := second.Write([]byte("second"))
+		require.NoError(t, err)
+		require.NoError(t, second.SetReadDeadline(time.Now().Add(50*time.Millisecond)))
+		_, err = second.Read(make([]byte, 1024))
+		assert.ErrorIs(t, err, os.ErrDeadlineExceeded)
+
+		assert.Equal(t, "first again", roundTrip(t, first, "first again"), "Existing sessions keep working")
+		assert.Equal(t, 1, udpProxy.Stats().ActiveSessions)
+		assert.Equal(t, 1.0, dropped())
+	})
+}
+
+func startUDPProxy(t *testing.T, upstreams []string, sessionTimeout time.Duration) *UDPProxy {
+	udpProxy := NewUDPProxy("127.0.0.1:0", mustUpstreamPool(t, upstreams))
+	udpProxy.SessionTimeout = sessionTimeout
+	serveUDPProxy(t, udpProxy)
+	return udpProxy
+}
+
+func serveUDPProxy(t *testing.T, udpProxy *UDPProxy) {
+	conn, err := net.ListenPacket("udp", udpProxy.Address)
+	require.NoError(t, err)
+	go func() {
+		_ = udpProxy.Serve(conn)
+	}()
+	require.Eventually(t, func() bool {
+		return udpProxy.Addr() != nil
+	}, time.Second, time.Millisecond)
+	t.Cleanup(func() {
+		_ = udpProxy.Shutdown(context.Background())
+	})
+}
+
+func dialUDP(t *testing.T, udpProxy *UDPProxy) net.Conn {
+	conn, err := net.Dial("udp", udpProxy.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+	return conn
+}
+
+func roundTrip(t *testing.T, conn net.Conn, message string) string {
+	_, err := conn.Write([]byte(message))
+	require.NoError(t, err)
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+	buf := make([]byte, 1024)
+	n, err := conn.Read(buf)
+	require.NoError(t, err)
+	return string(buf[:n])
+}
+
+// newUDPEchoServer starts a UDP server echoing datagrams back and returns its address.
+func newUDPEchoServer(t *testing.T) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			_, _ = conn.WriteTo(buf[:n], addr)
+		}
+	}()
+	return conn.LocalAddr().String()
+}
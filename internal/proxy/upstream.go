@@ -0,0 +1,198 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var ErrNoHealthyUpstream = errors.New("no healthy upstream")
+
+// Upstream is a single upstream target address, tracking its health and traffic.
+type Upstream struct {
+	Address   string
+	healthy   atomic.Bool
+	draining  atomic.Bool
+	active    atomic.Int64
+	bytesSent atomic.Int64
+	bytesRecv atomic.Int64
+}
+
+func NewUpstream(address string) *Upstream {
+	u := &Upstream{Address: address}
+	u.healthy.Store(true)
+	return u
+}
+
+func (u *Upstream) Healthy() bool {
+	return u.healthy.Load()
+}
+
+// SetDraining marks the upstream as draining, which keeps its active
+// connections but doesn't pick it for new ones.
+func (u *Upstream) SetDraining(draining bool) {
+	u.draining.Store(draining)
+}
+
+func (u *Upstream) Draining() bool {
+	return u.draining.Load()
+}
+
+// UpstreamStats is a snapshot of an upstream's state.
+type UpstreamStats struct {
+	Address           string `json:"address"`
+	Healthy           bool   `json:"healthy"`
+	Draining          bool   `json:"draining"`
+	ActiveConnections int64  `json:"active_connections"`
+	BytesSent         int64  `json:"bytes_sent"`
+	BytesReceived     int64  `json:"bytes_received"`
+}
+
+func (u *Upstream) Stats() UpstreamStats {
+	return UpstreamStats{
+		Address:           u.Address,
+		Healthy:           u.Healthy(),
+		Draining:          u.Draining(),
+		ActiveConnections: u.active.Load(),
+		BytesSent:         u.bytesSent.Load(),
+		BytesReceived:     u.bytesRecv.Load(),
+	}
+}
+
+// UpstreamPool balances between upstreams in round-robin order, skipping
+// upstreams which are marked as unhealthy or draining.
+type UpstreamPool struct {
+	Upstreams []*Upstream
+	next      atomic.Uint64
+}
+
+func NewUpstreamPool(addresses []string) (*UpstreamPool, error) {
+	if len(addresses) == 0 {
+		return nil, errors.New("no upstreams configured")
+	}
+	upstreams := make([]*Upstream, len(addresses))
+	for i, address := range addresses {
+		if _, _, err := net.SplitHostPort(address); err != nil {
+			return nil, err
+		}
+		upstreams[i] = NewUpstream(address)
+	}
+	return &UpstreamPool{Upstreams: upstreams}, nil
+}
+
+// Next returns the next healthy upstream which isn't draining.
+func (p *UpstreamPool) Next() (*Upstream, error) {
+	n := uint64(len(p.Upstreams))
+	start := p.next.Add(1) - 1
+	for i := uint64(0); i < n; i++ {
+		upstream := p.Upstreams[(start+i)%n]
+		if upstream.Healthy() && !upstream.Draining() {
+			return upstream, nil
+		}
+	}
+	return nil, ErrNoHealthyUpstream
+}
+
+// Upstream returns the upstream with the given address.
+func (p *UpstreamPool) Upstream(address string) (*Upstream, bool) {
+	for _, upstream := range p.Upstreams {
+		if upstream.Address == address {
+			return upstream, true
+		}
+	}
+	return nil, false
+}
+
+func (p *UpstreamPool) Stats() []UpstreamStats {
+	stats := make([]UpstreamStats, len(p.Upstreams))
+	for i, upstream := range p.Upstreams {
+		stats[i] = upstream.Stats()
+	}
+	return stats
+}
+
+// HealthCheck is a function checking whether an upstream is able to serve traffic.
+type HealthCheck func(ctx context.Context, address string) error
+
+// TCPHealthCheck considers an upstream healthy when a TCP connection can be established.
+func TCPHealthCheck(ctx context.Context, address string) error {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// HealthChecker periodically checks upstreams of a pool and marks them healthy or unhealthy.
+type HealthChecker struct {
+	Pool     *UpstreamPool
+	Check    HealthCheck
+	Interval time.Duration
+	Timeout  time.Duration
+
+	started  atomic.Bool
+	stopOnce sync.Once
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+func NewHealthChecker(pool *UpstreamPool, check HealthCheck, interval, timeout time.Duration) *HealthChecker {
+	return &HealthChecker{
+		Pool:     pool,
+		Check:    check,
+		Interval: interval,
+		Timeout:  timeout,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// Start checks all upstreams immediately and then once every interval, until Close is called.
+func (c *HealthChecker) Start() {
+	if !c.started.CompareAndSwap(false, true) {
+		return
+	}
+	go func() {
+		defer close(c.done)
+
+		ticker := time.NewTicker(c.Interval)
+		defer ticker.Stop()
+		for {
+			c.checkAll()
+			select {
+			case <-c.stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (c *HealthChecker) checkAll() {
+	var wg sync.WaitGroup
+	for _, upstream := range c.Pool.Upstreams {
+		wg.Add(1)
+		go func(u *Upstream) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), c.Timeout)
+			defer cancel()
+			u.healthy.Store(c.Check(ctx, u.Address) == nil)
+		}(upstream)
+	}
+	wg.Wait()
+}
+
+// Close stops the health checker and waits for the running check to complete.
+func (c *HealthChecker) Close() error {
+	c.stopOnce.Do(func() {
+		close(c.stop)
+	})
+	if c.started.Load() {
+		<-c.done
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUpstreamPool_Next(t *testing.T) {
+	t.Run("balances in round-robin order", func(t *testing.T) {
+		pool := mustUpstreamPool(t, []string{"a:1", "b:1", "c:1"})
+
+		var addresses []string
+		for i := 0; i < 4; i++ {
+			upstream, err := pool.Next()
+			require.NoError(t, err)
+			addresses = append(addresses, upstream.Address)
+		}
+
+		assert.Equal(t, []string{"a:1", "b:1", "c:1", "a:1"}, addresses)
+	})
+
+	t.Run("skips unhealthy upstreams", func(t *testing.T) {
+		pool := mustUpstreamPool(t, []string{"a:1", "b:1"})
+		pool.Upstreams[0].healthy.Store(false)
+
+		for i := 0; i < 2; i++ {
+			upstream, err := pool.Next()
+			require.NoError(t, err)
+			assert.Equal(t, "b:1", upstream.Address)
+		}
+	})
+
+	t.Run("skips draining upstreams", func(t *testing.T) {
+		pool := mustUpstreamPool(t, []string{"a:1", "b:1"})
+		pool.Upstreams[1].SetDraining(true)
+
+		for i := 0; i < 2; i++ {
+			upstream, err := pool.Next()
+			require.NoError(t, err)
+			assert.Equal(t, "a:1", upstream.Address)
+		}
+		assert.True(t, pool.Upstreams[1].Stats().Draining)
+	})
+
+	t.Run("fails without healthy upstreams", func(t *testing.T) {
+		pool := mustUpstreamPool(t, []string{"a:1"})
+		pool.Upstreams[0].healthy.Store(false)
+
+		_, err := pool.Next()
+		assert.ErrorIs(t, err, ErrNoHealthyUpstream)
+	})
+}
+
+func TestNewUpstreamPool(t *testing.T) {
+	t.Run("fails without upstreams", func(t *testing.T) {
+		_, err := NewUpstreamPool(nil)
+		assert.Error(t, err)
+	})
+
+	t.Run("fails with address missing port", func(t *testing.T) {
+		_, err := NewUpstreamPool([]string{"localhost"})
+		assert.Error(t, err)
+	})
+}
+
+func TestHealthChecker(t *testing.T) {
+	pool := mustUpstreamPool(t, []string{"healthy:1", "unhealthy:1"})
+	check := func(_ context.Context, address string) error {
+		if address == "unhealthy:1" {
+			return errors.New("connection refused")
+		}
+		return nil
+	}
+
+	checker := NewHealthChecker(pool, check, time.Hour, time.Second)
+	checker.Start()
+
+	assert.Eventually(t, func() bool {
+		return !pool.Upstreams[1].Healthy()
+	}, time.Second, 10*time.Millisecond, "Expected upstream to be marked unhealthy")
+	assert.True(t, pool.Upstreams[0].Healthy())
+	assert.NoError(t, checker.Close())
+}
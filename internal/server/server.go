@@ -3,7 +3,9 @@ package server
 import (
 	"context"
 	"errors"
-	"log"
+	"fmt"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -12,24 +14,48 @@ import (
 )
 
 type Config struct {
-	Port         string
-	ReadTimeout  time.Duration
-	WriteTimeout time.Duration
-	IdleTimeout  time.Duration
+	Port string
+	// Listeners are additional addresses the server listens on besides Port.
+	Listeners         []string
+	ReadTimeout       time.Duration
+	ReadHeaderTimeout time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	MaxHeaderBytes    int
+	ShutdownTimeout   time.Duration
+	DisableKeepAlives bool
 }
 
 func DefaultConfig() *Config {
 	return &Config{
-		Port:         ":8080",
-		ReadTimeout:  15 * time.Second,
-		WriteTimeout: 15 * time.Second,
-		IdleTimeout:  60 * time.Second,
+		Port:            ":8080",
+		ReadTimeout:     15 * time.Second,
+		WriteTimeout:    15 * time.Second,
+		IdleTimeout:     60 * time.Second,
+		MaxHeaderBytes:  http.DefaultMaxHeaderBytes,
+		ShutdownTimeout: 30 * time.Second,
 	}
 }
 
+// Addresses returns all addresses the server listens on.
+func (c *Config) Addresses() []string {
+	return append([]string{c.Port}, c.Listeners...)
+}
+
+// Service is a listener running alongside the HTTP server, e.g. a TCP proxy.
+// ListenAndServe blocks until Shutdown is called, after which it returns nil.
+type Service interface {
+	ListenAndServe() error
+	Shutdown(ctx context.Context) error
+}
+
 type Server struct {
-	config *Config
-	server *http.Server
+	// Logger logs the lifecycle of the server and errors of the http.Server.
+	Logger *slog.Logger
+
+	config   *Config
+	server   *http.Server
+	services []Service
 }
 
 func New(handler http.Handler, config *Config) *Server {
@@ -37,41 +63,142 @@ func New(handler http.Handler, config *Config) *Server {
 		config = DefaultConfig()
 	}
 
+	server := &http.Server{
+		Addr:              config.Port,
+		Handler:           handler,
+		ReadTimeout:       config.ReadTimeout,
+		ReadHeaderTimeout: config.ReadHeaderTimeout,
+		WriteTimeout:      config.WriteTimeout,
+		IdleTimeout:       config.IdleTimeout,
+		MaxHeaderBytes:    config.MaxHeaderBytes,
+	}
+	server.SetKeepAlivesEnabled(!config.DisableKeepAlives)
+
 	return &Server{
 		config: config,
-		server: &http.Server{
-			Addr:         config.Port,
-			Handler:      handler,
-			ReadTimeout:  config.ReadTimeout,
-			WriteTimeout: config.WriteTimeout,
-			IdleTimeout:  config.IdleTimeout,
-		},
+		server: server,
 	}
 }
 
+// AddService adds a service which is started and shut down together with the server.
+func (s *Server) AddService(service Service) {
+	s.services = append(s.services, service)
+}
+
 func (s *Server) Start() error {
 	// Channel to listen for interrupt signal
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(stop)
 
-	go func() {
-		log.Printf("Server starting on %s", s.config.Port)
-		if err := s.server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
-			log.Fatalf("Server failed to start: %v", err)
+	// bind all addresses upfront, so a taken port fails the start
+	listeners := make([]net.Listener, 0, len(s.config.Addresses()))
+	for _, address := range s.config.Addresses() {
+		listener, err := net.Listen("tcp", address)
+		if err != nil {
+			for _, l := range listeners {
+				_ = l.Close()
+			}
+			return fmt.Errorf("failed to listen on %s: %w", address, err)
 		}
-	}()
+		listeners = append(listeners, listener)
+	}
+
+	s.server.ErrorLog = slog.NewLogLogger(s.logger().Handler(), slog.LevelError)
+
+	// the first listener or service which fails stops the server
+	failed := make(chan error, len(listeners)+len(s.services))
+	for _, listener := range listeners {
+		go func(listener net.Listener) {
+			s.logger().Info("Server starting", "address", listener.Addr().String())
+			if err := s.server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				failed <- fmt.Errorf("server on %s failed: %w", listener.Addr(), err)
+			}
+		}(listener)
+	}
+
+	for _, service := range s.services {
+		go func(service Service) {
+			if err := service.ListenAndServe(); err != nil {
+				failed <- fmt.Errorf("service failed: %w", err)
+			}
+		}(service)
+	}
 
-	<-stop
-	log.Println("Server shutting down...")
+	var errs []error
+	select {
+	case <-stop:
+		s.logger().Info("Server shutting down")
+	case err := <-failed:
+		s.logger().Error("Server shutting down after failure", "error", err)
+		errs = append(errs, err)
+	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), s.config.ShutdownTimeout)
 	defer cancel()
 
+	var shutdownErrs []error
 	if err := s.server.Shutdown(ctx); err != nil {
-		log.Printf("Server forced to shutdown: %v", err)
+		shutdownErrs = append(shutdownErrs, err)
+	}
+	for _, service := range s.services {
+		if err := service.Shutdown(ctx); err != nil {
+			shutdownErrs = append(shutdownErrs, err)
+		}
+	}
+	if err := errors.Join(shutdownErrs...); err != nil {
+		s.logger().Error("Server forced to shutdown", "error", err)
+		errs = append(errs, err)
+	}
+	if err := errors.Join(errs...); err != nil {
 		return err
 	}
 
-	log.Println("Server gracefully stopped")
+	s.logger().Info("Server gracefully stopped")
 	return nil
 }
+
+func (s *Server) logger() *slog.Logger {
+	if s.Logger == nil {
+		return slog.Default()
+	}
+	return s.Logger
+}
+
+// HTTPService serves a handler on its own listener as a Service, e.g. an
+// admin API which must not be reachable through the public addresses.
+type HTTPService struct {
+	Logger *slog.Logger
+
+	server *http.Server
+}
+
+func NewHTTPService(address string, handler http.Handler) *HTTPService {
+	// long-lived requests, like streams, end when the service shuts down
+	ctx, cancel := context.WithCancel(context.Background())
+	server := &http.Server{
+		Addr:              address,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+		BaseContext:       func(net.Listener) context.Context { return ctx },
+	}
+	server.RegisterOnShutdown(cancel)
+	return &HTTPService{server: server}
+}
+
+func (s *HTTPService) ListenAndServe() error {
+	logger := s.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	s.server.ErrorLog = slog.NewLogLogger(logger.Handler(), slog.LevelError)
+	logger.Info("HTTP service listening", "address", s.server.Addr)
+	if err := s.server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+func (s *HTTPService) Shutdown(ctx context.Context) error {
+	return s.server.Shutdown(ctx)
+}